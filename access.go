@@ -0,0 +1,97 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrNoPermission is returned when the current principal lacks the rights
+// for a call. The underlying error is a *PermissionDeniedError
+var ErrNoPermission = errors.New("insufficient access")
+
+// Matches the attribute named in an ACIError, for example "Insufficient
+// 'write' privilege to the 'loginShell' attribute of entry ..."
+var aciAttrRegexp = regexp.MustCompile(`the '([^']+)' attribute`)
+
+// PermissionDeniedError is returned when FreeIPA denies Method on Target
+// (error 2100). Attribute is set if FreeIPA named the attribute which was
+// blocked.
+type PermissionDeniedError struct {
+	Method    string
+	Target    string
+	Attribute string
+	Err       *IpaError
+}
+
+func (e *PermissionDeniedError) Error() string {
+	msg := fmt.Sprintf("ipa: permission denied: %s", e.Method)
+	if e.Target != "" {
+		msg += fmt.Sprintf(" on %s", e.Target)
+	}
+	if e.Attribute != "" {
+		msg += fmt.Sprintf(" attribute %s", e.Attribute)
+	}
+
+	return fmt.Sprintf("%s: %s", msg, e.Err.Message)
+}
+
+func (e *PermissionDeniedError) Unwrap() []error {
+	return []error{ErrNoPermission, e.Err}
+}
+
+// Returns a PermissionDeniedError for an insufficient access error from
+// method called with params
+func newPermissionDeniedError(method string, params []string, ierr *IpaError) *PermissionDeniedError {
+	perr := &PermissionDeniedError{Method: method, Err: ierr}
+	if len(params) > 0 {
+		perr.Target = params[0]
+	}
+
+	info := ierr.Message
+	if len(ierr.Data) > 0 {
+		if v := gjson.GetBytes(ierr.Data, "info"); v.Exists() {
+			info = v.String()
+		}
+	}
+	if m := aciAttrRegexp.FindStringSubmatch(info); m != nil {
+		perr.Attribute = strings.ToLower(m[1])
+	}
+
+	return perr
+}
+
+// Check whether the current principal can write attrs of the entity entry
+// name, for example CheckAccess("user", "jdoe", []string{"loginshell"}).
+// Uses the attribute level rights returned by <entity>_show with rights=true.
+// Attributes missing from the rights are reported as not writable.
+func (c *Client) CheckAccess(entity, name string, attrs []string) (map[string]bool, error) {
+	if entity == "" || name == "" {
+		return nil, errors.New("entity and name are required")
+	}
+
+	data, err := c.show(entity+"_show", []string{name}, ShowOptions{All: true, Rights: true, NoMembers: true})
+	if err != nil {
+		return nil, err
+	}
+
+	rights := make(map[string]string)
+	gjson.GetBytes(data, "attributelevelrights").ForEach(func(key, value gjson.Result) bool {
+		rights[strings.ToLower(key.String())] = value.String()
+		return true
+	})
+
+	access := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		access[attr] = strings.Contains(rights[strings.ToLower(attr)], "w")
+	}
+
+	return access, nil
+}
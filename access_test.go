@@ -0,0 +1,85 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestPermissionDenied(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": {"code": 2100, "message": "Insufficient access: Insufficient 'write' privilege to the 'loginShell' attribute of entry 'uid=jdoe,cn=users,cn=accounts,dc=local'.", "name": "ACIError",
+			"data": {"info": "Insufficient 'write' privilege to the 'loginShell' attribute of entry 'uid=jdoe,cn=users,cn=accounts,dc=local'."}},
+			"id": %d, "principal": "svc@LOCAL", "version": "4.9.8", "result": null}`, stubRequestID(r))
+	})
+
+	_, err := c.UserMod(&ipa.User{Username: "jdoe", Shell: "/bin/zsh"})
+	require.Error(err)
+	assert.True(errors.Is(err, ipa.ErrNoPermission))
+
+	var perr *ipa.PermissionDeniedError
+	require.True(errors.As(err, &perr))
+	assert.Equal("user_mod", perr.Method)
+	assert.Equal("jdoe", perr.Target)
+	assert.Equal("loginshell", perr.Attribute)
+	assert.Contains(err.Error(), "user_mod on jdoe attribute loginshell")
+
+	var ierr *ipa.IpaError
+	require.Truef(errors.As(err, &ierr), "The FreeIPA error should be available")
+	assert.Equal(2100, ierr.Code)
+	assert.Equal("ACIError", ierr.Name)
+
+	c = newTestClientStub(t, stubError(2100, "Insufficient access: Insufficient privilege to add entries"))
+	_, err = c.UserAdd(&ipa.User{Username: "jdoe", First: "John", Last: "Doe"}, false)
+	require.True(errors.As(err, &perr))
+	assert.Equal("user_add", perr.Method)
+	assert.Equal("jdoe", perr.Target)
+	assert.Empty(perr.Attribute)
+}
+
+func TestCheckAccess(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var method string
+	var options map[string]interface{}
+	result := stubResult(`{"result": {"uid": ["jdoe"], "attributelevelrights": {"uid": "rsc", "loginshell": "rscwo", "mail": "rscwo", "telephonenumber": "rsc"}}, "value": "jdoe", "summary": null}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		method = req.Method
+		options = req.Options
+		result(w, r)
+	})
+
+	access, err := c.CheckAccess("user", "jdoe", []string{"loginShell", "mail", "telephonenumber", "ipasshpubkey"})
+	require.NoError(err)
+	assert.Equal("user_show", method)
+	assert.Equal(true, options["rights"])
+	assert.Equal(map[string]bool{
+		"loginShell":      true,
+		"mail":            true,
+		"telephonenumber": false,
+		"ipasshpubkey":    false,
+	}, access)
+
+	c.DryRun(true)
+	_, err = c.CheckAccess("hostgroup", "web", []string{"description"})
+	require.NoErrorf(err, "Show methods should be sent in dry-run mode")
+	assert.Equal("hostgroup_show", method)
+
+	_, err = c.CheckAccess("", "jdoe", nil)
+	assert.Error(err)
+}
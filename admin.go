@@ -0,0 +1,342 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrProtectedUser is returned when offboarding a built-in or admin user
+var ErrProtectedUser = errors.New("user is protected")
+
+// Admin runs common multi-step administration workflows built on Client
+// methods. If a step fails the steps already taken are rolled back where
+// possible and a *WorkflowError describing every step is returned.
+type Admin struct {
+	client *Client
+
+	// Called after each step, including rollback steps. Optional
+	OnStep func(step Step)
+}
+
+// Returns new Admin running workflows with client c
+func NewAdmin(c *Client) *Admin {
+	return &Admin{client: c}
+}
+
+// Step is a single step of an Admin workflow
+type Step struct {
+	// Name of the step, for example "user_add" or "group_add_member"
+	Name string
+
+	// Entry the step acted on, for example the group name
+	Target string
+
+	// True if the step undoes an earlier step
+	Rollback bool
+
+	Err error
+}
+
+func (s Step) String() string {
+	var b strings.Builder
+	if s.Rollback {
+		b.WriteString("rollback ")
+	}
+	b.WriteString(s.Name)
+	if s.Target != "" {
+		b.WriteString(" " + s.Target)
+	}
+	if s.Err != nil {
+		b.WriteString(": " + s.Err.Error())
+	}
+
+	return b.String()
+}
+
+// WorkflowError is returned when an Admin workflow fails. Steps lists every
+// step taken including any rollback steps.
+type WorkflowError struct {
+	Workflow string
+	Steps    []Step
+	Err      error
+}
+
+func (e *WorkflowError) Error() string {
+	return fmt.Sprintf("ipa: %s failed: %s", e.Workflow, e.Err)
+}
+
+func (e *WorkflowError) Unwrap() error {
+	return e.Err
+}
+
+// Steps which failed to roll back
+func (e *WorkflowError) RollbackFailed() []Step {
+	failed := []Step{}
+	for _, s := range e.Steps {
+		if s.Rollback && s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+
+	return failed
+}
+
+type workflow struct {
+	name  string
+	admin *Admin
+	steps []Step
+	undo  []Step
+	funcs []func() error
+}
+
+func (a *Admin) workflow(name string) *workflow {
+	return &workflow{name: name, admin: a}
+}
+
+func (w *workflow) record(step Step) {
+	w.steps = append(w.steps, step)
+	if w.admin.OnStep != nil {
+		w.admin.OnStep(step)
+	}
+}
+
+// Run step do. If do succeeds and undo is not nil undo is run on rollback
+func (w *workflow) run(name, target string, do func() error, undo func() error) error {
+	err := do()
+	w.record(Step{Name: name, Target: target, Err: err})
+	if err == nil && undo != nil {
+		w.undo = append(w.undo, Step{Name: name, Target: target, Rollback: true})
+		w.funcs = append(w.funcs, undo)
+	}
+
+	return err
+}
+
+// Roll back the steps taken in reverse order and return a *WorkflowError
+func (w *workflow) fail(err error) error {
+	for i := len(w.undo) - 1; i >= 0; i-- {
+		step := w.undo[i]
+		step.Err = w.funcs[i]()
+		w.record(step)
+	}
+
+	return &WorkflowError{Workflow: w.name, Steps: w.steps, Err: err}
+}
+
+// OnboardSpec describes a new user for Admin.OnboardUser
+type OnboardSpec struct {
+	Username string
+	First    string
+	Last     string
+	Email    string
+	Shell    string
+
+	// SSH public keys in authorized_keys format
+	SSHKeys []string
+
+	// Groups to add the user to
+	Groups []string
+
+	// If set an OTP token is added for the user using OTPToken as the
+	// template, for example DefaultTOTPToken. Owner is set to Username
+	OTPToken *OTPToken
+}
+
+// OnboardResult is the result of Admin.OnboardUserDetailed
+type OnboardResult struct {
+	User *User
+
+	// Temporary password. The user must change it on first login
+	Password Secret
+
+	// The added OTP token including the URI used to enroll it, if requested
+	OTPToken *OTPToken
+
+	Steps []Step
+}
+
+// Create a user with a random temporary password, add the user to groups and
+// add an OTP token. Returns the new user and the temporary password. If any
+// step fails the token and user are removed.
+func (a *Admin) OnboardUser(spec OnboardSpec) (*User, string, error) {
+	res, err := a.OnboardUserDetailed(spec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res.User, string(res.Password.Reveal()), nil
+}
+
+// Onboard a user like OnboardUser and report the added OTP token and the
+// steps taken
+func (a *Admin) OnboardUserDetailed(spec OnboardSpec) (*OnboardResult, error) {
+	w := a.workflow("onboard " + spec.Username)
+
+	user := &User{
+		Username: spec.Username,
+		First:    spec.First,
+		Last:     spec.Last,
+		Email:    spec.Email,
+		Shell:    spec.Shell,
+	}
+	for _, k := range spec.SSHKeys {
+		key, err := NewSSHAuthorizedKey(k)
+		if err != nil {
+			return nil, w.fail(err)
+		}
+		user.AddSSHAuthorizedKey(key)
+	}
+
+	var rec *User
+	err := w.run("user_add", spec.Username, func() (err error) {
+		rec, err = a.client.UserAdd(user, true)
+		return err
+	}, func() error {
+		return a.client.UserDelete(false, true, spec.Username)
+	})
+	if err != nil {
+		return nil, w.fail(err)
+	}
+
+	for _, group := range spec.Groups {
+		group := group
+		err := w.run("group_add_member", group, func() error {
+			_, err := a.client.AddUserToGroup(group, spec.Username)
+			return err
+		}, func() error {
+			_, err := a.client.RemoveUserFromGroup(group, spec.Username)
+			return err
+		})
+		if err != nil {
+			return nil, w.fail(err)
+		}
+	}
+
+	result := &OnboardResult{Password: rec.RandomPassword}
+
+	if spec.OTPToken != nil {
+		token := *spec.OTPToken
+		token.Owner = spec.Username
+
+		err := w.run("otptoken_add", spec.Username, func() (err error) {
+			result.OTPToken, err = a.client.AddOTPToken(&token)
+			return err
+		}, func() error {
+			return a.client.RemoveOTPToken(result.OTPToken.UUID)
+		})
+		if err != nil {
+			return nil, w.fail(err)
+		}
+	}
+
+	// The user has been onboarded so only fall back to the record returned
+	// by user_add if fetching the updated record fails
+	result.User, err = a.client.UserShow(spec.Username)
+	if err != nil {
+		result.User = rec
+	}
+	result.Steps = w.steps
+
+	return result, nil
+}
+
+// OffboardOptions configures Admin.OffboardUser
+type OffboardOptions struct {
+	// Move the user to the Delete container after disabling
+	Preserve bool
+
+	// Permanently delete the user after disabling. Takes precedence over
+	// Preserve
+	Delete bool
+
+	// Keep the user's direct group memberships
+	KeepGroups bool
+
+	// Keep the user's OTP tokens enabled
+	KeepTokens bool
+}
+
+// Disable a user, disable their OTP tokens, remove them from groups and
+// optionally preserve or delete the user. The user is disabled first and
+// stays disabled if a later step fails, only the token and group changes are
+// rolled back. Protected users such as admin can not be offboarded.
+func (a *Admin) OffboardUser(username string, opts OffboardOptions) error {
+	w := a.workflow("offboard " + username)
+
+	user, err := a.client.UserShow(username)
+	if err != nil {
+		return w.fail(err)
+	}
+
+	if user.IsProtected() {
+		return w.fail(ErrProtectedUser)
+	}
+
+	err = w.run("user_disable", username, func() error {
+		_, err := a.client.UserDisable(username)
+		return err
+	}, nil)
+	if err != nil {
+		return w.fail(err)
+	}
+
+	if !opts.KeepTokens {
+		tokens, err := a.client.FetchOTPTokens(username)
+		if err != nil {
+			return w.fail(err)
+		}
+
+		for _, t := range tokens {
+			if !t.Enabled {
+				continue
+			}
+
+			uuid := t.UUID
+			err := w.run("otptoken_disable", uuid, func() error {
+				return a.client.DisableOTPToken(uuid)
+			}, func() error {
+				return a.client.EnableOTPToken(uuid)
+			})
+			if err != nil {
+				return w.fail(err)
+			}
+		}
+	}
+
+	if !opts.KeepGroups {
+		for _, group := range user.Groups {
+			if group == DefaultIPAUsersGroup {
+				continue
+			}
+
+			group := group
+			err := w.run("group_remove_member", group, func() error {
+				_, err := a.client.RemoveUserFromGroup(group, username)
+				return err
+			}, func() error {
+				_, err := a.client.AddUserToGroup(group, username)
+				return err
+			})
+			if err != nil {
+				return w.fail(err)
+			}
+		}
+	}
+
+	if opts.Delete || opts.Preserve {
+		preserve := !opts.Delete
+		err := w.run("user_del", username, func() error {
+			return a.client.UserDelete(preserve, true, username)
+		}, nil)
+		if err != nil {
+			return w.fail(err)
+		}
+	}
+
+	return nil
+}
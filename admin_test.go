@@ -0,0 +1,160 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a client whose server records the method and first argument of each
+// call. Calls on failTarget fail with a not found error
+func newAdminStub(t *testing.T, calls *[]string, failTarget string) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+
+		target := ""
+		if len(args) > 0 {
+			target = args[0]
+		}
+		*calls = append(*calls, req.Method+" "+target)
+
+		if failTarget != "" && target == failTarget {
+			stubError(4001, target+": not found")(w, r)
+			return
+		}
+
+		switch req.Method {
+		case "user_add":
+			stubResult(`{"result": {"uid": ["jdoe"], "randompassword": "temp123"}, "summary": null, "value": "jdoe"}`)(w, r)
+		case "user_show":
+			stubResult(fmt.Sprintf(`{"result": {"uid": [%q], "uidnumber": ["1001"], "memberof_group": ["ipausers", "staff", "dev"]}, "summary": null, "value": %q}`, target, target))(w, r)
+		case "group_add_member", "group_remove_member":
+			stubResult(`{"completed": 1, "failed": {"member": {"group": [], "user": []}}, "result": {"cn": ["staff"]}}`)(w, r)
+		case "otptoken_add":
+			stubResult(`{"result": {"ipatokenuniqueid": ["tok1"], "ipatokenowner": ["jdoe"], "uri": "otpauth://totp/jdoe"}, "summary": null, "value": "tok1"}`)(w, r)
+		case "otptoken_find":
+			stubResult(`{"result": [{"ipatokenuniqueid": ["tok1"]}, {"ipatokenuniqueid": ["tok2"], "ipatokendisabled": [true]}], "count": 2, "summary": null, "truncated": false}`)(w, r)
+		default:
+			stubResult(`{"result": true, "summary": null, "value": "jdoe"}`)(w, r)
+		}
+	})
+}
+
+func TestOnboardUser(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := []string{}
+	admin := ipa.NewAdmin(newAdminStub(t, &calls, ""))
+
+	user, password, err := admin.OnboardUser(ipa.OnboardSpec{
+		Username: "jdoe",
+		First:    "John",
+		Last:     "Doe",
+		Groups:   []string{"staff", "dev"},
+		OTPToken: ipa.DefaultTOTPToken,
+	})
+	require.NoError(err)
+	assert.Equal("jdoe", user.Username)
+	assert.Equal("1001", user.Uid)
+	assert.Equal("temp123", password)
+	assert.Emptyf(ipa.DefaultTOTPToken.Owner, "Token template should not be modified")
+	assert.Equal([]string{
+		"user_add jdoe",
+		"group_add_member staff",
+		"group_add_member dev",
+		"otptoken_add ",
+		"user_show jdoe",
+	}, calls)
+}
+
+func TestOnboardUserRollback(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := []string{}
+	admin := ipa.NewAdmin(newAdminStub(t, &calls, "dev"))
+
+	steps := []string{}
+	admin.OnStep = func(step ipa.Step) {
+		steps = append(steps, step.String())
+	}
+
+	_, _, err := admin.OnboardUser(ipa.OnboardSpec{
+		Username: "jdoe",
+		Groups:   []string{"staff", "dev"},
+	})
+	require.Error(err)
+
+	var werr *ipa.WorkflowError
+	require.ErrorAs(err, &werr)
+	assert.Empty(werr.RollbackFailed())
+	assert.Len(werr.Steps, 5)
+	assert.Equal([]string{
+		"user_add jdoe",
+		"group_add_member staff",
+		"group_add_member dev: ipa: error 4001 - dev: not found",
+		"rollback group_add_member staff",
+		"rollback user_add jdoe",
+	}, steps)
+	assert.Equal([]string{
+		"user_add jdoe",
+		"group_add_member staff",
+		"group_add_member dev",
+		"group_remove_member staff",
+		"user_del jdoe",
+	}, calls)
+}
+
+func TestOffboardUser(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := []string{}
+	admin := ipa.NewAdmin(newAdminStub(t, &calls, ""))
+
+	err := admin.OffboardUser("jdoe", ipa.OffboardOptions{Preserve: true})
+	require.NoError(err)
+	assert.Equal([]string{
+		"user_show jdoe",
+		"user_disable jdoe",
+		"otptoken_find ",
+		"otptoken_mod tok1",
+		"group_remove_member staff",
+		"group_remove_member dev",
+		"user_del jdoe",
+	}, calls)
+
+	calls = calls[:0]
+	admin = ipa.NewAdmin(newAdminStub(t, &calls, "dev"))
+	err = admin.OffboardUser("jdoe", ipa.OffboardOptions{Delete: true})
+	require.Error(err)
+	assert.Equalf([]string{
+		"user_show jdoe",
+		"user_disable jdoe",
+		"otptoken_find ",
+		"otptoken_mod tok1",
+		"group_remove_member staff",
+		"group_remove_member dev",
+		"group_add_member staff",
+		"otptoken_mod tok1",
+	}, calls, "User should stay disabled after rollback")
+}
+
+func TestOffboardProtectedUser(t *testing.T) {
+	calls := []string{}
+	admin := ipa.NewAdmin(newAdminStub(t, &calls, ""))
+
+	err := admin.OffboardUser("admin", ipa.OffboardOptions{})
+	assert.ErrorIs(t, err, ipa.ErrProtectedUser)
+	assert.Equal(t, []string{"user_show admin"}, calls)
+}
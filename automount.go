@@ -0,0 +1,207 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+
+	"github.com/tidwall/gjson"
+)
+
+// AutomountMap encapsulates automount map data returned from ipa
+// automountmap commands
+type AutomountMap struct {
+	DN          string `json:"dn"`
+	Name        string `json:"automountmapname"`
+	Description string `json:"description"`
+}
+
+// AutomountKey encapsulates automount key data returned from ipa
+// automountkey commands
+type AutomountKey struct {
+	DN          string `json:"dn"`
+	Key         string `json:"automountkey"`
+	Information string `json:"automountinformation"`
+	Description string `json:"description"`
+}
+
+func (m *AutomountMap) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid automount map record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	m.DN = res.Get("dn").String()
+	m.Name = res.Get("automountmapname.0").String()
+	m.Description = res.Get("description.0").String()
+
+	return nil
+}
+
+func (k *AutomountKey) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid automount key record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	k.DN = res.Get("dn").String()
+	k.Key = res.Get("automountkey.0").String()
+	k.Information = res.Get("automountinformation.0").String()
+	k.Description = res.Get("description.0").String()
+
+	return nil
+}
+
+// Add automount location
+func (c *Client) AutomountLocationAdd(location string) error {
+	_, err := c.rpc("automountlocation_add", []string{location}, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete automount location. This removes all maps and keys in the location
+func (c *Client) AutomountLocationDel(location string) error {
+	_, err := c.rpc("automountlocation_del", []string{location}, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Add automount map to location
+func (c *Client) AutomountMapAdd(location, mapName string) (*AutomountMap, error) {
+	res, err := c.rpc("automountmap_add", []string{location, mapName}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	mapRec := new(AutomountMap)
+	err = mapRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return mapRec, nil
+}
+
+// Delete automount map from location. This removes all keys in the map
+func (c *Client) AutomountMapDel(location, mapName string) error {
+	_, err := c.rpc("automountmap_del", []string{location, mapName}, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Find automount maps in location
+func (c *Client) AutomountMapFind(location string) ([]*AutomountMap, error) {
+	res, err := c.rpc("automountmap_find", []string{location}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make([]*AutomountMap, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, m := range data.Array() {
+		mapRec := new(AutomountMap)
+		err := mapRec.fromJSON([]byte(m.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		maps = append(maps, mapRec)
+	}
+
+	return maps, nil
+}
+
+// Add automount key to map in location. The key is the mount point relative
+// to the map, for example "*" for a wildcard key in auto.home, and info is
+// the mount information, for example "-fstype=nfs4 nfs.example.com:/home/&"
+func (c *Client) AutomountKeyAdd(location, mapName, key, info string) (*AutomountKey, error) {
+	options := Options{
+		"automountkey":         key,
+		"automountinformation": info,
+		"all":                  true,
+	}
+
+	res, err := c.rpc("automountkey_add", []string{location, mapName}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRec := new(AutomountKey)
+	err = keyRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyRec, nil
+}
+
+// Modify the mount information of automount key in map in location
+func (c *Client) AutomountKeyMod(location, mapName, key, info string) (*AutomountKey, error) {
+	options := Options{
+		"automountkey":            key,
+		"newautomountinformation": info,
+		"all":                     true,
+	}
+
+	res, err := c.rpc("automountkey_mod", []string{location, mapName}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRec := new(AutomountKey)
+	err = keyRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyRec, nil
+}
+
+// Delete automount key from map in location
+func (c *Client) AutomountKeyDel(location, mapName, key string) error {
+	options := Options{
+		"automountkey": key,
+	}
+
+	_, err := c.rpc("automountkey_del", []string{location, mapName}, options)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Find automount keys in map in location
+func (c *Client) AutomountKeyFind(location, mapName string) ([]*AutomountKey, error) {
+	res, err := c.rpc("automountkey_find", []string{location, mapName}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*AutomountKey, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, k := range data.Array() {
+		keyRec := new(AutomountKey)
+		err := keyRec.fromJSON([]byte(k.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, keyRec)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutomountKeyParams(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var params []interface{}
+	result := stubResult(`{"result": {"automountkey": ["*"], "automountinformation": ["-fstype=nfs4 nfs:/home/&"]}, "value": "*", "summary": null}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		params = req.Params
+		result(w, r)
+	})
+
+	key, err := c.AutomountKeyAdd("default", "auto.home", "*", "-fstype=nfs4 nfs:/home/&")
+	require.NoError(err)
+	assert.Equal("*", key.Key)
+	assert.Equal("-fstype=nfs4 nfs:/home/&", key.Information)
+
+	require.Len(params, 2)
+	assert.Equal([]interface{}{"default", "auto.home"}, params[0])
+	options := params[1].(map[string]interface{})
+	assert.Equal("*", options["automountkey"])
+	assert.Equal("-fstype=nfs4 nfs:/home/&", options["automountinformation"])
+}
+
+func TestAutomount(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	location := gofakeit.Username()
+	mapName := "auto.home"
+	info := "-fstype=nfs4,rw nfs.example.com:/home/&"
+
+	err = c.AutomountLocationAdd(location)
+	require.NoErrorf(err, "Failed to add automount location")
+
+	mapRec, err := c.AutomountMapAdd(location, mapName)
+	require.NoErrorf(err, "Failed to add automount map")
+	assert.Equal(mapName, mapRec.Name)
+
+	keyRec, err := c.AutomountKeyAdd(location, mapName, "*", info)
+	require.NoErrorf(err, "Failed to add automount key")
+	assert.Equal("*", keyRec.Key)
+	assert.Equal(info, keyRec.Information)
+
+	maps, err := c.AutomountMapFind(location)
+	require.NoErrorf(err, "Failed to find automount maps")
+	names := []string{}
+	for _, m := range maps {
+		names = append(names, m.Name)
+	}
+	assert.Contains(names, mapName)
+
+	keys, err := c.AutomountKeyFind(location, mapName)
+	require.NoErrorf(err, "Failed to find automount keys")
+	require.Lenf(keys, 1, "Wrong number of automount keys found")
+	assert.Equal("*", keys[0].Key)
+	assert.Equal(info, keys[0].Information)
+
+	info = "-fstype=nfs4,ro nfs.example.com:/home/&"
+	keyRec, err = c.AutomountKeyMod(location, mapName, "*", info)
+	require.NoErrorf(err, "Failed to modify automount key")
+	assert.Equal(info, keyRec.Information)
+
+	err = c.AutomountKeyDel(location, mapName, "*")
+	assert.NoErrorf(err, "Failed to delete automount key")
+
+	keys, err = c.AutomountKeyFind(location, mapName)
+	require.NoError(err)
+	assert.Empty(keys)
+
+	err = c.AutomountLocationDel(location)
+	assert.NoErrorf(err, "Failed to delete automount location")
+}
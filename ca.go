@@ -0,0 +1,52 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrTLSVerification is returned when the FreeIPA server certificate can not
+// be verified, for example because the IPA CA was rotated. The underlying
+// x509 or tls error is wrapped.
+var ErrTLSVerification = errors.New("tls certificate verification failed")
+
+// Only trust the IPA CA in /etc/ipa/ca.crt and not the system roots. Returns
+// an error if the IPA CA could not be loaded.
+func WithOnlyIPACA() ClientOption {
+	return func(t *http.Transport) error {
+		if ipaCAPool == nil {
+			return fmt.Errorf("ipa: no CA certificate loaded from %s", ipaCAFile)
+		}
+
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		} else {
+			t.TLSClientConfig = t.TLSClientConfig.Clone()
+		}
+		t.TLSClientConfig.RootCAs = ipaCAPool
+		return nil
+	}
+}
+
+// Wrap certificate verification errors from requests to host in
+// ErrTLSVerification. Other errors are returned unchanged
+func tlsError(host string, err error) error {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	if errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &hostnameErr) {
+		return fmt.Errorf("ipa: %w for %s, check the FreeIPA CA certificate in %s matches the CA which signed the server certificate: %w", ErrTLSVerification, host, ipaCAFile, err)
+	}
+
+	return err
+}
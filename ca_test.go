@@ -0,0 +1,56 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestTLSVerificationError(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Certificate signed by the httptest CA which is unrelated to the IPA CA
+	// and system roots
+	ts := httptest.NewTLSServer(stubResult(`{"result": null, "summary": "IPA server version 4.9.8. API version 2.251"}`))
+	t.Cleanup(ts.Close)
+
+	c := ipa.NewClient(ts.Listener.Addr().String(), "LOCAL")
+	t.Cleanup(func() { c.Close() })
+
+	_, err := c.Ping()
+	require.Error(err)
+	assert.Truef(errors.Is(err, ipa.ErrTLSVerification), "Unexpected error: %v", err)
+	var authorityErr x509.UnknownAuthorityError
+	assert.Truef(errors.As(err, &authorityErr), "The x509 error should be wrapped")
+
+	err = c.RemoteLogin("jdoe", "secret")
+	assert.True(errors.Is(err, ipa.ErrTLSVerification))
+
+	// Network errors are not verification errors
+	ts.Close()
+	_, err = c.Ping()
+	require.Error(err)
+	assert.False(errors.Is(err, ipa.ErrTLSVerification))
+}
+
+func TestWithOnlyIPACA(t *testing.T) {
+	c := ipa.NewClientCustomHttp("ipa.example.com", "LOCAL", &http.Client{Transport: &http.Transport{}})
+
+	// Without /etc/ipa/ca.crt there is no IPA CA to restrict to
+	err := c.Configure(ipa.WithOnlyIPACA())
+	if err == nil {
+		t.Skip("IPA CA installed")
+	}
+	assert.Contains(t, err.Error(), "/etc/ipa/ca.crt")
+}
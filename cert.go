@@ -0,0 +1,129 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// CertProfile encapsulates FreeIPA certificate profile data returned from ipa
+// certprofile commands
+type CertProfile struct {
+	DN          string `json:"dn"`
+	ID          string `json:"cn"`
+	Description string `json:"description"`
+
+	// If true certificates issued with the profile are stored in the CA
+	StoreIssued bool `json:"ipacertprofilestoreissued"`
+}
+
+func (p *CertProfile) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid certificate profile record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	p.DN = res.Get("dn").String()
+	p.ID = res.Get("cn.0").String()
+	p.Description = res.Get("description.0").String()
+	p.StoreIssued = parseBool(res.Get("ipacertprofilestoreissued"))
+
+	return nil
+}
+
+// Find all certificate profiles. Servers without a CA return
+// ErrCommandNotSupported.
+func (c *Client) CertProfileFind() ([]*CertProfile, error) {
+	res, err := c.rpc("certprofile_find", []string{""}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*CertProfile, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, p := range data.Array() {
+		profile := new(CertProfile)
+		err := profile.fromJSON([]byte(p.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// Fetch certificate profile by id, for example caIPAserviceCert
+func (c *Client) CertProfileShow(id string) (*CertProfile, error) {
+	if err := checkArgs("certprofile_show", id); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc("certprofile_show", []string{id}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	profile := new(CertProfile)
+	err = profile.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// Returns true if the ACME service of the FreeIPA CA is enabled. There is no
+// JSON-RPC command for the ACME status so, like ipa-acme-manage status, the
+// ACME directory proxied by the FreeIPA server is fetched: it is served when
+// ACME is enabled. Servers without ACME support, before FreeIPA 4.9 or
+// without a CA, return ErrCommandNotSupported.
+func (c *Client) ACMEEnabled() (bool, error) {
+	if c.isClosed() {
+		return false, ErrClientClosed
+	}
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/acme/directory", c.host), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	trace := c.sampleTrace()
+	start := time.Now()
+	defer c.recordCall("acme_directory", nil, start)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		trace.trace("acme_directory", req, nil, nil, nil, start, err)
+		return false, tlsError(c.host, err)
+	}
+	defer res.Body.Close()
+
+	trace.traceResponse("acme_directory", req, nil, res, start)
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, &CommandNotSupportedError{Method: "acme"}
+	case http.StatusServiceUnavailable:
+		// Dogtag rejects ACME requests while the service is disabled
+		return false, nil
+	}
+
+	return false, fmt.Errorf("ipa: ACME status check failed with HTTP status code: %d", res.StatusCode)
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestCertProfiles(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	result := stubResult(`{"count": 2, "truncated": false, "summary": "2 profiles matched", "result": [
+		{"dn": "cn=caIPAserviceCert,cn=certprofiles,cn=ca,dc=local", "cn": ["caIPAserviceCert"], "description": ["Standard profile for network services"], "ipacertprofilestoreissued": [true]},
+		{"dn": "cn=acmeIPAServerCert,cn=certprofiles,cn=ca,dc=local", "cn": ["acmeIPAServerCert"], "description": ["ACME IPA service certificate profile"], "ipacertprofilestoreissued": ["FALSE"]}]}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		result(w, r)
+	})
+
+	profiles, err := c.CertProfileFind()
+	require.NoError(err)
+	require.Len(profiles, 2)
+	assert.Equal("caIPAserviceCert", profiles[0].ID)
+	assert.Equal("Standard profile for network services", profiles[0].Description)
+	assert.True(profiles[0].StoreIssued)
+	assert.Equal("acmeIPAServerCert", profiles[1].ID)
+	assert.False(profiles[1].StoreIssued)
+
+	result = stubResult(`{"result": {"dn": "cn=caIPAserviceCert,cn=certprofiles,cn=ca,dc=local", "cn": ["caIPAserviceCert"], "ipacertprofilestoreissued": ["TRUE"]}, "summary": null, "value": "caIPAserviceCert"}`)
+	profile, err := c.CertProfileShow("caIPAserviceCert")
+	require.NoError(err)
+	assert.Equal("caIPAserviceCert", profile.ID)
+	assert.True(profile.StoreIssued)
+
+	_, err = c.CertProfileShow("")
+	assert.ErrorIs(err, ipa.ErrEmptyArgument)
+
+	c = newTestClientStub(t, stubError(905, "unknown command 'certprofile_find'"))
+	_, err = c.CertProfileFind()
+	assert.ErrorIs(err, ipa.ErrCommandNotSupported)
+}
+
+func TestACMEEnabled(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var path string
+	status := http.StatusOK
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(status)
+		w.Write([]byte(`{"newNonce": "https://ipa-ca.local/acme/new-nonce"}`))
+	})
+
+	enabled, err := c.ACMEEnabled()
+	require.NoError(err)
+	assert.True(enabled)
+	assert.Equal("/acme/directory", path)
+
+	status = http.StatusServiceUnavailable
+	enabled, err = c.ACMEEnabled()
+	require.NoError(err)
+	assert.False(enabled)
+
+	status = http.StatusNotFound
+	_, err = c.ACMEEnabled()
+	assert.ErrorIsf(err, ipa.ErrCommandNotSupported, "Servers without ACME should not be supported")
+
+	status = http.StatusInternalServerError
+	_, err = c.ACMEEnabled()
+	assert.Error(err)
+	assert.False(errors.Is(err, ipa.ErrCommandNotSupported))
+
+	require.NoError(c.Close())
+	_, err = c.ACMEEnabled()
+	assert.ErrorIs(err, ipa.ErrClientClosed)
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+// Returns true if the FreeIPA server has command name, for example
+// "stageuser_add" or "user_add_passkey". The result is cached for the
+// lifetime of the client. Use this to feature-detect commands added in newer
+// FreeIPA versions instead of matching error messages.
+func (c *Client) SupportsCommand(name string) (bool, error) {
+	c.mu.RLock()
+	supported, ok := c.commands[name]
+	c.mu.RUnlock()
+	if ok {
+		return supported, nil
+	}
+
+	_, err := c.rpc("command_show", []string{name}, nil)
+	if err != nil {
+		if IsNotFound(err) {
+			c.setCommandSupported(name, false)
+			return false, nil
+		}
+		return false, err
+	}
+
+	c.setCommandSupported(name, true)
+	return true, nil
+}
+
+func (c *Client) setCommandSupported(name string, supported bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.commands == nil {
+		c.commands = make(map[string]bool)
+	}
+	c.commands[name] = supported
+}
+
+// Parameter classes reported by CommandMetadata. Other classes, such as StrEnum
+// or Password, are reported as returned by FreeIPA.
+const (
+	ParamStr      = "Str"
+	ParamInt      = "Int"
+	ParamBool     = "Bool"
+	ParamFlag     = "Flag"
+	ParamDateTime = "DateTime"
+)
+
+// ParamMeta describes a FreeIPA command argument or option
+type ParamMeta struct {
+	Name       string
+	CLIName    string
+	Label      string
+	Doc        string
+	Type       string
+	Required   bool
+	MultiValue bool
+
+	// Default values formatted as strings, nil if there is no default
+	Default []string
+
+	// Allowed values of enumerated parameters
+	Values []string
+
+	// Parameter flags, for example "no_create" or "virtual_attribute"
+	Flags []string
+
+	Raw json.RawMessage
+}
+
+// CommandMeta describes the arguments and options of a FreeIPA command
+type CommandMeta struct {
+	Name    string
+	Doc     string
+	Args    []*ParamMeta
+	Options []*ParamMeta
+}
+
+// Returns the option called name, or nil if the command has no such option
+func (m *CommandMeta) Option(name string) *ParamMeta {
+	for _, p := range m.Options {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+func (m *CommandMeta) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid command metadata json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	m.Name = res.Get("name").String()
+	m.Doc = res.Get("doc").String()
+
+	var err error
+	m.Args, err = parseParams(res.Get("takes_args"))
+	if err != nil {
+		return err
+	}
+	m.Options, err = parseParams(res.Get("takes_options"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseParams(res gjson.Result) ([]*ParamMeta, error) {
+	var params []*ParamMeta
+	for _, p := range res.Array() {
+		if !p.IsObject() {
+			return nil, fmt.Errorf("invalid param metadata: %s", p.Raw)
+		}
+
+		param := &ParamMeta{
+			Name:       p.Get("name").String(),
+			CLIName:    p.Get("cli_name").String(),
+			Label:      p.Get("label").String(),
+			Doc:        p.Get("doc").String(),
+			Type:       p.Get("class").String(),
+			Required:   parseBool(p.Get("required")),
+			MultiValue: parseBool(p.Get("multivalue")),
+			Values:     parseStrings(p, "values"),
+			Flags:      parseStrings(p, "flags"),
+			Raw:        json.RawMessage(p.Raw),
+		}
+
+		def := p.Get("default")
+		switch {
+		case !def.Exists() || def.Type == gjson.Null:
+		case def.IsArray():
+			param.Default = parseStrings(p, "default")
+		default:
+			param.Default = []string{def.String()}
+		}
+
+		params = append(params, param)
+	}
+
+	return params, nil
+}
+
+// Returns the arguments and options of FreeIPA command name, for example
+// "user_add", fetched with json_metadata. Metadata is cached for the lifetime
+// of the client. Returns a CommandNotSupportedError if the server does not
+// have the command.
+func (c *Client) CommandMetadata(name string) (*CommandMeta, error) {
+	c.mu.RLock()
+	meta, ok := c.commandMeta[name]
+	c.mu.RUnlock()
+	if ok {
+		return meta, nil
+	}
+
+	res, err := c.rpc("json_metadata", []string{}, Options{"command": name})
+	if err != nil {
+		if IsNotFound(err) {
+			c.setCommandSupported(name, false)
+			return nil, &CommandNotSupportedError{Method: name}
+		}
+		return nil, err
+	}
+
+	var raw gjson.Result
+	gjson.GetBytes(res.Result.raw, "commands").ForEach(func(key, value gjson.Result) bool {
+		if key.String() == name {
+			raw = value
+			return false
+		}
+		return true
+	})
+	if !raw.Exists() {
+		c.setCommandSupported(name, false)
+		return nil, &CommandNotSupportedError{Method: name}
+	}
+
+	meta = new(CommandMeta)
+	if err := meta.fromJSON([]byte(raw.Raw)); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.commandMeta == nil {
+		c.commandMeta = make(map[string]*CommandMeta)
+	}
+	c.commandMeta[name] = meta
+	c.mu.Unlock()
+	c.setCommandSupported(name, true)
+
+	return meta, nil
+}
+
+// Returns the sorted names of all commands on the FreeIPA server, fetched with
+// command_find. The list is cached for the lifetime of the client.
+func (c *Client) ListCommands() ([]string, error) {
+	c.mu.RLock()
+	cached := c.commandList
+	c.mu.RUnlock()
+	if cached != nil {
+		return append([]string(nil), cached...), nil
+	}
+
+	res, err := c.rpc("command_find", []string{""}, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	gjson.ParseBytes(res.Result.Data).ForEach(func(key, value gjson.Result) bool {
+		if name := value.Get("name").String(); name != "" {
+			names = append(names, name)
+		}
+		return true
+	})
+	sort.Strings(names)
+
+	c.mu.Lock()
+	c.commandList = names
+	if c.commands == nil {
+		c.commands = make(map[string]bool)
+	}
+	for _, name := range names {
+		c.commands[name] = true
+	}
+	c.mu.Unlock()
+
+	return append([]string(nil), names...), nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestSupportsCommand(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		req := stubDecode(r)
+		args := req.Args
+
+		switch {
+		case req.Method == "command_show" && args[0] == "user_add":
+			stubResult(`{"result": {"name": "user_add", "version": "1"}, "summary": null, "value": "user_add"}`)(w, r)
+		case req.Method == "command_show":
+			stubError(4001, args[0]+": command not found")(w, r)
+		default:
+			stubError(905, "unknown command '"+req.Method+"'")(w, r)
+		}
+	})
+
+	supported, err := c.SupportsCommand("user_add")
+	require.NoError(err)
+	assert.True(supported)
+
+	supported, err = c.SupportsCommand("stageuser_add")
+	require.NoError(err)
+	assert.False(supported)
+
+	_, err = c.SupportsCommand("user_add")
+	require.NoError(err)
+	assert.Equalf(2, calls, "Results should be cached")
+
+	_, err = c.UserAddPasskey("jdoe", "passkey:abc")
+	require.ErrorIs(err, ipa.ErrCommandNotSupported)
+	var cerr *ipa.CommandNotSupportedError
+	require.ErrorAs(err, &cerr)
+	assert.Equal("user_add_passkey", cerr.Method)
+
+	supported, err = c.SupportsCommand("user_add_passkey")
+	require.NoError(err)
+	assert.Falsef(supported, "Unknown command errors should be cached")
+	assert.Equal(3, calls)
+}
+
+const testUserAddMetadata = `{"objects": {}, "methods": {}, "commands": {"user_add": {
+	"name": "user_add",
+	"doc": "Add a new user.",
+	"takes_args": [
+		{"class": "Str", "name": "uid", "cli_name": "login", "label": "User login", "required": true, "multivalue": false, "primary_key": true}
+	],
+	"takes_options": [
+		{"class": "Str", "name": "givenname", "cli_name": "first", "label": "First name", "required": true, "multivalue": false},
+		{"class": "Int", "name": "uidnumber", "cli_name": "uid", "label": "UID", "required": false, "multivalue": false, "flags": ["no_update"]},
+		{"class": "Str", "name": "mail", "cli_name": "email", "required": false, "multivalue": true, "default": null},
+		{"class": "Bool", "name": "nsaccountlock", "cli_name": "disabled", "required": false, "multivalue": false, "default": false},
+		{"class": "Flag", "name": "random", "cli_name": "random", "required": false, "multivalue": false, "default": false},
+		{"class": "DateTime", "name": "krbprincipalexpiration", "cli_name": "principal_expiration", "required": false, "multivalue": false},
+		{"class": "StrEnum", "name": "ipauserauthtype", "cli_name": "user_auth_type", "multivalue": true, "values": ["password", "radius", "otp"], "default": ["password"]}
+	]
+}}}`
+
+func TestCommandMetadata(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		req := stubDecode(r)
+		options := req.Options
+
+		switch {
+		case req.Method == "json_metadata" && options["command"] == "user_add":
+			stubResult(testUserAddMetadata)(w, r)
+		case req.Method == "json_metadata":
+			stubResult(`{"objects": {}, "methods": {}, "commands": {}}`)(w, r)
+		default:
+			stubError(905, "unknown command '"+req.Method+"'")(w, r)
+		}
+	})
+
+	meta, err := c.CommandMetadata("user_add")
+	require.NoError(err)
+	assert.Equal("user_add", meta.Name)
+	assert.Equal("Add a new user.", meta.Doc)
+	require.Len(meta.Args, 1)
+	assert.Equal("uid", meta.Args[0].Name)
+	assert.True(meta.Args[0].Required)
+	require.Len(meta.Options, 7)
+
+	givenname := meta.Option("givenname")
+	require.NotNil(givenname)
+	assert.Equal(ipa.ParamStr, givenname.Type)
+	assert.Equal("first", givenname.CLIName)
+	assert.True(givenname.Required)
+	assert.Nil(givenname.Default)
+
+	uidnumber := meta.Option("uidnumber")
+	require.NotNil(uidnumber)
+	assert.Equal(ipa.ParamInt, uidnumber.Type)
+	assert.False(uidnumber.Required)
+	assert.Equal([]string{"no_update"}, uidnumber.Flags)
+
+	assert.True(meta.Option("mail").MultiValue)
+	assert.Nil(meta.Option("mail").Default)
+	assert.Equal(ipa.ParamBool, meta.Option("nsaccountlock").Type)
+	assert.Equal(ipa.ParamFlag, meta.Option("random").Type)
+	assert.Equal([]string{"false"}, meta.Option("random").Default)
+	assert.Equal(ipa.ParamDateTime, meta.Option("krbprincipalexpiration").Type)
+
+	authType := meta.Option("ipauserauthtype")
+	require.NotNil(authType)
+	assert.Equal("StrEnum", authType.Type)
+	assert.Equal([]string{"password", "radius", "otp"}, authType.Values)
+	assert.Equal([]string{"password"}, authType.Default)
+	assert.Nil(meta.Option("nosuchoption"))
+
+	_, err = c.CommandMetadata("user_add")
+	require.NoError(err)
+	assert.Equalf(1, calls, "Metadata should be cached")
+
+	supported, err := c.SupportsCommand("user_add")
+	require.NoError(err)
+	assert.True(supported)
+	assert.Equal(1, calls)
+
+	_, err = c.CommandMetadata("user_add_passkey")
+	require.ErrorIs(err, ipa.ErrCommandNotSupported)
+	supported, err = c.SupportsCommand("user_add_passkey")
+	require.NoError(err)
+	assert.False(supported)
+	assert.Equal(2, calls)
+}
+
+func TestListCommands(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		req := stubDecode(r)
+		options := req.Options
+
+		if req.Method != "command_find" {
+			stubError(905, "unknown command '"+req.Method+"'")(w, r)
+			return
+		}
+		if _, ok := options["sizelimit"]; ok {
+			stubError(3005, "Unknown option: sizelimit")(w, r)
+			return
+		}
+		stubResult(`{"result": [
+			{"name": "user_show", "version": "1", "full_name": "user_show/1"},
+			{"name": "user_add", "version": "1", "full_name": "user_add/1"},
+			{"name": "group_add", "version": "1", "full_name": "group_add/1"}
+		], "count": 3, "truncated": false, "summary": "3 commands matched"}`)(w, r)
+	})
+
+	names, err := c.ListCommands()
+	require.NoError(err)
+	assert.Equal([]string{"group_add", "user_add", "user_show"}, names)
+
+	names[0] = "changed"
+	names, err = c.ListCommands()
+	require.NoError(err)
+	assert.Equal([]string{"group_add", "user_add", "user_show"}, names)
+	assert.Equalf(1, calls, "Command list should be cached")
+
+	supported, err := c.SupportsCommand("group_add")
+	require.NoError(err)
+	assert.True(supported)
+	assert.Equal(1, calls)
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ComplianceStatus is the OTP token compliance of a group member
+type ComplianceStatus int
+
+const (
+	// Member has at least one enabled OTP token
+	ComplianceOK ComplianceStatus = iota
+
+	// Member has no OTP tokens
+	ComplianceNoTokens
+
+	// Member has OTP tokens but all of them are disabled
+	ComplianceDisabledOnly
+
+	// Member is exempt, their tokens are not looked up
+	ComplianceExempt
+
+	// Member's tokens could not be looked up, see ComplianceResult.Err
+	ComplianceFailed
+)
+
+func (s ComplianceStatus) String() string {
+	switch s {
+	case ComplianceOK:
+		return "ok"
+	case ComplianceNoTokens:
+		return "no_tokens"
+	case ComplianceDisabledOnly:
+		return "disabled_only"
+	case ComplianceExempt:
+		return "exempt"
+	case ComplianceFailed:
+		return "failed"
+	}
+
+	return "unknown"
+}
+
+// ComplianceOptions configures TokenComplianceCheck
+type ComplianceOptions struct {
+	// Usernames exempt from the policy. Matched case-insensitively
+	Exempt []string
+
+	// Number of members whose tokens are looked up concurrently. Defaults
+	// to 1
+	Concurrency int
+
+	// Maximum otptoken_find requests per second across all workers, at
+	// most 1e9. 0 is unlimited
+	QPS float64
+
+	// If set, each member's result is passed to Each in username order
+	// instead of being collected in the report, so memory use does not grow
+	// with the size of the group. An error returned by Each stops the check
+	// and is returned.
+	Each func(r *ComplianceResult) error
+}
+
+// ComplianceResult is the OTP token compliance of a single group member
+type ComplianceResult struct {
+	Username       string
+	Status         ComplianceStatus
+	EnabledTokens  int
+	DisabledTokens int
+
+	// Lookup error if Status is ComplianceFailed
+	Err error
+}
+
+// ComplianceReport is the result of TokenComplianceCheck. The member lists
+// are sorted and are left empty if ComplianceOptions.Each is set; the counts
+// are always set.
+type ComplianceReport struct {
+	Group string
+
+	// Number of effective members of the group and of members in each
+	// status
+	Members      int
+	Compliant    int
+	NoTokens     int
+	DisabledOnly int
+	Exempt       int
+	Failed       int
+
+	// Members violating the policy: members without tokens and members
+	// whose tokens are all disabled
+	NoTokensMembers     []string
+	DisabledOnlyMembers []string
+
+	// Exempt members
+	ExemptMembers []string
+
+	// Members whose tokens could not be looked up and the error
+	FailedMembers map[string]error
+}
+
+// Returns true if every member of the group is compliant or exempt and all
+// lookups succeeded
+func (r *ComplianceReport) OK() bool {
+	return r.Members == r.Compliant+r.Exempt
+}
+
+// Check that every effective member of group, direct or through nested
+// groups, has at least one enabled OTP token. Members are expanded with
+// EffectiveGroupMembers and the tokens of each member are then looked up
+// with otptoken_find, by opts.Concurrency workers limited to opts.QPS
+// requests per second. A failed token lookup does not stop the check: the
+// member is reported as failed and the remaining members are checked, so
+// callers must check Failed or OK before treating the group as compliant.
+// Errors expanding the group are returned.
+func (c *Client) TokenComplianceCheck(group string, opts ComplianceOptions) (*ComplianceReport, error) {
+	if err := checkArgs("group_show", group); err != nil {
+		return nil, err
+	}
+
+	limit, stop, err := newRateLimit(opts.QPS)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	members, err := c.EffectiveGroupMembers(group)
+	if err != nil {
+		return nil, err
+	}
+
+	exempt := make(map[string]bool, len(opts.Exempt))
+	for _, u := range opts.Exempt {
+		exempt[strings.ToLower(u)] = true
+	}
+
+	report := &ComplianceReport{
+		Group:               group,
+		Members:             len(members),
+		NoTokensMembers:     []string{},
+		DisabledOnlyMembers: []string{},
+		ExemptMembers:       []string{},
+		FailedMembers:       make(map[string]error),
+	}
+
+	// Members are looked up by concurrent workers and reported in order
+	err = runOrdered(len(members), opts.Concurrency, func(i int, done <-chan struct{}) func() error {
+		r := &ComplianceResult{Username: members[i], Status: ComplianceExempt}
+		if !exempt[strings.ToLower(members[i])] {
+			r = c.tokenCompliance(members[i], limit, done)
+		}
+
+		return func() error {
+			report.add(r, opts.Each == nil)
+			if opts.Each != nil {
+				return opts.Each(r)
+			}
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.NoTokensMembers)
+	sort.Strings(report.DisabledOnlyMembers)
+	sort.Strings(report.ExemptMembers)
+
+	return report, nil
+}
+
+// Count r in the report and, if collect is set, add it to the member lists
+func (r *ComplianceReport) add(res *ComplianceResult, collect bool) {
+	switch res.Status {
+	case ComplianceOK:
+		r.Compliant++
+	case ComplianceNoTokens:
+		r.NoTokens++
+		if collect {
+			r.NoTokensMembers = append(r.NoTokensMembers, res.Username)
+		}
+	case ComplianceDisabledOnly:
+		r.DisabledOnly++
+		if collect {
+			r.DisabledOnlyMembers = append(r.DisabledOnlyMembers, res.Username)
+		}
+	case ComplianceExempt:
+		r.Exempt++
+		if collect {
+			r.ExemptMembers = append(r.ExemptMembers, res.Username)
+		}
+	case ComplianceFailed:
+		r.Failed++
+		if collect {
+			r.FailedMembers[res.Username] = res.Err
+		}
+	}
+}
+
+// Look up the OTP tokens owned by username, waiting on limit if set
+func (c *Client) tokenCompliance(username string, limit <-chan time.Time, done <-chan struct{}) *ComplianceResult {
+	result := &ComplianceResult{Username: username}
+
+	if !waitRateLimit(limit, done) {
+		result.Status = ComplianceFailed
+		result.Err = errors.New("compliance check cancelled")
+		return result
+	}
+
+	search := NewOTPTokenSearch().Owner(username).SizeLimit(0)
+	res, err := c.rpc("otptoken_find", []string{}, search.toOptions())
+	if err == nil && res.Result.Truncated {
+		err = ErrTruncated
+	}
+	var tokens []*OTPToken
+	if err == nil {
+		tokens, err = parseOTPTokenList(res.Result.Data)
+	}
+	if err != nil {
+		result.Status = ComplianceFailed
+		result.Err = err
+		return result
+	}
+
+	for _, tok := range tokens {
+		if tok.Enabled {
+			result.EnabledTokens++
+		} else {
+			result.DisabledTokens++
+		}
+	}
+
+	switch {
+	case result.EnabledTokens > 0:
+		result.Status = ComplianceOK
+	case result.DisabledTokens > 0:
+		result.Status = ComplianceDisabledOnly
+	default:
+		result.Status = ComplianceNoTokens
+	}
+
+	return result
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newComplianceStub(t *testing.T) (*ipa.Client, func() []string) {
+	var mu sync.Mutex
+	var owners []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+
+		switch req.Method {
+		case "group_show":
+			stubResult(`{"result": {"cn": ["vpn-users"], "member_user": ["alice", "bob", "carol", "svc-backup"],
+				"memberindirect_user": ["dave", "Alice"]}, "value": "vpn-users"}`)(w, r)
+		case "otptoken_find":
+			var opts struct {
+				Owner string `json:"ipatokenowner"`
+			}
+			json.Unmarshal(req.Params[1], &opts)
+			mu.Lock()
+			owners = append(owners, opts.Owner)
+			mu.Unlock()
+
+			switch opts.Owner {
+			case "alice":
+				stubResult(`{"count": 2, "result": [{"ipatokenuniqueid": ["a1"], "ipatokendisabled": [true]}, {"ipatokenuniqueid": ["a2"]}], "truncated": false}`)(w, r)
+			case "carol":
+				stubResult(`{"count": 1, "result": [{"ipatokenuniqueid": ["c1"], "ipatokendisabled": [true]}], "truncated": false}`)(w, r)
+			case "dave":
+				stubError(4203, "Insufficient access")(w, r)
+			default:
+				stubResult(`{"count": 0, "result": [], "truncated": false}`)(w, r)
+			}
+		}
+	})
+
+	return c, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, owners...)
+	}
+}
+
+func TestTokenComplianceCheck(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, owners := newComplianceStub(t)
+	report, err := c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{
+		Exempt:      []string{"SVC-Backup"},
+		Concurrency: 3,
+		QPS:         1000,
+	})
+	require.NoError(err)
+
+	assert.Equal("vpn-users", report.Group)
+	assert.Equal(5, report.Members)
+	assert.Equal(1, report.Compliant)
+	assert.Equal([]string{"bob"}, report.NoTokensMembers)
+	assert.Equal([]string{"carol"}, report.DisabledOnlyMembers)
+	assert.Equal([]string{"svc-backup"}, report.ExemptMembers)
+	require.Contains(report.FailedMembers, "dave")
+	assert.Contains(report.FailedMembers["dave"].Error(), "Insufficient access")
+	assert.Equal(1, report.Failed)
+	assert.False(report.OK())
+	assert.ElementsMatchf([]string{"alice", "bob", "carol", "dave"}, owners(), "Exempt members should not be looked up")
+}
+
+func TestTokenComplianceCheckEach(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, _ := newComplianceStub(t)
+
+	var seen []string
+	var statuses []ipa.ComplianceStatus
+	report, err := c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{
+		Exempt:      []string{"svc-backup"},
+		Concurrency: 2,
+		Each: func(r *ipa.ComplianceResult) error {
+			seen = append(seen, r.Username)
+			statuses = append(statuses, r.Status)
+			return nil
+		},
+	})
+	require.NoError(err)
+	assert.Equal([]string{"alice", "bob", "carol", "dave", "svc-backup"}, seen)
+	assert.Equal([]ipa.ComplianceStatus{ipa.ComplianceOK, ipa.ComplianceNoTokens, ipa.ComplianceDisabledOnly,
+		ipa.ComplianceFailed, ipa.ComplianceExempt}, statuses)
+	assert.Emptyf(report.NoTokensMembers, "Streamed results should not be collected")
+	assert.Empty(report.FailedMembers)
+	assert.Equal(1, report.NoTokens)
+	assert.Equal(1, report.Failed)
+
+	stop := errors.New("stop")
+	_, err = c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{
+		Each: func(r *ipa.ComplianceResult) error { return stop },
+	})
+	assert.Equal(stop, err)
+
+	c = newTestClientStub(t, stubError(4001, "vpn-users: group not found"))
+	_, err = c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{})
+	assert.True(ipa.IsNotFound(err))
+
+	_, err = c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{QPS: 2e9})
+	assert.Errorf(err, "QPS above one request per nanosecond should be rejected")
+	assert.False(ipa.IsNotFound(err))
+
+	_, err = c.TokenComplianceCheck("vpn-users", ipa.ComplianceOptions{QPS: 1e-10})
+	assert.Errorf(err, "QPS with an interval overflowing time.Duration should be rejected")
+}
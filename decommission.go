@@ -0,0 +1,124 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+)
+
+// Steps run by HostDecommission, in order
+const (
+	DecommissionHostGroup    = "remove_hostgroup"
+	DecommissionCertificates = "revoke_certificates"
+	DecommissionDelete       = "delete_host"
+)
+
+// DecommissionOptions configures HostDecommission
+type DecommissionOptions struct {
+	// Remove the host's DNS records when it is deleted
+	UpdateDNS bool
+
+	// Leave the host in place after removing its host group memberships and
+	// revoking its certificates
+	KeepHost bool
+}
+
+// DecommissionStep is the outcome of a single HostDecommission step
+type DecommissionStep struct {
+	Step string
+
+	// Host group the host was removed from, for DecommissionHostGroup steps
+	Target string
+
+	// Set if the step was not run, with the reason in Note
+	Skipped bool
+	Note    string
+
+	Err error
+}
+
+// DecommissionReport is the result of HostDecommission
+type DecommissionReport struct {
+	Host string
+
+	// Steps in the order they were run
+	Steps []*DecommissionStep
+}
+
+// Returns true if every step succeeded or was skipped
+func (r *DecommissionReport) OK() bool {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the steps which failed
+func (r *DecommissionReport) Failed() []*DecommissionStep {
+	failed := []*DecommissionStep{}
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+
+	return failed
+}
+
+// Decommission host fqdn: remove it from its direct host groups, revoke its
+// certificates and delete it. Steps continue after a failure so as much of
+// the host as possible is cleaned up, except that the host is not deleted if
+// its certificates could not be revoked, as the delete would lose track of
+// them. Certificates are revoked with host_disable, which also removes the
+// host keytab; only certificates stored on the host entry are found. The
+// outcome of each step is in the report. An error is only returned if the
+// host could not be fetched, in which case nothing was changed.
+func (c *Client) HostDecommission(fqdn string, opts DecommissionOptions) (*DecommissionReport, error) {
+	host, err := c.HostShow(fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DecommissionReport{Host: host.FQDN}
+	step := func(name, target string, err error) *DecommissionStep {
+		s := &DecommissionStep{Step: name, Target: target, Err: err}
+		report.Steps = append(report.Steps, s)
+		return s
+	}
+
+	// Indirect host groups are inherited from the direct ones
+	for _, group := range host.HostGroups {
+		_, err := c.HostGroupRemoveMember(group, host.FQDN)
+		step(DecommissionHostGroup, group, err)
+	}
+
+	certs := step(DecommissionCertificates, "", nil)
+	if len(host.Certificates) == 0 {
+		certs.Skipped = true
+		certs.Note = "host has no certificates"
+	} else {
+		certs.Err = c.HostDisable(host.FQDN)
+		if certs.Err == nil {
+			certs.Note = fmt.Sprintf("%d certificates revoked with host_disable", len(host.Certificates))
+		}
+	}
+
+	del := step(DecommissionDelete, "", nil)
+	switch {
+	case opts.KeepHost:
+		del.Skipped = true
+		del.Note = "KeepHost is set"
+	case certs.Err != nil:
+		del.Skipped = true
+		del.Note = "certificates were not revoked"
+	default:
+		del.Err = c.HostDel(host.FQDN, HostDelOptions{UpdateDNS: opts.UpdateDNS})
+	}
+
+	return report, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+type decommissionCall struct {
+	method  string
+	params  []string
+	options map[string]interface{}
+}
+
+func newDecommissionStub(t *testing.T, certs bool, failDisable bool) (*ipa.Client, *[]decommissionCall) {
+	var calls []decommissionCall
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		call := decommissionCall{method: req.Method, options: map[string]interface{}{}}
+		json.Unmarshal(req.Params[0], &call.params)
+		json.Unmarshal(req.Params[1], &call.options)
+		calls = append(calls, call)
+
+		switch req.Method {
+		case "host_show":
+			cert := ""
+			if certs {
+				cert = `, "usercertificate": [{"__base64__": "MIIBAA=="}, {"__base64__": "MIIBAQ=="}]`
+			}
+			stubResult(`{"result": {"fqdn": ["web.local"], "memberof_hostgroup": ["webservers", "dmz"],
+				"memberofindirect_hostgroup": ["all"]`+cert+`}, "value": "web.local"}`)(w, r)
+		case "hostgroup_remove_member":
+			if call.params[0] == "dmz" {
+				stubError(4203, "Insufficient access: dmz")(w, r)
+				return
+			}
+			stubResult(`{"completed": 1, "failed": {"member": {"host": []}}, "result": {"cn": ["webservers"]}}`)(w, r)
+		case "host_disable":
+			if failDisable {
+				stubError(4203, "Insufficient access: host_disable")(w, r)
+				return
+			}
+			stubResult(`{"result": true, "value": "web.local"}`)(w, r)
+		case "host_del":
+			stubResult(`{"result": {"failed": []}, "value": ["web.local"]}`)(w, r)
+		}
+	})
+
+	return c, &calls
+}
+
+func TestHostDecommission(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, calls := newDecommissionStub(t, true, false)
+	report, err := c.HostDecommission("Web.Local", ipa.DecommissionOptions{UpdateDNS: true})
+	require.NoError(err)
+	assert.Equal("web.local", report.Host)
+
+	var methods []string
+	for _, call := range *calls {
+		methods = append(methods, call.method+" "+call.params[0])
+	}
+	assert.Equalf([]string{"host_show web.local", "hostgroup_remove_member webservers", "hostgroup_remove_member dmz",
+		"host_disable web.local", "host_del web.local"}, methods, "Certificates should be revoked before the host is deleted")
+	assert.Equal(true, (*calls)[4].options["updatedns"])
+
+	require.Len(report.Steps, 4)
+	assert.Equal(ipa.DecommissionHostGroup, report.Steps[0].Step)
+	assert.Equal("webservers", report.Steps[0].Target)
+	assert.NoError(report.Steps[0].Err)
+	assert.Equal("dmz", report.Steps[1].Target)
+	assert.Errorf(report.Steps[1].Err, "Failed steps should not stop the decommission")
+	assert.Equal(ipa.DecommissionCertificates, report.Steps[2].Step)
+	assert.NoError(report.Steps[2].Err)
+	assert.Contains(report.Steps[2].Note, "2 certificates")
+	assert.Equal(ipa.DecommissionDelete, report.Steps[3].Step)
+	assert.NoError(report.Steps[3].Err)
+	assert.False(report.OK())
+	assert.Equal([]*ipa.DecommissionStep{report.Steps[1]}, report.Failed())
+
+	// The host is kept if its certificates could not be revoked
+	c, calls = newDecommissionStub(t, true, true)
+	report, err = c.HostDecommission("web.local", ipa.DecommissionOptions{})
+	require.NoError(err)
+	assert.Error(report.Steps[2].Err)
+	assert.True(report.Steps[3].Skipped)
+	assert.NotEqual("host_del", (*calls)[len(*calls)-1].method)
+
+	c, calls = newDecommissionStub(t, false, false)
+	report, err = c.HostDecommission("web.local", ipa.DecommissionOptions{KeepHost: true})
+	require.NoError(err)
+	assert.True(report.Steps[2].Skipped)
+	assert.Equal("host has no certificates", report.Steps[2].Note)
+	assert.True(report.Steps[3].Skipped)
+	for _, call := range *calls {
+		assert.NotContains([]string{"host_disable", "host_del"}, call.method)
+	}
+
+	c = newTestClientStub(t, stubError(4001, "web.local: host not found"))
+	_, err = c.HostDecommission("web.local", ipa.DecommissionOptions{})
+	assert.True(ipa.IsNotFound(err))
+}
@@ -0,0 +1,261 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// TrustConfig encapsulates the FreeIPA AD trust configuration returned from
+// trustconfig_show
+type TrustConfig struct {
+	DN          string `json:"dn"`
+	Domain      string `json:"cn"`
+	NetBIOSName string `json:"ipantflatname"`
+	SID         string `json:"ipantsecurityidentifier"`
+
+	// Group assigned to AD users without a POSIX group
+	FallbackGroup string `json:"ipantfallbackprimarygroup"`
+
+	// Servers with the AD trust agent and controller roles
+	TrustAgents      []string `json:"ad_trust_agent_server"`
+	TrustControllers []string `json:"ad_trust_controller_server"`
+}
+
+func (t *TrustConfig) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid trust config record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	t.DN = res.Get("dn").String()
+	t.Domain = res.Get("cn.0").String()
+	t.NetBIOSName = res.Get("ipantflatname.0").String()
+	t.SID = res.Get("ipantsecurityidentifier.0").String()
+	t.FallbackGroup = dnValue(res.Get("ipantfallbackprimarygroup.0").String(), "cn")
+	t.TrustAgents = parseStrings(res, "ad_trust_agent_server")
+	t.TrustControllers = parseStrings(res, "ad_trust_controller_server")
+
+	return nil
+}
+
+// Fetch the AD trust configuration. Servers without trust support installed
+// return ErrCommandNotSupported.
+func (c *Client) TrustConfigShow() (*TrustConfig, error) {
+	res, err := c.rpc("trustconfig_show", []string{}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(TrustConfig)
+	err = config.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// DiagReport is the result of Diagnostics. It is meant to be serialized as
+// JSON, for example into a support bundle, and its JSON field names are
+// stable. Slices are sorted and never null.
+type DiagReport struct {
+	Host      string    `json:"host"`
+	Realm     string    `json:"realm"`
+	Generated time.Time `json:"generated"`
+
+	// Server version and API versions of the server and client
+	ServerVersion    string `json:"server_version"`
+	APIVersion       string `json:"api_version"`
+	ClientAPIVersion string `json:"client_api_version"`
+
+	// Principal the client is authenticated as and the identity type and
+	// name reported by whoami, for example user and admin
+	Principal    string `json:"principal"`
+	WhoAmIObject string `json:"whoami_object"`
+	WhoAmIName   string `json:"whoami_name"`
+
+	// Authentication types enabled by default for users
+	AuthTypes []string `json:"auth_types"`
+
+	Servers []DiagServer `json:"servers"`
+
+	// Trust configuration, nil if trusts are not supported or configured
+	Trust *DiagTrust `json:"trust"`
+
+	// Methods of probes the server does not support
+	Unsupported []string `json:"unsupported"`
+
+	// Probes in the order they were run
+	Probes []DiagProbe `json:"probes"`
+}
+
+// DiagServer is a FreeIPA server found by Diagnostics
+type DiagServer struct {
+	Name     string   `json:"name"`
+	Location string   `json:"location"`
+	Roles    []string `json:"roles"`
+}
+
+// DiagTrust is the AD trust configuration found by Diagnostics
+type DiagTrust struct {
+	Domain        string `json:"domain"`
+	NetBIOSName   string `json:"netbios_name"`
+	SID           string `json:"sid"`
+	FallbackGroup string `json:"fallback_group"`
+}
+
+// DiagProbe is the outcome of a single Diagnostics check
+type DiagProbe struct {
+	// FreeIPA method called by the probe
+	Method string `json:"method"`
+	OK     bool   `json:"ok"`
+
+	// Error message if the probe failed
+	Error string `json:"error,omitempty"`
+
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Methods called by Diagnostics in order
+var diagProbes = []string{"ping", "whoami", "config_show", "server_find", "trustconfig_show"}
+
+var pingVersionPattern = regexp.MustCompile(`IPA server version ([^ ]+?)\.? API version ([^ ]+?)\.?$`)
+
+// Run cheap read-only checks against the FreeIPA server: ping, whoami,
+// config_show, server_find and trustconfig_show. The server versions, enabled
+// authentication types, servers, trust configuration and any commands the
+// server does not support are collected into a single report. A failing
+// check is recorded in DiagReport.Probes and does not stop the others. The
+// ctx deadline applies to each request; if ctx is done the remaining checks
+// are recorded as failed. If no check succeeds the report is returned along
+// with the error of the first check.
+func (c *Client) Diagnostics(ctx context.Context) (*DiagReport, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client := c
+	if deadline, ok := ctx.Deadline(); ok {
+		client = c.derive()
+		if client.deadline.IsZero() || deadline.Before(client.deadline) {
+			client.deadline = deadline
+		}
+		client.allowTimeout(time.Until(deadline))
+	}
+
+	report := &DiagReport{
+		Host:             c.host,
+		Realm:            c.realm,
+		Generated:        time.Now().UTC().Truncate(time.Second),
+		ClientAPIVersion: IpaClientVersion,
+		AuthTypes:        []string{},
+		Servers:          []DiagServer{},
+		Unsupported:      []string{},
+		Probes:           make([]DiagProbe, 0, len(diagProbes)),
+	}
+
+	var firstErr error
+	succeeded := false
+	for _, method := range diagProbes {
+		probe := DiagProbe{Method: method}
+		start := time.Now()
+		err := ctx.Err()
+		if err == nil {
+			err = client.diagProbe(method, report)
+		}
+		probe.Duration = time.Since(start)
+
+		if err != nil {
+			probe.Error = err.Error()
+			if errors.Is(err, ErrCommandNotSupported) {
+				report.Unsupported = append(report.Unsupported, method)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			probe.OK = true
+			succeeded = true
+		}
+		report.Probes = append(report.Probes, probe)
+	}
+
+	sort.Strings(report.AuthTypes)
+	sort.Strings(report.Unsupported)
+	sort.Slice(report.Servers, func(i, j int) bool {
+		return report.Servers[i].Name < report.Servers[j].Name
+	})
+
+	if !succeeded {
+		return report, fmt.Errorf("ipa: all diagnostic checks failed: %w", firstErr)
+	}
+
+	return report, nil
+}
+
+// Run the diagnostic check calling method and add its findings to report
+func (c *Client) diagProbe(method string, report *DiagReport) error {
+	switch method {
+	case "ping":
+		res, err := c.Ping()
+		if err != nil {
+			return err
+		}
+		report.Principal = res.Principal
+		report.ServerVersion = res.Version
+		if m := pingVersionPattern.FindStringSubmatch(res.Result.Summary); m != nil {
+			report.ServerVersion = m[1]
+			report.APIVersion = m[2]
+		}
+	case "whoami":
+		res, err := c.rpc("whoami", []string{}, nil)
+		if err != nil {
+			return err
+		}
+		report.WhoAmIObject = gjson.GetBytes(res.Result.raw, "object").String()
+		report.WhoAmIName = gjson.GetBytes(res.Result.raw, "arguments.0").String()
+	case "config_show":
+		res, err := c.rpc("config_show", []string{}, Options{"all": true})
+		if err != nil {
+			return err
+		}
+		report.AuthTypes = append(report.AuthTypes, parseStrings(gjson.ParseBytes(res.Result.Data), "ipauserauthtype")...)
+	case "server_find":
+		servers, err := c.ServerFind()
+		if err != nil {
+			return err
+		}
+		for _, s := range servers {
+			roles := append([]string{}, s.Roles...)
+			sort.Strings(roles)
+			report.Servers = append(report.Servers, DiagServer{Name: s.Name, Location: s.Location, Roles: roles})
+		}
+	case "trustconfig_show":
+		trust, err := c.TrustConfigShow()
+		if err != nil {
+			return err
+		}
+		report.Trust = &DiagTrust{
+			Domain:        trust.Domain,
+			NetBIOSName:   trust.NetBIOSName,
+			SID:           trust.SID,
+			FallbackGroup: trust.FallbackGroup,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+const trustConfigResult = `{"result": {
+	"dn": "cn=local,cn=ad,cn=etc,dc=local",
+	"cn": ["local"],
+	"ipantflatname": ["LOCAL"],
+	"ipantsecurityidentifier": ["S-1-5-21-1-2-3"],
+	"ipantfallbackprimarygroup": ["cn=Default SMB Group,cn=groups,cn=accounts,dc=local"],
+	"ad_trust_agent_server": ["ipa1.local", "ipa2.local"],
+	"ad_trust_controller_server": ["ipa1.local"]
+}, "summary": null, "value": ""}`
+
+func TestTrustConfigShow(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(trustConfigResult))
+	trust, err := c.TrustConfigShow()
+	require.NoError(err)
+	assert.Equal("local", trust.Domain)
+	assert.Equal("LOCAL", trust.NetBIOSName)
+	assert.Equal("S-1-5-21-1-2-3", trust.SID)
+	assert.Equal("Default SMB Group", trust.FallbackGroup)
+	assert.Equal([]string{"ipa1.local", "ipa2.local"}, trust.TrustAgents)
+	assert.Equal([]string{"ipa1.local"}, trust.TrustControllers)
+
+	c = newTestClientStub(t, stubError(905, "unknown command 'trustconfig_show'"))
+	_, err = c.TrustConfigShow()
+	assert.True(errors.Is(err, ipa.ErrCommandNotSupported))
+}
+
+func newDiagStub(t *testing.T, trust bool) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+
+		switch req.Method {
+		case "ping":
+			stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245"}`)(w, r)
+		case "whoami":
+			stubResult(`{"object": "user", "command": "user_show/1", "arguments": ["admin"]}`)(w, r)
+		case "config_show":
+			stubResult(`{"result": {"ipauserauthtype": ["password", "otp"]}, "summary": null, "value": ""}`)(w, r)
+		case "server_find":
+			stubError(4203, "Insufficient access")(w, r)
+		case "trustconfig_show":
+			if trust {
+				stubResult(trustConfigResult)(w, r)
+				return
+			}
+			stubError(905, "unknown command 'trustconfig_show'")(w, r)
+		}
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newDiagStub(t, false)
+	report, err := c.Diagnostics(context.Background())
+	require.NoError(err)
+
+	assert.NotEmpty(report.Host)
+	assert.False(report.Generated.IsZero())
+	report.Host = ""
+	report.Generated = time.Time{}
+	for i := range report.Probes {
+		report.Probes[i].Duration = 0
+	}
+
+	out, err := json.Marshal(report)
+	require.NoError(err)
+	assert.JSONEq(`{
+		"host": "",
+		"realm": "LOCAL",
+		"generated": "0001-01-01T00:00:00Z",
+		"server_version": "4.9.8",
+		"api_version": "2.245",
+		"client_api_version": "`+ipa.IpaClientVersion+`",
+		"principal": "admin@LOCAL",
+		"whoami_object": "user",
+		"whoami_name": "admin",
+		"auth_types": ["otp", "password"],
+		"servers": [],
+		"trust": null,
+		"unsupported": ["trustconfig_show"],
+		"probes": [
+			{"method": "ping", "ok": true, "duration_ns": 0},
+			{"method": "whoami", "ok": true, "duration_ns": 0},
+			{"method": "config_show", "ok": true, "duration_ns": 0},
+			{"method": "server_find", "ok": false, "error": "`+report.Probes[3].Error+`", "duration_ns": 0},
+			{"method": "trustconfig_show", "ok": false, "error": "`+report.Probes[4].Error+`", "duration_ns": 0}
+		]
+	}`, string(out))
+	assert.Contains(report.Probes[3].Error, "Insufficient access")
+
+	c = newDiagStub(t, true)
+	report, err = c.Diagnostics(context.Background())
+	require.NoError(err)
+	assert.Empty(report.Unsupported)
+	assert.Equal(&ipa.DiagTrust{Domain: "local", NetBIOSName: "LOCAL", SID: "S-1-5-21-1-2-3", FallbackGroup: "Default SMB Group"}, report.Trust)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Diagnostics(ctx)
+	assert.True(errors.Is(err, context.Canceled))
+
+	c = newTestClientStub(t, stubError(2100, "Insufficient access"))
+	report, err = c.Diagnostics(context.Background())
+	require.Errorf(err, "Diagnostics should fail if no check succeeds")
+	assert.True(ipa.IsPermissionDenied(err))
+	require.NotNilf(report, "The report should be returned with the error")
+	assert.Len(report.Probes, 5)
+}
+
+func TestDiagnosticsReadOnly(t *testing.T) {
+	c := newDiagStub(t, true)
+	c.SetReadOnly(true)
+
+	report, err := c.Diagnostics(context.Background())
+	require.NoError(t, err)
+	for _, probe := range report.Probes {
+		if probe.Method != "server_find" {
+			assert.Truef(t, probe.OK, "%s: %s", probe.Method, probe.Error)
+		}
+	}
+}
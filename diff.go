@@ -0,0 +1,239 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldChange is a change to a single user attribute. See DiffUsers
+type FieldChange struct {
+	// FreeIPA attribute name as sent by User.ToOptions
+	Attribute string
+
+	// Old and new values. Values of multi-valued attributes are []string,
+	// all other values are strings
+	Old interface{}
+	New interface{}
+
+	MultiValued bool
+
+	// Values added and removed, set for multi-valued attributes only
+	Added   []string
+	Removed []string
+}
+
+// Setters for the attributes returned by User.ToOptions. Used by
+// User.ApplyChanges
+var userAttrSetters = map[string]func(u *User, v string){
+	"mail":            func(u *User, v string) { u.Email = v },
+	"givenname":       func(u *User, v string) { u.First = v },
+	"sn":              func(u *User, v string) { u.Last = v },
+	"homedirectory":   func(u *User, v string) { u.HomeDir = v },
+	"loginshell":      func(u *User, v string) { u.Shell = v },
+	"displayname":     func(u *User, v string) { u.DisplayName = v },
+	"telephonenumber": func(u *User, v string) { u.TelephoneNumber = v },
+	"mobile":          func(u *User, v string) { u.Mobile = v },
+	"nsaccountlock": func(u *User, v string) {
+		u.Locked = v == OptBool(true)
+		u.UpdateLocked = true
+	},
+}
+
+// Returns the attributes user_mod would set for u, with setattr values
+// expanded into attributes
+func userModAttrs(u *User) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for k, v := range u.ToOptions() {
+		if k != "setattr" {
+			attrs[k] = v
+			continue
+		}
+
+		for _, sa := range v.([]string) {
+			parts := strings.SplitN(sa, "=", 2)
+			attrs[parts[0]] = parts[1]
+		}
+	}
+
+	return attrs
+}
+
+// Compare the attributes UserMod would send for current and desired and
+// return the changes sorted by attribute. Attribute names and values are
+// those sent by User.ToOptions, so server managed fields such as UUID, DN and
+// timestamps and memberships, which user_mod can not change, are ignored.
+// SSH keys are compared by fingerprint. The lock status is only compared if
+// desired.UpdateLocked is set.
+func DiffUsers(current, desired *User) ([]FieldChange, error) {
+	if current == nil || desired == nil {
+		return nil, errors.New("current and desired users are required")
+	}
+	if current.Username != desired.Username {
+		return nil, fmt.Errorf("ipa: can not diff different users %s and %s", current.Username, desired.Username)
+	}
+
+	cur := *current
+	cur.UpdateLocked = true
+	old := userModAttrs(&cur)
+
+	changes := []FieldChange{}
+	for attr, v := range userModAttrs(desired) {
+		if attr == "ipasshpubkey" {
+			if change, ok := diffSSHKeys(current.SSHAuthKeys, desired.SSHAuthKeys); ok {
+				changes = append(changes, change)
+			}
+			continue
+		}
+		if attr == "userclass" {
+			if change, ok := diffValues(attr, optionValues(old[attr]), optionValues(v)); ok {
+				changes = append(changes, change)
+			}
+			continue
+		}
+
+		if old[attr] != v {
+			changes = append(changes, FieldChange{Attribute: attr, Old: old[attr], New: v})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Attribute < changes[j].Attribute
+	})
+
+	return changes, nil
+}
+
+// Returns the values of a multi-valued option. An empty string is no values
+func optionValues(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	}
+
+	return []string{}
+}
+
+// Compare the values of multi-valued attribute attr ignoring order. Returns
+// false if there are no changes
+func diffValues(attr string, current, desired []string) (FieldChange, bool) {
+	change := FieldChange{
+		Attribute:   attr,
+		Old:         append([]string{}, current...),
+		New:         append([]string{}, desired...),
+		MultiValued: true,
+	}
+
+	have := make(map[string]bool)
+	for _, v := range current {
+		have[v] = true
+	}
+	want := make(map[string]bool)
+	for _, v := range desired {
+		want[v] = true
+		if !have[v] {
+			change.Added = append(change.Added, v)
+		}
+	}
+	for _, v := range current {
+		if !want[v] {
+			change.Removed = append(change.Removed, v)
+		}
+	}
+
+	return change, len(change.Added) > 0 || len(change.Removed) > 0
+}
+
+// Compare ssh keys by fingerprint. Returns false if there are no changes
+func diffSSHKeys(current, desired []*SSHAuthorizedKey) (FieldChange, bool) {
+	change := FieldChange{
+		Attribute:   "ipasshpubkey",
+		Old:         formatSSHAuthorizedKeys(current),
+		New:         formatSSHAuthorizedKeys(desired),
+		MultiValued: true,
+	}
+
+	have := make(map[string]bool)
+	for _, k := range current {
+		have[k.Fingerprint] = true
+	}
+	want := make(map[string]bool)
+	for _, k := range desired {
+		want[k.Fingerprint] = true
+		if !have[k.Fingerprint] {
+			change.Added = append(change.Added, k.String())
+		}
+	}
+	for _, k := range current {
+		if !want[k.Fingerprint] {
+			change.Removed = append(change.Removed, k.String())
+		}
+	}
+
+	return change, len(change.Added) > 0 || len(change.Removed) > 0
+}
+
+// Apply changes, as returned by DiffUsers, to the user and return the
+// minimal options for user_mod. The options are taken from ToOptions after
+// applying the changes. See Client.UserModOptions
+func (u *User) ApplyChanges(changes []FieldChange) (Options, error) {
+	for _, change := range changes {
+		if change.Attribute == "ipasshpubkey" {
+			vals, ok := change.New.([]string)
+			if !ok {
+				return nil, fmt.Errorf("ipa: invalid value for %s: %v", change.Attribute, change.New)
+			}
+
+			keys := []*SSHAuthorizedKey{}
+			for _, v := range vals {
+				key, err := NewSSHAuthorizedKey(v)
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, key)
+			}
+			u.SSHAuthKeys = keys
+			continue
+		}
+		if change.Attribute == "userclass" {
+			classes := optionValues(change.New)
+			u.Categories = append([]string{}, classes...)
+			u.Category = ""
+			if len(classes) > 0 {
+				u.Category = classes[0]
+			}
+			continue
+		}
+
+		set, ok := userAttrSetters[change.Attribute]
+		if !ok {
+			return nil, fmt.Errorf("ipa: unsupported user attribute %s", change.Attribute)
+		}
+		v, ok := change.New.(string)
+		if !ok {
+			return nil, fmt.Errorf("ipa: invalid value for %s: %v", change.Attribute, change.New)
+		}
+		set(u, v)
+	}
+
+	all := u.ToOptions()
+	options := Options{}
+	for _, change := range changes {
+		if change.Attribute == "nsaccountlock" {
+			options.SetAttr("nsaccountlock", OptBool(u.Locked))
+			continue
+		}
+		options[change.Attribute] = all[change.Attribute]
+	}
+
+	return options, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+const (
+	testSSHKeyLaptop  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIG2rwNwFFCHqBA2MYm7sokIKv/kosrKehjFYv3qkevkx jdoe@laptop"
+	testSSHKeyDesktop = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIC0r7OKn5xhnqs6ORJgRv06EgE5EI5almzJKLjPBkj5O jdoe@desktop"
+)
+
+func testUser(t *testing.T, keys ...string) *ipa.User {
+	user := &ipa.User{
+		UUID:     "8a3a2c4e-0000-11ee-8000-525400000001",
+		DN:       "uid=jdoe,cn=users,cn=accounts,dc=local",
+		Username: "jdoe",
+		First:    "John",
+		Last:     "Doe",
+		Email:    "jdoe@example.com",
+		Shell:    "/bin/bash",
+		HomeDir:  "/home/jdoe",
+		Groups:   []string{"ipausers"},
+	}
+	for _, k := range keys {
+		key, err := ipa.NewSSHAuthorizedKey(k)
+		require.NoError(t, err)
+		user.AddSSHAuthorizedKey(key)
+	}
+
+	return user
+}
+
+func TestDiffUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		current *ipa.User
+		desired func(u *ipa.User)
+	}{
+		{"unchanged", testUser(t, testSSHKeyLaptop), func(u *ipa.User) {}},
+		{"server-managed", testUser(t), func(u *ipa.User) {
+			u.UUID = "other"
+			u.DN = "uid=jdoe,cn=deleted users,cn=accounts,cn=provisioning,dc=local"
+			u.Groups = []string{"admins"}
+			u.Locked = true
+		}},
+		{"single-valued", testUser(t), func(u *ipa.User) {
+			u.Shell = "/bin/zsh"
+			u.Email = "john.doe@example.com"
+			u.Mobile = "555-0100"
+		}},
+		{"ssh-keys", testUser(t, testSSHKeyLaptop), func(u *ipa.User) {
+			u.SSHAuthKeys = nil
+			key, _ := ipa.NewSSHAuthorizedKey(testSSHKeyDesktop)
+			u.AddSSHAuthorizedKey(key)
+		}},
+		{"ssh-key-order", testUser(t, testSSHKeyLaptop, testSSHKeyDesktop), func(u *ipa.User) {
+			u.SSHAuthKeys[0], u.SSHAuthKeys[1] = u.SSHAuthKeys[1], u.SSHAuthKeys[0]
+		}},
+		{"lock", testUser(t), func(u *ipa.User) {
+			u.Locked = true
+			u.UpdateLocked = true
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			desired := *test.current
+			desired.SSHAuthKeys = append([]*ipa.SSHAuthorizedKey{}, test.current.SSHAuthKeys...)
+			test.desired(&desired)
+
+			changes, err := ipa.DiffUsers(test.current, &desired)
+			require.NoError(err)
+
+			out, err := json.MarshalIndent(changes, "", "  ")
+			require.NoError(err)
+
+			golden := filepath.Join("testdata", "diff", test.name+".golden")
+			if *updateGolden {
+				require.NoError(os.WriteFile(golden, append(out, '\n'), 0644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(err)
+			assert.JSONEq(t, string(want), string(out))
+
+			// Applying the changes to the current user must produce the
+			// same options as the desired user
+			current := *test.current
+			options, err := current.ApplyChanges(changes)
+			require.NoError(err)
+			all := desired.ToOptions()
+			for attr, v := range options {
+				if attr == "setattr" {
+					assert.Equal(t, all[attr], v)
+					continue
+				}
+				assert.Equalf(t, all[attr], v, "Option %s should match ToOptions", attr)
+			}
+			assert.Len(t, options, len(changes))
+		})
+	}
+
+	_, err := ipa.DiffUsers(testUser(t), &ipa.User{Username: "jsmith"})
+	assert.Error(t, err)
+}
+
+func TestApplyChangesAllAttributes(t *testing.T) {
+	require := require.New(t)
+
+	desired := testUser(t, testSSHKeyLaptop)
+	desired.DisplayName = "John Doe"
+	desired.TelephoneNumber = "555-0100"
+	desired.Mobile = "555-0101"
+	desired.Category = "staff"
+	desired.Locked = true
+	desired.UpdateLocked = true
+
+	current := &ipa.User{Username: "jdoe"}
+	changes, err := ipa.DiffUsers(current, desired)
+	require.NoError(err)
+
+	options, err := current.ApplyChanges(changes)
+	require.NoError(err)
+	require.Equalf(desired.ToOptions(), options, "Every ToOptions attribute should be diffed and applied")
+
+	_, err = current.ApplyChanges([]ipa.FieldChange{{Attribute: "uidnumber", New: "1001"}})
+	require.Error(err)
+}
+
+func TestDiffUserClasses(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	current := testUser(t)
+	current.Categories = []string{"staff", "hpc"}
+	current.Category = "staff"
+
+	desired := testUser(t)
+	desired.Categories = []string{"staff", "hpc"}
+	desired.Category = "staff"
+	changes, err := ipa.DiffUsers(current, desired)
+	require.NoError(err)
+	assert.Emptyf(changes, "Classes in the same order should not be a change")
+
+	// Setting only Category replaces the first class and keeps the rest
+	desired.Category = "faculty"
+	changes, err = ipa.DiffUsers(current, desired)
+	require.NoError(err)
+	require.Len(changes, 1)
+	assert.Equal("userclass", changes[0].Attribute)
+	assert.Equal([]string{"staff", "hpc"}, changes[0].Old)
+	assert.Equal([]string{"faculty", "hpc"}, changes[0].New)
+
+	options, err := current.ApplyChanges(changes)
+	require.NoError(err)
+	assert.Equal([]string{"faculty", "hpc"}, options["userclass"])
+	assert.Equal([]string{"faculty", "hpc"}, current.Categories)
+	assert.Equal("faculty", current.Category)
+
+	desired.Categories = nil
+	desired.Category = ""
+	changes, err = ipa.DiffUsers(current, desired)
+	require.NoError(err)
+	require.Len(changes, 1)
+	assert.Empty(changes[0].New)
+}
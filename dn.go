@@ -0,0 +1,127 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidDN is returned by ParseDN for strings which are not a
+// distinguished name
+var ErrInvalidDN = errors.New("invalid dn")
+
+// RDN is an attribute and value of a distinguished name, for example uid=jdoe
+type RDN struct {
+	Attr  string
+	Value string
+}
+
+// DN is a parsed distinguished name with the most specific RDN first.
+// Multi-valued RDNs such as cn=a+sn=b are flattened into one RDN per value.
+type DN []RDN
+
+// Parse distinguished name dn as described in RFC 4514, for example
+// uid=jdoe,cn=users,cn=accounts,dc=example,dc=com. Escaped characters in
+// values, such as \, or \2C, are unescaped. An empty string parses to an
+// empty DN. ErrInvalidDN is returned if dn is not a distinguished name, for
+// example a plain uid.
+func ParseDN(dn string) (DN, error) {
+	var parsed DN
+	if strings.TrimSpace(dn) == "" {
+		return parsed, nil
+	}
+
+	for i := 0; i <= len(dn); {
+		eq := strings.IndexByte(dn[i:], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("ipa: %w: missing attribute value in %q", ErrInvalidDN, dn)
+		}
+		attr := strings.TrimSpace(dn[i : i+eq])
+		if attr == "" || strings.ContainsAny(attr, ",+\\\"") {
+			return nil, fmt.Errorf("ipa: %w: invalid attribute %q in %q", ErrInvalidDN, attr, dn)
+		}
+
+		value, n, err := parseDNValue(dn[i+eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("ipa: %w: %s in %q", ErrInvalidDN, err, dn)
+		}
+		parsed = append(parsed, RDN{Attr: attr, Value: value})
+		i += eq + 1 + n + 1
+	}
+
+	return parsed, nil
+}
+
+// Parse an attribute value up to the next unescaped , or +. Returns the
+// unescaped value and the number of bytes of s consumed, excluding the
+// separator.
+func parseDNValue(s string) (string, int, error) {
+	var value []byte
+	// Length of value without trailing unescaped spaces
+	end := 0
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == ',' || c == '+' {
+			break
+		}
+		if c != '\\' {
+			if c == ' ' && len(value) == 0 {
+				continue
+			}
+			value = append(value, c)
+			if c != ' ' {
+				end = len(value)
+			}
+			continue
+		}
+
+		if i+1 >= len(s) {
+			return "", 0, errors.New("trailing backslash")
+		}
+		if i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b, _ := hex.DecodeString(s[i+1 : i+3])
+			value = append(value, b...)
+			i += 2
+		} else {
+			value = append(value, s[i+1])
+			i++
+		}
+		end = len(value)
+	}
+
+	return string(value[:end]), i, nil
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Returns the value of the first RDN with attribute attr, compared case
+// insensitively, or an empty string
+func (d DN) Get(attr string) string {
+	for _, rdn := range d {
+		if strings.EqualFold(rdn.Attr, attr) {
+			return rdn.Value
+		}
+	}
+
+	return ""
+}
+
+// Returns the value of the first RDN of dn if its attribute is attr, for
+// example the uid of a user DN. Otherwise dn is returned unchanged, so plain
+// names FreeIPA returns for the same attribute pass through.
+func dnValue(dn, attr string) string {
+	parsed, err := ParseDN(dn)
+	if err != nil || len(parsed) == 0 || !strings.EqualFold(parsed[0].Attr, attr) {
+		return dn
+	}
+
+	return parsed[0].Value
+}
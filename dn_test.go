@@ -0,0 +1,61 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestParseDN(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dn, err := ipa.ParseDN("uid=jsmith,cn=users,cn=accounts,dc=example,dc=com")
+	require.NoError(err)
+	assert.Equal(ipa.DN{
+		{Attr: "uid", Value: "jsmith"},
+		{Attr: "cn", Value: "users"},
+		{Attr: "cn", Value: "accounts"},
+		{Attr: "dc", Value: "example"},
+		{Attr: "dc", Value: "com"},
+	}, dn)
+	assert.Equal("jsmith", dn.Get("UID"))
+	assert.Equal("users", dn.Get("cn"))
+	assert.Empty(dn.Get("ou"))
+
+	tests := []struct {
+		dn    string
+		first ipa.RDN
+		len   int
+	}{
+		{`cn=Doe\, John,ou=people`, ipa.RDN{Attr: "cn", Value: "Doe, John"}, 2},
+		{`cn=Doe\2C John,ou=people`, ipa.RDN{Attr: "cn", Value: "Doe, John"}, 2},
+		{`cn=J\C3\BCrgen`, ipa.RDN{Attr: "cn", Value: "Jürgen"}, 1},
+		{`UID = jdoe , cn=users`, ipa.RDN{Attr: "UID", Value: "jdoe"}, 2},
+		{`cn=a+sn=b,dc=local`, ipa.RDN{Attr: "cn", Value: "a"}, 3},
+		{`cn=a=b`, ipa.RDN{Attr: "cn", Value: "a=b"}, 1},
+		{`cn=trailing\ `, ipa.RDN{Attr: "cn", Value: "trailing "}, 1},
+	}
+	for _, test := range tests {
+		dn, err := ipa.ParseDN(test.dn)
+		if assert.NoErrorf(err, "Failed to parse %s", test.dn) {
+			assert.Lenf(dn, test.len, "Wrong number of RDNs in %s", test.dn)
+			assert.Equalf(test.first, dn[0], "Wrong first RDN of %s", test.dn)
+		}
+	}
+
+	dn, err = ipa.ParseDN("")
+	require.NoError(err)
+	assert.Empty(dn)
+
+	for _, invalid := range []string{"jsmith", "uid=jsmith,", "=jsmith", `cn=a\`, "uid=jsmith,cn"} {
+		_, err := ipa.ParseDN(invalid)
+		assert.ErrorIsf(err, ipa.ErrInvalidDN, "%q should not parse", invalid)
+	}
+}
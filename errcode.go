@@ -0,0 +1,190 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// FreeIPA error codes returned in IpaError.Code. See ipalib/errors.py in
+// FreeIPA for the full list.
+const (
+	// Unknown command (CommandError)
+	ErrCodeCommandError = 905
+
+	// Network errors talking to or from the server (NetworkError,
+	// ServerNetworkError)
+	ErrCodeNetworkError       = 907
+	ErrCodeServerNetworkError = 908
+
+	// Insufficient access (ACIError)
+	ErrCodeACIError = 2100
+
+	// Invalid parameter value (ValidationError)
+	ErrCodeValidation = 3009
+
+	// Entry not found (NotFound)
+	ErrCodeNotFound = 4001
+
+	// Entry already exists (DuplicateEntry)
+	ErrCodeDuplicateEntry = 4002
+
+	// Entry already enabled or disabled (AlreadyActive, AlreadyInactive)
+	ErrCodeAlreadyActive   = 4009
+	ErrCodeAlreadyInactive = 4010
+
+	// No modifications to be performed (EmptyModlist)
+	ErrCodeNoModifications = 4202
+
+	// LDAP database error, for example a constraint violation or a busy
+	// server (DatabaseError), and LDAP operation timeouts (DatabaseTimeout,
+	// TaskTimeout)
+	ErrCodeDatabaseError   = 4203
+	ErrCodeDatabaseTimeout = 4211
+	ErrCodeTaskTimeout     = 4213
+
+	// Limits exceeded for a search (LimitsExceeded), returned instead of
+	// truncated results when the time, size or admin limit is exceeded
+	ErrCodeLimitsExceeded     = 4204
+	ErrCodeTimeLimitExceeded  = 4214
+	ErrCodeSizeLimitExceeded  = 4215
+	ErrCodeAdminLimitExceeded = 4216
+
+	// Conflicting values, for example a rule category of all with explicit
+	// members (MutuallyExclusiveError)
+	ErrCodeMutuallyExclusive = 4303
+)
+
+// Returns the FreeIPA error code of err, or 0 if err is not and does not wrap
+// an IpaError
+func ErrorCode(err error) int {
+	var ierr *IpaError
+	if errors.As(err, &ierr) {
+		return ierr.Code
+	}
+
+	return 0
+}
+
+// Returns true if err is a FreeIPA not found error or ErrGroupNotFound
+func IsNotFound(err error) bool {
+	return ErrorCode(err) == ErrCodeNotFound || errors.Is(err, ErrGroupNotFound)
+}
+
+// Returns true if err is a FreeIPA duplicate entry error or one of the
+// library's already exists errors, such as ErrGroupExists
+func IsDuplicate(err error) bool {
+	return ErrorCode(err) == ErrCodeDuplicateEntry || errors.Is(err, ErrUserExists) ||
+		errors.Is(err, ErrGroupExists) || errors.Is(err, ErrHostGroupExists)
+}
+
+// Returns true if err is a FreeIPA no modifications error, returned when a
+// mod call would not change the entry
+func IsNoModifications(err error) bool {
+	return ErrorCode(err) == ErrCodeNoModifications
+}
+
+// Returns true if err is a FreeIPA insufficient access error. See
+// PermissionDeniedError
+func IsPermissionDenied(err error) bool {
+	return ErrorCode(err) == ErrCodeACIError || errors.Is(err, ErrNoPermission)
+}
+
+// Returns true if err is a FreeIPA validation error
+func IsValidation(err error) bool {
+	return ErrorCode(err) == ErrCodeValidation
+}
+
+// Returns true if a search exceeded the server's time, size or admin limit
+func IsLimitsExceeded(err error) bool {
+	switch ErrorCode(err) {
+	case ErrCodeLimitsExceeded, ErrCodeTimeLimitExceeded, ErrCodeSizeLimitExceeded, ErrCodeAdminLimitExceeded:
+		return true
+	}
+
+	return false
+}
+
+// FreeIPA error codes of transient failures
+var retryableCodes = map[int]bool{
+	ErrCodeNetworkError:       true,
+	ErrCodeServerNetworkError: true,
+	ErrCodeDatabaseError:      true,
+	ErrCodeDatabaseTimeout:    true,
+	ErrCodeTaskTimeout:        true,
+}
+
+// Returns true if err is a FreeIPA error with the code of a transient
+// failure, such as a network or database error, which may succeed if
+// retried. Errors are classified by code, so errors from localized servers
+// are classified the same, except that database errors reporting an LDAP
+// constraint violation and errors wrapping ErrPasswordPolicy are permanent.
+// Errors without a FreeIPA error code, including transport errors and the
+// errors defined by this package, are not retryable. Limits exceeded errors
+// are not retryable by default, see Client.SetRetryableCodes.
+func IsRetryable(err error) bool {
+	if isPermanent(err) {
+		return false
+	}
+
+	return retryableCodes[ErrorCode(err)]
+}
+
+// Returns true if err will fail the same way if retried regardless of its
+// code
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrPasswordPolicy) || isConstraintViolation(err)
+}
+
+// Returns true if err is a FreeIPA database error for an LDAP constraint
+// violation, for example a password rejected by the password policy. The
+// LDAP error description is not localized.
+func isConstraintViolation(err error) bool {
+	var ierr *IpaError
+	if !errors.As(err, &ierr) || ierr.Code != ErrCodeDatabaseError {
+		return false
+	}
+
+	desc := gjson.GetBytes(ierr.Data, "desc").String()
+	if desc == "" {
+		desc = ierr.Message
+	}
+
+	return strings.Contains(strings.ToLower(desc), "constraint violation")
+}
+
+// Set FreeIPA error codes classified as retryable by c.IsRetryable in
+// addition to those of IsRetryable, for example ErrCodeTimeLimitExceeded.
+// Replaces the codes of previous calls.
+func (c *Client) SetRetryableCodes(codes ...int) {
+	retry := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		retry[code] = true
+	}
+
+	c.mu.Lock()
+	c.retryCodes = retry
+	c.mu.Unlock()
+}
+
+// Returns true if err is retryable by IsRetryable or has one of the codes
+// set with SetRetryableCodes. Used by WatchEntries to decide whether to retry
+// a failed poll.
+func (c *Client) IsRetryable(err error) bool {
+	if isPermanent(err) {
+		return false
+	}
+	if IsRetryable(err) {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.retryCodes[ErrorCode(err)]
+}
@@ -0,0 +1,291 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestErrorHelpers(t *testing.T) {
+	assert := assert.New(t)
+
+	wrap := func(code int) error {
+		return fmt.Errorf("context: %w", &ipa.IpaError{Code: code, Message: "stub"})
+	}
+
+	tests := []struct {
+		name  string
+		check func(error) bool
+		match []error
+	}{
+		{"IsNotFound", ipa.IsNotFound, []error{wrap(ipa.ErrCodeNotFound), ipa.ErrGroupNotFound}},
+		{"IsDuplicate", ipa.IsDuplicate, []error{wrap(ipa.ErrCodeDuplicateEntry), ipa.ErrUserExists, fmt.Errorf("ipa: %w: jdoe", ipa.ErrGroupExists), ipa.ErrHostGroupExists}},
+		{"IsNoModifications", ipa.IsNoModifications, []error{wrap(ipa.ErrCodeNoModifications)}},
+		{"IsPermissionDenied", ipa.IsPermissionDenied, []error{wrap(ipa.ErrCodeACIError), &ipa.PermissionDeniedError{Method: "user_mod", Err: &ipa.IpaError{Code: ipa.ErrCodeACIError}}}},
+		{"IsValidation", ipa.IsValidation, []error{wrap(ipa.ErrCodeValidation)}},
+		{"IsLimitsExceeded", ipa.IsLimitsExceeded, []error{wrap(ipa.ErrCodeLimitsExceeded), wrap(ipa.ErrCodeSizeLimitExceeded), wrap(ipa.ErrCodeTimeLimitExceeded), wrap(ipa.ErrCodeAdminLimitExceeded)}},
+	}
+
+	others := []error{nil, errors.New("ipa: error 4001 - not found"), wrap(ipa.ErrCodeDatabaseError)}
+	for _, test := range tests {
+		for _, err := range test.match {
+			assert.Truef(test.check(err), "%s should match %v", test.name, err)
+		}
+		for _, err := range others {
+			assert.Falsef(test.check(err), "%s should not match %v", test.name, err)
+		}
+	}
+
+	assert.Equal(ipa.ErrCodeNotFound, ipa.ErrorCode(wrap(ipa.ErrCodeNotFound)))
+	assert.Equal(0, ipa.ErrorCode(errors.New("other")))
+	assert.Equal(0, ipa.ErrorCode(nil))
+}
+
+func TestErrorHelpersClient(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubError(4001, "jdoe: user not found"))
+	_, err := c.UserShow("jdoe")
+	require.Error(err)
+	assert.True(ipa.IsNotFound(err))
+	assert.False(ipa.IsDuplicate(err))
+
+	c = newTestClientStub(t, stubError(2100, "Insufficient access: Insufficient 'write' privilege to the 'loginShell' attribute"))
+	_, err = c.UserShow("jdoe")
+	assert.True(ipa.IsPermissionDenied(err))
+	assert.Equal(ipa.ErrCodeACIError, ipa.ErrorCode(err))
+
+	c = newTestClientStub(t, stubError(4204, "limits exceeded for this query"))
+	_, err = c.UserFind(nil)
+	assert.True(ipa.IsLimitsExceeded(err))
+	assert.ErrorIsf(err, ipa.ErrTruncated, "Finds exceeding a limit should report truncation")
+
+	_, err = c.UserShow("jdoe")
+	assert.True(ipa.IsLimitsExceeded(err))
+	assert.False(errors.Is(err, ipa.ErrTruncated))
+}
+
+// FreeIPA error class names mapped to the package constants. Every constant
+// must be listed
+var errorCodeNames = map[string]int{
+	"CommandError":           ipa.ErrCodeCommandError,
+	"NetworkError":           ipa.ErrCodeNetworkError,
+	"ServerNetworkError":     ipa.ErrCodeServerNetworkError,
+	"ACIError":               ipa.ErrCodeACIError,
+	"ValidationError":        ipa.ErrCodeValidation,
+	"NotFound":               ipa.ErrCodeNotFound,
+	"DuplicateEntry":         ipa.ErrCodeDuplicateEntry,
+	"AlreadyActive":          ipa.ErrCodeAlreadyActive,
+	"AlreadyInactive":        ipa.ErrCodeAlreadyInactive,
+	"EmptyModlist":           ipa.ErrCodeNoModifications,
+	"DatabaseError":          ipa.ErrCodeDatabaseError,
+	"LimitsExceeded":         ipa.ErrCodeLimitsExceeded,
+	"DatabaseTimeout":        ipa.ErrCodeDatabaseTimeout,
+	"TaskTimeout":            ipa.ErrCodeTaskTimeout,
+	"TimeLimitExceeded":      ipa.ErrCodeTimeLimitExceeded,
+	"SizeLimitExceeded":      ipa.ErrCodeSizeLimitExceeded,
+	"AdminLimitExceeded":     ipa.ErrCodeAdminLimitExceeded,
+	"MutuallyExclusiveError": ipa.ErrCodeMutuallyExclusive,
+}
+
+// Pin each error code constant to the error FreeIPA returns for its class.
+// The fixture errors follow the codes and message formats of ipalib/errors.py
+func TestErrorCodeFixtures(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	data, err := os.ReadFile("testdata/errors/errors.json")
+	require.NoError(err)
+	var fixtures []json.RawMessage
+	require.NoError(json.Unmarshal(data, &fixtures))
+
+	seen := make(map[string]bool)
+	for _, fixture := range fixtures {
+		var ierr ipa.IpaError
+		require.NoError(json.Unmarshal(fixture, &ierr))
+		code, ok := errorCodeNames[ierr.Name]
+		if !assert.Truef(ok, "No constant for FreeIPA error %s", ierr.Name) {
+			continue
+		}
+		seen[ierr.Name] = true
+		assert.Equalf(code, ierr.Code, "Invalid code for %s", ierr.Name)
+
+		c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"error": %s, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": null}`, fixture, stubRequestID(r))
+		})
+		_, err := c.UserFind(nil)
+		if code == ipa.ErrCodeCommandError {
+			assert.ErrorIs(err, ipa.ErrCommandNotSupported)
+			continue
+		}
+		assert.Equalf(code, ipa.ErrorCode(err), "Invalid code for %s", ierr.Name)
+
+		limit := strings.HasSuffix(ierr.Name, "LimitExceeded") || ierr.Name == "LimitsExceeded"
+		assert.Equalf(limit, ipa.IsLimitsExceeded(err), "IsLimitsExceeded(%s)", ierr.Name)
+		assert.Equalf(limit, errors.Is(err, ipa.ErrTruncated), "Find exceeding a limit should wrap ErrTruncated (%s)", ierr.Name)
+	}
+
+	for name := range errorCodeNames {
+		assert.Truef(seen[name], "Missing fixture for %s", name)
+	}
+}
+
+// Every FreeIPA error code constant and sentinel error in the package with
+// whether it is retryable. New codes and errors must be added here.
+var retryableClassification = map[string]struct {
+	err       error
+	retryable bool
+}{
+	"ErrCodeCommandError":       {codeError(ipa.ErrCodeCommandError), false},
+	"ErrCodeNetworkError":       {codeError(ipa.ErrCodeNetworkError), true},
+	"ErrCodeServerNetworkError": {codeError(ipa.ErrCodeServerNetworkError), true},
+	"ErrCodeACIError":           {codeError(ipa.ErrCodeACIError), false},
+	"ErrCodeValidation":         {codeError(ipa.ErrCodeValidation), false},
+	"ErrCodeNotFound":           {codeError(ipa.ErrCodeNotFound), false},
+	"ErrCodeDuplicateEntry":     {codeError(ipa.ErrCodeDuplicateEntry), false},
+	"ErrCodeAlreadyActive":      {codeError(ipa.ErrCodeAlreadyActive), false},
+	"ErrCodeAlreadyInactive":    {codeError(ipa.ErrCodeAlreadyInactive), false},
+	"ErrCodeNoModifications":    {codeError(ipa.ErrCodeNoModifications), false},
+	"ErrCodeDatabaseError":      {codeError(ipa.ErrCodeDatabaseError), true},
+	"ErrCodeDatabaseTimeout":    {codeError(ipa.ErrCodeDatabaseTimeout), true},
+	"ErrCodeTaskTimeout":        {codeError(ipa.ErrCodeTaskTimeout), true},
+	"ErrCodeLimitsExceeded":     {codeError(ipa.ErrCodeLimitsExceeded), false},
+	"ErrCodeTimeLimitExceeded":  {codeError(ipa.ErrCodeTimeLimitExceeded), false},
+	"ErrCodeSizeLimitExceeded":  {codeError(ipa.ErrCodeSizeLimitExceeded), false},
+	"ErrCodeAdminLimitExceeded": {codeError(ipa.ErrCodeAdminLimitExceeded), false},
+	"ErrCodeMutuallyExclusive":  {codeError(ipa.ErrCodeMutuallyExclusive), false},
+
+	"ErrNoPermission":            {ipa.ErrNoPermission, false},
+	"ErrProtectedUser":           {ipa.ErrProtectedUser, false},
+	"ErrTLSVerification":         {ipa.ErrTLSVerification, false},
+	"ErrInvalidDN":               {ipa.ErrInvalidDN, false},
+	"ErrTruncated":               {ipa.ErrTruncated, false},
+	"ErrGidInUse":                {ipa.ErrGidInUse, false},
+	"ErrLastMember":              {ipa.ErrLastMember, false},
+	"ErrGroupNotFound":           {ipa.ErrGroupNotFound, false},
+	"ErrTooManyChanges":          {ipa.ErrTooManyChanges, false},
+	"ErrMutationDenied":          {ipa.ErrMutationDenied, false},
+	"ErrConflictingExisting":     {ipa.ErrConflictingExisting, false},
+	"ErrPasswordPolicy":          {ipa.ErrPasswordPolicy, false},
+	"ErrInvalidPassword":         {ipa.ErrInvalidPassword, false},
+	"ErrExpiredPassword":         {ipa.ErrExpiredPassword, false},
+	"ErrOTPRequired":             {ipa.ErrOTPRequired, false},
+	"ErrUnauthorized":            {ipa.ErrUnauthorized, false},
+	"ErrUserExists":              {ipa.ErrUserExists, false},
+	"ErrGroupExists":             {ipa.ErrGroupExists, false},
+	"ErrHostGroupExists":         {ipa.ErrHostGroupExists, false},
+	"ErrInvalidName":             {ipa.ErrInvalidName, false},
+	"ErrDryRun":                  {ipa.ErrDryRun, false},
+	"ErrReadOnlyClient":          {ipa.ErrReadOnlyClient, false},
+	"ErrResponseTooLarge":        {ipa.ErrResponseTooLarge, false},
+	"ErrCommandNotSupported":     {ipa.ErrCommandNotSupported, false},
+	"ErrInvalidResponse":         {ipa.ErrInvalidResponse, false},
+	"ErrClientClosed":            {ipa.ErrClientClosed, false},
+	"ErrEmptyArgument":           {ipa.ErrEmptyArgument, false},
+	"ErrNoKerberosCredentials":   {ipa.ErrNoKerberosCredentials, false},
+	"ErrKerberosAuth":            {ipa.ErrKerberosAuth, false},
+	"ErrDelegationNotConfigured": {ipa.ErrDelegationNotConfigured, false},
+	"ErrPrincipalMismatch":       {ipa.ErrPrincipalMismatch, false},
+	"ErrInvalidExpiration":       {ipa.ErrInvalidExpiration, false},
+	"ErrConcurrentModification":  {ipa.ErrConcurrentModification, false},
+	"ErrMigrationDisabled":       {ipa.ErrMigrationDisabled, false},
+	"ErrTokenOwnerChange":        {ipa.ErrTokenOwnerChange, false},
+	"ErrHasReferences":           {ipa.ErrHasReferences, false},
+	"ErrInvalidDuration":         {ipa.ErrInvalidDuration, false},
+	"ErrUnsupportedEntity":       {ipa.ErrUnsupportedEntity, false},
+	"ErrDuplicateSSHKey":         {ipa.ErrDuplicateSSHKey, false},
+	"ErrCategoryConflict":        {ipa.ErrCategoryConflict, false},
+}
+
+func codeError(code int) error {
+	return fmt.Errorf("ipa: user_mod: %w", &ipa.IpaError{Code: code, Message: "localized message"})
+}
+
+func TestIsRetryable(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Find all error code constants and sentinel errors declared in the
+	// package so new ones can not be left unclassified
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	require.NoError(err)
+	require.Contains(pkgs, "ipa")
+
+	for _, file := range pkgs["ipa"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || (gen.Tok != token.CONST && gen.Tok != token.VAR) {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					isCode := gen.Tok == token.CONST && strings.HasPrefix(name.Name, "ErrCode")
+					isSentinel := gen.Tok == token.VAR && strings.HasPrefix(name.Name, "Err")
+					if isCode || isSentinel {
+						_, ok := retryableClassification[name.Name]
+						assert.Truef(ok, "%s must be classified as retryable or not in retryableClassification", name.Name)
+					}
+				}
+			}
+		}
+	}
+
+	c := ipa.NewDefaultClient()
+	for name, test := range retryableClassification {
+		assert.Equalf(test.retryable, ipa.IsRetryable(test.err), "Wrong classification of %s", name)
+		assert.Equalf(test.retryable, c.IsRetryable(test.err), "Wrong client classification of %s", name)
+	}
+
+	assert.False(ipa.IsRetryable(nil))
+	assert.False(ipa.IsRetryable(errors.New("ipa: error 4203 - database error")))
+
+	c.SetRetryableCodes(ipa.ErrCodeTimeLimitExceeded, ipa.ErrCodeAdminLimitExceeded)
+	assert.True(c.IsRetryable(codeError(ipa.ErrCodeTimeLimitExceeded)))
+	assert.True(c.IsRetryable(codeError(ipa.ErrCodeDatabaseError)))
+	assert.False(ipa.IsRetryable(codeError(ipa.ErrCodeTimeLimitExceeded)))
+	assert.Truef(c.Clone().IsRetryable(codeError(ipa.ErrCodeAdminLimitExceeded)), "Clones should keep retryable codes")
+
+	c.SetRetryableCodes(ipa.ErrCodeSizeLimitExceeded)
+	assert.Falsef(c.IsRetryable(codeError(ipa.ErrCodeTimeLimitExceeded)), "Codes should be replaced")
+	assert.True(c.IsRetryable(codeError(ipa.ErrCodeSizeLimitExceeded)))
+}
+
+func TestIsRetryablePermanent(t *testing.T) {
+	assert := assert.New(t)
+
+	constraint := &ipa.IpaError{Code: ipa.ErrCodeDatabaseError, Message: "Constraint violation: Password is too short"}
+	assert.Falsef(ipa.IsRetryable(constraint), "Constraint violations should not be retried")
+
+	localized := &ipa.IpaError{Code: ipa.ErrCodeDatabaseError, Message: "localized message", Data: []byte(`{"desc": "Constraint violation", "info": "Password is too short"}`)}
+	assert.False(ipa.IsRetryable(localized))
+
+	c := newTestClientStub(t, stubError(ipa.ErrCodeDatabaseError, "Constraint violation: Password is too short"))
+	c.SetRetryableCodes(ipa.ErrCodeDatabaseError)
+	err := c.ChangePassword("jdoe", "old", "new", "")
+	assert.ErrorIs(err, ipa.ErrPasswordPolicy)
+	assert.Falsef(ipa.IsRetryable(err), "Password policy rejections should not be retried")
+	assert.False(c.IsRetryable(err))
+	assert.False(ipa.IsRetryable(fmt.Errorf("ipa: %w: %w", ipa.ErrPasswordPolicy, codeError(ipa.ErrCodeDatabaseError))))
+
+	assert.True(ipa.IsRetryable(&ipa.IpaError{Code: ipa.ErrCodeDatabaseError, Message: "Server is unwilling to perform: database is read-only"}))
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+// Offboard a FreeIPA user: disable the user and their OTP tokens, remove them
+// from groups and optionally preserve or delete the user.
+//
+//	$ kinit admin
+//	$ go run ./examples/offboard -user jdoe -preserve
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ubccr/goipa"
+)
+
+func main() {
+	var (
+		ccache     = flag.String("ccache", os.Getenv("KRB5CCNAME"), "kerberos credential cache")
+		keytab     = flag.String("keytab", "", "login using keytab instead of ccache")
+		username   = flag.String("user", "", "username")
+		preserve   = flag.Bool("preserve", false, "move the user to the Delete container")
+		del        = flag.Bool("delete", false, "permanently delete the user")
+		keepGroups = flag.Bool("keep-groups", false, "keep group memberships")
+		keepTokens = flag.Bool("keep-tokens", false, "keep otp tokens enabled")
+	)
+	flag.Parse()
+
+	client, err := login(*ccache, *keytab)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin := ipa.NewAdmin(client)
+	admin.OnStep = func(step ipa.Step) {
+		log.Println(step)
+	}
+
+	err = admin.OffboardUser(*username, ipa.OffboardOptions{
+		Preserve:   *preserve,
+		Delete:     *del,
+		KeepGroups: *keepGroups,
+		KeepTokens: *keepTokens,
+	})
+
+	var werr *ipa.WorkflowError
+	if errors.As(err, &werr) {
+		for _, step := range werr.RollbackFailed() {
+			log.Printf("manual cleanup required: %s", step)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func login(ccache, keytab string) (*ipa.Client, error) {
+	client := ipa.NewDefaultClient()
+
+	if keytab != "" {
+		return client, client.LoginWithKeytab(keytab, os.Getenv("USER"))
+	}
+
+	if ccache == "" {
+		ccache = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+
+	return client, client.LoginFromCCache(strings.TrimPrefix(ccache, "FILE:"))
+}
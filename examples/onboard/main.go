@@ -0,0 +1,88 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+// Onboard a new FreeIPA user: create the user with a temporary password, add
+// them to groups and add a TOTP token.
+//
+//	$ kinit admin
+//	$ go run ./examples/onboard -user jdoe -first John -last Doe -group staff -otp
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ubccr/goipa"
+)
+
+func main() {
+	var (
+		ccache   = flag.String("ccache", os.Getenv("KRB5CCNAME"), "kerberos credential cache")
+		keytab   = flag.String("keytab", "", "login using keytab instead of ccache")
+		username = flag.String("user", "", "username")
+		first    = flag.String("first", "", "first name")
+		last     = flag.String("last", "", "last name")
+		email    = flag.String("email", "", "email address")
+		shell    = flag.String("shell", "", "login shell")
+		sshKey   = flag.String("sshkey", "", "ssh public key")
+		groups   = flag.String("group", "", "comma separated list of groups")
+		otp      = flag.Bool("otp", false, "add a TOTP token")
+	)
+	flag.Parse()
+
+	client, err := login(*ccache, *keytab)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	spec := ipa.OnboardSpec{
+		Username: *username,
+		First:    *first,
+		Last:     *last,
+		Email:    *email,
+		Shell:    *shell,
+	}
+	if *sshKey != "" {
+		spec.SSHKeys = []string{*sshKey}
+	}
+	if *groups != "" {
+		spec.Groups = strings.Split(*groups, ",")
+	}
+	if *otp {
+		spec.OTPToken = ipa.DefaultTOTPToken
+	}
+
+	admin := ipa.NewAdmin(client)
+	admin.OnStep = func(step ipa.Step) {
+		log.Println(step)
+	}
+
+	res, err := admin.OnboardUserDetailed(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Created user %s (uid %s)\n", res.User.Username, res.User.Uid)
+	fmt.Printf("Temporary password: %s\n", res.Password.Reveal())
+	if res.OTPToken != nil {
+		fmt.Printf("OTP token URI: %s\n", res.OTPToken.URI)
+	}
+}
+
+func login(ccache, keytab string) (*ipa.Client, error) {
+	client := ipa.NewDefaultClient()
+
+	if keytab != "" {
+		return client, client.LoginWithKeytab(keytab, os.Getenv("USER"))
+	}
+
+	if ccache == "" {
+		ccache = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+
+	return client, client.LoginFromCCache(strings.TrimPrefix(ccache, "FILE:"))
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Returns true if user exists
+func (c *Client) UserExists(username string) (bool, error) {
+	return c.exists("user_find", "uid", username)
+}
+
+// Returns true if group exists
+func (c *Client) GroupExists(name string) (bool, error) {
+	return c.exists("group_find", "cn", name)
+}
+
+// Returns true if host group exists
+func (c *Client) HostGroupExists(name string) (bool, error) {
+	return c.exists("hostgroup_find", "cn", name)
+}
+
+// Returns true if host exists. Internationalized host names are converted to
+// punycode.
+func (c *Client) HostExists(fqdn string) (bool, error) {
+	fqdn, err := hostToASCII(fqdn)
+	if err != nil {
+		return false, err
+	}
+
+	return c.exists("host_find", "fqdn", fqdn)
+}
+
+// Returns true if an entry with primary key name exists. entity is the
+// FreeIPA object name, for example "hbacrule" or "sudorule", and the
+// <entity>_find method is used to search for name. Only the primary keys of
+// matching entries are fetched, without a size limit. If FreeIPA truncates
+// the results, for example at its configured search limit, and name is not
+// among them ErrTruncated is returned.
+func (c *Client) Exists(entity, name string) (bool, error) {
+	options := Options{
+		"pkey_only": true,
+		"sizelimit": 0,
+	}
+
+	res, err := c.rpc(entity+"_find", []string{name}, options)
+	if err != nil {
+		return false, existsError(err)
+	}
+
+	// Find matches name as a substring of any attribute so check the primary
+	// key of each entry. With pkey_only entries only include the dn and
+	// primary key.
+	found := false
+	gjson.ParseBytes(res.Result.Data).ForEach(func(_, entry gjson.Result) bool {
+		entry.ForEach(func(attr, value gjson.Result) bool {
+			if attr.String() != "dn" && strings.EqualFold(value.Get("0").String(), name) {
+				found = true
+			}
+			return !found
+		})
+		return !found
+	})
+
+	if !found && res.Result.Truncated {
+		return false, ErrTruncated
+	}
+
+	return found, nil
+}
+
+// Returns true if find method returns an entry with attr equal to value
+func (c *Client) exists(method, attr, value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	options := Options{
+		attr:        value,
+		"pkey_only": true,
+		"sizelimit": 1,
+	}
+
+	res, err := c.rpc(method, []string{}, options)
+	if err != nil {
+		return false, existsError(err)
+	}
+
+	return len(gjson.ParseBytes(res.Result.Data).Array()) > 0, nil
+}
+
+// Existence checks only fail on transport or permission errors
+func existsError(err error) error {
+	if IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
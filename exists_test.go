@@ -0,0 +1,119 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestExists(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	ok, err := c.UserExists(TestEnvAdminUser)
+	require.NoError(err)
+	assert.Truef(ok, "Admin user should exist")
+
+	ok, err = c.UserExists(gofakeit.Username())
+	require.NoError(err)
+	assert.Falsef(ok, "Random user should not exist")
+
+	ok, err = c.GroupExists("admins")
+	require.NoError(err)
+	assert.Truef(ok, "admins group should exist")
+
+	ok, err = c.HostExists(TestEnvHost)
+	require.NoError(err)
+	assert.Truef(ok, "Test host should exist")
+
+	ok, err = c.Exists("hbacrule", "allow_all")
+	require.NoError(err)
+	assert.Truef(ok, "allow_all hbac rule should exist")
+}
+
+func TestExistsStub(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var method string
+	var options map[string]interface{}
+	result := `{"count": 0, "result": [], "summary": null, "truncated": false}`
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		method = req.Method
+		options = req.Options
+		stubResult(result)(w, r)
+	})
+
+	ok, err := c.UserExists("jdoe")
+	require.NoError(err)
+	assert.False(ok)
+	assert.Equal("user_find", method)
+	assert.Equal("jdoe", options["uid"])
+	assert.Equal(true, options["pkey_only"])
+	assert.Equal(float64(1), options["sizelimit"])
+
+	result = `{"count": 1, "result": [{"dn": "fqdn=client.local,cn=computers,cn=accounts,dc=local", "fqdn": ["client.local"]}], "summary": "1 host matched", "truncated": false}`
+	ok, err = c.HostExists("client.local")
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal("host_find", method)
+	assert.Equal("client.local", options["fqdn"])
+
+	// Generic find matches substrings so only exact primary keys count
+	result = `{"count": 1, "result": [{"dn": "cn=allow_all_users,cn=sudorules,cn=sudo,dc=local", "cn": ["allow_all_users"]}], "summary": "1 rule matched", "truncated": false}`
+	ok, err = c.Exists("sudorule", "allow_all")
+	require.NoError(err)
+	assert.False(ok)
+	assert.Equal("sudorule_find", method)
+
+	ok, err = c.Exists("sudorule", "allow_all_users")
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equalf(float64(0), options["sizelimit"], "Exists should not limit the number of matches")
+
+	// The exact name may be cut off from truncated results
+	result = `{"count": 1, "result": [{"dn": "cn=allow_all_users,cn=sudorules,cn=sudo,dc=local", "cn": ["allow_all_users"]}], "summary": "1 rule matched", "truncated": true}`
+	_, err = c.Exists("sudorule", "allow_all")
+	assert.ErrorIs(err, ipa.ErrTruncated)
+	ok, err = c.Exists("sudorule", "allow_all_users")
+	require.NoError(err)
+	assert.True(ok)
+
+	c = newTestClientStub(t, stubError(2100, `Insufficient access`))
+	_, err = c.GroupExists("admins")
+	assert.Errorf(err, "Permission errors should be returned")
+}
+
+func BenchmarkUserExists(b *testing.B) {
+	c, err := newTestClientCCache()
+	if err != nil {
+		b.Skip("No kerberos credentials. Skipping")
+	}
+
+	for i := 0; i < b.N; i++ {
+		c.UserExists(TestEnvAdminUser)
+	}
+}
+
+func BenchmarkUserShow(b *testing.B) {
+	c, err := newTestClientCCache()
+	if err != nil {
+		b.Skip("No kerberos credentials. Skipping")
+	}
+
+	for i := 0; i < b.N; i++ {
+		c.UserShow(TestEnvAdminUser)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+// GroupMode controls how ImportUsers handles group memberships
+type GroupMode int
+
+const (
+	// GroupsIgnore does not change group memberships
+	GroupsIgnore GroupMode = iota
+
+	// GroupsApply adds users to their groups. Missing groups are reported
+	// as errors
+	GroupsApply
+
+	// GroupsCreate adds users to their groups, creating missing groups
+	GroupsCreate
+)
+
+// Outcomes of importing a single user
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportConflict  = "conflict"
+	ImportFailed    = "failed"
+)
+
+// ImportOptions configures ImportUsers
+type ImportOptions struct {
+	// Update existing users. Otherwise existing users are reported as
+	// conflicts and left unchanged
+	Upsert bool
+
+	GroupMode GroupMode
+
+	// Report the changes which would be made without making them
+	DryRun bool
+}
+
+// ImportResult is the outcome of importing a single user
+type ImportResult struct {
+	Username string
+	Outcome  string
+
+	// Attribute changes made, or which would be made in dry-run mode. For
+	// created users the changes are from an empty user
+	Changes []FieldChange
+
+	// Groups the user was added to, or would be added to in dry-run mode
+	Groups []string
+
+	// Error importing the user. If the user was created or updated but
+	// adding it to a group failed, Outcome is unchanged and Err is set
+	Err error
+}
+
+// ImportReport lists the outcome for each imported user in input order
+type ImportReport struct {
+	Results []ImportResult
+	DryRun  bool
+}
+
+// Returns the number of users with outcome
+func (r *ImportReport) Count(outcome string) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Outcome == outcome {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Returns the results with errors
+func (r *ImportReport) Errors() []ImportResult {
+	errs := []ImportResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			errs = append(errs, res)
+		}
+	}
+
+	return errs
+}
+
+// User record as written by ExportUsers. Fields of User which can not be
+// unmarshalled from their marshalled form are shadowed.
+type userRecord struct {
+	*User
+	SSHAuthKeys    []string        `json:"ipasshpubkey"`
+	RandomPassword json.RawMessage `json:"randompassword"`
+
+	// The first class, or a list of classes. Exports list all classes in
+	// userclasses, older exports only have userclass
+	UserClass json.RawMessage `json:"userclass"`
+}
+
+// Returns the user with ssh keys parsed
+func (r *userRecord) user() (*User, error) {
+	if r.Username == "" {
+		return nil, errors.New("Username is required")
+	}
+
+	u := *r.User
+	if len(u.Categories) == 0 && len(r.UserClass) > 0 {
+		classes, err := parseStringOrList(r.UserClass)
+		if err != nil {
+			return nil, fmt.Errorf("ipa: invalid user class for %s: %w", u.Username, err)
+		}
+		u.Categories = classes
+	}
+	u.Category = ""
+	if len(u.Categories) > 0 {
+		u.Category = u.Categories[0]
+	}
+
+	u.SSHAuthKeys = nil
+	for _, k := range r.SSHAuthKeys {
+		key, err := NewSSHAuthorizedKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("ipa: invalid ssh key for %s: %w", u.Username, err)
+		}
+		u.SSHAuthKeys = append(u.SSHAuthKeys, key)
+	}
+
+	return &u, nil
+}
+
+// Unmarshal a JSON string, list of strings or null. An empty string is an
+// empty list
+func parseStringOrList(raw json.RawMessage) ([]string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return optionValues(s), nil
+	}
+
+	list := []string{}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []string{}
+	}
+
+	return list, nil
+}
+
+// Write users matching the user_find options to w as newline-delimited JSON,
+// one User per line in its marshalled form. Only usernames are searched for
+// up front and each user is then fetched and written in turn, so memory use
+// does not grow with the number of users. The default find size limit is not
+// applied; ErrTruncated is returned if the server truncates the search.
+func ExportUsers(c *Client, options Options, w io.Writer) error {
+	findOptions := Options{"sizelimit": 0}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+	findOptions["pkey_only"] = true
+
+	res, err := c.rpc("user_find", []string{""}, findOptions)
+	if err != nil {
+		return err
+	}
+	if res.Result.Truncated {
+		return ErrTruncated
+	}
+
+	enc := json.NewEncoder(w)
+	for _, entry := range gjson.ParseBytes(res.Result.Data).Array() {
+		user, err := c.UserShow(entry.Get("uid.0").String())
+		if err != nil {
+			return err
+		}
+
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create users read from r, in the format written by ExportUsers. Users are
+// read and imported one at a time. Existing users are updated if opts.Upsert
+// is set, using DiffUsers so only changed attributes are sent. Per user
+// failures are reported in the returned report and do not stop the import.
+// An error is only returned if r can not be read or decoded.
+func ImportUsers(c *Client, r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{DryRun: opts.DryRun}
+
+	dec := json.NewDecoder(r)
+	for {
+		rec := userRecord{User: new(User)}
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("ipa: invalid user record %d: %w", len(report.Results)+1, err)
+		}
+
+		report.Results = append(report.Results, c.importUser(&rec, opts))
+	}
+
+	return report, nil
+}
+
+func (c *Client) importUser(rec *userRecord, opts ImportOptions) ImportResult {
+	result := ImportResult{Username: rec.Username}
+	fail := func(err error) ImportResult {
+		result.Outcome = ImportFailed
+		result.Err = err
+		return result
+	}
+
+	desired, err := rec.user()
+	if err != nil {
+		return fail(err)
+	}
+	desired.UpdateLocked = true
+
+	exists := true
+	current, err := c.UserShow(desired.Username)
+	if err != nil {
+		if !IsNotFound(err) {
+			return fail(err)
+		}
+		exists = false
+		current = &User{Username: desired.Username}
+	}
+
+	if exists && !opts.Upsert {
+		result.Outcome = ImportConflict
+		return result
+	}
+
+	changes, err := DiffUsers(current, desired)
+	if err != nil {
+		return fail(err)
+	}
+	attrChanges := changes
+	authChange, authChanged := diffAuthTypes(current.AuthTypes, desired.AuthTypes)
+	if authChanged {
+		changes = append(changes, authChange)
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Attribute < changes[j].Attribute
+		})
+	}
+	result.Changes = changes
+
+	switch {
+	case !exists:
+		result.Outcome = ImportCreated
+	case len(changes) > 0:
+		result.Outcome = ImportUpdated
+	default:
+		result.Outcome = ImportUnchanged
+	}
+
+	groups := []string{}
+	if opts.GroupMode != GroupsIgnore {
+		for _, g := range desired.Groups {
+			if g != DefaultIPAUsersGroup && !current.HasGroup(g) {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	if opts.DryRun {
+		result.Groups = groups
+		return result
+	}
+
+	if !exists {
+		if _, err := c.UserAdd(desired, false); err != nil {
+			return fail(err)
+		}
+	} else if len(attrChanges) > 0 {
+		options, err := current.ApplyChanges(attrChanges)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := c.UserModOptions(desired.Username, options); err != nil {
+			return fail(err)
+		}
+	}
+
+	if authChanged {
+		if _, err := c.SetAuthTypes(desired.Username, desired.AuthTypes); err != nil {
+			return fail(err)
+		}
+	}
+
+	for _, g := range groups {
+		if err := c.importGroupMember(g, desired.Username, opts.GroupMode); err != nil {
+			result.Err = fmt.Errorf("ipa: failed to add %s to group %s: %w", desired.Username, g, err)
+			continue
+		}
+		result.Groups = append(result.Groups, g)
+	}
+
+	return result
+}
+
+// Add username to group, creating the group first if it is missing and mode
+// is GroupsCreate
+func (c *Client) importGroupMember(group, username string, mode GroupMode) error {
+	_, err := c.AddUserToGroup(group, username)
+	if err == nil || mode != GroupsCreate {
+		return err
+	}
+
+	if !IsNotFound(err) {
+		return err
+	}
+
+	if _, err := c.GroupAdd(group, ""); err != nil && !errors.Is(err, ErrGroupExists) {
+		return err
+	}
+
+	_, err = c.AddUserToGroup(group, username)
+	return err
+}
+
+// Compare authentication types ignoring order. Returns false if there are no
+// changes
+func diffAuthTypes(current, desired []string) (FieldChange, bool) {
+	return diffValues("ipauserauthtype", current, desired)
+}
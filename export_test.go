@@ -0,0 +1,233 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a client whose server has the users jdoe and jsmith
+func newExportStub(t *testing.T, findOptions *map[string]interface{}) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+
+		switch req.Method {
+		case "user_find":
+			json.Unmarshal(req.Params[1], findOptions)
+			stubResult(`{"count": 2, "result": [{"dn": "uid=jdoe,cn=users,cn=accounts,dc=local", "uid": ["jdoe"]}, {"dn": "uid=jsmith,cn=users,cn=accounts,dc=local", "uid": ["jsmith"]}], "summary": "2 users matched", "truncated": false}`)(w, r)
+		case "user_show":
+			stubResult(fmt.Sprintf(`{"result": {"uid": [%q], "givenname": ["John"], "sn": ["Doe"], "loginshell": ["/bin/bash"], "uidnumber": ["1001"],
+				"ipasshpubkey": [%q], "ipauserauthtype": ["otp"], "memberof_group": ["ipausers", "staff"],
+				"krblastpwdchange": [{"__datetime__": "20230405060708Z"}]}, "summary": null, "value": %q}`, args[0], testSSHKeyLaptop, args[0]))(w, r)
+		default:
+			stubError(905, "unexpected method")(w, r)
+		}
+	})
+}
+
+func TestExportUsers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var findOptions map[string]interface{}
+	c := newExportStub(t, &findOptions)
+
+	var out bytes.Buffer
+	require.NoError(ipa.ExportUsers(c, ipa.Options{"in_group": "staff"}, &out))
+	assert.Equal(true, findOptions["pkey_only"])
+	assert.Equalf(float64(0), findOptions["sizelimit"], "Export should not apply the default size limit")
+	assert.Equal("staff", findOptions["in_group"])
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(lines, 2)
+	var rec map[string]interface{}
+	require.NoError(json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal("jsmith", rec["uid"])
+	assert.Equal([]interface{}{testSSHKeyLaptop}, rec["ipasshpubkey"])
+	assert.Equal([]interface{}{"otp"}, rec["ipauserauthtype"])
+	assert.Equal([]interface{}{"ipausers", "staff"}, rec["memberof_group"])
+}
+
+// Target realm with user jsmith in group staff
+type importStub struct {
+	users  map[string]string
+	groups map[string]bool
+	calls  []string
+}
+
+func newImportStub(t *testing.T, s *importStub) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		options := req.Options
+		delete(options, "version")
+		delete(options, "all")
+
+		if req.Method != "user_show" {
+			b, _ := json.Marshal(options)
+			s.calls = append(s.calls, fmt.Sprintf("%s %s %s", req.Method, args[0], b))
+		}
+
+		switch req.Method {
+		case "user_show":
+			rec, ok := s.users[args[0]]
+			if !ok {
+				stubError(4001, args[0]+": user not found")(w, r)
+				return
+			}
+			stubResult(`{"result": `+rec+`, "summary": null}`)(w, r)
+		case "user_add", "user_mod":
+			stubResult(fmt.Sprintf(`{"result": {"uid": [%q]}, "summary": null, "value": %q}`, args[0], args[0]))(w, r)
+		case "group_add":
+			s.groups[args[0]] = true
+			stubResult(fmt.Sprintf(`{"result": {"cn": [%q]}, "summary": null, "value": %q}`, args[0], args[0]))(w, r)
+		case "group_add_member":
+			if !s.groups[args[0]] {
+				stubError(4001, args[0]+": group not found")(w, r)
+				return
+			}
+			stubResult(`{"completed": 1, "failed": {"member": {"group": [], "user": []}}, "result": {"cn": ["staff"]}}`)(w, r)
+		default:
+			stubError(905, "unexpected method")(w, r)
+		}
+	})
+}
+
+const importUsers = `{"uid": "jdoe", "givenname": "John", "sn": "Doe", "loginshell": "/bin/bash", "ipasshpubkey": ["` + testSSHKeyLaptop + `"], "ipauserauthtype": ["otp"], "memberof_group": ["ipausers", "staff", "dev"], "randompassword": "[REDACTED]", "krblastpwdchange": {"__datetime__": "20230405060708Z"}}
+{"uid": "jsmith", "givenname": "Jane", "sn": "Smith", "loginshell": "/bin/zsh", "memberof_group": ["ipausers", "staff"]}
+{"uid": "", "givenname": "Nobody"}
+`
+
+func newTargetRealm() *importStub {
+	return &importStub{
+		users:  map[string]string{"jsmith": `{"uid": ["jsmith"], "givenname": ["Jane"], "sn": ["Smith"], "loginshell": ["/bin/sh"], "memberof_group": ["ipausers", "staff"]}`},
+		groups: map[string]bool{"staff": true},
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	s := newTargetRealm()
+	c := newImportStub(t, s)
+
+	report, err := ipa.ImportUsers(c, strings.NewReader(importUsers), ipa.ImportOptions{Upsert: true, GroupMode: ipa.GroupsCreate})
+	require.NoError(err)
+	require.Len(report.Results, 3)
+
+	jdoe := report.Results[0]
+	assert.Equal(ipa.ImportCreated, jdoe.Outcome)
+	assert.NoError(jdoe.Err)
+	assert.Equal([]string{"staff", "dev"}, jdoe.Groups)
+
+	jsmith := report.Results[1]
+	assert.Equal(ipa.ImportUpdated, jsmith.Outcome)
+	require.Len(jsmith.Changes, 1)
+	assert.Equal("loginshell", jsmith.Changes[0].Attribute)
+	assert.Empty(jsmith.Groups)
+
+	assert.Equal(ipa.ImportFailed, report.Results[2].Outcome)
+	assert.Error(report.Results[2].Err)
+	assert.Len(report.Errors(), 1)
+	assert.Equal(1, report.Count(ipa.ImportCreated))
+
+	assert.Equal([]string{
+		`user_add jdoe {"displayname":"","givenname":"John","homedirectory":"","ipasshpubkey":["` + testSSHKeyLaptop + `"],"loginshell":"/bin/bash","mail":"","mobile":"","setattr":["nsaccountlock=FALSE"],"sn":"Doe","telephonenumber":"","userclass":""}`,
+		`user_mod jdoe {"ipauserauthtype":["otp"],"no_members":false}`,
+		`group_add_member staff {"user":["jdoe"]}`,
+		`group_add_member dev {"user":["jdoe"]}`,
+		`group_add dev {}`,
+		`group_add_member dev {"user":["jdoe"]}`,
+		`user_mod jsmith {"loginshell":"/bin/zsh"}`,
+	}, s.calls)
+}
+
+func TestImportUsersConflicts(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	s := newTargetRealm()
+	c := newImportStub(t, s)
+
+	report, err := ipa.ImportUsers(c, strings.NewReader(importUsers), ipa.ImportOptions{GroupMode: ipa.GroupsApply})
+	require.NoError(err)
+	assert.Equal(ipa.ImportConflict, report.Results[1].Outcome)
+	assert.Equal([]string{"staff"}, report.Results[0].Groups)
+	require.Error(report.Results[0].Err)
+	assert.Contains(report.Results[0].Err.Error(), "group dev")
+	for _, call := range s.calls {
+		assert.NotContains(call, "jsmith")
+		assert.NotContains(call, "group_add ")
+	}
+
+	_, err = ipa.ImportUsers(c, strings.NewReader(`{"uid": "jdoe"`), ipa.ImportOptions{})
+	assert.Error(err)
+}
+
+func TestImportUsersDryRun(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	s := newTargetRealm()
+	c := newImportStub(t, s)
+
+	report, err := ipa.ImportUsers(c, strings.NewReader(importUsers), ipa.ImportOptions{Upsert: true, GroupMode: ipa.GroupsCreate, DryRun: true})
+	require.NoError(err)
+	assert.True(report.DryRun)
+	assert.Emptyf(s.calls, "Dry run should not modify the target")
+
+	jdoe := report.Results[0]
+	assert.Equal(ipa.ImportCreated, jdoe.Outcome)
+	assert.Equal([]string{"staff", "dev"}, jdoe.Groups)
+	attrs := []string{}
+	for _, change := range jdoe.Changes {
+		attrs = append(attrs, change.Attribute)
+	}
+	assert.Equal([]string{"givenname", "ipasshpubkey", "ipauserauthtype", "loginshell", "sn"}, attrs)
+
+	jsmith := report.Results[1]
+	assert.Equal(ipa.ImportUpdated, jsmith.Outcome)
+	require.Len(jsmith.Changes, 1)
+	assert.Equal("/bin/sh", jsmith.Changes[0].Old)
+	assert.Equal("/bin/zsh", jsmith.Changes[0].New)
+}
+
+func TestImportUsersUserClass(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	s := newTargetRealm()
+	c := newImportStub(t, s)
+
+	// Older exports have a single userclass string, exports list every
+	// class in userclasses
+	users := `{"uid": "jdoe", "givenname": "John", "sn": "Doe", "userclass": "staff"}
+{"uid": "jsmith", "givenname": "Jane", "sn": "Smith", "loginshell": "/bin/sh", "userclass": "staff", "userclasses": ["staff", "hpc"]}
+`
+	report, err := ipa.ImportUsers(c, strings.NewReader(users), ipa.ImportOptions{Upsert: true, DryRun: true})
+	require.NoError(err)
+	require.Len(report.Results, 2)
+
+	changes := map[string]ipa.FieldChange{}
+	for _, change := range report.Results[0].Changes {
+		changes[change.Attribute] = change
+	}
+	assert.Equal([]string{"staff"}, changes["userclass"].New)
+
+	require.Len(report.Results[1].Changes, 1)
+	assert.Equal("userclass", report.Results[1].Changes[0].Attribute)
+	assert.Equal([]string{"staff", "hpc"}, report.Results[1].Changes[0].New)
+}
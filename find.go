@@ -0,0 +1,80 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrTruncated is returned by find methods when FindOptions.FailOnTruncated
+// is set and FreeIPA truncated the results
+var ErrTruncated = errors.New("find results truncated")
+
+// FindOptions bounds the server side work of all *_find methods. Limits are
+// sent as the sizelimit and timelimit options unless the caller sets them
+// explicitly in the method options. A limit of 0 is unlimited.
+type FindOptions struct {
+	// Maximum number of entries returned
+	SizeLimit int
+
+	// Maximum time the server spends searching. Rounded up to whole seconds
+	TimeLimit time.Duration
+
+	// Return ErrTruncated instead of partial results if the size or time
+	// limit was exceeded
+	FailOnTruncated bool
+}
+
+// DefaultFindOptions are used by clients which have not called
+// SetFindOptions
+var DefaultFindOptions = FindOptions{
+	SizeLimit: 2000,
+	TimeLimit: 10 * time.Second,
+}
+
+// Set the limits applied to find methods
+func (c *Client) SetFindOptions(opts FindOptions) {
+	c.findOpts = &opts
+}
+
+func (c *Client) findOptions() FindOptions {
+	if c.findOpts != nil {
+		return *c.findOpts
+	}
+
+	return DefaultFindOptions
+}
+
+// Set sizelimit and timelimit on options unless they are already set
+func (f FindOptions) apply(options Options) {
+	if _, ok := options["sizelimit"]; !ok {
+		options["sizelimit"] = f.SizeLimit
+	}
+	if _, ok := options["timelimit"]; !ok {
+		seconds := f.TimeLimit / time.Second
+		if f.TimeLimit%time.Second > 0 {
+			seconds++
+		}
+		options["timelimit"] = int(seconds)
+	}
+}
+
+// Returns true if method is a FreeIPA search method
+func isFind(method string) bool {
+	return strings.HasSuffix(method, "_find")
+}
+
+// Returns true if method searches the FreeIPA API schema, such as
+// command_find. Schema searches do not take sizelimit or timelimit
+func isSchemaFind(method string) bool {
+	switch method {
+	case "class_find", "command_find", "param_find", "output_find", "topic_find":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestFindOptions(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options string
+	c := newRecordingClient(t, &options)
+
+	_, err := c.UserFind(nil)
+	require.NoError(err)
+	assert.JSONEq(`{"all":true,"no_members":false,"sizelimit":2000,"timelimit":10}`, options)
+
+	_, err = c.FetchOTPTokens("jdoe")
+	require.NoError(err)
+	assert.JSONEq(`{"all":true,"ipatokenowner":"jdoe","sizelimit":2000,"timelimit":10}`, options)
+
+	_, err = c.UserFind(ipa.Options{"sizelimit": 0, "timelimit": 0})
+	require.NoError(err)
+	assert.JSONEqf(`{"all":true,"no_members":false,"sizelimit":0,"timelimit":0}`, options, "Explicit limits should not be overridden")
+
+	c.SetFindOptions(ipa.FindOptions{SizeLimit: 10, TimeLimit: 1500 * time.Millisecond})
+	_, err = c.UserFind(nil)
+	require.NoError(err)
+	assert.JSONEq(`{"all":true,"no_members":false,"sizelimit":10,"timelimit":2}`, options)
+
+	_, err = c.GroupExists("staff")
+	require.NoError(err)
+	assert.JSONEq(`{"cn":"staff","pkey_only":true,"sizelimit":1,"timelimit":2}`, options)
+
+	_, err = c.Ping()
+	require.NoError(err)
+	assert.JSONEqf(`{}`, options, "Limits should only be sent to find methods")
+}
+
+func TestFindTruncated(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"count": 1, "result": [{"uid": ["jdoe"]}], "summary": "1 user matched", "truncated": true}`))
+
+	events := []*ipa.Event{}
+	c.SetObserver(func(event *ipa.Event) {
+		events = append(events, event)
+	})
+
+	users, err := c.UserFind(nil)
+	require.NoError(err)
+	assert.Len(users, 1)
+	require.Len(events, 1)
+	assert.Equal(ipa.EventFindTruncated, events[0].Type)
+	assert.Equal("user_find", events[0].Method)
+
+	c.SetFindOptions(ipa.FindOptions{FailOnTruncated: true})
+	_, err = c.UserFind(nil)
+	assert.ErrorIs(err, ipa.ErrTruncated)
+}
+
+func TestFindRulesTruncated(t *testing.T) {
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		if req.Method == "host_show" {
+			stubResult(`{"result": {"fqdn": ["web01.example.com"]}, "summary": null, "value": "web01.example.com"}`)(w, r)
+			return
+		}
+		stubResult(`{"count": 1, "result": [{"cn": ["allow_all"], "ipaenabledflag": [true], "hostcategory": ["all"]}], "truncated": true}`)(w, r)
+	})
+
+	_, err := c.HbacRulesForHost("web01.example.com")
+	assert.ErrorIs(t, err, ipa.ErrTruncated)
+}
@@ -0,0 +1,232 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Read only commands captured by RecordFixtures. Show commands are run against
+// the first entry returned by find, whose primary key is attribute key. The
+// find result is only recorded if saveFind is set. Show commands without a
+// find, such as pwpolicy_show, are run without arguments. If raw is set the
+// show command is also recorded with raw=true as <method>_raw.json.
+var fixtureCommands = []struct {
+	find     string
+	show     string
+	key      string
+	saveFind bool
+	raw      bool
+}{
+	{"user_find", "user_show", "uid", true, true},
+	{"group_find", "group_show", "cn", false, false},
+	{"hbacrule_find", "hbacrule_show", "cn", false, false},
+	{"sudorule_find", "sudorule_show", "cn", false, false},
+	{"", "pwpolicy_show", "", false, false},
+}
+
+// Expiry of the OTP token added by RecordFixtures, in the past so the token
+// can never be used to log in
+var fixtureTokenNotAfter = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Attributes removed from recorded fixtures in addition to secretAttrs
+var fixtureSecretAttrs = []string{"krbprincipalkey", "ipanthash", "sambantpassword"}
+
+// Prefix of the placeholder UUIDs written to fixtures
+const fixtureUUIDPrefix = "00000000-0000-4000-8000-"
+
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Matches the secret parameter of otpauth URIs returned by otptoken_add
+var otpauthSecretRegexp = regexp.MustCompile(`(otpauth://[^"]*?[?&]secret=)[^&"]*`)
+
+// RecordFixtures captures responses from the FreeIPA server c is connected to
+// and writes them to dir as <method>.json, for use as parser test fixtures.
+// These are the files in testdata/versions/<version>. Apart from otptoken_add,
+// which is recorded by adding an already expired TOTP token for the first
+// user found and deleting it again, only read only commands are run. Secrets
+// are removed and UUIDs replaced with stable placeholders, however names,
+// email addresses and other identifying data are kept and should be reviewed
+// before fixtures are published.
+func RecordFixtures(c *Client, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	owner := ""
+	for _, cmd := range fixtureCommands {
+		params := []string{}
+		if cmd.find != "" {
+			res, err := c.rpc(cmd.find, []string{""}, Options{"all": true, "no_members": false, "sizelimit": 5})
+			if err != nil {
+				return fmt.Errorf("ipa: failed to record %s: %w", cmd.find, err)
+			}
+			if cmd.saveFind {
+				if err := writeFixture(dir, cmd.find, res.Result.Data); err != nil {
+					return err
+				}
+			}
+
+			pkey := gjson.GetBytes(res.Result.Data, "0."+cmd.key+".0").String()
+			if pkey == "" {
+				log.Warnf("ipa: no entries found by %s, skipping %s", cmd.find, cmd.show)
+				continue
+			}
+			params = []string{pkey}
+			if cmd.find == "user_find" {
+				owner = pkey
+			}
+		}
+
+		res, err := c.rpc(cmd.show, params, Options{"all": true, "no_members": false})
+		if err != nil {
+			return fmt.Errorf("ipa: failed to record %s: %w", cmd.show, err)
+		}
+		if err := writeFixture(dir, cmd.show, res.Result.Data); err != nil {
+			return err
+		}
+
+		if !cmd.raw {
+			continue
+		}
+		res, err = c.rpc(cmd.show, params, ShowOptions{All: true, Raw: true}.toOptions())
+		if err != nil {
+			return fmt.Errorf("ipa: failed to record raw %s: %w", cmd.show, err)
+		}
+		if err := writeFixture(dir, cmd.show+"_raw", res.Result.Data); err != nil {
+			return err
+		}
+	}
+
+	if owner == "" {
+		log.Warnf("ipa: no users found, skipping otptoken_add and otptoken_find")
+		return nil
+	}
+
+	return recordOTPFixtures(c, dir, owner)
+}
+
+// Record otptoken_add and otptoken_find for a TOTP token added for owner. The
+// token is deleted once recorded.
+func recordOTPFixtures(c *Client, dir, owner string) (err error) {
+	res, err := c.rpc("otptoken_add", []string{}, Options{
+		"type":             TokenTypeTOTP,
+		"ipatokenowner":    owner,
+		"description":      "laptop",
+		"ipatokennotafter": fixtureTokenNotAfter,
+		"no_qrcode":        true,
+		"qrcode":           false,
+		"no_members":       false,
+		"all":              true,
+	})
+	if err != nil {
+		return fmt.Errorf("ipa: failed to record otptoken_add: %w", err)
+	}
+
+	uuid := gjson.GetBytes(res.Result.Data, "ipatokenuniqueid.0").String()
+	defer func() {
+		if _, delErr := c.rpc("otptoken_del", []string{uuid}, nil); delErr != nil && err == nil {
+			err = fmt.Errorf("ipa: failed to delete fixture otp token %s: %w", uuid, delErr)
+		}
+	}()
+
+	if err = writeFixture(dir, "otptoken_add", res.Result.Data); err != nil {
+		return err
+	}
+
+	res, err = c.rpc("otptoken_find", []string{}, Options{"ipatokenowner": owner, "ipatokenuniqueid": uuid, "all": true, "no_members": false})
+	if err != nil {
+		return fmt.Errorf("ipa: failed to record otptoken_find: %w", err)
+	}
+
+	return writeFixture(dir, "otptoken_find", res.Result.Data)
+}
+
+func writeFixture(dir, method string, raw []byte) error {
+	out, err := sanitizeFixture(raw)
+	if err != nil {
+		return fmt.Errorf("ipa: failed to sanitize %s fixture: %w", method, err)
+	}
+
+	return os.WriteFile(filepath.Join(dir, method+".json"), out, 0644)
+}
+
+// Returns raw with secrets removed, including otpauth URI secrets, UUIDs
+// replaced with placeholders and keys sorted, indented by two spaces.
+// Sanitizing a fixture twice gives the same result, so fixtures can be checked
+// for stable formatting.
+func sanitizeFixture(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	removeFixtureSecrets(v)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	out := otpauthSecretRegexp.ReplaceAll(buf.Bytes(), []byte("${1}REDACTED"))
+	return uuidRegexp.ReplaceAllFunc(out, fixtureUUID), nil
+}
+
+func removeFixtureSecrets(v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		// Raw responses use the LDAP case of attribute names
+		for key, child := range val {
+			if isFixtureSecret(key) {
+				delete(val, key)
+				continue
+			}
+			removeFixtureSecrets(child)
+		}
+	case []interface{}:
+		for _, child := range val {
+			removeFixtureSecrets(child)
+		}
+	}
+}
+
+func isFixtureSecret(attr string) bool {
+	attr = strings.ToLower(attr)
+	for _, secret := range append(append([]string{}, secretAttrs...), fixtureSecretAttrs...) {
+		if attr == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns a placeholder for uuid derived from its hash, so the same UUID maps
+// to the same placeholder in every fixture. Placeholders are left unchanged.
+func fixtureUUID(uuid []byte) []byte {
+	if bytes.HasPrefix(bytes.ToLower(uuid), []byte(fixtureUUIDPrefix)) {
+		return uuid
+	}
+
+	sum := sha256.Sum256(bytes.ToLower(uuid))
+	return []byte(fixtureUUIDPrefix + hex.EncodeToString(sum[:6]))
+}
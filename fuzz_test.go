@@ -0,0 +1,78 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func FuzzUserFromJSON(f *testing.F) {
+	f.Add([]byte(`{"uid": ["jdoe"], "uidnumber": ["1000"], "memberof_group": ["ipausers"], "nsaccountlock": false}`))
+	f.Add([]byte(`{"krblastpwdchange": [{"__datetime__": "20200101000000Z"}]}`))
+	f.Add([]byte(`{"krblastpwdchange": [[[[{"__datetime__": [[[{}]]]}]]]]}`))
+	f.Add([]byte(`{"ipasshpubkey": ["ssh-rsa", "", null, 1, {}]}`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		u := new(User)
+		_ = u.fromJSON(raw)
+	})
+}
+
+func FuzzOTPTokenFromJSON(f *testing.F) {
+	f.Add([]byte(`{"ipatokenuniqueid": ["a3d4c7c8-7c7c-4c4c-9c9c-0123456789ab"], "ipatokenotpdigits": ["6"]}`))
+	f.Add([]byte(`{"ipatokenotpdigits": ["99999999999999999999999999999999"]}`))
+	f.Add([]byte(`{"ipatokenotpdigits": [-1], "ipatokentotptimestep": [1e400]}`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		tok := new(OTPToken)
+		if tok.fromJSON(raw) == nil {
+			_ = tok.DisplayName()
+		}
+	})
+}
+
+func FuzzFindResult(f *testing.F) {
+	f.Add([]byte(`[{"uid": ["jdoe"]}, {"uid": ["jsmith"]}]`))
+	f.Add([]byte(`[]`))
+	f.Add([]byte(`{"uid": ["jdoe"]}`))
+	f.Add([]byte(`[null, true, 1, "jdoe", [[]]]`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		_, _ = parseUserList(raw, UserLoadedFull)
+		_, _ = parseOTPTokenList(raw)
+	})
+}
+
+func TestFromJSONBounds(t *testing.T) {
+	keys := make([]string, MaxSSHKeys+1)
+	for i := range keys {
+		keys[i] = `"ssh-rsa AAAA"`
+	}
+
+	u := new(User)
+	err := u.fromJSON([]byte(fmt.Sprintf(`{"ipasshpubkey": [%s]}`, strings.Join(keys, ","))))
+	if err == nil {
+		t.Errorf("Too many ssh keys should return an error")
+	}
+
+	groups := make([]string, MaxGroups+1)
+	for i := range groups {
+		groups[i] = fmt.Sprintf(`"group%d"`, i)
+	}
+
+	u = new(User)
+	err = u.fromJSON([]byte(fmt.Sprintf(`{"memberof_group": [%s]}`, strings.Join(groups, ","))))
+	if err == nil {
+		t.Errorf("Too many groups should return an error")
+	}
+
+	tok := new(OTPToken)
+	err = tok.fromJSON([]byte(`{"ipatokenotpdigits": ["99999999999999999999999999999999"]}`))
+	if err == nil {
+		t.Errorf("Out of range otp token digits should return an error")
+	}
+}
@@ -0,0 +1,644 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Well-known FreeIPA groups
+const (
+	// AdminsGroup is the group of FreeIPA administrators
+	AdminsGroup = "admins"
+
+	// TrustAdminsGroup is the group of Active Directory trust administrators
+	TrustAdminsGroup = "trust admins"
+
+	// DefaultIPAUsersGroup is the default group of all FreeIPA users
+	DefaultIPAUsersGroup = "ipausers"
+)
+
+var (
+	// ErrGidInUse is returned when creating a group with a GID used by
+	// another group
+	ErrGidInUse = errors.New("gid already in use")
+
+	// ErrLastMember is returned by GroupRemoveMemberSafe when the removal
+	// would leave a protected group without members
+	ErrLastMember = errors.New("refusing to remove last member of protected group")
+
+	// ErrGroupNotFound is returned by UserPrimaryGroup when no group has the
+	// user's GID, for example for GIDs from an Active Directory trust range
+	ErrGroupNotFound = errors.New("group not found")
+)
+
+// Groups which GroupRemoveMemberSafe refuses to empty unless configured with
+// SetProtectedGroups
+var defaultProtectedGroups = []string{AdminsGroup}
+
+// Group encapsulates group data returned from ipa group commands
+type Group struct {
+	UUID        string   `json:"ipauniqueid"`
+	DN          string   `json:"dn"`
+	Name        string   `json:"cn"`
+	Description string   `json:"description"`
+	Gid         string   `json:"gidnumber"`
+	Users       []string `json:"member_user"`
+	Groups      []string `json:"member_group"`
+	Services    []string `json:"member_service"`
+
+	// Users and groups which are members through nested groups
+	IndirectUsers  []string `json:"memberindirect_user"`
+	IndirectGroups []string `json:"memberindirect_group"`
+
+	// External members of external groups, usually Active Directory SIDs
+	ExternalMembers []string `json:"ipaexternalmember"`
+
+	// Users and groups allowed to manage the group's members
+	MemberManagerUsers  []string `json:"membermanager_user"`
+	MemberManagerGroups []string `json:"membermanager_group"`
+
+	// Entry creation and last modification times
+	CreateTimestamp Time `json:"createtimestamp"`
+	ModifyTimestamp Time `json:"modifytimestamp"`
+
+	// Raw group record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+func (g *Group) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid group record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	g.Raw = append(json.RawMessage(nil), raw...)
+
+	for _, attr := range []string{"member_user", "member_group", "member_service", "ipaexternalmember", "memberindirect_user", "memberindirect_group"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
+
+	g.UUID = res.Get("ipauniqueid.0").String()
+	g.DN = res.Get("dn").String()
+	g.Name = res.Get("cn.0").String()
+	g.Description = res.Get("description.0").String()
+	g.Gid = res.Get("gidnumber.0").String()
+	g.CreateTimestamp = parseTimeAttr(res, "createtimestamp")
+	g.ModifyTimestamp = parseTimeAttr(res, "modifytimestamp")
+	res.Get("member_user").ForEach(func(key, value gjson.Result) bool {
+		g.Users = append(g.Users, value.String())
+		return true
+	})
+	res.Get("member_group").ForEach(func(key, value gjson.Result) bool {
+		g.Groups = append(g.Groups, value.String())
+		return true
+	})
+	g.Services = parseStrings(res, "member_service")
+	g.IndirectUsers = parseStrings(res, "memberindirect_user")
+	g.IndirectGroups = parseStrings(res, "memberindirect_group")
+	g.ExternalMembers = parseStrings(res, "ipaexternalmember")
+	g.MemberManagerUsers = parseStrings(res, "membermanager_user")
+	g.MemberManagerGroups = parseStrings(res, "membermanager_group")
+
+	return nil
+}
+
+// Fetch group details by call the FreeIPA group-show method
+func (c *Client) GroupShow(name string) (*Group, error) {
+	return c.GroupShowWithOptions(name, DefaultShowOptions())
+}
+
+// Fetch group details by calling the FreeIPA group-show method with show
+// options o
+func (c *Client) GroupShowWithOptions(name string, o ShowOptions) (*Group, error) {
+	data, err := c.show("group_show", []string{name}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	groupRec := new(Group)
+	err = groupRec.fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Maximum length of group and host group names
+const maxGroupNameLength = 255
+
+var (
+	groupNamePattern     = regexp.MustCompile(`^[a-zA-Z0-9_.][a-zA-Z0-9_.-]*[a-zA-Z0-9_.$-]?$`)
+	hostGroupNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.][a-zA-Z0-9_.-]*$`)
+	numericNamePattern   = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// Check cn against the group name rules enforced by FreeIPA: at most 255
+// letters, numbers, _, - and ., not starting with -, optionally ending with
+// $ and not only digits. Returns an error wrapping ErrInvalidName with the
+// first rule broken.
+func ValidateGroupName(cn string) error {
+	reason := nameError(cn, groupNamePattern, "letters, numbers, _, -, . and a trailing $")
+	if reason == "" && numericNamePattern.MatchString(cn) {
+		reason = "must not be only digits"
+	}
+	if reason != "" {
+		return fmt.Errorf("ipa: %w: group %q %s", ErrInvalidName, cn, reason)
+	}
+
+	return nil
+}
+
+// Returns the reason name breaks the common group name rules or matches
+// pattern, or an empty string if it is valid
+func nameError(name string, pattern *regexp.Regexp, allowed string) string {
+	switch {
+	case name == "":
+		return "must not be empty"
+	case strings.TrimSpace(name) != name:
+		return "has leading or trailing whitespace"
+	case len(name) > maxGroupNameLength:
+		return fmt.Sprintf("is longer than %d characters", maxGroupNameLength)
+	case strings.HasPrefix(name, "-"):
+		return "must not start with -"
+	case !pattern.MatchString(name):
+		return "may only include " + allowed
+	}
+
+	return ""
+}
+
+// GroupAddOptions are the optional attributes of a new group
+type GroupAddOptions struct {
+	Description string
+
+	// GID of the group. If 0 FreeIPA assigns the next available GID
+	Gid int
+
+	// Create a non-POSIX group, which has no GID
+	NonPosix bool
+
+	// Leave the group name for FreeIPA to validate, see ValidateGroupName
+	SkipNameValidation bool
+}
+
+// Add new group
+func (c *Client) GroupAdd(cn, description string) (*Group, error) {
+	return c.GroupAddWithOptions(cn, GroupAddOptions{Description: description})
+}
+
+// Add new group with options. The returned group has the GID assigned by
+// FreeIPA if none was given. The name is checked with ValidateGroupName
+// unless opts.SkipNameValidation is set. Returns ErrGroupExists if the group
+// exists, with a hint if a user of the same name exists as their user
+// private group takes the name, and ErrGidInUse if the GID is already used
+// by another group, including user private groups.
+func (c *Client) GroupAddWithOptions(cn string, opts GroupAddOptions) (*Group, error) {
+	if err := checkArgs("group_add", cn); err != nil {
+		return nil, err
+	}
+	if !opts.SkipNameValidation {
+		if err := ValidateGroupName(cn); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Gid < 0 {
+		return nil, errors.New("Group gid must be positive")
+	}
+	if opts.NonPosix && opts.Gid != 0 {
+		return nil, errors.New("Non-POSIX groups can not have a gid")
+	}
+
+	options := Options{"all": true}
+	if opts.Description != "" {
+		options["description"] = opts.Description
+	}
+	if opts.NonPosix {
+		options["nonposix"] = true
+	}
+	if opts.Gid != 0 {
+		options["gidnumber"] = opts.Gid
+
+		// FreeIPA does not enforce unique GIDs by default so check first
+		inUse, err := c.gidInUse(strconv.Itoa(opts.Gid))
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, fmt.Errorf("ipa: %w: %d", ErrGidInUse, opts.Gid)
+		}
+	}
+
+	res, err := c.rpc("group_add", []string{cn}, options)
+	if err != nil {
+		// Duplicate entry, either the group or, if the uniqueness plugin is
+		// enabled for gidnumber, the gid
+		var ierr *IpaError
+		if errors.As(err, &ierr) && IsDuplicate(err) {
+			if opts.Gid != 0 && strings.Contains(strings.ToLower(ierr.Message), "gid") {
+				return nil, fmt.Errorf("ipa: %w: %d", ErrGidInUse, opts.Gid)
+			}
+			if isUser, _ := c.UserExists(cn); isUser {
+				return nil, fmt.Errorf("ipa: %w: %s is the user private group of user %s", ErrGroupExists, cn, cn)
+			}
+			return nil, ErrGroupExists
+		}
+		return nil, err
+	}
+
+	groupRec := new(Group)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Modify the attributes of group cn given in extra, for example a site
+// specific schema extension. Returns the current group if there were no
+// modifications to be performed.
+func (c *Client) GroupMod(cn string, extra ExtraAttrs) (*Group, error) {
+	if err := checkArgs("group_mod", cn); err != nil {
+		return nil, err
+	}
+
+	options := Options{"all": true}
+	if err := extra.appendTo(options); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc("group_mod", []string{cn}, options)
+	if err != nil {
+		if IsNoModifications(err) {
+			return c.GroupShow(cn)
+		}
+		return nil, err
+	}
+
+	groupRec := new(Group)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Rename group
+func (c *Client) GroupRename(oldCn, newCn string) (*Group, error) {
+	if err := checkArgs("group_mod", oldCn, newCn); err != nil {
+		return nil, err
+	}
+	if oldCn == newCn {
+		return nil, errors.New("New group name must be different")
+	}
+
+	options := Options{
+		"rename": newCn,
+		"all":    true,
+	}
+
+	res, err := c.rpc("group_mod", []string{oldCn}, options)
+	if err != nil {
+		return nil, renameError(err, ErrGroupExists)
+	}
+
+	groupRec := new(Group)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Fetch the group with gidnumber gid. User private groups are only returned
+// by group_find with the private option so they are searched for second.
+func (c *Client) groupByGid(gid string) (*Group, error) {
+	if gid == "" {
+		return nil, fmt.Errorf("ipa: %w: no gid", ErrGroupNotFound)
+	}
+
+	for _, private := range []bool{false, true} {
+		options := Options{"gidnumber": gid, "all": true}
+		if private {
+			options["private"] = true
+		}
+
+		res, err := c.rpc("group_find", []string{""}, options)
+		if err != nil {
+			return nil, err
+		}
+
+		records := gjson.ParseBytes(res.Result.Data).Array()
+		if len(records) == 0 {
+			continue
+		}
+
+		group := new(Group)
+		if err := group.fromJSON([]byte(records[0].Raw)); err != nil {
+			return nil, err
+		}
+		return group, nil
+	}
+
+	return nil, fmt.Errorf("ipa: %w: gid %s", ErrGroupNotFound, gid)
+}
+
+// Returns true if gid is the gidnumber of a group. As in groupByGid, user
+// private groups are searched for second.
+func (c *Client) gidInUse(gid string) (bool, error) {
+	for _, private := range []bool{false, true} {
+		options := Options{
+			"gidnumber": gid,
+			"pkey_only": true,
+			"sizelimit": 1,
+		}
+		if private {
+			options["private"] = true
+		}
+
+		res, err := c.rpc("group_find", []string{}, options)
+		if err != nil {
+			return false, existsError(err)
+		}
+		if len(gjson.ParseBytes(res.Result.Data).Array()) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Returns the sorted users which are members of group cn directly or through
+// nested groups. Indirect members are taken from the memberindirect_user
+// attribute computed by FreeIPA. Users are deduplicated case-insensitively.
+func (c *Client) EffectiveGroupMembers(cn string) ([]string, error) {
+	group, err := c.GroupShow(cn)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	members := make([]string, 0, len(group.Users)+len(group.IndirectUsers))
+	for _, u := range append(append([]string{}, group.Users...), group.IndirectUsers...) {
+		if !seen[strings.ToLower(u)] {
+			seen[strings.ToLower(u)] = true
+			members = append(members, u)
+		}
+	}
+	sort.Strings(members)
+
+	return members, nil
+}
+
+// Returns the group nesting below group cn, mapping each group to its sorted
+// direct member groups. Groups are walked breadth-first to at most maxDepth
+// levels below cn, or without limit if maxDepth is 0. Each group is fetched
+// once, so nesting cycles show up as edges back to groups already in the map.
+// Groups at the depth limit appear only as members.
+func (c *Client) GroupNesting(cn string, maxDepth int) (map[string][]string, error) {
+	if err := checkArgs("group_show", cn); err != nil {
+		return nil, err
+	}
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("ipa: invalid nesting depth: %d", maxDepth)
+	}
+
+	nesting := make(map[string][]string)
+	visited := map[string]bool{strings.ToLower(cn): true}
+	level := []string{cn}
+	for depth := 0; len(level) > 0 && (maxDepth == 0 || depth < maxDepth); depth++ {
+		var next []string
+		for _, name := range level {
+			group, err := c.GroupShow(name)
+			if err != nil {
+				return nil, err
+			}
+
+			children := append([]string{}, group.Groups...)
+			sort.Strings(children)
+			nesting[name] = children
+
+			for _, child := range children {
+				if !visited[strings.ToLower(child)] {
+					visited[strings.ToLower(child)] = true
+					next = append(next, child)
+				}
+			}
+		}
+		level = next
+	}
+
+	return nesting, nil
+}
+
+// GroupMemberResult is the result of adding or removing group members
+type GroupMemberResult struct {
+	// Group after the change, including its description and members
+	Group *Group
+
+	// Users which were added or removed, in the order given
+	Completed []string
+
+	// Users which failed to be added or removed mapped to the reason, for
+	// example "This entry is already a member" or "no such entry"
+	Failed map[string]string
+}
+
+// Returns an error listing the failed members, or nil if all members were
+// added or removed
+func (r *GroupMemberResult) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(r.Failed))
+	for name, reason := range r.Failed {
+		names = append(names, name+": "+reason)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("ipa: group member change failed for %s", strings.Join(names, ", "))
+}
+
+// Add users to group cn with a single call. Users which can not be added, for
+// example because they are already members, are reported in the result,
+// which is returned along with an error listing them.
+func (c *Client) AddUsersToGroup(cn string, uids ...string) (*GroupMemberResult, error) {
+	return c.groupMember("group_add_member", cn, uids)
+}
+
+// Remove users from group cn with a single call. See AddUsersToGroup
+func (c *Client) RemoveUsersFromGroup(cn string, uids ...string) (*GroupMemberResult, error) {
+	return c.groupMember("group_remove_member", cn, uids)
+}
+
+// Add user to group cn. See AddUsersToGroup
+func (c *Client) AddUserToGroup(cn, uid string) (*GroupMemberResult, error) {
+	return c.AddUsersToGroup(cn, uid)
+}
+
+// Remove user from group cn. See RemoveUsersFromGroup
+func (c *Client) RemoveUserFromGroup(cn, uid string) (*GroupMemberResult, error) {
+	return c.RemoveUsersFromGroup(cn, uid)
+}
+
+// Set the groups GroupRemoveMemberSafe refuses to remove the last member
+// from. Defaults to admins. Call with no groups to protect none.
+func (c *Client) SetProtectedGroups(groups ...string) {
+	c.protected = append([]string{}, groups...)
+}
+
+// Returns true if cn is a protected group
+func (c *Client) isProtectedGroup(cn string) bool {
+	groups := c.protected
+	if groups == nil {
+		groups = defaultProtectedGroups
+	}
+	for _, g := range groups {
+		if strings.EqualFold(g, cn) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the number of direct user and group members of group cn
+func (c *Client) GroupMemberCount(cn string) (int, error) {
+	group, err := c.GroupShow(cn)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(group.Users) + len(group.Groups), nil
+}
+
+// Remove users from group unless cn is a protected group (see
+// SetProtectedGroups) and the removal would leave it without direct members,
+// in which case ErrLastMember is returned and nothing is removed. Set force
+// to skip the check. The check and removal are separate calls, so this is
+// best-effort: a concurrent removal between them can still empty the group.
+func (c *Client) GroupRemoveMemberSafe(cn string, force bool, users ...string) (*GroupMemberResult, error) {
+	if len(users) == 0 {
+		return nil, errors.New("At least one user is required")
+	}
+
+	if !force && c.isProtectedGroup(cn) {
+		group, err := c.GroupShow(cn)
+		if err != nil {
+			return nil, err
+		}
+
+		removing := make(map[string]bool)
+		for _, u := range users {
+			removing[strings.ToLower(u)] = true
+		}
+		remaining := len(group.Groups)
+		for _, u := range group.Users {
+			if !removing[strings.ToLower(u)] {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			return nil, fmt.Errorf("ipa: %w: %s", ErrLastMember, cn)
+		}
+	}
+
+	return c.RemoveUsersFromGroup(cn, users...)
+}
+
+// Add or remove group members with method and map the failures reported by
+// FreeIPA back to the given users. FreeIPA may report names in a different
+// case so they are matched case-insensitively. Duplicate users are sent once.
+// The result is returned with an error if any user failed.
+func (c *Client) groupMember(method, cn string, users []string) (*GroupMemberResult, error) {
+	if len(users) == 0 {
+		return nil, errors.New("At least one user is required")
+	}
+
+	seen := make(map[string]bool, len(users))
+	unique := make([]string, 0, len(users))
+	for _, u := range users {
+		if !seen[strings.ToLower(u)] {
+			seen[strings.ToLower(u)] = true
+			unique = append(unique, u)
+		}
+	}
+
+	options := Options{
+		"user": unique,
+		"all":  true,
+	}
+
+	res, err := c.rpc(method, []string{cn}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	group := new(Group)
+	err = group.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := make(map[string]string)
+	for name, reason := range parseFailedMembers(res.Result, "member.user") {
+		reasons[strings.ToLower(name)] = reason
+	}
+
+	result := &GroupMemberResult{
+		Group:     group,
+		Completed: make([]string, 0, len(unique)),
+		Failed:    make(map[string]string),
+	}
+	for _, u := range unique {
+		if reason, ok := reasons[strings.ToLower(u)]; ok {
+			result.Failed[u] = reason
+			delete(reasons, strings.ToLower(u))
+			continue
+		}
+		result.Completed = append(result.Completed, u)
+	}
+
+	// Failures for names which were not sent are still reported
+	for name, reason := range reasons {
+		result.Failed[name] = reason
+	}
+
+	return result, result.Err()
+}
+
+// Map FreeIPA errors returned from rename to typed errors. exists is
+// returned if the new name is already taken. Validation errors for the new
+// name wrap ErrInvalidName, other validation errors are returned as is.
+func renameError(err error, exists error) error {
+	var ierr *IpaError
+	if !errors.As(err, &ierr) {
+		return err
+	}
+
+	switch {
+	case IsDuplicate(err):
+		return exists
+	case IsValidation(err) && strings.HasPrefix(ierr.Message, "invalid 'rename'"):
+		return fmt.Errorf("ipa: %w: %w", ErrInvalidName, err)
+	}
+
+	return err
+}
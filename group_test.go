@@ -0,0 +1,439 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestGroupRename(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var params []interface{}
+	result := stubResult(`{"result": {"cn": ["newgroup"], "description": ["Test group"], "gidnumber": ["1500"], "dn": "cn=newgroup,cn=groups,cn=accounts,dc=local"}, "summary": "Modified group \"oldgroup\"", "value": "oldgroup"}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		params = req.Params
+		result(w, r)
+	})
+
+	rec, err := c.GroupRename("oldgroup", "newgroup")
+	require.NoError(err)
+	require.Len(params, 2)
+	assert.Equal([]interface{}{"oldgroup"}, params[0])
+	assert.Equal("newgroup", params[1].(map[string]interface{})["rename"])
+	assert.Equal("newgroup", rec.Name)
+	assert.Equal("1500", rec.Gid)
+
+	_, err = c.GroupRename("newgroup", "newgroup")
+	assert.Error(err)
+
+	c = newTestClientStub(t, stubError(4002, `This entry already exists`))
+	_, err = c.GroupRename("oldgroup", "admins")
+	assert.ErrorIs(err, ipa.ErrGroupExists)
+}
+
+func TestGroupAdd(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Server which assigns GIDs from 1600 and does not enforce unique GIDs
+	gids := map[string]string{}
+	next := 1600
+	var options map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		options = req.Options
+
+		switch req.Method {
+		case "group_find":
+			gid := options["gidnumber"].(string)
+			if gid == "6000" && options["private"] == true {
+				stubResult(`{"count": 1, "result": [{"cn": ["jdoe"]}], "summary": null, "truncated": false}`)(w, r)
+				return
+			}
+			for cn, g := range gids {
+				if g == gid {
+					stubResult(fmt.Sprintf(`{"count": 1, "result": [{"cn": [%q]}], "summary": null, "truncated": false}`, cn))(w, r)
+					return
+				}
+			}
+			stubResult(`{"count": 0, "result": [], "summary": null, "truncated": false}`)(w, r)
+		case "group_add":
+			if _, ok := gids[args[0]]; ok {
+				stubError(4002, fmt.Sprintf("group with name %q already exists", args[0]))(w, r)
+				return
+			}
+			gid := fmt.Sprintf("%d", next)
+			if g, ok := options["gidnumber"]; ok {
+				gid = fmt.Sprintf("%v", g)
+			} else {
+				next++
+			}
+			gids[args[0]] = gid
+			stubResult(fmt.Sprintf(`{"result": {"cn": [%q], "gidnumber": [%q]}, "summary": null, "value": %q}`, args[0], gid, args[0]))(w, r)
+		}
+	})
+
+	group, err := c.GroupAddWithOptions("nfs", ipa.GroupAddOptions{Gid: 5000, Description: "Legacy NFS"})
+	require.NoError(err)
+	assert.Equal(float64(5000), options["gidnumber"])
+	assert.Equal("Legacy NFS", options["description"])
+	assert.Equal("5000", group.Gid)
+
+	_, err = c.GroupAddWithOptions("nfs2", ipa.GroupAddOptions{Gid: 5000})
+	assert.ErrorIs(err, ipa.ErrGidInUse)
+	assert.NotContains(gids, "nfs2")
+
+	// GIDs of user private groups are in use too
+	_, err = c.GroupAddWithOptions("nfs2", ipa.GroupAddOptions{Gid: 6000})
+	assert.ErrorIs(err, ipa.ErrGidInUse)
+	assert.NotContains(gids, "nfs2")
+
+	group, err = c.GroupAdd("staff", "")
+	require.NoError(err)
+	assert.Equalf("1600", group.Gid, "Auto-allocated GID should be returned")
+	assert.NotContains(options, "description")
+
+	_, err = c.GroupAdd("staff", "")
+	assert.ErrorIs(err, ipa.ErrGroupExists)
+
+	_, err = c.GroupAddWithOptions("web", ipa.GroupAddOptions{NonPosix: true})
+	require.NoError(err)
+	assert.Equal(true, options["nonposix"])
+
+	_, err = c.GroupAddWithOptions("web", ipa.GroupAddOptions{NonPosix: true, Gid: 5001})
+	assert.Error(err)
+
+	// Servers with the uniqueness plugin enabled for gidnumber reject
+	// duplicates on add
+	c = newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		if req.Method == "group_find" {
+			stubResult(`{"count": 0, "result": [], "summary": null, "truncated": false}`)(w, r)
+			return
+		}
+		stubError(4002, `This entry already exists: another entry with gidNumber 5000 exists`)(w, r)
+	})
+	_, err = c.GroupAddWithOptions("nfs2", ipa.GroupAddOptions{Gid: 5000})
+	assert.ErrorIs(err, ipa.ErrGidInUse)
+}
+
+func TestGroupRemoveMemberSafe(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	members := map[string][]string{
+		"admins": {"admin"},
+		"staff":  {"jdoe"},
+	}
+	removed := []string{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+
+		users, _ := json.Marshal(members[args[0]])
+		if req.Method == "group_remove_member" {
+			removed = append(removed, args[0])
+			users = []byte("[]")
+		}
+		stubResult(fmt.Sprintf(`{"completed": 1, "failed": {"member": {"group": [], "user": []}}, "result": {"cn": [%q], "member_user": %s}, "summary": null, "value": %q}`, args[0], users, args[0]))(w, r)
+	})
+
+	n, err := c.GroupMemberCount("admins")
+	require.NoError(err)
+	assert.Equal(1, n)
+
+	_, err = c.GroupRemoveMemberSafe("Admins", false, "ADMIN")
+	assert.ErrorIs(err, ipa.ErrLastMember)
+	assert.Empty(removed)
+
+	_, err = c.GroupRemoveMemberSafe("admins", true, "admin")
+	require.NoError(err)
+	assert.Equal([]string{"admins"}, removed)
+
+	_, err = c.GroupRemoveMemberSafe("staff", false, "jdoe")
+	require.NoError(err)
+
+	c.SetProtectedGroups("staff")
+	_, err = c.GroupRemoveMemberSafe("staff", false, "jdoe")
+	assert.ErrorIs(err, ipa.ErrLastMember)
+
+	c.SetProtectedGroups()
+	_, err = c.GroupRemoveMemberSafe("admins", false, "admin")
+	require.NoError(err)
+	assert.Equal([]string{"admins", "staff", "admins"}, removed)
+}
+
+func TestGroupShowTrust(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	fixture := func(name string) http.HandlerFunc {
+		data, err := os.ReadFile(filepath.Join("testdata", "group", name+".json"))
+		require.NoError(err)
+		return stubResult(string(data))
+	}
+
+	c := newTestClientStub(t, fixture("external"))
+	group, err := c.GroupShow("ad_admins_external")
+	require.NoError(err)
+	assert.Equal([]string{"S-1-5-21-3655990580-1375374850-1633065477-512", "S-1-5-21-3655990580-1375374850-1633065477-519"}, group.ExternalMembers)
+	assert.Equal([]string{"jdoe"}, group.MemberManagerUsers)
+	assert.Empty(group.MemberManagerGroups)
+	assert.Empty(group.Gid)
+
+	c = newTestClientStub(t, fixture("posix"))
+	group, err = c.GroupShow("ad_admins")
+	require.NoError(err)
+	assert.Empty(group.ExternalMembers)
+	assert.Equal([]string{"ad_admins_external"}, group.Groups)
+	assert.Equal([]string{"HTTP/web.ipa.example.com@IPA.EXAMPLE.COM"}, group.Services)
+	assert.Equal([]string{"jdoe", "jsmith"}, group.MemberManagerUsers)
+	assert.Equal([]string{"helpdesk"}, group.MemberManagerGroups)
+}
+
+func TestValidateGroupName(t *testing.T) {
+	valid := []string{"staff", "team.web-dev", "_svc", "machines$", "a", "123abc", strings.Repeat("g", 255)}
+	for _, cn := range valid {
+		assert.NoErrorf(t, ipa.ValidateGroupName(cn), "Group name %q should be valid", cn)
+	}
+
+	invalid := map[string]string{
+		"":                       "empty",
+		"admins ":                "whitespace",
+		" admins":                "whitespace",
+		strings.Repeat("g", 256): "longer than 255",
+		"12345":                  "only digits",
+		"-staff":                 "start with -",
+		"team web":               "may only include",
+		"pay$roll":               "may only include",
+		"équipe":                 "may only include",
+	}
+	for cn, reason := range invalid {
+		err := ipa.ValidateGroupName(cn)
+		if assert.ErrorIsf(t, err, ipa.ErrInvalidName, "Group name %q should be invalid", cn) {
+			assert.Contains(t, err.Error(), reason)
+		}
+	}
+
+	assert.NoError(t, ipa.ValidateHostGroupName("12345"))
+	assert.ErrorIs(t, ipa.ValidateHostGroupName("web$"), ipa.ErrInvalidName)
+	assert.ErrorIs(t, ipa.ValidateHostGroupName("web "), ipa.ErrInvalidName)
+}
+
+func TestGroupAddNames(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		options := req.Options
+		methods = append(methods, req.Method)
+
+		switch {
+		case req.Method == "user_find" && options["uid"] == "jdoe":
+			stubResult(`{"count": 1, "result": [{"uid": ["jdoe"]}], "summary": null, "truncated": false}`)(w, r)
+		case req.Method == "user_find":
+			stubResult(`{"count": 0, "result": [], "summary": null, "truncated": false}`)(w, r)
+		case args[0] == "jdoe" || args[0] == "staff" || args[0] == "web":
+			stubError(4002, fmt.Sprintf("%s with name %q already exists", req.Method, args[0]))(w, r)
+		case req.Method == "hostgroup_add":
+			stubResult(fmt.Sprintf(`{"result": {"cn": [%q]}, "summary": null, "value": %q}`, args[0], args[0]))(w, r)
+		default:
+			stubError(3009, "invalid 'group_name': may only include letters, numbers, _, -, . and $")(w, r)
+		}
+	})
+
+	_, err := c.GroupAdd("admins ", "")
+	assert.ErrorIs(err, ipa.ErrInvalidName)
+	assert.Emptyf(methods, "Invalid names should not be sent")
+
+	// The server still validates when client checks are skipped
+	_, err = c.GroupAddWithOptions("admins ", ipa.GroupAddOptions{SkipNameValidation: true})
+	var ierr *ipa.IpaError
+	require.ErrorAs(err, &ierr)
+	assert.Equal(3009, ierr.Code)
+
+	methods = nil
+	_, err = c.GroupAdd("jdoe", "")
+	require.ErrorIs(err, ipa.ErrGroupExists)
+	assert.Contains(err.Error(), "user private group of user jdoe")
+	assert.Equal([]string{"group_add", "user_find"}, methods)
+
+	_, err = c.GroupAdd("staff", "")
+	require.ErrorIs(err, ipa.ErrGroupExists)
+	assert.NotContains(err.Error(), "user private group")
+
+	group, err := c.HostGroupAdd("db", "Databases")
+	require.NoError(err)
+	assert.Equal("db", group.Name)
+
+	_, err = c.HostGroupAdd("web", "")
+	assert.ErrorIs(err, ipa.ErrHostGroupExists)
+
+	methods = nil
+	_, err = c.HostGroupAdd("web$", "")
+	assert.ErrorIs(err, ipa.ErrInvalidName)
+	assert.Empty(methods)
+}
+
+func TestAddUsersToGroup(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var calls []memberCall
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		var options struct {
+			User []string `json:"user"`
+		}
+		json.Unmarshal(req.Params[1], &options)
+		calls = append(calls, memberCall{req.Method, options.User})
+
+		switch req.Method {
+		case "group_add_member":
+			stubResult(`{"completed": 2, "failed": {"member": {"group": [], "user": [["jsmith", "This entry is already a member"], ["nosuchuser", "no such entry"]]}},
+				"result": {"cn": ["staff"], "description": ["Staff"], "gidnumber": ["1100"], "member_user": ["jdoe", "jsmith", "newuser", "other"]}}`)(w, r)
+		case "group_remove_member":
+			stubResult(`{"completed": 0, "failed": {"member": {"group": [], "user": [["jdoe", "This entry is not a member"]]}},
+				"result": {"cn": ["staff"], "description": ["Staff"], "member_user": ["jsmith"]}}`)(w, r)
+		}
+	})
+
+	res, err := c.AddUsersToGroup("staff", "jdoe", "JSmith", "newuser", "nosuchuser", "JDOE", "other")
+	require.Error(err)
+	assert.Contains(err.Error(), "nosuchuser: no such entry")
+	require.NotNilf(res, "The result should be returned with the member failures")
+	require.Len(calls, 1)
+	assert.Equalf([]string{"jdoe", "JSmith", "newuser", "nosuchuser", "other"}, calls[0].Users, "All users should be sent in one call without duplicates")
+	assert.Equal([]string{"jdoe", "newuser", "other"}, res.Completed)
+	assert.Equal(map[string]string{"JSmith": "This entry is already a member", "nosuchuser": "no such entry"}, res.Failed)
+	assert.Equal("Staff", res.Group.Description)
+	assert.Equal("1100", res.Group.Gid)
+	assert.Equal([]string{"jdoe", "jsmith", "newuser", "other"}, res.Group.Users)
+	require.Error(res.Err())
+	assert.Contains(res.Err().Error(), "nosuchuser: no such entry")
+
+	res, err = c.RemoveUsersFromGroup("staff", "jdoe")
+	require.Error(err)
+	assert.Empty(res.Completed)
+	assert.Equal(map[string]string{"jdoe": "This entry is not a member"}, res.Failed)
+	assert.Equal([]string{"jsmith"}, res.Group.Users)
+
+	_, err = c.AddUsersToGroup("staff")
+	assert.Error(err)
+	assert.Lenf(calls, 2, "Calls without users should never reach the server")
+
+	res, err = c.RemoveUserFromGroup("staff", "jdoe")
+	require.Error(err)
+	require.NotNilf(res, "Single user wrappers should return the result with the error")
+	assert.Equal(map[string]string{"jdoe": "This entry is not a member"}, res.Failed)
+}
+
+func TestAddUserToGroupCompleted(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"completed": 1, "failed": {"member": {"group": [], "user": []}},
+		"result": {"cn": ["staff"], "description": ["Staff"], "member_user": ["jdoe"]}}`))
+
+	res, err := c.AddUserToGroup("staff", "jdoe")
+	require.NoError(err)
+	assert.Equal([]string{"jdoe"}, res.Completed)
+	assert.Empty(res.Failed)
+	assert.NoError(res.Err())
+	assert.Equal("Staff", res.Group.Description)
+}
+
+func TestEffectiveGroupMembers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"cn": ["staff"], "member_user": ["jsmith", "jdoe"], "member_group": ["devs"],
+		"memberindirect_user": ["JDOE", "intern1"], "memberindirect_group": ["interns"]}, "summary": null, "value": "staff"}`))
+
+	group, err := c.GroupShow("staff")
+	require.NoError(err)
+	assert.Equal([]string{"JDOE", "intern1"}, group.IndirectUsers)
+	assert.Equal([]string{"interns"}, group.IndirectGroups)
+
+	members, err := c.EffectiveGroupMembers("staff")
+	require.NoError(err)
+	assert.Equal([]string{"intern1", "jdoe", "jsmith"}, members)
+}
+
+func TestGroupNesting(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	groups := map[string]string{
+		"staff":       `["ops", "devs"]`,
+		"devs":        `["interns", "Staff"]`,
+		"ops":         `[]`,
+		"interns":     `["ops", "contractors"]`,
+		"contractors": `[]`,
+	}
+
+	var shown []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		shown = append(shown, args[0])
+
+		members, ok := groups[strings.ToLower(args[0])]
+		if !ok {
+			stubError(4001, args[0]+": group not found")(w, r)
+			return
+		}
+		stubResult(fmt.Sprintf(`{"result": {"cn": [%q], "member_group": %s}, "summary": null, "value": %q}`, args[0], members, args[0]))(w, r)
+	})
+
+	nesting, err := c.GroupNesting("staff", 0)
+	require.NoError(err)
+	assert.Equal(map[string][]string{
+		"staff":       {"devs", "ops"},
+		"devs":        {"Staff", "interns"},
+		"ops":         {},
+		"interns":     {"contractors", "ops"},
+		"contractors": {},
+	}, nesting)
+	assert.Equalf([]string{"staff", "devs", "ops", "interns", "contractors"}, shown, "Groups should be walked breadth-first and fetched once")
+
+	shown = nil
+	nesting, err = c.GroupNesting("staff", 1)
+	require.NoError(err)
+	assert.Equal(map[string][]string{"staff": {"devs", "ops"}}, nesting)
+	assert.Equal([]string{"staff"}, shown)
+
+	nesting, err = c.GroupNesting("staff", 2)
+	require.NoError(err)
+	assert.Len(nesting, 3)
+	assert.NotContains(nesting, "interns")
+
+	_, err = c.GroupNesting("staff", -1)
+	assert.Error(err)
+
+	groups["staff"] = `["missing"]`
+	_, err = c.GroupNesting("staff", 0)
+	assert.Error(err)
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrTooManyChanges is returned by GroupSyncMembers when the number of
+// changes exceeds the MaxChanges limit
+var ErrTooManyChanges = errors.New("too many changes")
+
+// SyncReport is the result of GroupSyncMembers
+type SyncReport struct {
+	Added     []string
+	Removed   []string
+	Unchanged []string
+
+	// Members which failed to be added or removed mapped to the reason
+	Failed map[string]string
+}
+
+type syncConfig struct {
+	dryRun     bool
+	protected  map[string]bool
+	maxChanges int
+}
+
+// SyncOption configures GroupSyncMembers
+type SyncOption func(*syncConfig)
+
+// Compute the changes without applying them
+func SyncDryRun() SyncOption {
+	return func(cfg *syncConfig) {
+		cfg.dryRun = true
+	}
+}
+
+// Never remove users, even if they are not in the desired members
+func ProtectUsers(users []string) SyncOption {
+	return func(cfg *syncConfig) {
+		for _, u := range users {
+			cfg.protected[strings.ToLower(u)] = true
+		}
+	}
+}
+
+// Abort without making changes if more than n members would be added or
+// removed. This guards against an empty upstream feed emptying a group.
+func MaxChanges(n int) SyncOption {
+	return func(cfg *syncConfig) {
+		cfg.maxChanges = n
+	}
+}
+
+// Sync the direct user members of group cn to desiredUsers. Users are
+// compared case-insensitively. Members are added and removed with a single
+// call each. Members which fail to be added or removed are reported in the
+// SyncReport, an error is only returned if the sync could not be attempted.
+func (c *Client) GroupSyncMembers(cn string, desiredUsers []string, opts ...SyncOption) (*SyncReport, error) {
+	cfg := &syncConfig{
+		protected:  make(map[string]bool),
+		maxChanges: -1,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	group, err := c.GroupShow(cn)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool)
+	for _, u := range group.Users {
+		current[strings.ToLower(u)] = true
+	}
+
+	desired := make(map[string]bool)
+	for _, u := range desiredUsers {
+		desired[strings.ToLower(u)] = true
+	}
+
+	report := &SyncReport{
+		Added:     make([]string, 0),
+		Removed:   make([]string, 0),
+		Unchanged: make([]string, 0),
+		Failed:    make(map[string]string),
+	}
+
+	for u := range desired {
+		if current[u] {
+			report.Unchanged = append(report.Unchanged, u)
+		} else {
+			report.Added = append(report.Added, u)
+		}
+	}
+	for u := range current {
+		if desired[u] {
+			continue
+		}
+		if cfg.protected[u] {
+			report.Unchanged = append(report.Unchanged, u)
+		} else {
+			report.Removed = append(report.Removed, u)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Unchanged)
+
+	if cfg.maxChanges >= 0 && len(report.Added)+len(report.Removed) > cfg.maxChanges {
+		return report, ErrTooManyChanges
+	}
+
+	if cfg.dryRun {
+		return report, nil
+	}
+
+	report.Added, err = c.groupSyncApply("group_add_member", cn, report.Added, report.Failed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users were added so report them along with the error
+	report.Removed, err = c.groupSyncApply("group_remove_member", cn, report.Removed, report.Failed)
+	if err != nil {
+		report.Removed = make([]string, 0)
+		return report, err
+	}
+
+	return report, nil
+}
+
+// Apply member changes and return the users which were changed. Failures are
+// recorded in failed.
+func (c *Client) groupSyncApply(method, cn string, users []string, failed map[string]string) ([]string, error) {
+	if len(users) == 0 {
+		return users, nil
+	}
+
+	// Users which failed are recorded instead of failing the sync
+	res, err := c.groupMember(method, cn, users)
+	if res == nil {
+		return nil, err
+	}
+
+	for u, reason := range res.Failed {
+		failed[u] = reason
+	}
+
+	return res.Completed, nil
+}
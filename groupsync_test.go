@@ -0,0 +1,74 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+type memberCall struct {
+	Method string
+	Users  []string
+}
+
+func newGroupSyncStub(t *testing.T, calls *[]memberCall) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		var options struct {
+			User []string `json:"user"`
+		}
+		json.Unmarshal(req.Params[1], &options)
+
+		switch req.Method {
+		case "group_show":
+			stubResult(`{"result": {"cn": ["staff"], "member_user": ["jdoe", "Jsmith", "admin", "olduser"]}, "summary": null, "value": "staff"}`)(w, r)
+		case "group_add_member":
+			*calls = append(*calls, memberCall{req.Method, options.User})
+			stubResult(`{"completed": 1, "failed": {"member": {"group": [], "user": [["nosuchuser", "no such entry"]]}}, "result": {"cn": ["staff"]}}`)(w, r)
+		case "group_remove_member":
+			*calls = append(*calls, memberCall{req.Method, options.User})
+			stubResult(`{"completed": 1, "failed": {"member": {"group": [], "user": []}}, "result": {"cn": ["staff"]}}`)(w, r)
+		}
+	})
+}
+
+func TestGroupSyncMembers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := []memberCall{}
+	c := newGroupSyncStub(t, &calls)
+
+	desired := []string{"JDOE", "jsmith", "newuser", "nosuchuser"}
+
+	report, err := c.GroupSyncMembers("staff", desired, ipa.ProtectUsers([]string{"admin"}))
+	require.NoError(err)
+	assert.Equal([]string{"newuser"}, report.Added)
+	assert.Equal([]string{"olduser"}, report.Removed)
+	assert.Equal([]string{"admin", "jdoe", "jsmith"}, report.Unchanged)
+	assert.Equal(map[string]string{"nosuchuser": "no such entry"}, report.Failed)
+	assert.Equal([]memberCall{
+		{"group_add_member", []string{"newuser", "nosuchuser"}},
+		{"group_remove_member", []string{"olduser"}},
+	}, calls)
+
+	calls = calls[:0]
+	report, err = c.GroupSyncMembers("staff", desired, ipa.SyncDryRun())
+	require.NoError(err)
+	assert.Equal([]string{"newuser", "nosuchuser"}, report.Added)
+	assert.Equal([]string{"admin", "olduser"}, report.Removed)
+	assert.Emptyf(calls, "Dry run should not change members")
+
+	report, err = c.GroupSyncMembers("staff", nil, ipa.MaxChanges(2))
+	assert.ErrorIs(err, ipa.ErrTooManyChanges)
+	assert.Len(report.Removed, 4)
+	assert.Emptyf(calls, "Too many changes should abort the sync")
+}
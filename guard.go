@@ -0,0 +1,152 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrMutationDenied is returned by the guards in this package when a
+// destructive method is refused
+var ErrMutationDenied = errors.New("mutation denied by guard")
+
+// MutationGuard is consulted before executing destructive methods. A non-nil
+// error aborts the call and is returned to the caller.
+type MutationGuard func(method string, params []string, options Options) error
+
+// Suffixes of the FreeIPA methods checked by the mutation guard
+var destructiveSuffixes = []string{"_del", "_disable", "_remove_member"}
+
+// Option keys holding the members of *_remove_member methods, or the entry
+// deleted by automountkey_del
+var memberOptions = []string{"user", "group", "host", "hostgroup", "service", "automountkey"}
+
+// Number of leading params naming the parents of entries of nested objects,
+// for example the location of an automount map. Parents are not affected by
+// a destructive call so they are not checked by the guards.
+var parentParams = map[string]int{
+	"automountmap": 1,
+	"automountkey": 2,
+}
+
+func isDestructive(method string) bool {
+	for _, suffix := range destructiveSuffixes {
+		if strings.HasSuffix(method, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the object of a destructive method, for example automountmap for
+// automountmap_del
+func guardObject(method string) string {
+	for _, suffix := range destructiveSuffixes {
+		if strings.HasSuffix(method, suffix) {
+			return strings.TrimSuffix(method, suffix)
+		}
+	}
+
+	return method
+}
+
+// Set the guard consulted before executing destructive methods: every
+// *_del, *_disable and *_remove_member method, for example user_del,
+// hbacsvc_del, hbacsvcgroup_del, automountmap_del or host_disable. Guarded
+// calls which are refused never reach FreeIPA.
+func (c *Client) SetMutationGuard(guard MutationGuard) {
+	c.guard = guard
+}
+
+// Returns the names affected by a destructive call, the entries being
+// deleted or disabled or the entry and members of a member removal. The
+// parents of nested entries, such as the location and map of an automount
+// key, are skipped.
+func guardNames(method string, params []string, options Options) []string {
+	names := []string{}
+	if n := parentParams[guardObject(method)]; n < len(params) {
+		names = append(names, params[n:]...)
+	}
+	for _, key := range memberOptions {
+		switch v := options[key].(type) {
+		case string:
+			names = append(names, v)
+		case []string:
+			names = append(names, v...)
+		}
+	}
+
+	return names
+}
+
+// Returns a guard which refuses destructive calls affecting any of names,
+// for example DenyList("admin", "admins")
+func DenyList(names ...string) MutationGuard {
+	return func(method string, params []string, options Options) error {
+		for _, n := range guardNames(method, params, options) {
+			for _, deny := range names {
+				if strings.EqualFold(n, deny) {
+					return fmt.Errorf("%w: %s on %q", ErrMutationDenied, method, n)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// Returns a guard which only allows destructive calls if every affected name
+// matches one of patterns. Patterns use path.Match syntax, for example
+// "test-*".
+func RequireExplicitAllow(patterns ...string) MutationGuard {
+	return func(method string, params []string, options Options) error {
+		for _, n := range guardNames(method, params, options) {
+			allowed := false
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, n); ok {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("%w: %s on %q not explicitly allowed", ErrMutationDenied, method, n)
+			}
+		}
+
+		return nil
+	}
+}
+
+// Returns a guard which refuses deletes of more than max entries in a
+// single call
+func MaxDeletes(max int) MutationGuard {
+	return func(method string, params []string, options Options) error {
+		if !strings.HasSuffix(method, "_del") {
+			return nil
+		}
+		if n := len(guardNames(method, params, options)); n > max {
+			return fmt.Errorf("%w: %s of %d entries exceeds limit of %d", ErrMutationDenied, method, n, max)
+		}
+
+		return nil
+	}
+}
+
+// Returns a guard which refuses a call if any of guards refuse it
+func AllGuards(guards ...MutationGuard) MutationGuard {
+	return func(method string, params []string, options Options) error {
+		for _, g := range guards {
+			if err := g(method, params, options); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
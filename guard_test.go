@@ -0,0 +1,131 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestMutationGuard(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	calls := 0
+	result := stubResult(`{"result": {"failed": []}, "summary": "Deleted", "value": ["jdoe"]}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		result(w, r)
+	})
+
+	c.SetMutationGuard(ipa.AllGuards(ipa.DenyList("admin", "admins"), ipa.MaxDeletes(2)))
+
+	err := c.UserDelete(false, false, "jdoe", "Admin")
+	assert.ErrorIs(err, ipa.ErrMutationDenied)
+
+	_, err = c.UserDeleteDetailed(ipa.UserDeleteOptions{}, "a", "b", "c")
+	assert.ErrorIs(err, ipa.ErrMutationDenied)
+	assert.Equalf(0, calls, "Guarded calls should never reach the server")
+
+	err = c.UserDelete(false, false, "jdoe")
+	require.NoError(err)
+	assert.Equal(1, calls)
+
+	// Non-destructive methods are not guarded
+	_, err = c.UserShow("admin")
+	require.NoError(err)
+	assert.Equal(2, calls)
+
+	// Guard errors are returned as is
+	errCustom := errors.New("change freeze")
+	c.SetMutationGuard(func(method string, params []string, options ipa.Options) error {
+		return errCustom
+	})
+	err = c.RemoveOTPToken("abc")
+	assert.ErrorIs(err, errCustom)
+	assert.Equal(2, calls)
+}
+
+func TestGuardHelpers(t *testing.T) {
+	assert := assert.New(t)
+
+	deny := ipa.DenyList("admin", "admins")
+	assert.ErrorIs(deny("group_remove_member", []string{"admins"}, ipa.Options{"user": []string{"jdoe"}}), ipa.ErrMutationDenied)
+	assert.ErrorIs(deny("group_remove_member", []string{"staff"}, ipa.Options{"user": []string{"jdoe", "admin"}}), ipa.ErrMutationDenied)
+	assert.NoError(deny("group_remove_member", []string{"staff"}, ipa.Options{"user": []string{"jdoe"}}))
+
+	allow := ipa.RequireExplicitAllow("test-*", "ci.example.com")
+	assert.NoError(allow("user_del", []string{"test-1", "test-2"}, nil))
+	assert.NoError(allow("host_del", []string{"ci.example.com"}, nil))
+	assert.ErrorIs(allow("user_del", []string{"test-1", "jdoe"}, nil), ipa.ErrMutationDenied)
+
+	max := ipa.MaxDeletes(1)
+	assert.NoError(max("user_del", []string{"a"}, nil))
+	assert.ErrorIs(max("user_del", []string{"a", "b"}, nil), ipa.ErrMutationDenied)
+	assert.NoErrorf(max("group_remove_member", []string{"a", "b"}, nil), "MaxDeletes only limits deletes")
+
+	// Only the map or key being deleted counts, not its location and map
+	assert.NoError(max("automountmap_del", []string{"default", "auto.home"}, nil))
+	assert.NoError(max("automountkey_del", []string{"default", "auto.home"}, ipa.Options{"automountkey": "jdoe"}))
+
+	allow = ipa.RequireExplicitAllow("auto.test*", "test-*")
+	assert.NoError(allow("automountmap_del", []string{"default", "auto.test"}, nil))
+	assert.NoError(allow("automountkey_del", []string{"default", "auto.home"}, ipa.Options{"automountkey": "test-1"}))
+	assert.ErrorIs(allow("automountmap_del", []string{"default", "auto.home"}, nil), ipa.ErrMutationDenied)
+	assert.ErrorIs(allow("automountkey_del", []string{"default", "auto.test"}, ipa.Options{"automountkey": "jdoe"}), ipa.ErrMutationDenied)
+	assert.NoError(ipa.DenyList("default")("automountkey_del", []string{"default", "auto.home"}, ipa.Options{"automountkey": "jdoe"}))
+}
+
+func TestMutationGuardWrappers(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": [], "count": 0, "truncated": false, "summary": null}`))
+
+	errCustom := errors.New("change freeze")
+	var guarded []string
+	c.SetMutationGuard(func(method string, params []string, options ipa.Options) error {
+		guarded = append(guarded, method)
+		return errCustom
+	})
+
+	// Every wrapper deleting or disabling entries must be guarded
+	wrappers := map[string]func() error{
+		"user_del":            func() error { return c.UserDelete(false, false, "jdoe") },
+		"user_disable":        func() error { _, err := c.UserDisable("jdoe"); return err },
+		"group_del":           func() error { return c.GroupDelete("staff", false) },
+		"group_remove_member": func() error { _, err := c.RemoveUserFromGroup("staff", "jdoe"); return err },
+		"host_del":            func() error { return c.HostDel("ci.example.com", ipa.HostDelOptions{}) },
+		"host_disable":        func() error { return c.HostDisable("ci.example.com") },
+		"hostgroup_del":       func() error { return c.HostGroupDelete("web", false) },
+		"hostgroup_remove_member": func() error {
+			_, err := c.HostGroupRemoveMember("web", "ci.example.com")
+			return err
+		},
+		"hbacrule_disable":      func() error { return c.SetEnabled("hbacrule", "allow_ssh", false) },
+		"sudorule_disable":      func() error { return c.SetEnabled("sudorule", "admins_all", false) },
+		"otptoken_del":          func() error { return c.RemoveOTPToken("abc") },
+		"automountlocation_del": func() error { return c.AutomountLocationDel("default") },
+		"automountmap_del":      func() error { return c.AutomountMapDel("default", "auto.home") },
+		"hbacsvc_del":           func() error { return c.HbacSvcDel("sshd") },
+		"hbacsvcgroup_del":      func() error { return c.HbacSvcGroupDel("login") },
+		"hbacsvcgroup_remove_member": func() error {
+			_, err := c.HbacSvcGroupRemoveMember("login", "sshd")
+			return err
+		},
+		"automountkey_del": func() error { return c.AutomountKeyDel("default", "auto.home", "jdoe") },
+	}
+
+	for method, call := range wrappers {
+		guarded = nil
+		err := call()
+		assert.ErrorIsf(err, errCustom, "%s should be guarded", method)
+		assert.Equal([]string{method}, guarded)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tidwall/gjson"
+)
+
+// HbacSvc encapsulates FreeIPA HBAC services, the PAM service names HBAC
+// rules control access to
+type HbacSvc struct {
+	DN          string   `json:"dn"`
+	Name        string   `json:"cn"`
+	Description string   `json:"description"`
+	Groups      []string `json:"memberof_hbacsvcgroup"`
+}
+
+// HbacSvcGroup encapsulates FreeIPA HBAC service groups
+type HbacSvcGroup struct {
+	DN          string   `json:"dn"`
+	Name        string   `json:"cn"`
+	Description string   `json:"description"`
+	Services    []string `json:"member_hbacsvc"`
+
+	// Raw hbacsvcgroup record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+func (s *HbacSvc) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid hbac service record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	s.DN = res.Get("dn").String()
+	s.Name = res.Get("cn.0").String()
+	s.Description = res.Get("description.0").String()
+	s.Groups = parseStrings(res, "memberof_hbacsvcgroup")
+
+	return nil
+}
+
+func (g *HbacSvcGroup) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid hbac service group record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	g.Raw = append(json.RawMessage(nil), raw...)
+
+	if err := checkValueCount(res, "member_hbacsvc", MaxGroups); err != nil {
+		return err
+	}
+
+	g.DN = res.Get("dn").String()
+	g.Name = res.Get("cn.0").String()
+	g.Description = res.Get("description.0").String()
+	g.Services = parseStrings(res, "member_hbacsvc")
+
+	return nil
+}
+
+// Add new HBAC service. name is the PAM service name, for example "sshd"
+func (c *Client) HbacSvcAdd(name, description string) (*HbacSvc, error) {
+	if err := checkArgs("hbacsvc_add", name); err != nil {
+		return nil, err
+	}
+
+	options := Options{"all": true}
+	if description != "" {
+		options["description"] = description
+	}
+
+	res, err := c.rpc("hbacsvc_add", []string{name}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := new(HbacSvc)
+	err = svc.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// Delete HBAC service
+func (c *Client) HbacSvcDel(name string) error {
+	if err := checkArgs("hbacsvc_del", name); err != nil {
+		return err
+	}
+
+	_, err := c.rpc("hbacsvc_del", []string{name}, Options{})
+	return err
+}
+
+// Find all HBAC services
+func (c *Client) HbacSvcFind() ([]*HbacSvc, error) {
+	res, err := c.rpc("hbacsvc_find", []string{""}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]*HbacSvc, 0)
+	for _, r := range gjson.ParseBytes(res.Result.Data).Array() {
+		svc := new(HbacSvc)
+		err := svc.fromJSON([]byte(r.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		services = append(services, svc)
+	}
+
+	return services, nil
+}
+
+// Add new HBAC service group
+func (c *Client) HbacSvcGroupAdd(cn string) (*HbacSvcGroup, error) {
+	if err := checkArgs("hbacsvcgroup_add", cn); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc("hbacsvcgroup_add", []string{cn}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	group := new(HbacSvcGroup)
+	err = group.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// Delete HBAC service group. The services in the group are not deleted.
+func (c *Client) HbacSvcGroupDel(cn string) error {
+	if err := checkArgs("hbacsvcgroup_del", cn); err != nil {
+		return err
+	}
+
+	_, err := c.rpc("hbacsvcgroup_del", []string{cn}, Options{})
+	return err
+}
+
+// Add HBAC services to service group. An error listing the services which
+// could not be added is returned if any failed.
+func (c *Client) HbacSvcGroupAddMember(cn string, services ...string) (*HbacSvcGroup, error) {
+	return c.hbacSvcGroupMember("hbacsvcgroup_add_member", cn, services)
+}
+
+// Remove HBAC services from service group
+func (c *Client) HbacSvcGroupRemoveMember(cn string, services ...string) (*HbacSvcGroup, error) {
+	return c.hbacSvcGroupMember("hbacsvcgroup_remove_member", cn, services)
+}
+
+func (c *Client) hbacSvcGroupMember(method, cn string, services []string) (*HbacSvcGroup, error) {
+	if err := checkArgs(method, cn); err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, errors.New("At least one service is required")
+	}
+
+	options := Options{
+		"hbacsvc": services,
+		"all":     true,
+	}
+
+	res, err := c.rpc(method, []string{cn}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers(method, res.Result, "member.hbacsvc")
+	if err != nil {
+		return nil, err
+	}
+
+	group := new(HbacSvcGroup)
+	err = group.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// Add HBAC services and service groups to the services rule controls access
+// to. Either may be empty. An error listing the services and groups which
+// could not be added is returned if any failed.
+func (c *Client) HbacRuleAddService(rule string, services, serviceGroups []string) (*HbacRule, error) {
+	return c.hbacRuleService("hbacrule_add_service", rule, services, serviceGroups)
+}
+
+// Remove HBAC services and service groups from rule
+func (c *Client) HbacRuleRemoveService(rule string, services, serviceGroups []string) (*HbacRule, error) {
+	return c.hbacRuleService("hbacrule_remove_service", rule, services, serviceGroups)
+}
+
+func (c *Client) hbacRuleService(method, rule string, services, serviceGroups []string) (*HbacRule, error) {
+	if err := checkArgs(method, rule); err != nil {
+		return nil, err
+	}
+	if len(services) == 0 && len(serviceGroups) == 0 {
+		return nil, errors.New("At least one service or service group is required")
+	}
+
+	options := Options{"all": true}
+	if len(services) > 0 {
+		options["hbacsvc"] = services
+	}
+	if len(serviceGroups) > 0 {
+		options["hbacsvcgroup"] = serviceGroups
+	}
+
+	res, err := c.rpc(method, []string{rule}, options)
+	if err != nil {
+		return nil, categoryConflict(method, rule, err)
+	}
+
+	err = failedMembers(method, res.Result, "memberservice.hbacsvc", "memberservice.hbacsvcgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	hbacRule := new(HbacRule)
+	err = hbacRule.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hbacRule, nil
+}
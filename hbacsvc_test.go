@@ -0,0 +1,115 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestHbacSvc(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	var options []map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		opts := req.Options
+		delete(opts, "version")
+		methods = append(methods, req.Method)
+		options = append(options, opts)
+
+		switch req.Method {
+		case "hbacsvc_add":
+			stubResult(`{"result": {"dn": "cn=rstudio,cn=hbacservices,cn=hbac,dc=local", "cn": ["rstudio"], "description": ["RStudio server"]},
+				"summary": "Added HBAC service \"rstudio\"", "value": "rstudio"}`)(w, r)
+		case "hbacsvc_find":
+			stubResult(`{"count": 2, "result": [{"cn": ["rstudio"], "description": ["RStudio server"]},
+				{"cn": ["sshd"], "memberof_hbacsvcgroup": ["Sudo", "remote"]}], "summary": "2 HBAC services matched", "truncated": false}`)(w, r)
+		case "hbacsvcgroup_add":
+			stubResult(`{"result": {"dn": "cn=remote,cn=hbacservicegroups,cn=hbac,dc=local", "cn": ["remote"]},
+				"summary": "Added HBAC service group \"remote\"", "value": "remote"}`)(w, r)
+		case "hbacsvcgroup_add_member":
+			stubResult(`{"completed": 2, "failed": {"member": {"hbacsvc": []}}, "result": {"cn": ["remote"], "member_hbacsvc": ["rstudio", "sshd"]}}`)(w, r)
+		case "hbacsvc_del", "hbacsvcgroup_del":
+			stubResult(`{"result": {"failed": []}, "summary": "Deleted", "value": ["remote"]}`)(w, r)
+		}
+	})
+
+	svc, err := c.HbacSvcAdd("rstudio", "RStudio server")
+	require.NoError(err)
+	assert.Equal("rstudio", svc.Name)
+	assert.Equal("RStudio server", svc.Description)
+	assert.Equal("cn=rstudio,cn=hbacservices,cn=hbac,dc=local", svc.DN)
+	assert.Equal(map[string]interface{}{"all": true, "description": "RStudio server"}, options[0])
+
+	services, err := c.HbacSvcFind()
+	require.NoError(err)
+	require.Len(services, 2)
+	assert.Equal("sshd", services[1].Name)
+	assert.Equal([]string{"Sudo", "remote"}, services[1].Groups)
+
+	group, err := c.HbacSvcGroupAdd("remote")
+	require.NoError(err)
+	assert.Equal("remote", group.Name)
+
+	group, err = c.HbacSvcGroupAddMember("remote", "rstudio", "sshd")
+	require.NoError(err)
+	assert.Equal([]string{"rstudio", "sshd"}, group.Services)
+	assert.Equal([]interface{}{"rstudio", "sshd"}, options[3]["hbacsvc"])
+
+	require.NoError(c.HbacSvcGroupDel("remote"))
+	require.NoError(c.HbacSvcDel("rstudio"))
+	assert.Equal([]string{"hbacsvc_add", "hbacsvc_find", "hbacsvcgroup_add", "hbacsvcgroup_add_member", "hbacsvcgroup_del", "hbacsvc_del"}, methods)
+
+	_, err = c.HbacSvcGroupAddMember("remote")
+	assert.Error(err)
+	_, err = c.HbacSvcAdd("", "")
+	assert.True(errors.Is(err, ipa.ErrEmptyArgument))
+}
+
+func TestHbacSvcFailedMembers(t *testing.T) {
+	c := newTestClientStub(t, stubResult(`{"completed": 1, "failed": {"member": {"hbacsvc": [["nosuchsvc", "no such entry"]]}},
+		"result": {"cn": ["remote"], "member_hbacsvc": ["sshd"]}}`))
+
+	_, err := c.HbacSvcGroupAddMember("remote", "sshd", "nosuchsvc")
+	assert.EqualError(t, err, "ipa: hbacsvcgroup_add_member failed for nosuchsvc: no such entry")
+}
+
+func TestHbacRuleAddService(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		options = req.Options
+
+		if _, ok := options["hbacsvcgroup"]; ok {
+			stubError(ipa.ErrCodeMutuallyExclusive, "services cannot be added when service category='all'")(w, r)
+			return
+		}
+		stubResult(`{"completed": 1, "failed": {"memberservice": {"hbacsvc": [["nosuchsvc", "no such entry"]], "hbacsvcgroup": []}},
+			"result": {"cn": ["allow_rstudio"], "memberservice_hbacsvc": ["rstudio"]}}`)(w, r)
+	})
+
+	_, err := c.HbacRuleAddService("allow_rstudio", []string{"rstudio", "nosuchsvc"}, nil)
+	assert.EqualError(err, "ipa: hbacrule_add_service failed for nosuchsvc: no such entry")
+	assert.Equal([]interface{}{"rstudio", "nosuchsvc"}, options["hbacsvc"])
+	assert.NotContains(options, "hbacsvcgroup")
+
+	_, err = c.HbacRuleAddService("allow_rstudio", nil, []string{"remote"})
+	require.Error(err)
+	assert.True(errors.Is(err, ipa.ErrCategoryConflict))
+
+	_, err = c.HbacRuleAddService("allow_rstudio", nil, nil)
+	assert.Error(err)
+}
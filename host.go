@@ -0,0 +1,456 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Host encapsulates host data returned from ipa host commands
+type Host struct {
+	UUID               string               `json:"ipauniqueid"`
+	DN                 string               `json:"dn"`
+	FQDN               string               `json:"fqdn"`
+	UnicodeFQDN        string               `json:"-"`
+	Principal          string               `json:"krbprincipalname"`
+	Description        string               `json:"description"`
+	Locality           string               `json:"l"`
+	Location           string               `json:"nshostlocation"`
+	Platform           string               `json:"nshardwareplatform"`
+	OSVersion          string               `json:"nsosversion"`
+	HasKeytab          bool                 `json:"has_keytab"`
+	HasPassword        bool                 `json:"has_password"`
+	SSHAuthKeys        []*SSHAuthorizedKey  `json:"ipasshpubkey"`
+	SSHKeyFingerprints []*SSHKeyFingerprint `json:"sshpubkeyfp"`
+	Certificates       []string             `json:"usercertificate"`
+	RandomPassword     string               `json:"randompassword"`
+	HostGroups         []string             `json:"memberof_hostgroup"`
+	IndirectHostGroups []string             `json:"memberofindirect_hostgroup"`
+	HbacRules          []string             `json:"memberofindirect_hbacrule"`
+	ManagedBy          []string             `json:"managedby_host"`
+	SudoRules          []string             `json:"memberofindirect_sudorule"`
+	CreateTimestamp    Time                 `json:"createtimestamp"`
+	ModifyTimestamp    Time                 `json:"modifytimestamp"`
+
+	// HbacRules and SudoRules hold both the rules the host is a direct
+	// member of and those it is a member of through a host group. These
+	// hold each kind separately
+	DirectHbacRules   []string `json:"memberof_hbacrule"`
+	IndirectHbacRules []string `json:"-"`
+	DirectSudoRules   []string `json:"memberof_sudorule"`
+	IndirectSudoRules []string `json:"-"`
+
+	// Raw host record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+// SSH public key fingerprint as computed by the FreeIPA server
+type SSHKeyFingerprint struct {
+	Fingerprint string
+	Comment     string
+	Type        string
+}
+
+// Parse a FreeIPA sshpubkeyfp value. These are formatted as
+// "fingerprint [comment] (type)", for example
+// "SHA256:9NiBLAynn/9d9lNcu/rOh5VXdXIJeA1oJDxfBGsI9xc test@localhost (ssh-rsa)"
+func ParseSSHKeyFingerprint(in string) (*SSHKeyFingerprint, error) {
+	fields := strings.Fields(in)
+	if len(fields) < 2 {
+		return nil, errors.New("invalid ssh key fingerprint")
+	}
+
+	keyType := fields[len(fields)-1]
+	if !strings.HasPrefix(keyType, "(") || !strings.HasSuffix(keyType, ")") {
+		return nil, errors.New("invalid ssh key fingerprint type")
+	}
+
+	return &SSHKeyFingerprint{
+		Fingerprint: fields[0],
+		Comment:     strings.Join(fields[1:len(fields)-1], " "),
+		Type:        strings.Trim(keyType, "()"),
+	}, nil
+}
+
+func (h *Host) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid host record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	h.Raw = stripSecrets(raw)
+
+	for _, attr := range []string{"ipasshpubkey", "sshpubkeyfp", "usercertificate"} {
+		if err := checkValueCount(res, attr, MaxSSHKeys); err != nil {
+			return err
+		}
+	}
+	for _, attr := range []string{"memberof_hostgroup", "memberofindirect_hostgroup", "memberof_hbacrule", "memberofindirect_hbacrule", "memberof_sudorule", "memberofindirect_sudorule", "managedby_host"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
+
+	h.UUID = res.Get("ipauniqueid.0").String()
+	h.DN = res.Get("dn").String()
+	h.FQDN = res.Get("fqdn.0").String()
+	h.UnicodeFQDN = hostToUnicode(h.FQDN)
+	h.Principal = res.Get("krbprincipalname.0").String()
+	h.Description = res.Get("description.0").String()
+	h.Locality = res.Get("l.0").String()
+	h.Location = res.Get("nshostlocation.0").String()
+	h.Platform = res.Get("nshardwareplatform.0").String()
+	h.OSVersion = res.Get("nsosversion.0").String()
+	h.CreateTimestamp = parseTimeAttr(res, "createtimestamp")
+	h.ModifyTimestamp = parseTimeAttr(res, "modifytimestamp")
+	h.HasKeytab = parseBool(res.Get("has_keytab"))
+	h.HasPassword = parseBool(res.Get("has_password"))
+	h.RandomPassword = res.Get("randompassword").String()
+	res.Get("ipasshpubkey").ForEach(func(key, value gjson.Result) bool {
+		k, err := NewSSHAuthorizedKey(value.String())
+		if err == nil {
+			h.SSHAuthKeys = append(h.SSHAuthKeys, k)
+		}
+		return true
+	})
+	res.Get("usercertificate").ForEach(func(key, value gjson.Result) bool {
+		// Certificates are base64 DER using the class-hint system, for
+		// example {"__base64__": "MII..."}
+		if cert := value.Get("__base64__"); cert.Exists() {
+			h.Certificates = append(h.Certificates, cert.String())
+		} else {
+			h.Certificates = append(h.Certificates, value.String())
+		}
+		return true
+	})
+	res.Get("sshpubkeyfp").ForEach(func(key, value gjson.Result) bool {
+		fp, err := ParseSSHKeyFingerprint(value.String())
+		if err == nil {
+			h.SSHKeyFingerprints = append(h.SSHKeyFingerprints, fp)
+		}
+		return true
+	})
+	res.Get("memberof_hostgroup").ForEach(func(key, value gjson.Result) bool {
+		h.HostGroups = append(h.HostGroups, value.String())
+		return true
+	})
+	res.Get("memberofindirect_hostgroup").ForEach(func(key, value gjson.Result) bool {
+		h.IndirectHostGroups = append(h.IndirectHostGroups, value.String())
+		return true
+	})
+	h.DirectHbacRules = parseStrings(res, "memberof_hbacrule")
+	h.IndirectHbacRules = parseStrings(res, "memberofindirect_hbacrule")
+	h.HbacRules = append(append([]string(nil), h.DirectHbacRules...), h.IndirectHbacRules...)
+	res.Get("managedby_host").ForEach(func(key, value gjson.Result) bool {
+		h.ManagedBy = append(h.ManagedBy, value.String())
+		return true
+	})
+	h.DirectSudoRules = parseStrings(res, "memberof_sudorule")
+	h.IndirectSudoRules = parseStrings(res, "memberofindirect_sudorule")
+	h.SudoRules = append(append([]string(nil), h.DirectSudoRules...), h.IndirectSudoRules...)
+
+	return nil
+}
+
+// Returns true if the host is a direct or indirect member of host group
+func (h *Host) HasHostGroup(group string) bool {
+	for _, g := range h.HostGroups {
+		if g == group {
+			return true
+		}
+	}
+	for _, g := range h.IndirectHostGroups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes ssh authorized key
+func (h *Host) RemoveSSHAuthorizedKey(fingerprint string) {
+	h.SSHAuthKeys = removeSSHAuthorizedKey(h.SSHAuthKeys, fingerprint)
+}
+
+// Add ssh authorized key, replacing any key with the same fingerprint.
+// Returns true if a key was replaced
+func (h *Host) AddSSHAuthorizedKey(key *SSHAuthorizedKey) bool {
+	keys, replaced, _ := addSSHAuthorizedKey(h.SSHAuthKeys, key, SSHKeyReplace)
+	h.SSHAuthKeys = keys
+	return replaced
+}
+
+// Format ssh authorized keys
+func (h *Host) FormatSSHAuthorizedKeys() []string {
+	return formatSSHAuthorizedKeys(h.SSHAuthKeys)
+}
+
+// Returns true if the fingerprint of every ssh key on the host matches a
+// fingerprint computed by the FreeIPA server
+func (h *Host) VerifySSHKeyFingerprints() bool {
+	for _, k := range h.SSHAuthKeys {
+		found := false
+		for _, fp := range h.SSHKeyFingerprints {
+			if fp.Fingerprint == k.Fingerprint {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Normalize host fqdn. The fqdn is lowercased, any trailing dot is removed
+// and internationalized names are converted to punycode. Returns an error if
+// fqdn is not fully qualified unless force is true.
+func normalizeFQDN(fqdn string, force bool) (string, error) {
+	fqdn = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(fqdn)), ".")
+	if fqdn == "" {
+		return "", fmt.Errorf("ipa: %w: host fqdn", ErrEmptyArgument)
+	}
+
+	fqdn, err := hostToASCII(fqdn)
+	if err != nil {
+		return "", err
+	}
+
+	if !force && !strings.Contains(fqdn, ".") {
+		return "", fmt.Errorf("%w: host %q is not fully qualified", ErrInvalidName, fqdn)
+	}
+
+	return fqdn, nil
+}
+
+// Add host. If ipAddress is not empty a DNS A/AAAA record is created for the
+// host along with a reverse record unless noReverse is true. If force is true
+// the host is added even if it is not fully qualified or has no DNS records.
+func (c *Client) HostAdd(fqdn, ipAddress string, force, noReverse bool) (*Host, error) {
+	return c.hostAdd(&HostSpec{
+		FQDN:      fqdn,
+		IPAddress: ipAddress,
+		Force:     force,
+		NoReverse: noReverse,
+	})
+}
+
+func (c *Client) hostAdd(spec *HostSpec) (*Host, error) {
+	fqdn, err := normalizeFQDN(spec.FQDN, spec.Force)
+	if err != nil {
+		return nil, err
+	}
+
+	options := Options{
+		"force": spec.Force,
+		"all":   true,
+	}
+
+	if spec.Description != "" {
+		options["description"] = spec.Description
+	}
+
+	if spec.GenerateOTP {
+		options["random"] = true
+	}
+
+	// Some FreeIPA versions reject an empty ip_address so only send it if set
+	if spec.IPAddress != "" {
+		options["ip_address"] = spec.IPAddress
+		if spec.NoReverse {
+			options["no_reverse"] = true
+		}
+	}
+
+	res, err := c.rpc("host_add", []string{fqdn}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	hostRec := new(Host)
+	err = hostRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostRec, nil
+}
+
+// Fetch host details by call the FreeIPA host-show method. Internationalized
+// host names are converted to punycode.
+func (c *Client) HostShow(fqdn string) (*Host, error) {
+	return c.HostShowWithOptions(fqdn, DefaultShowOptions())
+}
+
+// Fetch host details by calling the FreeIPA host-show method with show
+// options o
+func (c *Client) HostShowWithOptions(fqdn string, o ShowOptions) (*Host, error) {
+	fqdn, err := hostToASCII(fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.show("host_show", []string{fqdn}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	hostRec := new(Host)
+	err = hostRec.fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostRec, nil
+}
+
+// Returns the host groups host fqdn is a direct member of and those it is a
+// member of through nested host groups
+func (c *Client) HostGroupsForHost(fqdn string) ([]string, []string, error) {
+	host, err := c.HostShow(fqdn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]string{}, host.HostGroups...), append([]string{}, host.IndirectHostGroups...), nil
+}
+
+// Modify the attributes of host fqdn given in extra, for example a site
+// specific schema extension. Returns the current host if there were no
+// modifications to be performed.
+func (c *Client) HostMod(fqdn string, extra ExtraAttrs) (*Host, error) {
+	if err := checkArgs("host_mod", fqdn); err != nil {
+		return nil, err
+	}
+
+	options := Options{"all": true}
+	if err := extra.appendTo(options); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc("host_mod", []string{fqdn}, options)
+	if err != nil {
+		if IsNoModifications(err) {
+			return c.HostShow(fqdn)
+		}
+		return nil, err
+	}
+
+	hostRec := new(Host)
+	err = hostRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostRec, nil
+}
+
+// Set host ssh public keys. This replaces all existing keys on the host. If
+// keys is empty all ssh public keys are removed from the host.
+func (c *Client) HostSetSSHKeys(fqdn string, keys []*SSHAuthorizedKey) error {
+	options := Options{
+		"ipasshpubkey": formatSSHAuthorizedKeys(keys),
+	}
+
+	if len(keys) == 0 {
+		options["ipasshpubkey"] = ""
+	}
+
+	_, err := c.rpc("host_mod", []string{fqdn}, options)
+	if err != nil {
+		if IsNoModifications(err) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// HostDelOptions configures HostDel
+type HostDelOptions struct {
+	// Remove the host's A, AAAA and SSHFP DNS records
+	UpdateDNS bool
+}
+
+// Delete host fqdn. FreeIPA removes the host from its host groups and
+// revokes its certificates, if the CA is enabled, as part of the delete.
+func (c *Client) HostDel(fqdn string, opts HostDelOptions) error {
+	options := Options{}
+	if opts.UpdateDNS {
+		options["updatedns"] = true
+	}
+
+	_, err := c.rpc("host_del", []string{fqdn}, options)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Disable host. This removes the host keytab and any certificates. Use
+// HasKeytab on the returned host from HostShow to check keytab status.
+func (c *Client) HostDisable(fqdn string) error {
+	_, err := c.rpc("host_disable", []string{fqdn}, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Add hosts to the managers of host fqdn. Managing hosts can, for example,
+// retrieve keytabs for the host and its services.
+func (c *Client) HostAddManagedBy(fqdn string, managers ...string) (*Host, error) {
+	return c.hostManagedBy("host_add_managedby", fqdn, managers)
+}
+
+// Remove hosts from the managers of host fqdn
+func (c *Client) HostRemoveManagedBy(fqdn string, managers ...string) (*Host, error) {
+	return c.hostManagedBy("host_remove_managedby", fqdn, managers)
+}
+
+func (c *Client) hostManagedBy(method, fqdn string, managers []string) (*Host, error) {
+	if len(managers) == 0 {
+		return nil, errors.New("At least one host is required")
+	}
+
+	fqdn, err := hostToASCII(fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	options := Options{
+		"host": managers,
+		"all":  true,
+	}
+
+	res, err := c.rpc(method, []string{fqdn}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers(method, res.Result, "managedby.host")
+	if err != nil {
+		return nil, err
+	}
+
+	hostRec := new(Host)
+	err = hostRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostRec, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestParseSSHKeyFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	fp, err := ipa.ParseSSHKeyFingerprint("SHA256:9NiBLAynn/9d9lNcu/rOh5VXdXIJeA1oJDxfBGsI9xc test@localhost (ssh-rsa)")
+	if assert.NoError(err) {
+		assert.Equal("SHA256:9NiBLAynn/9d9lNcu/rOh5VXdXIJeA1oJDxfBGsI9xc", fp.Fingerprint)
+		assert.Equal("test@localhost", fp.Comment)
+		assert.Equal("ssh-rsa", fp.Type)
+	}
+
+	fp, err = ipa.ParseSSHKeyFingerprint("SHA256:AI0RufiZpCJvXH4OaUmW2kFkNmf5k5YFSRWDrMDtGPg (ssh-ed25519)")
+	if assert.NoError(err) {
+		assert.Empty(fp.Comment)
+		assert.Equal("ssh-ed25519", fp.Type)
+	}
+
+	_, err = ipa.ParseSSHKeyFingerprint("SHA256:AI0RufiZpCJvXH4OaUmW2kFkNmf5k5YFSRWDrMDtGPg")
+	assert.Error(err)
+}
+
+func TestHostSSHKeys(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	rsaKey := "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDVBSs8RP8KPbdMwOmuKgjScx301k1mBZTubfcJc7HKcJ19f1Z/eJ5y9R7LjhsK1WGn8ISRtP2c0NUNPWcZHdWzTv6m2AFL4qniXr2vvKcewq2fxy8uXnUSvS054wwFDW6trmWV1Vrrab0eXO9S7tGGLdx2ySQ8Bzfe8wY3M2/N1gd5dzGSVg3qFspgikTKjRt5rfaWoN+/OWLDg1HHEWjY0Hgqry1bJW3U83SlIi9+JwKW0zxunwImgFsI1xC15lf7X9LOE9e6XGT1km/NTPOqoAvaCCA0KyAK7P6cLjFVAA/k9UnC/QX6JKXoURFRdhPEdFqauF3Xw9rwDFCFkMUp test@localhost"
+	rsaFingerprint := "SHA256:9NiBLAynn/9d9lNcu/rOh5VXdXIJeA1oJDxfBGsI9xc"
+	edKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJ/bv/yntdmOywdQVS53Qs3f9WGQkY6dvJZL5rmibGOf host@localhost"
+	edFingerprint := "SHA256:AI0RufiZpCJvXH4OaUmW2kFkNmf5k5YFSRWDrMDtGPg"
+
+	orig, err := c.HostShow(TestEnvHost)
+	require.NoErrorf(err, "Failed to fetch host")
+
+	rec := &ipa.Host{}
+	for _, key := range []string{rsaKey, edKey} {
+		authKey, err := ipa.NewSSHAuthorizedKey(key)
+		require.NoError(err)
+		rec.AddSSHAuthorizedKey(authKey)
+	}
+
+	err = c.HostSetSSHKeys(TestEnvHost, rec.SSHAuthKeys)
+	require.NoErrorf(err, "Failed to set host ssh keys")
+
+	rec, err = c.HostShow(TestEnvHost)
+	require.NoErrorf(err, "Failed to fetch host")
+
+	assert.Lenf(rec.SSHAuthKeys, 2, "Invalid number of ssh keys")
+	assert.Lenf(rec.SSHKeyFingerprints, 2, "Invalid number of ssh key fingerprints")
+	assert.Truef(rec.VerifySSHKeyFingerprints(), "Server fingerprints do not match local fingerprints")
+	for _, k := range rec.SSHAuthKeys {
+		assert.Containsf([]string{rsaFingerprint, edFingerprint}, k.Fingerprint, "Unknown ssh key fingerprint")
+	}
+
+	rec.RemoveSSHAuthorizedKey(rsaFingerprint)
+	assert.Lenf(rec.SSHAuthKeys, 1, "Failed to remove ssh key")
+
+	err = c.HostSetSSHKeys(TestEnvHost, orig.SSHAuthKeys)
+	assert.NoErrorf(err, "Failed to restore host ssh keys")
+}
+
+func TestHostAdd(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var params []json.RawMessage
+	var options map[string]interface{}
+	result := stubResult(`{"result": {"dn": "fqdn=web01.example.com,cn=computers,cn=accounts,dc=example,dc=com", "fqdn": ["web01.example.com"], "krbprincipalname": ["host/web01.example.com@EXAMPLE.COM"], "ipauniqueid": ["a6c5ae0a-1d8a-11ee-8e6e-525400c3f4b1"], "has_keytab": false, "has_password": false}, "summary": "Added host \"web01.example.com\"", "value": "web01.example.com"}`)
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		params = req.Params
+		options = req.Options
+		calls++
+		result(w, r)
+	})
+
+	rec, err := c.HostAdd("Web01.Example.com.", "", false, false)
+	require.NoError(err)
+	assert.JSONEq(`["web01.example.com"]`, string(params[0]))
+	assert.NotContainsf(options, "ip_address", "Empty ip_address should be omitted")
+	assert.NotContains(options, "no_reverse")
+	assert.Equal(false, options["force"])
+	assert.Equal("web01.example.com", rec.FQDN)
+	assert.Equal("host/web01.example.com@EXAMPLE.COM", rec.Principal)
+	assert.Equal("a6c5ae0a-1d8a-11ee-8e6e-525400c3f4b1", rec.UUID)
+
+	_, err = c.HostAdd("web01.example.com", "10.0.0.1", false, true)
+	require.NoError(err)
+	assert.Equal("10.0.0.1", options["ip_address"])
+	assert.Equal(true, options["no_reverse"])
+
+	calls = 0
+	_, err = c.HostAdd("web01", "", false, false)
+	assert.ErrorIs(err, ipa.ErrInvalidName)
+	_, err = c.HostAdd(" . ", "", true, false)
+	assert.Error(err)
+	assert.Equalf(0, calls, "Invalid host names should be refused client side")
+
+	_, err = c.HostAdd("web01", "", true, false)
+	require.NoError(err)
+	assert.JSONEq(`["web01"]`, string(params[0]))
+	assert.Equal(true, options["force"])
+}
+
+func TestHostManagedBy(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var req stubCall
+	failed := `{"managedby": {"host": []}}`
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req = stubDecode(r)
+		stubResult(`{"completed": 1, "failed": `+failed+`, "result": {"fqdn": ["app.example.com"], "managedby_host": ["app.example.com", "mgmt.example.com"]}}`)(w, r)
+	})
+
+	host, err := c.HostAddManagedBy("app.example.com", "mgmt.example.com")
+	require.NoError(err)
+	assert.Equal("host_add_managedby", req.Method)
+	assert.Equal([]interface{}{"mgmt.example.com"}, req.Options["host"])
+	assert.Equal([]string{"app.example.com", "mgmt.example.com"}, host.ManagedBy)
+
+	_, err = c.HostRemoveManagedBy("app.example.com", "mgmt.example.com")
+	require.NoError(err)
+	assert.Equal("host_remove_managedby", req.Method)
+
+	_, err = c.HostAddManagedBy("app.example.com")
+	assert.Error(err)
+
+	failed = `{"managedby": {"host": [["mgmt.example.com", "This entry is already a member"]]}}`
+	_, err = c.HostAddManagedBy("app.example.com", "mgmt.example.com")
+	assert.Error(err)
+}
+
+func TestHostMemberOf(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var args []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args = req.Args
+		if args[0] == "bare.example.com" {
+			stubResult(`{"result": {"fqdn": ["bare.example.com"]}, "value": "bare.example.com"}`)(w, r)
+			return
+		}
+		stubResult(`{"result": {"fqdn": ["web01.example.com"],
+			"memberof_hostgroup": ["web"], "memberofindirect_hostgroup": ["servers", "all_hosts"],
+			"memberof_hbacrule": ["web01_admin"], "memberofindirect_hbacrule": ["allow_web"],
+			"memberof_sudorule": ["web01_restart"], "memberofindirect_sudorule": ["web_ops", "servers_ops"]},
+			"value": "web01.example.com"}`)(w, r)
+	})
+
+	host, err := c.HostShow("web01.example.com")
+	require.NoError(err)
+	assert.Equal([]string{"web01_admin"}, host.DirectHbacRules)
+	assert.Equal([]string{"allow_web"}, host.IndirectHbacRules)
+	assert.Equal([]string{"web01_admin", "allow_web"}, host.HbacRules)
+	assert.Equal([]string{"web01_restart"}, host.DirectSudoRules)
+	assert.Equal([]string{"web_ops", "servers_ops"}, host.IndirectSudoRules)
+	assert.Equal([]string{"web01_restart", "web_ops", "servers_ops"}, host.SudoRules)
+
+	direct, indirect, err := c.HostGroupsForHost("web01.example.com")
+	require.NoError(err)
+	assert.Equal([]string{"web"}, direct)
+	assert.Equal([]string{"servers", "all_hosts"}, indirect)
+	assert.Equal([]string{"web01.example.com"}, args)
+
+	direct, indirect, err = c.HostGroupsForHost("bare.example.com")
+	require.NoError(err)
+	assert.NotNil(direct)
+	assert.Empty(direct)
+	assert.Empty(indirect)
+
+	_, _, err = c.HostGroupsForHost("")
+	assert.ErrorIs(err, ipa.ErrEmptyArgument)
+}
+
+func TestHostDel(t *testing.T) {
+	c, calls := newDecommissionStub(t, false, false)
+	require.NoError(t, c.HostDel("web.local", ipa.HostDelOptions{}))
+	require.NoError(t, c.HostDel("web.local", ipa.HostDelOptions{UpdateDNS: true}))
+	assert.NotContains(t, (*calls)[0].options, "updatedns")
+	assert.Equal(t, true, (*calls)[1].options["updatedns"])
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+)
+
+// HostSpec describes a host to provision with HostAddBulk
+type HostSpec struct {
+	FQDN        string
+	IPAddress   string
+	Description string
+
+	// Host groups to add the host to
+	HostGroups []string
+
+	// Generate a one-time password for enrolling the host with
+	// ipa-client-install
+	GenerateOTP bool
+
+	// Add the host even if it is not fully qualified or has no DNS records
+	Force bool
+
+	// Do not create a reverse DNS record for IPAddress
+	NoReverse bool
+}
+
+// HostResult is the result of provisioning a single host with HostAddBulk
+type HostResult struct {
+	FQDN string
+
+	// The created host. Nil if adding the host failed
+	Host *Host
+
+	// One-time enrollment password if requested
+	OTP string
+
+	// Error adding the host or adding it to host groups
+	Err error
+}
+
+// Add hosts and add them to host groups using up to concurrency concurrent
+// requests. Errors are reported per host in the results, which are returned
+// in the same order as hosts. In dry-run mode the requests for every host are
+// passed to the dry-run sink, which may be called concurrently, and each
+// result has Err set to ErrDryRun.
+func (c *Client) HostAddBulk(hosts []*HostSpec, concurrency int) ([]HostResult, error) {
+	if concurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+
+	results := make([]HostResult, len(hosts))
+	err := runOrdered(len(hosts), concurrency, func(i int, done <-chan struct{}) func() error {
+		r := c.hostProvision(hosts[i])
+		return func() error {
+			results[i] = r
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Add a single host and add it to its host groups
+func (c *Client) hostProvision(spec *HostSpec) HostResult {
+	result := HostResult{FQDN: spec.FQDN}
+
+	fqdn, err := normalizeFQDN(spec.FQDN, spec.Force)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.FQDN = fqdn
+
+	host, err := c.hostAdd(spec)
+	if err != nil && !errors.Is(err, ErrDryRun) {
+		result.Err = err
+		return result
+	}
+
+	if host != nil {
+		result.Host = host
+		result.OTP = host.RandomPassword
+	}
+
+	for _, group := range spec.HostGroups {
+		_, gerr := c.HostGroupAddMember(group, fqdn)
+		if gerr != nil && !errors.Is(gerr, ErrDryRun) {
+			result.Err = gerr
+			return result
+		}
+	}
+
+	// Report dry-run after all requests were passed to the sink
+	result.Err = err
+
+	return result
+}
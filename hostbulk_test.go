@@ -0,0 +1,110 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newHostBulkStub(t *testing.T) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+
+		switch req.Method {
+		case "host_add":
+			if args[0] == "exists.example.com" {
+				stubError(4002, `host with name "exists.example.com" already exists`)(w, r)
+				return
+			}
+			stubResult(fmt.Sprintf(`{"result": {"fqdn": [%q], "randompassword": "otp-%s"}, "summary": null, "value": %q}`, args[0], args[0], args[0]))(w, r)
+		case "hostgroup_add_member":
+			if args[0] == "nosuchgroup" {
+				stubError(4001, "nosuchgroup: host group not found")(w, r)
+				return
+			}
+			stubResult(fmt.Sprintf(`{"completed": 1, "failed": {"member": {"host": [], "hostgroup": []}}, "result": {"cn": [%q]}}`, args[0]))(w, r)
+		default:
+			http.Error(w, "unexpected method", http.StatusBadRequest)
+		}
+	})
+}
+
+func TestHostAddBulk(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newHostBulkStub(t)
+
+	hosts := []*ipa.HostSpec{
+		{FQDN: "web01.example.com", HostGroups: []string{"webservers"}, GenerateOTP: true},
+		{FQDN: "Web02.Example.com.", HostGroups: []string{"webservers", "nosuchgroup"}},
+		{FQDN: "exists.example.com"},
+		{FQDN: "shortname"},
+		{FQDN: "db01.example.com", IPAddress: "10.0.0.5"},
+	}
+
+	results, err := c.HostAddBulk(hosts, 3)
+	require.NoError(err)
+	require.Len(results, len(hosts))
+
+	assert.NoError(results[0].Err)
+	assert.Equal("web01.example.com", results[0].FQDN)
+	assert.Equal("otp-web01.example.com", results[0].OTP)
+	require.NotNil(results[0].Host)
+	assert.Equal("web01.example.com", results[0].Host.FQDN)
+
+	assert.Errorf(results[1].Err, "Missing host group should fail")
+	assert.Equal("web02.example.com", results[1].FQDN)
+	assert.NotNilf(results[1].Host, "Host should be returned when adding to host group fails")
+
+	assert.Error(results[2].Err)
+	assert.Nil(results[2].Host)
+
+	assert.ErrorIs(results[3].Err, ipa.ErrInvalidName)
+
+	assert.NoError(results[4].Err)
+
+	_, err = c.HostAddBulk(hosts, 0)
+	assert.Error(err)
+}
+
+func TestHostAddBulkDryRun(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newHostBulkStub(t)
+	c.DryRun(true)
+
+	var mu sync.Mutex
+	methods := []string{}
+	c.SetDryRunSink(func(method string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		methods = append(methods, method)
+	})
+
+	hosts := []*ipa.HostSpec{
+		{FQDN: "web01.example.com", HostGroups: []string{"webservers"}},
+		{FQDN: "web02.example.com", HostGroups: []string{"webservers", "frontend"}},
+	}
+
+	results, err := c.HostAddBulk(hosts, 2)
+	require.NoError(err)
+	for _, res := range results {
+		assert.ErrorIs(res.Err, ipa.ErrDryRun)
+	}
+
+	sort.Strings(methods)
+	assert.Equal([]string{"host_add", "host_add", "hostgroup_add_member", "hostgroup_add_member", "hostgroup_add_member"}, methods)
+}
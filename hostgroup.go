@@ -0,0 +1,174 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// HostGroup encapsulates host group data returned from ipa hostgroup commands
+type HostGroup struct {
+	DN          string   `json:"dn"`
+	Name        string   `json:"cn"`
+	Description string   `json:"description"`
+	Hosts       []string `json:"member_host"`
+	HostGroups  []string `json:"member_hostgroup"`
+
+	// Raw hostgroup record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+func (g *HostGroup) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid host group record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	g.Raw = append(json.RawMessage(nil), raw...)
+
+	for _, attr := range []string{"member_host", "member_hostgroup"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
+
+	g.DN = res.Get("dn").String()
+	g.Name = res.Get("cn.0").String()
+	g.Description = res.Get("description.0").String()
+	res.Get("member_host").ForEach(func(key, value gjson.Result) bool {
+		g.Hosts = append(g.Hosts, value.String())
+		return true
+	})
+	res.Get("member_hostgroup").ForEach(func(key, value gjson.Result) bool {
+		g.HostGroups = append(g.HostGroups, value.String())
+		return true
+	})
+
+	return nil
+}
+
+// Check name against the host group name rules enforced by FreeIPA: at most
+// 255 letters, numbers, _, - and ., not starting with -. Returns an error
+// wrapping ErrInvalidName with the first rule broken.
+func ValidateHostGroupName(name string) error {
+	if reason := nameError(name, hostGroupNamePattern, "letters, numbers, _, - and ."); reason != "" {
+		return fmt.Errorf("ipa: %w: host group %q %s", ErrInvalidName, name, reason)
+	}
+
+	return nil
+}
+
+// HostGroupAddOptions are the optional attributes of a new host group
+type HostGroupAddOptions struct {
+	Description string
+
+	// Leave the host group name for FreeIPA to validate, see
+	// ValidateHostGroupName
+	SkipNameValidation bool
+}
+
+// Add new host group
+func (c *Client) HostGroupAdd(name, description string) (*HostGroup, error) {
+	return c.HostGroupAddWithOptions(name, HostGroupAddOptions{Description: description})
+}
+
+// Add new host group with options. The name is checked with
+// ValidateHostGroupName unless opts.SkipNameValidation is set. Returns
+// ErrHostGroupExists if the host group exists.
+func (c *Client) HostGroupAddWithOptions(name string, opts HostGroupAddOptions) (*HostGroup, error) {
+	if err := checkArgs("hostgroup_add", name); err != nil {
+		return nil, err
+	}
+	if !opts.SkipNameValidation {
+		if err := ValidateHostGroupName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	options := Options{"all": true}
+	if opts.Description != "" {
+		options["description"] = opts.Description
+	}
+
+	res, err := c.rpc("hostgroup_add", []string{name}, options)
+	if err != nil {
+		if IsDuplicate(err) {
+			return nil, ErrHostGroupExists
+		}
+		return nil, err
+	}
+
+	groupRec := new(HostGroup)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Add hosts to host group
+func (c *Client) HostGroupAddMember(name string, hosts ...string) (*HostGroup, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("At least one host is required")
+	}
+
+	options := Options{
+		"host": hosts,
+		"all":  true,
+	}
+
+	res, err := c.rpc("hostgroup_add_member", []string{name}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers("hostgroup_add_member", res.Result, "member.host")
+	if err != nil {
+		return nil, err
+	}
+
+	groupRec := new(HostGroup)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
+
+// Remove hosts from host group
+func (c *Client) HostGroupRemoveMember(name string, hosts ...string) (*HostGroup, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("At least one host is required")
+	}
+
+	options := Options{
+		"host": hosts,
+		"all":  true,
+	}
+
+	res, err := c.rpc("hostgroup_remove_member", []string{name}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers("hostgroup_remove_member", res.Result, "member.host")
+	if err != nil {
+		return nil, err
+	}
+
+	groupRec := new(HostGroup)
+	err = groupRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return groupRec, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Returns s in Unicode Normalization Form C. FreeIPA compares attribute
+// values byte for byte, so composed and decomposed forms of the same name
+// are different values.
+func normalizeString(s string) string {
+	return norm.NFC.String(s)
+}
+
+// Returns true if s contains non-ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Convert an internationalized host name to its ASCII (punycode) form as
+// stored by FreeIPA. The name is normalized first, see NormalizeName.
+func hostToASCII(fqdn string) (string, error) {
+	fqdn, err := NormalizeName("host", fqdn)
+	if err != nil {
+		return "", err
+	}
+	if isASCII(fqdn) {
+		return fqdn, nil
+	}
+
+	ascii, err := idna.Punycode.ToASCII(normalizeString(fqdn))
+	if err != nil {
+		return "", fmt.Errorf("%w: host %q: %s", ErrInvalidName, fqdn, err)
+	}
+
+	return ascii, nil
+}
+
+// Convert a punycode host name to its Unicode form. Returns fqdn unchanged
+// if it can not be converted.
+func hostToUnicode(fqdn string) string {
+	u, err := idna.Punycode.ToUnicode(fqdn)
+	if err != nil {
+		return fqdn
+	}
+
+	return u
+}
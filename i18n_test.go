@@ -0,0 +1,109 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+const (
+	// José Núñez with precomposed and combining accents
+	composedName   = "Jos\u00e9 N\u00fa\u00f1ez"
+	decomposedName = "Jose\u0301 Nu\u0301n\u0303ez"
+)
+
+func TestUserNormalization(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	require.NotEqual(composedName, decomposedName)
+
+	u := &ipa.User{Username: "jnunez", DisplayName: decomposedName}
+	assert.Equal(composedName, u.ToOptions()["displayname"])
+
+	u.NoNormalize = true
+	assert.Equal(decomposedName, u.ToOptions()["displayname"])
+
+	// A value written decomposed by other tooling does not differ from the
+	// composed desired value
+	current := &ipa.User{Username: "jnunez", DisplayName: decomposedName, First: "Jose\u0301"}
+	desired := &ipa.User{Username: "jnunez", DisplayName: composedName, First: "José"}
+	changes, err := ipa.DiffUsers(current, desired)
+	require.NoError(err)
+	assert.Empty(changes)
+
+	desired.DisplayName = "José Núñez García"
+	changes, err = ipa.DiffUsers(current, desired)
+	require.NoError(err)
+	require.Len(changes, 1)
+	assert.Equal("displayname", changes[0].Attribute)
+}
+
+func TestUserModNormalizationRoundTrip(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	stored := decomposedName
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		if req.Method == "user_mod" {
+			stored = req.Options["displayname"].(string)
+		}
+		name, _ := json.Marshal(stored)
+		stubResult(fmt.Sprintf(`{"result": {"uid": ["jnunez"], "displayname": [%s]}, "summary": null, "value": "jnunez"}`, name))(w, r)
+	})
+
+	u, err := c.UserShow("jnunez")
+	require.NoError(err)
+	assert.Equal(decomposedName, u.DisplayName)
+
+	u, err = c.UserMod(u)
+	require.NoError(err)
+	assert.Equal(composedName, stored)
+	assert.Equal(composedName, u.DisplayName)
+
+	// Saving again is stable
+	u, err = c.UserMod(u)
+	require.NoError(err)
+	assert.Equal(composedName, u.DisplayName)
+}
+
+func TestHostIDN(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var args []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args = req.Args
+		stubResult(`{"result": {"fqdn": ["xn--bcher-kva.example.com"]}, "summary": null, "value": "xn--bcher-kva.example.com"}`)(w, r)
+	})
+
+	host, err := c.HostShow("bücher.example.com")
+	require.NoError(err)
+	assert.Equal([]string{"xn--bcher-kva.example.com"}, args)
+	assert.Equal("xn--bcher-kva.example.com", host.FQDN)
+	assert.Equal("bücher.example.com", host.UnicodeFQDN)
+
+	_, err = c.HostAdd("Bücher.Example.com.", "", false, false)
+	require.NoError(err)
+	assert.Equal([]string{"xn--bcher-kva.example.com"}, args)
+
+	// Decomposed names are normalized before conversion
+	_, err = c.HostShow("bu\u0308cher.example.com")
+	require.NoError(err)
+	assert.Equal([]string{"xn--bcher-kva.example.com"}, args)
+
+	_, err = c.HostShow("ipa.example.com")
+	require.NoError(err)
+	assert.Equal([]string{"ipa.example.com"}, args)
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrConflictingExisting is returned by the idempotent add methods when the
+// entry already exists with different attributes. The underlying error is a
+// *ConflictError
+var ErrConflictingExisting = errors.New("existing entry conflicts with request")
+
+// ConflictError lists the attributes of an existing entry which differ from
+// those requested
+type ConflictError struct {
+	Name       string
+	Attributes []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("ipa: existing entry %s differs in %s", e.Name, strings.Join(e.Attributes, ", "))
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflictingExisting
+}
+
+// Collects attributes which differ between a request and an existing entry
+type conflicts []string
+
+// Record attr if the requested value is set and differs from existing
+func (c *conflicts) check(attr, requested, existing string) {
+	if requested != "" && requested != existing {
+		*c = append(*c, attr)
+	}
+}
+
+func (c conflicts) err(name string) error {
+	if len(c) == 0 {
+		return nil
+	}
+
+	return &ConflictError{Name: name, Attributes: c}
+}
+
+// Add new user unless it already exists. Safe to retry, for example after a
+// timeout where the first attempt succeeded. If the user exists it is
+// returned with created false, provided its first name, last name and email
+// match user. Otherwise ErrConflictingExisting is returned. Existing users
+// are returned without a random password.
+func (c *Client) UserAddIdempotent(user *User, random bool) (*User, bool, error) {
+	rec, err := c.UserAdd(user, random)
+	if err == nil {
+		return rec, true, nil
+	}
+	if !errors.Is(err, ErrUserExists) {
+		return nil, false, err
+	}
+
+	existing, err := c.UserShow(user.Username)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var diff conflicts
+	diff.check("givenname", user.First, existing.First)
+	diff.check("sn", user.Last, existing.Last)
+	diff.check("mail", strings.ToLower(user.Email), strings.ToLower(existing.Email))
+	if err := diff.err(user.Username); err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
+
+// Add new group unless it already exists. If the group exists it is returned
+// with created false, provided its description, GID and POSIX type match
+// opts. Otherwise ErrConflictingExisting is returned. See
+// UserAddIdempotent.
+func (c *Client) GroupAddIdempotent(cn string, opts GroupAddOptions) (*Group, bool, error) {
+	rec, err := c.GroupAddWithOptions(cn, opts)
+	if err == nil {
+		return rec, true, nil
+	}
+
+	// A retry with an explicit GID finds the GID in use by the group itself
+	if !errors.Is(err, ErrGroupExists) && !errors.Is(err, ErrGidInUse) {
+		return nil, false, err
+	}
+
+	existing, serr := c.GroupShow(cn)
+	if serr != nil {
+		if IsNotFound(serr) {
+			// The GID is used by another group
+			return nil, false, err
+		}
+		return nil, false, serr
+	}
+
+	var diff conflicts
+	diff.check("description", opts.Description, existing.Description)
+	if opts.Gid != 0 {
+		diff.check("gidnumber", fmt.Sprintf("%d", opts.Gid), existing.Gid)
+	}
+	if opts.NonPosix != (existing.Gid == "") {
+		diff = append(diff, "nonposix")
+	}
+	if err := diff.err(cn); err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
+
+// Add new host unless it already exists. If the host exists it is returned
+// with created false. The IP address is stored in DNS, not the host entry, so
+// existing hosts are not compared with the request. See UserAddIdempotent.
+func (c *Client) HostAddIdempotent(fqdn, ipAddress string, force, noReverse bool) (*Host, bool, error) {
+	rec, err := c.HostAdd(fqdn, ipAddress, force, noReverse)
+	if err == nil {
+		return rec, true, nil
+	}
+
+	if !IsDuplicate(err) {
+		return nil, false, err
+	}
+
+	name, err := normalizeFQDN(fqdn, force)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := c.HostShow(name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a client whose server has the entries in records, keyed by the
+// entity and primary key, for example "user jdoe". Adds of existing entries
+// fail with a duplicate entry error.
+func newIdempotentStub(t *testing.T, records map[string]string) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		options := req.Options
+
+		entity, verb, _ := strings.Cut(req.Method, "_")
+		switch verb {
+		case "add":
+			if _, ok := records[entity+" "+args[0]]; ok {
+				stubError(4002, "This entry already exists")(w, r)
+				return
+			}
+			stubResult(`{"result": {"uid": ["`+args[0]+`"], "cn": ["`+args[0]+`"], "fqdn": ["`+args[0]+`"]}, "summary": null}`)(w, r)
+		case "show":
+			rec, ok := records[entity+" "+args[0]]
+			if !ok {
+				stubError(4001, args[0]+": not found")(w, r)
+				return
+			}
+			stubResult(`{"result": `+rec+`, "summary": null}`)(w, r)
+		case "find":
+			for key, rec := range records {
+				if strings.HasPrefix(key, entity+" ") && strings.Contains(rec, `"gidnumber": ["`+options["gidnumber"].(string)+`"]`) {
+					stubResult(`{"count": 1, "result": [`+rec+`], "summary": null, "truncated": false}`)(w, r)
+					return
+				}
+			}
+			stubResult(`{"count": 0, "result": [], "summary": null, "truncated": false}`)(w, r)
+		}
+	})
+}
+
+func TestUserAddIdempotent(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newIdempotentStub(t, map[string]string{
+		"user jdoe": `{"uid": ["jdoe"], "givenname": ["John"], "sn": ["Doe"], "mail": ["JDoe@example.com"], "uidnumber": ["1001"]}`,
+	})
+
+	user, created, err := c.UserAddIdempotent(&ipa.User{Username: "jdoe", First: "John", Last: "Doe", Email: "jdoe@example.com"}, true)
+	require.NoError(err)
+	assert.False(created)
+	assert.Equalf("1001", user.Uid, "The existing user should be returned")
+
+	_, _, err = c.UserAddIdempotent(&ipa.User{Username: "jdoe", First: "Jane", Last: "Doe", Email: "jane@example.com"}, true)
+	assert.ErrorIs(err, ipa.ErrConflictingExisting)
+	var cerr *ipa.ConflictError
+	require.ErrorAs(err, &cerr)
+	assert.Equal([]string{"givenname", "mail"}, cerr.Attributes)
+
+	user, created, err = c.UserAddIdempotent(&ipa.User{Username: "jsmith", First: "Jane", Last: "Smith"}, true)
+	require.NoError(err)
+	assert.True(created)
+	assert.Equal("jsmith", user.Username)
+}
+
+func TestGroupAddIdempotent(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newIdempotentStub(t, map[string]string{
+		"group nfs": `{"cn": ["nfs"], "gidnumber": ["5000"], "description": ["Legacy NFS"]}`,
+		"group web": `{"cn": ["web"]}`,
+	})
+
+	group, created, err := c.GroupAddIdempotent("nfs", ipa.GroupAddOptions{Gid: 5000, Description: "Legacy NFS"})
+	require.NoErrorf(err, "A retry should find the GID in use by the group itself")
+	assert.False(created)
+	assert.Equal("5000", group.Gid)
+
+	_, _, err = c.GroupAddIdempotent("nfs2", ipa.GroupAddOptions{Gid: 5000})
+	assert.ErrorIs(err, ipa.ErrGidInUse)
+
+	_, _, err = c.GroupAddIdempotent("nfs", ipa.GroupAddOptions{Description: "Shared storage"})
+	assert.ErrorIs(err, ipa.ErrConflictingExisting)
+
+	_, created, err = c.GroupAddIdempotent("web", ipa.GroupAddOptions{NonPosix: true})
+	require.NoError(err)
+	assert.False(created)
+
+	_, _, err = c.GroupAddIdempotent("web", ipa.GroupAddOptions{})
+	assert.ErrorIsf(err, ipa.ErrConflictingExisting, "A POSIX group was requested but the existing group is non-POSIX")
+
+	_, created, err = c.GroupAddIdempotent("staff", ipa.GroupAddOptions{})
+	require.NoError(err)
+	assert.True(created)
+}
+
+func TestHostAddIdempotent(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newIdempotentStub(t, map[string]string{
+		"host web1.example.com": `{"fqdn": ["web1.example.com"], "has_keytab": true}`,
+	})
+
+	host, created, err := c.HostAddIdempotent("Web1.example.com.", "", true, false)
+	require.NoError(err)
+	assert.False(created)
+	assert.True(host.HasKeytab)
+
+	host, created, err = c.HostAddIdempotent("web2.example.com", "", true, false)
+	require.NoError(err)
+	assert.True(created)
+	assert.Equal("web2.example.com", host.FQDN)
+}
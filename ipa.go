@@ -12,13 +12,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-ini/ini"
@@ -26,20 +29,35 @@ import (
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/keytab"
-	"github.com/jcmturner/gokrb5/v8/spnego"
 	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
 )
 
 const (
 	DefaultKerbConf   = "/etc/krb5.conf"
 	IpaClientVersion  = "2.237"
 	IpaDatetimeFormat = "20060102150405Z"
+
+	// DefaultMaxResponseSize is the default maximum size in bytes of a
+	// response to show and other read-only calls
+	DefaultMaxResponseSize = 1 << 20
+
+	// DefaultMaxFindResponseSize is the default maximum size in bytes of a
+	// response to find calls and json_metadata
+	DefaultMaxFindResponseSize = 64 << 20
+
+	// Renew kerberos credentials this long before the TGT expires
+	krbRenewWindow = 5 * time.Minute
+
+	// FreeIPA CA certificate installed by ipa-client-install
+	ipaCAFile = "/etc/ipa/ca.crt"
 )
 
 var (
 	ipaDefaultHost    string
 	ipaDefaultRealm   string
 	ipaCertPool       *x509.CertPool
+	ipaCAPool         *x509.CertPool
 	ipaSessionPattern = regexp.MustCompile(`^ipa_session=([^;]+);`)
 
 	// ErrPasswordPolicy is returned when a password does not conform to the password policy
@@ -51,22 +69,175 @@ var (
 	// ErrExpiredPassword is returned when a password is expired
 	ErrExpiredPassword = errors.New("password expired")
 
+	// ErrOTPRequired is returned when a password was rejected because the
+	// user must also provide an OTP code
+	ErrOTPRequired = errors.New("otp required")
+
 	// ErrUnauthorized is returned when user is not authorized
 	ErrUnauthorized = errors.New("unauthorized")
 
 	// ErrUserExists is returned when user account already exists
-	ErrUserExists = errors.New("unauthorized")
+	ErrUserExists = errors.New("user already exists")
+
+	// ErrGroupExists is returned when group already exists
+	ErrGroupExists = errors.New("group already exists")
+
+	// ErrHostGroupExists is returned when host group already exists
+	ErrHostGroupExists = errors.New("host group already exists")
+
+	// ErrInvalidName is returned when a user or group name is rejected by
+	// FreeIPA
+	ErrInvalidName = errors.New("invalid name")
+
+	// ErrDryRun is returned instead of executing a mutating method when the
+	// client is in dry-run mode
+	ErrDryRun = errors.New("dry run: request not sent")
+
+	// ErrReadOnlyClient is returned instead of executing a mutating method
+	// when the client is read-only, see SetReadOnly
+	ErrReadOnlyClient = errors.New("client is read-only")
+
+	// ErrResponseTooLarge is returned when a response exceeds the maximum
+	// response size. See ResponseTooLargeError
+	ErrResponseTooLarge = errors.New("response too large")
+
+	// ErrCommandNotSupported is returned when the FreeIPA server does not
+	// have the command called, for example because it is an older version.
+	// See CommandNotSupportedError
+	ErrCommandNotSupported = errors.New("command not supported")
+
+	// ErrInvalidResponse is returned when a JSON-RPC response envelope is
+	// malformed, for example if the id does not match the request
+	ErrInvalidResponse = errors.New("invalid response")
+
+	// ErrClientClosed is returned when calling methods on a closed client
+	ErrClientClosed = errors.New("client closed")
+
+	// ErrEmptyArgument is returned without calling FreeIPA when a method is
+	// called with an empty or whitespace-only primary key
+	ErrEmptyArgument = errors.New("empty argument")
+
+	// ErrNoKerberosCredentials is returned when renewing kerberos credentials
+	// and the client has no credential source to login with
+	ErrNoKerberosCredentials = errors.New("no kerberos credentials available for renewal")
+
+	// ErrKerberosAuth is returned when a request can not be authenticated
+	// with the client kerberos credentials, for example because the ticket
+	// is invalid. The underlying gokrb5 error is wrapped.
+	ErrKerberosAuth = errors.New("kerberos authentication failed")
+
+	// ErrPrincipalMismatch is returned when FreeIPA reports a call was made
+	// as a different principal than expected, see Client.RequirePrincipal
+	ErrPrincipalMismatch = errors.New("principal mismatch")
+
+	// ErrInvalidExpiration is returned when setting an account expiration
+	// which is not in the future
+	ErrInvalidExpiration = errors.New("invalid expiration")
+
+	// ErrConcurrentModification is returned by UserModGuarded when the user
+	// was modified after it was loaded
+	ErrConcurrentModification = errors.New("concurrent modification")
+
+	// MaxSSHKeys is the maximum number of ssh public keys parsed from a single
+	// record. Records with more keys are rejected with an error.
+	MaxSSHKeys = 256
+
+	// MaxGroups is the maximum number of values parsed from a single
+	// membership attribute of a record (groups, hbac and sudo rules). Records
+	// with more values are rejected with an error.
+	MaxGroups = 65536
 )
 
+// FreeIPA methods which do not modify the directory. These are executed
+// normally when the client is in dry-run or read-only mode. Any method not
+// listed here, other than show and find methods, is considered mutating.
+var readOnlyMethods = map[string]bool{
+	"ping":              true,
+	"automountmap_find": true,
+	"automountkey_find": true,
+	"config_show":       true,
+	"pwpolicy_show":     true,
+	"user_show":         true,
+	"user_find":         true,
+	"host_show":         true,
+	"otptoken_find":     true,
+	"server_find":       true,
+	"server_role_find":  true,
+	"location_find":     true,
+	"group_show":        true,
+	"group_find":        true,
+	"host_find":         true,
+	"hostgroup_find":    true,
+	"hbacrule_find":     true,
+	"sudorule_find":     true,
+	"command_show":      true,
+	"json_metadata":     true,
+	"user_status":       true,
+	"whoami":            true,
+	"env":               true,
+}
+
+// Returns true if method does not modify FreeIPA. All show and find methods
+// are read-only
+func isReadOnly(method string) bool {
+	return readOnlyMethods[method] || isFind(method) || strings.HasSuffix(method, "_show")
+}
+
+// Returns true if FreeIPA method name may modify the directory and is refused
+// by read-only clients. Unknown methods are considered mutating, as are the
+// change_password and migration form endpoints. Gateways passing raw calls
+// through can use this to apply the same policy as the client.
+func IsMutatingMethod(name string) bool {
+	return !isReadOnly(name)
+}
+
 // FreeIPA Client
 type Client struct {
-	host       string
-	realm      string
-	keyTab     string
-	sessionID  string
-	sticky     bool
-	httpClient *http.Client
-	krbClient  *client.Client
+	host          string
+	realm         string
+	keyTab        string
+	sessionID     string
+	sticky        bool
+	sessionValid  func(cookie string) bool
+	dryRun        bool
+	readOnly      bool
+	dryRunSink    func(method string, payload []byte)
+	guard         MutationGuard
+	protected     []string
+	maxFindLen    int64
+	maxRespLen    int64
+	findOpts      *FindOptions
+	commands      map[string]bool
+	retryCodes    map[int]bool
+	commandMeta   map[string]*CommandMeta
+	commandList   []string
+	deadline      time.Time
+	reqTimeout    time.Duration
+	lastID        int64
+	jsonrpc2      bool
+	caseGroups    bool
+	mu            sync.RWMutex
+	observer      Observer
+	httpClient    *http.Client
+	ownsHTTP      bool
+	closed        bool
+	krbClient     KrbClient
+	krbLease      *krbLease
+	krbRenewing   bool
+	krbLogin      func() (*client.Client, time.Time, error)
+	krbValidUntil time.Time
+	keepPassword  bool
+	tracer        *tracer
+	ldapSearcher  LDAPSearcher
+	slowThreshold time.Duration
+	slowCall      func(method string, params []string, elapsed time.Duration)
+	lastCall      time.Duration
+	lastReused    bool
+	connEvents    bool
+	principal     string
+	sessLogin     func(c *Client) error
+	reloginState  *reloginState
+	parent        *Client
 }
 
 // FreeIPA api options map
@@ -76,6 +247,10 @@ type Options map[string]interface{}
 type IpaError struct {
 	Message string
 	Code    int
+
+	// Error class name, for example "ACIError", and additional error data
+	Name string
+	Data json.RawMessage
 }
 
 // Result returned from a FreeIPA JSON rpc call
@@ -83,6 +258,36 @@ type Result struct {
 	Summary string          `json:"summary"`
 	Value   interface{}     `json:"value"`
 	Data    json.RawMessage `json:"result"`
+
+	// Member changes completed and failed, set by member add and remove
+	// methods only
+	Completed int             `json:"completed"`
+	Failed    json.RawMessage `json:"failed"`
+
+	// Number of entries returned and whether the size or time limit was
+	// exceeded, set by find methods only
+	Count     int  `json:"count"`
+	Truncated bool `json:"truncated"`
+
+	// Messages returned with the command result
+	Messages []IpaMessage `json:"messages"`
+
+	// Whole result of commands which return their output at the top level
+	// instead of in result, such as json_metadata
+	raw json.RawMessage
+}
+
+// Unmarshal result, keeping the whole result if there is no result attribute
+func (r *Result) UnmarshalJSON(b []byte) error {
+	type result Result
+	if err := json.Unmarshal(b, (*result)(r)); err != nil {
+		return err
+	}
+	if r.Data == nil {
+		r.raw = append(json.RawMessage(nil), b...)
+	}
+
+	return nil
 }
 
 // Response returned from a FreeIPA JSON rpc call
@@ -92,17 +297,35 @@ type Response struct {
 	Principal string    `json:"principal"`
 	Version   string    `json:"version"`
 	Result    *Result   `json:"result"`
+
+	// Warnings and notices returned with the response, including those
+	// returned with the command result
+	Messages []IpaMessage `json:"messages"`
+}
+
+// Returns true if raw is a JSON object, the shape of a single FreeIPA entry.
+// Some commands return a boolean or list result instead
+func isRecord(raw []byte) bool {
+	return gjson.ValidBytes(raw) && gjson.ParseBytes(raw).IsObject()
 }
 
 func init() {
-	// If ca.crt for ipa exists, use it as the cert pool
-	// otherwise default to system root ca.
-	pem, err := ioutil.ReadFile("/etc/ipa/ca.crt")
+	// If ca.crt for ipa exists, add it to the system root ca so a stale ipa
+	// ca does not break servers signed by a public ca. Otherwise default to
+	// the system root ca.
+	pem, err := os.ReadFile(ipaCAFile)
 	if err == nil {
-		ipaCertPool = x509.NewCertPool()
-		if !ipaCertPool.AppendCertsFromPEM(pem) {
-			ipaCertPool = nil
+		ipaCAPool = x509.NewCertPool()
+		if !ipaCAPool.AppendCertsFromPEM(pem) {
+			ipaCAPool = nil
+		}
+	}
+	if ipaCAPool != nil {
+		ipaCertPool, err = x509.SystemCertPool()
+		if err != nil {
+			ipaCertPool = x509.NewCertPool()
 		}
+		ipaCertPool.AppendCertsFromPEM(pem)
 	}
 
 	// Load default IPA host
@@ -120,6 +343,7 @@ func newHTTPClient() *http.Client {
 	return &http.Client{
 		Timeout: 1 * time.Minute,
 		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -129,8 +353,12 @@ func newHTTPClient() *http.Client {
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig:       &tls.Config{RootCAs: ipaCertPool},
-			DisableCompression:    false,
+			TLSClientConfig: &tls.Config{
+				RootCAs: ipaCertPool,
+				// Resume TLS sessions when a new connection is needed
+				ClientSessionCache: tls.NewLRUClientSessionCache(0),
+			},
+			DisableCompression: false,
 		},
 	}
 }
@@ -142,6 +370,7 @@ func NewDefaultClient() *Client {
 		realm:      ipaDefaultRealm,
 		sticky:     true,
 		httpClient: newHTTPClient(),
+		ownsHTTP:   true,
 	}
 }
 
@@ -151,6 +380,7 @@ func NewDefaultClientWithSession(sessionID string) *Client {
 		host:       ipaDefaultHost,
 		realm:      ipaDefaultRealm,
 		httpClient: newHTTPClient(),
+		ownsHTTP:   true,
 		sticky:     true,
 		sessionID:  sessionID,
 	}
@@ -163,6 +393,7 @@ func NewClient(host, realm string) *Client {
 		realm:      realm,
 		sticky:     true,
 		httpClient: newHTTPClient(),
+		ownsHTTP:   true,
 	}
 }
 
@@ -180,11 +411,144 @@ func (e *IpaError) Error() string {
 	return fmt.Sprintf("ipa: error %d - %s", e.Code, e.Message)
 }
 
+// Unmarshal a FreeIPA error. Codes are accepted as numbers or as numeric
+// strings, as sent by proxies which re-serialize responses. A missing, null
+// or non-numeric code is 0 so the error message still reaches the caller.
+func (e *IpaError) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Message string
+		Code    json.RawMessage
+		Name    string
+		Data    json.RawMessage
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*e = IpaError{Message: raw.Message, Name: raw.Name, Data: raw.Data}
+
+	var code json.Number
+	if err := json.Unmarshal(raw.Code, &code); err != nil {
+		var s string
+		if json.Unmarshal(raw.Code, &s) != nil {
+			return nil
+		}
+		code = json.Number(strings.TrimSpace(s))
+	}
+	if n, err := code.Int64(); err == nil {
+		e.Code = int(n)
+	}
+
+	return nil
+}
+
+// ResponseTooLargeError is returned when the response to method exceeds Limit
+// bytes
+type ResponseTooLargeError struct {
+	Method string
+	Limit  int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("ipa: %s response exceeds %d bytes", e.Method, e.Limit)
+}
+
+func (e *ResponseTooLargeError) Unwrap() error {
+	return ErrResponseTooLarge
+}
+
+// CommandNotSupportedError is returned when the FreeIPA server does not have
+// Method
+type CommandNotSupportedError struct {
+	Method string
+}
+
+func (e *CommandNotSupportedError) Error() string {
+	return fmt.Sprintf("ipa: command %s not supported by server", e.Method)
+}
+
+func (e *CommandNotSupportedError) Unwrap() error {
+	return ErrCommandNotSupported
+}
+
+// Returns the maximum response size for method, or 0 if the response is not
+// limited. Find calls and json_metadata can return many records and are
+// allowed a larger response than show calls. Responses to mutating calls are
+// not limited, the change is already applied when the response is read and
+// failing the call would hide that.
+func (c *Client) maxResponseSize(method string) int64 {
+	switch {
+	case !isReadOnly(method):
+		return 0
+	case isFind(method) || method == "json_metadata":
+		if c.maxFindLen > 0 {
+			return c.maxFindLen
+		}
+		return DefaultMaxFindResponseSize
+	case c.maxRespLen > 0:
+		return c.maxRespLen
+	}
+
+	return DefaultMaxResponseSize
+}
+
+// Returns ErrEmptyArgument if any of args is empty or whitespace-only
+func checkArgs(method string, args ...string) error {
+	for _, arg := range args {
+		if strings.TrimSpace(arg) == "" {
+			return fmt.Errorf("ipa: %w: %s", ErrEmptyArgument, method)
+		}
+	}
+
+	return nil
+}
+
 // Call FreeIPA API with method, params and options
 func (c *Client) rpc(method string, params []string, options Options) (*Response, error) {
+	return c.call(method, params, options, true)
+}
+
+// Call FreeIPA API with method, params and options. If relogin is set and
+// the session is rejected the client logs in again, see SetSessionLogin, and
+// the call is retried once.
+func (c *Client) call(method string, params []string, options Options, relogin bool) (*Response, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	if err := c.checkReadOnly(method); err != nil {
+		return nil, err
+	}
+
+	// Find methods take search criteria, where an empty string matches all
+	// entries, rather than primary keys
+	if !isFind(method) {
+		if err := checkArgs(method, params...); err != nil {
+			return nil, err
+		}
+
+		var err error
+		params, err = c.normalizeParams(method, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if options == nil {
 		options = Options{}
 	}
+
+	var findOpts FindOptions
+	if isFind(method) && !isSchemaFind(method) {
+		findOpts = c.findOptions()
+		findOpts.apply(options)
+	}
+
+	if c.guard != nil && isDestructive(method) {
+		if err := c.guard(method, params, options); err != nil {
+			return nil, err
+		}
+	}
+
 	options["version"] = IpaClientVersion
 
 	data := []interface{}{
@@ -192,59 +556,119 @@ func (c *Client) rpc(method string, params []string, options Options) (*Response
 		options,
 	}
 
+	// Requests which are not sent keep id 0 so dry-run payloads are
+	// reproducible
+	dryRun := c.dryRun && !isReadOnly(method)
+	id := 0
+	if !dryRun {
+		id = int(atomic.AddInt64(&c.lastID, 1))
+	}
+
 	payload := Options{
-		"id":     0,
+		"id":     id,
 		"method": method,
 		"params": data,
 	}
+	if c.jsonrpc2 {
+		payload["jsonrpc"] = "2.0"
+	}
 
+	// Map keys are marshalled in sorted order so payloads are reproducible
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
+	if dryRun {
+		if c.dryRunSink != nil {
+			c.dryRunSink(method, redactSecrets(b))
+		}
+		return nil, ErrDryRun
+	}
+
+	return c.send(method, params, b, id, findOpts, relogin)
+}
+
+// Send the request payload b with id to FreeIPA and parse the response. If
+// relogin is set and the session is rejected the client logs in again and
+// only the send is retried, so the mutation guard is not consulted twice.
+func (c *Client) send(method string, params []string, b []byte, id int, findOpts FindOptions, relogin bool) (*Response, error) {
+	sessionID, krbClient, release, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	ipaUrl := fmt.Sprintf("https://%s/ipa/json", c.host)
-	if len(c.sessionID) > 0 {
+	if len(sessionID) > 0 {
 		ipaUrl = fmt.Sprintf("https://%s/ipa/session/json", c.host)
 	}
 
-	req, err := http.NewRequest("POST", ipaUrl, bytes.NewBuffer(b))
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ipaUrl, bytes.NewBuffer(b))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Referer", fmt.Sprintf("https://%s/ipa/xml", c.host))
 
-	if len(c.sessionID) > 0 {
+	if len(sessionID) > 0 {
 		// If session is set, use the session id
-		req.Header.Set("Cookie", fmt.Sprintf("ipa_session=%s", c.sessionID))
-	} else if c.krbClient != nil {
+		req.Header.Set("Cookie", fmt.Sprintf("ipa_session=%s", sessionID))
+	} else if krbClient != nil {
 		// use Kerberos auth (SPNEGO)
-		spnego.SetSPNEGOHeader(c.krbClient, req, "")
+		if err := krbClient.SetSPNEGOHeader(req); err != nil {
+			return nil, fmt.Errorf("ipa: %w: %w", ErrKerberosAuth, err)
+		}
 	}
 
-	if log.IsLevelEnabled(log.TraceLevel) {
-		dump, _ := httputil.DumpRequestOut(req, true)
-		log.Tracef("FreeIPA RPC request: %s", dump)
-	}
+	conn := &connTrace{}
+	req = conn.attach(req)
 
+	trace := c.sampleTrace()
+	start := time.Now()
+	defer c.recordCall(method, params, start)
 	res, err := c.httpClient.Do(req)
+	c.recordConn(method, conn)
 	if err != nil {
-		return nil, err
+		trace.trace(method, req, b, nil, nil, start, err)
+		return nil, tlsError(c.host, err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
+		trace.traceResponse(method, req, b, res, start)
+		if res.StatusCode == 401 && len(sessionID) == 0 && krbClient != nil {
+			return nil, fmt.Errorf("ipa: %w: server rejected kerberos authentication (WWW-Authenticate: %q)", ErrUnauthorized, res.Header.Get("WWW-Authenticate"))
+		}
+		if res.StatusCode == 401 && relogin && c.canRelogin(sessionID, krbClient) {
+			res.Body.Close()
+			if err := c.relogin(sessionID, krbClient); err != nil {
+				return nil, err
+			}
+			return c.send(method, params, b, id, findOpts, false)
+		}
 		return nil, fmt.Errorf("IPA RPC called failed with HTTP status code: %d", res.StatusCode)
 	}
 
+	// Invalid session cookies are not fatal here, the response is still valid
 	if err = c.setSessionID(res); err != nil {
-		return nil, err
+		log.Warnf("FreeIPA RPC ignoring session cookie: %s", err)
 	}
 
-	rawJson, err := ioutil.ReadAll(res.Body)
+	var body io.Reader = res.Body
+	limit := c.maxResponseSize(method)
+	if limit > 0 {
+		body = io.LimitReader(res.Body, limit+1)
+	}
+	rawJson, err := io.ReadAll(body)
+	trace.trace(method, req, b, res, rawJson, start, err)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Tracef("FreeIPA JSON response: %s", string(rawJson))
+	if limit > 0 && int64(len(rawJson)) > limit {
+		return nil, &ResponseTooLargeError{Method: method, Limit: limit}
+	}
 
 	var ipaRes Response
 	err = json.Unmarshal(rawJson, &ipaRes)
@@ -252,10 +676,55 @@ func (c *Client) rpc(method string, params []string, options Options) (*Response
 		return nil, err
 	}
 
+	if ipaRes.ID != id {
+		return nil, fmt.Errorf("%w: %s response id %d does not match request id %d", ErrInvalidResponse, method, ipaRes.ID, id)
+	}
+
+	if ipaRes.Error == nil && ipaRes.Result == nil {
+		return nil, fmt.Errorf("%w: %s response has neither result nor error", ErrInvalidResponse, method)
+	}
+
+	if err := c.checkPrincipal(method, ipaRes.Principal); err != nil {
+		return nil, err
+	}
+
+	ipaRes.collectMessages()
+	if len(ipaRes.Messages) > 0 {
+		for _, m := range ipaRes.Messages {
+			log.Debugf("FreeIPA %s message: %s", method, m)
+		}
+		c.notify(&Event{Type: EventMessages, Method: method, Messages: ipaRes.Messages})
+	}
+
 	if ipaRes.Error != nil {
+		// Unknown command
+		if ipaRes.Error.Code == ErrCodeCommandError {
+			c.setCommandSupported(method, false)
+			return nil, &CommandNotSupportedError{Method: method}
+		}
+		if ipaRes.Error.Code == ErrCodeACIError {
+			return nil, newPermissionDeniedError(method, params, ipaRes.Error)
+		}
+		// Searches which exceed a limit fail instead of returning truncated
+		// results if the server is configured to
+		if isFind(method) && IsLimitsExceeded(ipaRes.Error) {
+			return nil, fmt.Errorf("ipa: %w: %w", ErrTruncated, ipaRes.Error)
+		}
 		return nil, ipaRes.Error
 	}
 
+	// Find methods always return a list of entries
+	if isFind(method) && !gjson.ParseBytes(ipaRes.Result.Data).IsArray() {
+		return nil, fmt.Errorf("%w: %s result is not a list", ErrInvalidResponse, method)
+	}
+
+	if ipaRes.Result.Truncated {
+		c.notify(&Event{Type: EventFindTruncated, Method: method})
+		if findOpts.FailOnTruncated {
+			return nil, ErrTruncated
+		}
+	}
+
 	return &ipaRes, nil
 }
 
@@ -280,14 +749,156 @@ func (c *Client) Ping() (*Response, error) {
 	return res, nil
 }
 
+// Release the resources held by the client. Idle connections are closed
+// unless the http client was provided with NewClientCustomHttp and not copied
+// by Configure, the kerberos session is destroyed and the session id is
+// cleared. Methods called after Close return ErrClientClosed. Close is safe to
+// call more than once.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	c.setKrbClient(nil, false)
+	c.krbLogin = nil
+	c.krbValidUntil = time.Time{}
+	c.sessionID = ""
+
+	if c.ownsHTTP {
+		c.httpClient.CloseIdleConnections()
+	}
+
+	return nil
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed
+}
+
+// Returns a new client with the same host, realm and settings sharing the
+// http transport. The new client is not logged in, it has its own session
+// and kerberos credentials. Closing the new client does not close the shared
+// transport's idle connections.
+func (c *Client) Clone() *Client {
+	c.mu.RLock()
+	slowThreshold, slowCall := c.slowThreshold, c.slowCall
+	connEvents, principal := c.connEvents, c.principal
+	retryCodes := c.retryCodes
+	c.mu.RUnlock()
+
+	return &Client{
+		host:          c.host,
+		realm:         c.realm,
+		keyTab:        c.keyTab,
+		sticky:        c.sticky,
+		sessionValid:  c.sessionValid,
+		dryRun:        c.dryRun,
+		readOnly:      c.readOnly,
+		dryRunSink:    c.dryRunSink,
+		guard:         c.guard,
+		protected:     c.protected,
+		maxFindLen:    c.maxFindLen,
+		maxRespLen:    c.maxRespLen,
+		jsonrpc2:      c.jsonrpc2,
+		caseGroups:    c.caseGroups,
+		findOpts:      c.findOpts,
+		observer:      c.observer,
+		httpClient:    c.httpClient,
+		keepPassword:  c.keepPassword,
+		tracer:        c.tracer,
+		slowThreshold: slowThreshold,
+		slowCall:      slowCall,
+		connEvents:    connEvents,
+		principal:     principal,
+		deadline:      c.deadline,
+		reqTimeout:    c.reqTimeout,
+		retryCodes:    retryCodes,
+		sessLogin:     c.sessLogin,
+	}
+}
+
+// Send "jsonrpc": "2.0" in requests. Newer FreeIPA servers accept it, older
+// servers may reject it
+func (c *Client) UseJSONRPC2(enable bool) {
+	c.jsonrpc2 = enable
+}
+
+// Returns the client holding the session of c. Clients returned by
+// WithCallTimeout and similar share the session of the client they were
+// derived from.
+func (c *Client) sessionOwner() *Client {
+	if c.parent != nil {
+		return c.parent
+	}
+
+	return c
+}
+
 // Return current FreeIPA sessionID
 func (c *Client) SessionID() string {
-	return c.sessionID
+	o := c.sessionOwner()
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.sessionID
 }
 
 // Clears out FreeIPA session id
 func (c *Client) ClearSession() {
-	c.sessionID = ""
+	o := c.sessionOwner()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sessionID = ""
+}
+
+// Returns true if the client has a session or kerberos credentials
+func (c *Client) authenticated() bool {
+	return len(c.SessionID()) > 0 || c.KerberosClient() != nil
+}
+
+// Returns the session id and kerberos client to authenticate a request with,
+// renewing kerberos credentials first if they are near expiry. The kerberos
+// client is not destroyed before release is called.
+func (c *Client) credentials() (sessionID string, krbClient KrbClient, release func(), err error) {
+	sessionID = c.SessionID()
+	o := c.sessionOwner()
+
+	// Renew kerberos credentials if the TGT is near expiry. A single
+	// goroutine logs in again while others keep using the current
+	// credentials. Renewal failures are only fatal once the TGT has expired.
+	var login func() (*client.Client, time.Time, error)
+	o.mu.Lock()
+	if len(sessionID) == 0 && o.krbLogin != nil && !o.krbRenewing && !o.krbValidUntil.IsZero() && time.Until(o.krbValidUntil) <= krbRenewWindow {
+		login = o.krbLogin
+		o.krbRenewing = true
+	}
+	o.mu.Unlock()
+
+	if login != nil {
+		err = o.renew(login)
+		c.notify(&Event{Type: EventKerberosRenew, Err: err})
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err != nil {
+		if !time.Now().Before(o.krbValidUntil) {
+			return "", nil, nil, err
+		}
+		log.Warnf("FreeIPA failed to renew kerberos credentials: %s", err)
+	}
+	if o.krbLease == nil {
+		return sessionID, nil, func() {}, nil
+	}
+
+	lease := o.krbLease
+	lease.acquire()
+	return sessionID, lease.cl, lease.release, nil
 }
 
 // Set stick sessions.
@@ -295,6 +906,72 @@ func (c *Client) StickySession(enable bool) {
 	c.sticky = enable
 }
 
+// Set dry-run mode. When enabled, mutating methods are not sent to FreeIPA.
+// Instead the request payload is passed to the dry-run sink and ErrDryRun is
+// returned. Read-only methods are executed normally.
+func (c *Client) DryRun(enable bool) {
+	c.dryRun = enable
+}
+
+// Set read-only mode. When enabled, mutating methods, see IsMutatingMethod,
+// fail with ErrReadOnlyClient before a request is built. Unlike dry-run mode
+// nothing is passed to the dry-run sink.
+func (c *Client) SetReadOnly(enable bool) {
+	c.readOnly = enable
+}
+
+// Returns an error wrapping ErrReadOnlyClient if the client is read-only and
+// method is mutating
+func (c *Client) checkReadOnly(method string) error {
+	if c.readOnly && IsMutatingMethod(method) {
+		return fmt.Errorf("ipa: %w: %s not allowed", ErrReadOnlyClient, method)
+	}
+
+	return nil
+}
+
+// Set the function called with the method name and request payload of each
+// request skipped in dry-run mode. Passwords and other secrets in the payload
+// are redacted as in traces.
+func (c *Client) SetDryRunSink(sink func(method string, payload []byte)) {
+	c.dryRunSink = sink
+}
+
+// Set the maximum size in bytes of a response to find calls and
+// json_metadata. Defaults to DefaultMaxFindResponseSize.
+func (c *Client) SetMaxFindResponseSize(size int64) {
+	c.maxFindLen = size
+}
+
+// Set the maximum size in bytes of a response to show and other read-only
+// calls, for example group_show of groups with many members. Defaults to
+// DefaultMaxResponseSize. Responses to mutating calls are not limited.
+func (c *Client) SetMaxResponseSize(size int64) {
+	c.maxRespLen = size
+}
+
+// Set function used to validate FreeIPA session cookie values. By default
+// only 32 character session ids and MagBearerToken sessions are accepted.
+// Use this to accept nonstandard session formats, for example those issued
+// by an authenticating proxy.
+func (c *Client) SetSessionValidator(validator func(cookie string) bool) {
+	c.sessionValid = validator
+}
+
+func (c *Client) validSession(session string) bool {
+	if c.sessionValid != nil {
+		return c.sessionValid(session)
+	}
+
+	return len(session) == 32 || strings.HasPrefix(session, "MagBearerToken")
+}
+
+// Returns the value of header key of res trimmed and lower cased. Servers
+// differ in the case of values such as X-IPA-Pwchange-Result: OK
+func headerValue(res *http.Response, key string) string {
+	return strings.ToLower(strings.TrimSpace(res.Header.Get(key)))
+}
+
 // Set FreeIPA sessionID from http response cookie
 func (c *Client) setSessionID(res *http.Response) error {
 	if !c.sticky {
@@ -312,8 +989,11 @@ func (c *Client) setSessionID(res *http.Response) error {
 		ipaSession = matches[1]
 	}
 
-	if len(ipaSession) == 32 || strings.HasPrefix(ipaSession, "MagBearerToken") {
-		c.sessionID = ipaSession
+	if c.validSession(ipaSession) {
+		o := c.sessionOwner()
+		o.mu.Lock()
+		o.sessionID = ipaSession
+		o.mu.Unlock()
 	} else {
 		return errors.New("invalid set-cookie header")
 	}
@@ -324,33 +1004,39 @@ func (c *Client) setSessionID(res *http.Response) error {
 // Login to FreeIPA using web API with uid/passwd and set the FreeIPA session
 // id on the client for subsequent requests.
 func (c *Client) RemoteLogin(uid, passwd string) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
 	ipaUrl := fmt.Sprintf("https://%s/ipa/session/login_password", c.host)
 
 	form := url.Values{"user": {uid}, "password": {passwd}}
-	req, err := http.NewRequest("POST", ipaUrl, strings.NewReader(form.Encode()))
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ipaUrl, strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Referer", fmt.Sprintf("https://%s/ipa", c.host))
 
+	trace := c.sampleTrace()
+	start := time.Now()
+	defer c.recordCall("login_password", []string{uid}, start)
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		trace.trace("login_password", req, redactForm(form), nil, nil, start, err)
+		return tlsError(c.host, err)
 	}
 	defer res.Body.Close()
 
-	if log.IsLevelEnabled(log.TraceLevel) {
-		dump, _ := httputil.DumpResponse(res, true)
-		log.Tracef("FreeIPA RemoteLogin response: %s", dump)
-	}
-
-	if res.StatusCode == 401 && res.Header.Get("X-IPA-Rejection-Reason") == "password-expired" {
-		return ErrExpiredPassword
-	}
-
-	if res.StatusCode == 401 && res.Header.Get("X-IPA-Rejection-Reason") == "invalid-password" {
-		return ErrInvalidPassword
-	}
+	trace.traceResponse("login_password", req, redactForm(form), res, start)
 
 	if res.StatusCode == 401 {
+		switch headerValue(res, "X-IPA-Rejection-Reason") {
+		case "password-expired":
+			return ErrExpiredPassword
+		case "invalid-password":
+			return ErrInvalidPassword
+		}
 		return ErrUnauthorized
 	}
 
@@ -365,84 +1051,250 @@ func (c *Client) RemoteLogin(uid, passwd string) error {
 	return nil
 }
 
-// Login to FreeIPA using local kerberos login username and password
+// Login to FreeIPA using local kerberos login username and password. The
+// password is only kept in memory for renewing the TGT if KeepPassword is
+// enabled.
 func (c *Client) Login(username, password string) error {
-	cfg, err := config.Load(DefaultKerbConf)
-	if err != nil {
-		return err
-	}
+	login := func() (*client.Client, time.Time, error) {
+		cfg, err := config.Load(DefaultKerbConf)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
 
-	cl := client.NewWithPassword(username, c.realm, password, cfg)
+		cl := client.NewWithPassword(username, c.realm, password, cfg)
 
-	err = cl.Login()
-	if err != nil {
-		return err
+		err = cl.Login()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return cl, time.Now().Add(cfg.LibDefaults.TicketLifetime), nil
 	}
 
-	c.krbClient = cl
+	if !c.keepPassword {
+		return c.kerberosLogin(login, nil)
+	}
 
-	return nil
+	return c.kerberosLogin(login, login)
 }
 
-// Login to FreeIPA using local kerberos login with keytab and username
+// Login to FreeIPA using local kerberos login with keytab and username. The
+// keytab is re-read from ktab when renewing the TGT.
 func (c *Client) LoginWithKeytab(ktab, username string) error {
-	cfg, err := config.Load(DefaultKerbConf)
-	if err != nil {
-		return err
+	login := func() (*client.Client, time.Time, error) {
+		cfg, err := config.Load(DefaultKerbConf)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		kt, err := keytab.Load(ktab)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		cl := client.NewWithKeytab(username, c.realm, kt, cfg)
+
+		err = cl.Login()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return cl, time.Now().Add(cfg.LibDefaults.TicketLifetime), nil
 	}
 
-	kt, err := keytab.Load(ktab)
-	if err != nil {
-		return err
+	return c.kerberosLogin(login, login)
+}
+
+// Login to FreeIPA using credentials cache. The credentials cache is re-read
+// from cpath when renewing the TGT, so it should be kept fresh externally,
+// for example by k5start or sssd.
+func (c *Client) LoginFromCCache(cpath string) error {
+	login := func() (*client.Client, time.Time, error) {
+		cfg, err := config.Load(DefaultKerbConf)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		ccache, err := credentials.LoadCCache(cpath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		cl, err := client.NewFromCCache(ccache, cfg, client.AssumePreAuthentication(true))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		err = cl.Login()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		return cl, ccacheValidUntil(ccache), nil
 	}
 
-	cl := client.NewWithKeytab(username, c.realm, kt, cfg)
+	return c.kerberosLogin(login, login)
+}
 
-	err = cl.Login()
+// Returns the end time of the TGT in the credentials cache
+func ccacheValidUntil(ccache *credentials.CCache) time.Time {
+	tgt := "krbtgt/" + ccache.GetClientRealm()
+	for _, cred := range ccache.GetEntries() {
+		if cred.Server.PrincipalName.PrincipalNameString() == tgt {
+			return cred.EndTime
+		}
+	}
+
+	return time.Time{}
+}
+
+// Login with kerberos and store the resulting client on the session owner,
+// see sessionOwner. renew is used to login again when the TGT is near expiry
+// and may be nil if renewal is not possible.
+func (c *Client) kerberosLogin(login, renew func() (*client.Client, time.Time, error)) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	cl, validUntil, err := login()
 	if err != nil {
 		return err
 	}
 
-	c.krbClient = cl
+	o := c.sessionOwner()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		cl.Destroy()
+		return ErrClientClosed
+	}
+
+	o.setKrbClient(Gokrb5Client{cl}, true)
+	o.krbValidUntil = validUntil
+	o.krbLogin = renew
 
 	return nil
 }
 
-// Login to FreeIPA using credentials cache
-func (c *Client) LoginFromCCache(cpath string) error {
-	cfg, err := config.Load(DefaultKerbConf)
-	if err != nil {
-		return err
-	}
+// Keep the password in memory after Login so the TGT can be renewed by
+// logging in again. Must be set before calling Login.
+func (c *Client) KeepPassword(enable bool) {
+	c.keepPassword = enable
+}
 
-	ccache, err := credentials.LoadCCache(cpath)
-	if err != nil {
-		return err
+// Returns the time the kerberos TGT expires. For password and keytab logins
+// this is estimated from the ticket lifetime in krb5.conf. Returns the zero
+// time if not logged in with kerberos or the expiry is unknown.
+func (c *Client) KerberosValidUntil() time.Time {
+	o := c.sessionOwner()
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.krbValidUntil
+}
+
+// Renew kerberos credentials by logging in again using the original
+// credential source. Returns nil without logging in if a renewal is already
+// running.
+func (c *Client) Renew() error {
+	o := c.sessionOwner()
+	o.mu.Lock()
+	login := o.krbLogin
+	if login == nil {
+		o.mu.Unlock()
+		return ErrNoKerberosCredentials
+	}
+	if o.krbRenewing {
+		o.mu.Unlock()
+		return nil
 	}
+	o.krbRenewing = true
+	o.mu.Unlock()
+
+	err := o.renew(login)
+	c.notify(&Event{Type: EventKerberosRenew, Err: err})
+	return err
+}
 
-	cl, err := client.NewFromCCache(ccache, cfg, client.AssumePreAuthentication(true))
+// Renew kerberos credentials with login. The caller sets c.krbRenewing, which
+// is cleared once the login completes. The login runs without holding c.mu
+// so requests continue with the current credentials meanwhile, which are
+// destroyed once the last of them completes.
+func (c *Client) renew(login func() (*client.Client, time.Time, error)) error {
+	cl, validUntil, err := login()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.krbRenewing = false
 	if err != nil {
 		return err
 	}
 
-	err = cl.Login()
-	if err != nil {
-		return err
+	// The client was closed or its credentials replaced during the login
+	if c.closed || c.krbLogin == nil {
+		cl.Destroy()
+		if c.closed {
+			return ErrClientClosed
+		}
+		return nil
 	}
 
-	c.krbClient = cl
+	c.setKrbClient(Gokrb5Client{cl}, true)
+	c.krbValidUntil = validUntil
 
 	return nil
 }
 
 // Parse a FreeIPA datetime. Datetimes in FreeIPA are returned using a
 // class-hint system. Values are stored as an array with a single element
-// indicating the type and value, for example, '[{"__datetime__": "YYYY-MM-DDTHH:MM:SSZ"]}'
+// indicating the type and value, for example, '[{"__datetime__": "YYYY-MM-DDTHH:MM:SSZ"]}'.
+// LDAP generalized times with a zone offset and RFC 3339 times are also
+// accepted. The time is returned in UTC, or the zero time if str is invalid.
 func ParseDateTime(str string) time.Time {
-	dt, err := time.Parse(IpaDatetimeFormat, str)
+	dt, err := parseDatetimeString(str)
 	if err != nil {
 		return time.Time{}
 	}
 
 	return dt
 }
+
+// Returns the members which failed to be added or removed mapped to the
+// reason. paths are the locations of the failures in the result, for example
+// "member.host". Failures are reported as [name, reason] pairs.
+func parseFailedMembers(res *Result, paths ...string) map[string]string {
+	failed := make(map[string]string)
+	for _, path := range paths {
+		gjson.GetBytes(res.Failed, path).ForEach(func(key, value gjson.Result) bool {
+			failed[value.Get("0").String()] = value.Get("1").String()
+			return true
+		})
+	}
+
+	return failed
+}
+
+// Returns an error listing the members which failed to be added or removed by
+// method. See parseFailedMembers
+func failedMembers(method string, res *Result, paths ...string) error {
+	failed := parseFailedMembers(res, paths...)
+	if len(failed) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(failed))
+	for name, reason := range failed {
+		names = append(names, name+": "+reason)
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("ipa: %s failed for %s", method, strings.Join(names, ", "))
+}
+
+// Check the number of values of a multi-valued attribute does not exceed max
+func checkValueCount(res gjson.Result, attr string, max int) error {
+	if n := res.Get(attr + ".#").Int(); n > int64(max) {
+		return fmt.Errorf("ipa: too many values for attribute %s (%d > %d)", attr, n, max)
+	}
+
+	return nil
+}
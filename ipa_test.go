@@ -5,10 +5,19 @@
 package ipa_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/user"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	log "github.com/sirupsen/logrus"
@@ -22,6 +31,7 @@ var (
 	TestEnvAdminPass  = getenv("IPA_ADMIN_PASS", "")
 	TestEnvKeytabFile = getenv("IPA_KEYTAB", "")
 	TestEnvKeytabUser = getenv("IPA_KEYTAB_USER", "")
+	TestEnvHost       = getenv("IPA_TEST_HOST", "client.mokey.local")
 )
 
 func getenv(key, fallback string) string {
@@ -31,6 +41,82 @@ func getenv(key, fallback string) string {
 	return fallback
 }
 
+type requestIDKey struct{}
+
+// Returns a client connected to a local TLS server running handler. The
+// JSON rpc request id is available to handlers with stubRequestID.
+func newTestClientStub(t testing.TB, handler http.HandlerFunc) *ipa.Client {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			ID int `json:"id"`
+		}
+		json.Unmarshal(body, &req)
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, req.ID)))
+	}))
+	t.Cleanup(ts.Close)
+
+	return ipa.NewClientCustomHttp(ts.Listener.Addr().String(), "LOCAL", ts.Client())
+}
+
+// Returns the JSON rpc id of request r
+func stubRequestID(r *http.Request) int {
+	id, _ := r.Context().Value(requestIDKey{}).(int)
+	return id
+}
+
+// A FreeIPA JSON rpc request received by a stub server
+type stubCall struct {
+	Method string
+	Params []json.RawMessage
+
+	// Positional arguments and options, decoded from Params
+	Args    []string
+	Options map[string]interface{}
+}
+
+// Decodes the FreeIPA JSON rpc request r. Params keeps the raw values for
+// handlers which decode them into their own types
+func stubDecode(r *http.Request) stubCall {
+	var req stubCall
+	var body struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	req.Method = body.Method
+	req.Params = body.Params
+	req.Options = map[string]interface{}{}
+	if len(body.Params) > 0 {
+		json.Unmarshal(body.Params[0], &req.Args)
+	}
+	if len(body.Params) > 1 {
+		json.Unmarshal(body.Params[1], &req.Options)
+	}
+	return req
+}
+
+// Returns an http handler which replies to every FreeIPA JSON rpc call with
+// result
+func stubResult(result string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": %s}`, stubRequestID(r), result)
+	}
+}
+
+// Returns an http handler which replies to every FreeIPA JSON rpc call with
+// an error
+func stubError(code int, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": %q, "name": "StubError"}, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": null}`, code, message, stubRequestID(r))
+	}
+}
+
 func newTestClientUserPassword() (*ipa.Client, error) {
 	c := ipa.NewDefaultClient()
 
@@ -131,3 +217,461 @@ func TestPing(t *testing.T) {
 	assert.Containsf(res.Principal, c.Realm(), "Realm not found in principal")
 	assert.NotEmptyf(c.SessionID(), "Missing sessionID")
 }
+
+func TestDryRun(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	requests := 0
+	result := stubResult(`{"result": {"uid": ["jdoe"]}, "value": "jdoe", "summary": null}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		result(w, r)
+	})
+
+	payloads := make(map[string]string)
+	c.DryRun(true)
+	c.SetDryRunSink(func(method string, payload []byte) {
+		payloads[method] = string(payload)
+	})
+
+	_, err := c.UserDisable("jdoe")
+	assert.ErrorIsf(err, ipa.ErrDryRun, "Mutating method should return ErrDryRun")
+
+	_, err = c.UserMod(&ipa.User{Username: "jdoe", First: "John", Last: "Doe"})
+	assert.ErrorIsf(err, ipa.ErrDryRun, "Mutating method should return ErrDryRun")
+
+	err = c.SetPassword("jdoe", "old", "new", "")
+	assert.ErrorIsf(err, ipa.ErrDryRun, "Mutating method should return ErrDryRun")
+
+	assert.Equalf(0, requests, "No http requests should be made for mutating methods")
+	assert.Equalf(`{"id":0,"method":"user_disable","params":[["jdoe"],{"version":"2.237"}]}`, payloads["user_disable"], "Invalid dry-run payload")
+	assert.Containsf(payloads, "user_mod", "Missing dry-run payload")
+	assert.Containsf(payloads, "change_password", "Missing dry-run payload")
+
+	first := payloads["user_mod"]
+	for i := 0; i < 10; i++ {
+		_, err = c.UserMod(&ipa.User{Username: "jdoe", First: "John", Last: "Doe"})
+		require.ErrorIs(err, ipa.ErrDryRun)
+		assert.Equalf(first, payloads["user_mod"], "Dry-run payload should be deterministic")
+	}
+
+	rec, err := c.UserShow("jdoe")
+	require.NoErrorf(err, "Read-only method should bypass dry-run")
+	assert.Equal("jdoe", rec.Username)
+
+	_, err = c.Ping()
+	require.NoErrorf(err, "Read-only method should bypass dry-run")
+	assert.Equalf(2, requests, "Read-only methods should make http requests")
+
+	c.DryRun(false)
+	_, err = c.UserDisable("jdoe")
+	assert.NoErrorf(err, "Mutating method should run with dry-run disabled")
+	assert.Equal(3, requests)
+}
+
+func TestDryRunRedactsSecrets(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"cn": ["global_policy"], "krbpwdminlength": ["8"]}, "value": "global_policy", "summary": null}`))
+	c.DryRun(true)
+
+	var payloads []string
+	c.SetDryRunSink(func(method string, payload []byte) {
+		payloads = append(payloads, method+" "+string(payload))
+	})
+
+	secret := "s3cret-Passw0rd"
+	assert.ErrorIs(c.ChangePassword("jdoe", secret+"-old", secret, "123456"), ipa.ErrDryRun)
+	assert.ErrorIs(c.SetPassword("jdoe", secret+"-old", secret, "123456"), ipa.ErrDryRun)
+	assert.ErrorIs(c.MigratePassword("jdoe", secret), ipa.ErrDryRun)
+	_, err := c.UserMod(&ipa.User{Username: "jdoe", Extra: ipa.ExtraAttrs{
+		Set: map[string][]string{"userPassword": {secret}},
+	}})
+	assert.ErrorIs(err, ipa.ErrDryRun)
+	_, err = c.ResetPasswordLocal("jdoe")
+	assert.ErrorIs(err, ipa.ErrDryRun)
+
+	require.Len(payloads, 5)
+	for _, payload := range payloads {
+		assert.NotContainsf(payload, secret, "Password should not reach the dry-run sink")
+		assert.NotContains(payload, "123456")
+		assert.Contains(payload, "REDACTED")
+	}
+	assert.Containsf(payloads[4], `"userpassword":"[REDACTED]"`, "Password set by ResetPasswordLocal should be redacted")
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	padding := strings.Repeat("x", 2*ipa.DefaultMaxResponseSize)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": {"result": [{"uid": ["jdoe"], "description": ["%s"]}], "count": 1}}`, stubRequestID(r), padding)
+	})
+
+	_, err := c.UserShow("jdoe")
+	require.ErrorIsf(err, ipa.ErrResponseTooLarge, "Oversized show response should fail")
+
+	var sizeErr *ipa.ResponseTooLargeError
+	require.ErrorAs(err, &sizeErr)
+	assert.Equal("user_show", sizeErr.Method)
+	assert.Equal(int64(ipa.DefaultMaxResponseSize), sizeErr.Limit)
+
+	users, err := c.UserFind(nil)
+	require.NoErrorf(err, "Find response within default find limit should succeed")
+	assert.Len(users, 1)
+
+	c.SetMaxFindResponseSize(ipa.DefaultMaxResponseSize)
+	_, err = c.UserFind(nil)
+	require.ErrorIsf(err, ipa.ErrResponseTooLarge, "Oversized find response should fail")
+	require.ErrorAs(err, &sizeErr)
+	assert.Equal("user_find", sizeErr.Method)
+
+	c.SetMaxResponseSize(4 * ipa.DefaultMaxResponseSize)
+	_, err = c.UserShow("jdoe")
+	assert.NotErrorIsf(err, ipa.ErrResponseTooLarge, "Show response within configured limit should be read")
+
+	// The change is applied by the time a mutation response is read, so its
+	// size is not limited
+	c = newTestClientStub(t, stubResult(fmt.Sprintf(`{"result": {"uid": ["jdoe"], "description": ["%s"]}, "summary": null, "value": "jdoe"}`, padding)))
+	user, err := c.UserModOptions("jdoe", ipa.Options{"description": "big"})
+	require.NoErrorf(err, "Oversized mutation response should not fail")
+	assert.Equal("jdoe", user.Username)
+}
+
+func TestSessionCookie(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	session := strings.Repeat("a1b2c3d4", 8)
+	result := stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", fmt.Sprintf("ipa_session=%s; Domain=localhost; Path=/ipa; HttpOnly; Secure", session))
+		result(w, r)
+	})
+	c.StickySession(true)
+
+	_, err := c.Ping()
+	require.NoErrorf(err, "Nonstandard session cookie should not fail rpc")
+	assert.Emptyf(c.SessionID(), "Nonstandard session cookie should not be stored")
+
+	err = c.RemoteLogin("jdoe", "secret")
+	assert.Errorf(err, "Nonstandard session cookie should fail login")
+
+	c.SetSessionValidator(func(cookie string) bool {
+		return len(cookie) == 64
+	})
+
+	_, err = c.Ping()
+	require.NoError(err)
+	assert.Equalf(session, c.SessionID(), "Session cookie accepted by validator should be stored")
+}
+
+func TestKerberosRenew(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	validUntil := c.KerberosValidUntil()
+	assert.Truef(validUntil.After(time.Now()), "TGT should not be expired")
+
+	events := []*ipa.Event{}
+	c.SetObserver(func(event *ipa.Event) {
+		events = append(events, event)
+	})
+
+	err = c.Renew()
+	require.NoError(err)
+	require.Len(events, 1)
+	assert.Equal(ipa.EventKerberosRenew, events[0].Type)
+	assert.NoError(events[0].Err)
+	assert.False(c.KerberosValidUntil().IsZero())
+
+	_, err = c.Ping()
+	assert.NoError(err)
+}
+
+func TestKerberosRenewNoCredentials(t *testing.T) {
+	c := newTestClientStub(t, stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`))
+
+	assert.True(t, c.KerberosValidUntil().IsZero())
+	assert.ErrorIs(t, c.Renew(), ipa.ErrNoKerberosCredentials)
+}
+
+func TestCloseAndClone(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	session := strings.Repeat("a1b2c3d4", 8)
+	result := stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", fmt.Sprintf("ipa_session=%s; Path=/ipa; HttpOnly; Secure", session))
+		result(w, r)
+	})
+	c.SetSessionValidator(func(cookie string) bool {
+		return len(cookie) == 64
+	})
+
+	_, err := c.Ping()
+	require.NoError(err)
+	require.Equal(session, c.SessionID())
+
+	clone := c.Clone()
+	assert.Emptyf(clone.SessionID(), "Clone should not share the session")
+
+	require.NoError(c.Close())
+	assert.Emptyf(c.SessionID(), "Close should clear the session")
+	assert.NoErrorf(c.Close(), "Close should be idempotent")
+
+	_, err = c.Ping()
+	assert.ErrorIs(err, ipa.ErrClientClosed)
+	assert.ErrorIs(c.RemoteLogin("jdoe", "secret"), ipa.ErrClientClosed)
+	assert.ErrorIs(c.SetPassword("jdoe", "old", "new", ""), ipa.ErrClientClosed)
+
+	_, err = clone.Ping()
+	require.NoErrorf(err, "Clone should be usable after the original is closed")
+	assert.Equal(session, clone.SessionID())
+	assert.Emptyf(c.SessionID(), "Clone session should not change the original")
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var ids []int
+	var jsonrpc []interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		ids = append(ids, stubRequestID(r))
+		jsonrpc = append(jsonrpc, req["jsonrpc"])
+		stubResult(`{"result": {"uid": ["jdoe"]}, "summary": null, "value": "jdoe"}`)(w, r)
+	})
+
+	_, err := c.UserShow("jdoe")
+	require.NoError(err)
+	c.UseJSONRPC2(true)
+	_, err = c.UserShow("jdoe")
+	require.NoError(err)
+
+	assert.Equalf([]int{1, 2}, ids, "Request ids should be unique")
+	assert.Equal([]interface{}{nil, "2.0"}, jsonrpc)
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"mismatched id", `{"error": null, "id": 42, "principal": "admin@LOCAL", "version": "4.9.8", "result": {"result": {"uid": ["jdoe"]}}}`},
+		{"missing id", `{"error": null, "principal": "admin@LOCAL", "version": "4.9.8", "result": {"result": {"uid": ["jdoe"]}}}`},
+		{"empty envelope", `{"id": %d}`},
+		{"null result and error", `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": null}`},
+	}
+
+	for _, test := range tests {
+		response := test.response
+		c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if strings.Contains(response, "%d") {
+				fmt.Fprintf(w, response, stubRequestID(r))
+				return
+			}
+			fmt.Fprint(w, response)
+		})
+
+		_, err := c.UserShow("jdoe")
+		assert.ErrorIsf(err, ipa.ErrInvalidResponse, "Response with %s should be rejected", test.name)
+	}
+}
+
+func TestIpaErrorCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		error string
+		code  int
+	}{
+		{"number", `{"code": 4001, "message": "jdoe: user not found", "name": "NotFound"}`, 4001},
+		{"string", `{"code": "4001", "message": "jdoe: user not found", "name": "NotFound"}`, 4001},
+		{"padded string", `{"code": " 4001 ", "message": "jdoe: user not found", "name": "NotFound"}`, 4001},
+		{"missing", `{"message": "jdoe: user not found", "name": "NotFound"}`, 0},
+		{"null", `{"code": null, "message": "jdoe: user not found", "name": "NotFound"}`, 0},
+		{"non-numeric", `{"code": "E4001", "message": "jdoe: user not found", "name": "NotFound"}`, 0},
+		{"extra fields", `{"code": 4001, "message": "jdoe: user not found", "name": "NotFound", "data": {"reason": "x"}, "proxy": "lb1"}`, 4001},
+	}
+
+	for _, test := range tests {
+		errJSON := test.error
+		c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"error": %s, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": null}`, errJSON, stubRequestID(r))
+		})
+
+		_, err := c.UserShow("jdoe")
+		var ipaErr *ipa.IpaError
+		if assert.Truef(t, errors.As(err, &ipaErr), "%s: FreeIPA error should be returned, got %v", test.name, err) {
+			assert.Equalf(t, test.code, ipaErr.Code, "%s: wrong code", test.name)
+			assert.Equalf(t, "jdoe: user not found", ipaErr.Message, "%s: wrong message", test.name)
+			assert.Equalf(t, "NotFound", ipaErr.Name, "%s: wrong name", test.name)
+		}
+		if test.code == 4001 {
+			assert.Truef(t, ipa.IsNotFound(err), "%s: code should be classified", test.name)
+		}
+	}
+
+	var ipaErr ipa.IpaError
+	require.NoError(t, json.Unmarshal([]byte(`{"code": "4001", "message": "m", "data": {"reason": "x"}}`), &ipaErr))
+	assert.JSONEq(t, `{"reason": "x"}`, string(ipaErr.Data))
+	assert.Error(t, json.Unmarshal([]byte(`["not", "an", "error"]`), &ipaErr))
+}
+
+func TestEmptyArguments(t *testing.T) {
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		stubResult(`{"result": {}, "value": ""}`)(w, r)
+	})
+
+	tests := map[string]func(name string) error{
+		"UserShow":            func(name string) error { _, err := c.UserShow(name); return err },
+		"UserMod":             func(name string) error { _, err := c.UserMod(&ipa.User{Username: name}); return err },
+		"UserModOptions":      func(name string) error { _, err := c.UserModOptions(name, ipa.Options{}); return err },
+		"UserDelete":          func(name string) error { return c.UserDelete(false, false, name) },
+		"UserDisable":         func(name string) error { _, err := c.UserDisable(name); return err },
+		"UserEnable":          func(name string) error { _, err := c.UserEnable(name); return err },
+		"UserSetLocked":       func(name string) error { return c.UserSetLocked(name, true) },
+		"UserRename":          func(name string) error { _, err := c.UserRename(name, "jdoe"); return err },
+		"UserAdd":             func(name string) error { _, err := c.UserAdd(&ipa.User{Username: name}, false); return err },
+		"ResetPassword":       func(name string) error { _, err := c.ResetPassword(name); return err },
+		"SetAuthTypes":        func(name string) error { _, err := c.SetAuthTypes(name, []string{ipa.AuthTypeOTP}); return err },
+		"GroupShow":           func(name string) error { _, err := c.GroupShow(name); return err },
+		"GroupAdd":            func(name string) error { _, err := c.GroupAdd(name, ""); return err },
+		"GroupRename":         func(name string) error { _, err := c.GroupRename(name, "staff"); return err },
+		"AddUserToGroup":      func(name string) error { _, err := c.AddUserToGroup(name, "jdoe"); return err },
+		"RemoveUserFromGroup": func(name string) error { _, err := c.RemoveUserFromGroup(name, "jdoe"); return err },
+		"GroupDelete":         func(name string) error { return c.GroupDelete(name, false) },
+		"HostShow":            func(name string) error { _, err := c.HostShow(name); return err },
+		"HostDisable":         func(name string) error { return c.HostDisable(name) },
+		"HostDel":             func(name string) error { return c.HostDel(name, ipa.HostDelOptions{}) },
+		"HostDecommission":    func(name string) error { _, err := c.HostDecommission(name, ipa.DecommissionOptions{}); return err },
+		"HostGroupDelete":     func(name string) error { return c.HostGroupDelete(name, false) },
+		"ServiceShow":         func(name string) error { _, err := c.ServiceShow(name); return err },
+		"RemoveOTPToken":      func(name string) error { return c.RemoveOTPToken(name) },
+		"EnableOTPToken":      func(name string) error { return c.EnableOTPToken(name) },
+		"OTPTokenSetOwner":    func(name string) error { _, err := c.OTPTokenSetOwner(name, "jdoe"); return err },
+		"AutomountMapDel":     func(name string) error { return c.AutomountMapDel(name, "auto.home") },
+	}
+
+	for method, call := range tests {
+		for _, name := range []string{"", "  \t"} {
+			assert.ErrorIsf(t, call(name), ipa.ErrEmptyArgument, "%s(%q) should fail", method, name)
+		}
+	}
+	assert.Equalf(t, 0, calls, "Calls with empty arguments should never reach the server")
+
+	// Find methods accept empty criteria
+	_, err := c.UserFind(nil)
+	assert.False(t, errors.Is(err, ipa.ErrEmptyArgument))
+	assert.Equal(t, 1, calls)
+}
+
+func TestResultShapes(t *testing.T) {
+	fixtures := map[string]string{
+		"boolean": `{"result": true, "value": "jdoe", "summary": "Done"}`,
+		"null":    `{"result": null, "value": "jdoe", "summary": "Done"}`,
+		"list":    `{"result": [{"uid": ["jdoe"], "cn": ["jdoe"]}], "count": 1, "truncated": false}`,
+		"entry":   `{"result": {"uid": ["jdoe"], "cn": ["jdoe"], "fqdn": ["jdoe.example.com"]}, "value": "jdoe", "summary": null}`,
+		"empty":   `{}`,
+	}
+
+	tests := []struct {
+		name  string
+		call  func(c *ipa.Client) error
+		valid []string
+	}{
+		{"UserShow", func(c *ipa.Client) error { _, err := c.UserShow("jdoe"); return err }, []string{"entry"}},
+		{"GroupShow", func(c *ipa.Client) error { _, err := c.GroupShow("jdoe"); return err }, []string{"entry"}},
+		{"HostShow", func(c *ipa.Client) error { _, err := c.HostShow("jdoe.example.com"); return err }, []string{"entry"}},
+		{"UserFind", func(c *ipa.Client) error { _, err := c.UserFind(nil); return err }, []string{"list"}},
+		{"GroupExists", func(c *ipa.Client) error { _, err := c.GroupExists("jdoe"); return err }, []string{"list"}},
+		{"UserDelete", func(c *ipa.Client) error { return c.UserDelete(false, false, "jdoe") }, []string{"boolean", "null", "list", "entry", "empty"}},
+		{"UserDeleteDetailed", func(c *ipa.Client) error {
+			_, err := c.UserDeleteDetailed(ipa.UserDeleteOptions{}, "jdoe")
+			return err
+		}, []string{"boolean", "null", "list", "entry", "empty"}},
+		{"RemoveOTPToken", func(c *ipa.Client) error { return c.RemoveOTPToken("abc") }, []string{"boolean", "null", "list", "entry", "empty"}},
+	}
+
+	for shape, fixture := range fixtures {
+		c := newTestClientStub(t, stubResult(fixture))
+		for _, test := range tests {
+			valid := false
+			for _, v := range test.valid {
+				valid = valid || v == shape
+			}
+
+			var err error
+			assert.NotPanicsf(t, func() { err = test.call(c) }, "%s should not panic on %s result", test.name, shape)
+			if valid {
+				assert.NoErrorf(t, err, "%s should accept %s result", test.name, shape)
+			} else {
+				assert.Errorf(t, err, "%s should reject %s result", test.name, shape)
+			}
+		}
+	}
+
+	c := newTestClientStub(t, stubResult(fixtures["entry"]))
+	_, err := c.UserFind(nil)
+	assert.ErrorIs(t, err, ipa.ErrInvalidResponse)
+}
+
+func TestSlowCallLog(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	delay := 50 * time.Millisecond
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		if req.Method == "user_show" {
+			time.Sleep(delay)
+		}
+		stubResult(`{"result": {"uid": ["jdoe"]}, "value": "jdoe", "summary": null}`)(w, r)
+	})
+
+	assert.Zero(c.LastCallDuration())
+
+	type slowCall struct {
+		method  string
+		params  []string
+		elapsed time.Duration
+	}
+	var calls []slowCall
+	c.SetSlowCallThreshold(20*time.Millisecond, func(method string, params []string, elapsed time.Duration) {
+		// Client methods can be called from the callback without deadlocking
+		c.LastCallDuration()
+		calls = append(calls, slowCall{method, params, elapsed})
+	})
+
+	_, err := c.UserShow("jdoe")
+	require.NoError(err)
+	require.Len(calls, 1)
+	assert.Equal("user_show", calls[0].method)
+	assert.Equal([]string{"jdoe"}, calls[0].params)
+	assert.GreaterOrEqual(calls[0].elapsed, delay)
+	assert.Less(calls[0].elapsed, 5*time.Second)
+	assert.Equal(calls[0].elapsed, c.LastCallDuration())
+
+	// Fast calls are not logged
+	_, err = c.GroupShow("admins")
+	require.NoError(err)
+	assert.Len(calls, 1)
+	assert.Less(c.LastCallDuration(), delay)
+
+	c.SetSlowCallThreshold(0, nil)
+	_, err = c.UserShow("jdoe")
+	require.NoError(err)
+	assert.Len(calls, 1)
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// KrbClient authenticates requests to FreeIPA with SPNEGO. Gokrb5Client
+// adapts a gokrb5 client, implement KrbClient to plug in another kerberos
+// implementation or a fake in tests.
+type KrbClient interface {
+	// Set the SPNEGO Authorization header on req for the HTTP service of
+	// the request host
+	SetSPNEGOHeader(req *http.Request) error
+
+	// Release the credentials held by the client
+	Destroy()
+}
+
+// ErrDelegationNotConfigured is returned by Impersonate when the client can
+// not obtain service tickets on behalf of other users
+var ErrDelegationNotConfigured = errors.New("kerberos constrained delegation not configured")
+
+// Gokrb5Client adapts a gokrb5 client to KrbClient. GSSAPI binds of the ldap
+// package require the kerberos client to be a Gokrb5Client.
+type Gokrb5Client struct {
+	*client.Client
+}
+
+func (g Gokrb5Client) SetSPNEGOHeader(req *http.Request) error {
+	return spnego.SetSPNEGOHeader(g.Client, req, "")
+}
+
+// KrbClientOption configures a kerberos client set with SetKrbClient
+type KrbClientOption func(cfg *krbClientConfig)
+
+type krbClientConfig struct {
+	destroyOnClose bool
+}
+
+// Destroy the kerberos client when the goipa client is closed or another
+// kerberos client is set. By default the caller keeps ownership.
+func WithDestroyOnClose() KrbClientOption {
+	return func(cfg *krbClientConfig) {
+		cfg.destroyOnClose = true
+	}
+}
+
+// Authenticate requests with an existing, logged in gokrb5 client, for
+// example one configured with custom KDCs or FAST armor, instead of the Login
+// methods. The client is used as is: goipa does not renew its TGT and does
+// not destroy it on Close unless WithDestroyOnClose is given. As with the
+// Login methods, a session id takes precedence over kerberos.
+func (c *Client) SetKrbClient(cl *client.Client, opts ...KrbClientOption) error {
+	if cl == nil {
+		return errors.New("ipa: kerberos client is required")
+	}
+
+	return c.UseKrbClient(Gokrb5Client{cl}, opts...)
+}
+
+// Authenticate requests with cl, see SetKrbClient. On clients derived with
+// WithCallTimeout and similar, cl is shared with the client they were derived
+// from.
+func (c *Client) UseKrbClient(cl KrbClient, opts ...KrbClientOption) error {
+	if cl == nil {
+		return errors.New("ipa: kerberos client is required")
+	}
+
+	cfg := &krbClientConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	o := c.sessionOwner()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrClientClosed
+	}
+
+	o.setKrbClient(cl, cfg.destroyOnClose)
+	o.krbLogin = nil
+	o.krbValidUntil = time.Time{}
+
+	return nil
+}
+
+// Returns the kerberos client used to authenticate requests, or nil if
+// there is none. Clients from the Login methods and SetKrbClient are a
+// Gokrb5Client.
+func (c *Client) KerberosClient() KrbClient {
+	o := c.sessionOwner()
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.krbClient
+}
+
+// Returns the kerberos client used to authenticate requests, or nil if there
+// is none, for authenticating to other services on the FreeIPA host such as
+// LDAP. Credentials near expiry are renewed first, as for requests. The
+// kerberos client is not destroyed before release is called, even if it is
+// replaced or the client is closed.
+func (c *Client) LeaseKrbClient() (cl KrbClient, release func(), err error) {
+	if c.isClosed() {
+		return nil, nil, ErrClientClosed
+	}
+
+	_, cl, release, err = c.credentials()
+	return cl, release, err
+}
+
+// Returns a client which runs requests as username using kerberos
+// constrained delegation (S4U2Self and S4U2Proxy) with the client's kerberos
+// credentials, so requests are limited to what username may do. gokrb5 does
+// not implement S4U2Proxy, so this currently always returns
+// ErrDelegationNotConfigured.
+func (c *Client) Impersonate(username string) (*Client, error) {
+	if username == "" {
+		return nil, errors.New("ipa: username is required")
+	}
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+
+	return nil, fmt.Errorf("ipa: %w: S4U2Proxy is not supported by gokrb5", ErrDelegationNotConfigured)
+}
+
+// Replace the kerberos client. The current one is destroyed if it is owned,
+// once no request uses it. Must be called with c.mu locked
+func (c *Client) setKrbClient(cl KrbClient, owned bool) {
+	if c.krbLease != nil {
+		c.krbLease.retire()
+	}
+	c.krbClient = cl
+	c.krbLease = nil
+	if cl != nil {
+		c.krbLease = &krbLease{cl: cl, owned: owned}
+	}
+}
+
+// A kerberos client and the number of requests using it. Replaced clients
+// are destroyed once the last request using them releases them.
+type krbLease struct {
+	mu      sync.Mutex
+	cl      KrbClient
+	owned   bool
+	users   int
+	retired bool
+}
+
+func (l *krbLease) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.users++
+}
+
+func (l *krbLease) release() {
+	l.mu.Lock()
+	l.users--
+	destroy := l.retired && l.owned && l.users == 0
+	l.mu.Unlock()
+
+	if destroy {
+		l.cl.Destroy()
+	}
+}
+
+// Mark the client replaced, destroying it now if it is owned and unused
+func (l *krbLease) retire() {
+	l.mu.Lock()
+	l.retired = true
+	destroy := l.owned && l.users == 0
+	l.mu.Unlock()
+
+	if destroy {
+		l.cl.Destroy()
+	}
+}
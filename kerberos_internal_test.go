@@ -0,0 +1,164 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+)
+
+// Sets a fixed SPNEGO header and records whether it was destroyed
+type leaseKrbClient struct {
+	destroyed bool
+}
+
+func (f *leaseKrbClient) SetSPNEGOHeader(req *http.Request) error {
+	req.Header.Set("Authorization", "Negotiate ZmFrZQ==")
+	return nil
+}
+
+func (f *leaseKrbClient) Destroy() {
+	f.destroyed = true
+}
+
+func TestKerberosRenewConcurrent(t *testing.T) {
+	inFlight := make(chan struct{})
+	finish := make(chan struct{})
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the first request until the credentials were renewed
+		if atomic.AddInt32(&requests, 1) == 1 {
+			inFlight <- struct{}{}
+			<-finish
+		}
+		var req struct {
+			ID int `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "svc@EXAMPLE.COM", "version": "4.9.8", "result": {"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}}`, req.ID)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := NewClientCustomHttp(strings.TrimPrefix(ts.URL, "https://"), "EXAMPLE.COM", ts.Client())
+	old := &leaseKrbClient{}
+	c.UseKrbClient(old, WithDestroyOnClose())
+
+	login := make(chan struct{})
+	logins := 0
+	c.mu.Lock()
+	c.krbValidUntil = time.Now().Add(time.Hour)
+	c.krbLogin = func() (*client.Client, time.Time, error) {
+		logins++
+		<-login
+		return client.NewWithPassword("jdoe", "EXAMPLE.COM", "secret", config.New()), time.Now().Add(time.Hour), nil
+	}
+	c.mu.Unlock()
+
+	// A request using the current credentials is still in flight when they
+	// are renewed
+	held := make(chan error)
+	go func() {
+		_, err := c.Ping()
+		held <- err
+	}()
+	<-inFlight
+
+	c.mu.Lock()
+	c.krbValidUntil = time.Now().Add(time.Minute)
+	c.mu.Unlock()
+
+	renewed := make(chan struct{})
+	go func() {
+		c.Ping()
+		close(renewed)
+	}()
+
+	// Requests during the renewal continue with the current credentials
+	// instead of waiting for the login
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.RLock()
+		renewing := c.krbRenewing
+		c.mu.RUnlock()
+		if renewing || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Ping(); err != nil {
+		t.Errorf("Request during renewal failed: %s", err)
+	}
+
+	close(login)
+	<-renewed
+	if c.KerberosClient() == KrbClient(old) {
+		t.Errorf("Credentials should be renewed")
+	}
+	if logins != 1 {
+		t.Errorf("Expected a single renewal, got %d", logins)
+	}
+
+	if old.destroyed {
+		t.Errorf("Replaced credentials should not be destroyed while a request uses them")
+	}
+
+	close(finish)
+	if err := <-held; err != nil {
+		t.Errorf("Request holding the replaced credentials failed: %s", err)
+	}
+	if !old.destroyed {
+		t.Errorf("Replaced credentials should be destroyed once no request uses them")
+	}
+}
+
+func TestKerberosValidUntilDerived(t *testing.T) {
+	c := NewClient("ipa.example.com", "EXAMPLE.COM")
+	validUntil := time.Now().Add(time.Hour)
+	c.mu.Lock()
+	c.krbValidUntil = validUntil
+	c.mu.Unlock()
+
+	if got := c.WithCallTimeout(time.Minute).KerberosValidUntil(); !got.Equal(validUntil) {
+		t.Errorf("Derived client should report the shared TGT expiry, got %s", got)
+	}
+}
+
+func TestKerberosRenewRunning(t *testing.T) {
+	c := NewClient("ipa.example.com", "EXAMPLE.COM")
+	logins := 0
+	c.mu.Lock()
+	c.krbLogin = func() (*client.Client, time.Time, error) {
+		logins++
+		return client.NewWithPassword("jdoe", "EXAMPLE.COM", "secret", config.New()), time.Now().Add(time.Hour), nil
+	}
+	c.krbRenewing = true
+	c.mu.Unlock()
+
+	if err := c.Renew(); err != nil || logins != 0 {
+		t.Errorf("Renew should not start a second renewal, logins %d: %v", logins, err)
+	}
+
+	c.mu.Lock()
+	c.krbRenewing = false
+	c.mu.Unlock()
+	if err := c.Renew(); err != nil || logins != 1 {
+		t.Errorf("Renew should login again, logins %d: %v", logins, err)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.krbRenewing {
+		t.Errorf("Renew should clear the renewing flag")
+	}
+}
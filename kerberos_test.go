@@ -0,0 +1,156 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Sets a fixed SPNEGO header and records whether it was destroyed
+type fakeKrbClient struct {
+	destroyed bool
+}
+
+func (f *fakeKrbClient) SetSPNEGOHeader(req *http.Request) error {
+	req.Header.Set("Authorization", "Negotiate ZmFrZQ==")
+	return nil
+}
+
+func (f *fakeKrbClient) Destroy() {
+	f.destroyed = true
+}
+
+func TestKerberosAuthErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	requests := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	cl := client.NewWithPassword("jdoe", "EXAMPLE.COM", "secret", config.New())
+	c.SetKrbClient(cl)
+
+	// Destroyed clients have no credentials to build the SPNEGO header from
+	cl.Destroy()
+	_, err := c.Ping()
+	assert.ErrorIsf(err, ipa.ErrKerberosAuth, "Invalid kerberos client state")
+	assert.Falsef(errors.Is(err, ipa.ErrUnauthorized), "Client side kerberos failures should not be reported as unauthorized")
+	assert.Equalf(0, requests, "Request should not be sent without an Authorization header")
+}
+
+func TestKerberosUnauthorized(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var paths []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, fmt.Sprintf("%s %s", r.URL.Path, r.Header.Get("Authorization")))
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	require.NoError(c.UseKrbClient(&fakeKrbClient{}))
+
+	logins := 0
+	c.SetSessionLogin(func(c *ipa.Client) error {
+		logins++
+		return nil
+	})
+
+	// Without a session a 401 is a rejected SPNEGO token: it fails at once
+	// instead of logging in again, which would only send the same token
+	_, err := c.Ping()
+	assert.ErrorIs(err, ipa.ErrUnauthorized)
+	assert.Contains(err.Error(), "server rejected kerberos authentication")
+	assert.Contains(err.Error(), `WWW-Authenticate: "Negotiate"`)
+	assert.Equalf(0, logins, "Kerberos 401s should not take the session login path")
+	assert.Equal([]string{"/ipa/json Negotiate ZmFrZQ=="}, paths)
+}
+
+func TestUseKrbClient(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var auth string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`)(w, r)
+	})
+	fake := &fakeKrbClient{}
+	require.NoError(c.UseKrbClient(fake))
+	assert.Equalf(ipa.KrbClient(fake), c.KerberosClient(), "KerberosClient should return the injected client")
+
+	_, err := c.Ping()
+	require.NoError(err)
+	assert.Equalf("Negotiate ZmFrZQ==", auth, "Expected SPNEGO header from the injected client")
+
+	// The caller owns the client unless told otherwise
+	c.Close()
+	assert.Falsef(fake.destroyed, "Injected client should not be destroyed on Close")
+	assert.Nilf(c.KerberosClient(), "Closed client should not keep the kerberos client")
+	assert.ErrorIs(c.UseKrbClient(fake), ipa.ErrClientClosed)
+
+	c = ipa.NewClient("ipa.example.com", "EXAMPLE.COM")
+	owned := &fakeKrbClient{}
+	c.UseKrbClient(owned, ipa.WithDestroyOnClose())
+	c.UseKrbClient(fake)
+	assert.Truef(owned.destroyed, "Owned client should be destroyed when replaced")
+	assert.Errorf(c.SetKrbClient(nil), "Expected error for nil kerberos client")
+}
+
+func TestUseKrbClientDerived(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var auth string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`)(w, r)
+	})
+
+	fake := &fakeKrbClient{}
+	d := c.WithCallTimeout(time.Minute)
+	require.NoError(d.UseKrbClient(fake))
+	assert.Equalf(ipa.KrbClient(fake), c.KerberosClient(), "Kerberos client set on a derived client should be shared")
+
+	_, err := c.Ping()
+	require.NoError(err)
+	assert.Equal("Negotiate ZmFrZQ==", auth)
+
+	d.Close()
+	assert.Equalf(ipa.KrbClient(fake), c.KerberosClient(), "Closing a derived client should keep the shared kerberos client")
+	assert.ErrorIs(d.UseKrbClient(fake), ipa.ErrClientClosed)
+}
+
+func TestImpersonate(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+	c.UseKrbClient(&fakeKrbClient{})
+
+	d, err := c.Impersonate("jdoe")
+	assert.ErrorIs(err, ipa.ErrDelegationNotConfigured)
+	assert.Nil(d)
+	assert.Error(func() error { _, err := c.Impersonate(""); return err }())
+	assert.Equalf(0, calls, "Impersonate should not reach the server")
+
+	c.Close()
+	_, err = c.Impersonate("jdoe")
+	assert.ErrorIs(err, ipa.ErrClientClosed)
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+// Package ldap provides read-only access to the FreeIPA LDAP directory for
+// attributes not exposed by the JSON API, for example passwordgraceusertime,
+// using the host, CA pool and kerberos credentials of an ipa.Client.
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/ubccr/goipa"
+)
+
+// ErrNoCredentials is returned by Search when the ipa client has neither
+// kerberos credentials nor a bind DN set with SetBind
+var ErrNoCredentials = errors.New("no credentials for ldap bind")
+
+const ldapsPort = "636"
+
+// Page size of searches
+const pageSize = 500
+
+// Client searches the LDAP directory of the FreeIPA server of an ipa.Client
+type Client struct {
+	ipa      *ipa.Client
+	addr     string
+	bindDN   string
+	password string
+	timeout  time.Duration
+	mu       sync.RWMutex
+}
+
+// Returns a client searching the LDAP directory of the FreeIPA host of c. By
+// default searches bind with the kerberos credentials of c using SASL/GSSAPI.
+// The client can be passed to c.SetLDAPSearcher.
+func New(c *ipa.Client) *Client {
+	return &Client{ipa: c, timeout: time.Minute}
+}
+
+// Set the DN and password to bind with instead of kerberos credentials
+func (l *Client) SetBind(dn, password string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bindDN = dn
+	l.password = password
+}
+
+// Set the timeout of each LDAP request. Defaults to one minute.
+func (l *Client) SetTimeout(timeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timeout = timeout
+}
+
+// Search for entries below baseDN matching the RFC 4515 filter and return the
+// attrs of each entry, or all user attributes if attrs is empty. The DN of
+// each entry is returned as attribute "dn". Attribute names are as returned
+// by the server.
+//
+// A new LDAPS connection is made to the FreeIPA host on port 636 for each
+// search, verified with the same TLS config as the JSON API, and bound with
+// SASL/GSSAPI using the kerberos credentials of the ipa client or with the DN
+// set by SetBind. Only bind and search operations are sent, the directory is
+// never modified. Results are requested in pages of 500 entries so searches
+// are not cut short by the server's per request limits. ipa.ErrTruncated is
+// returned if the server size limit is exceeded. Other LDAP failures are
+// returned as a *ldap.Error of github.com/go-ldap/ldap/v3.
+func (l *Client) Search(baseDN, filter string, attrs []string) ([]map[string][]string, error) {
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	if _, err := goldap.CompileFilter(filter); err != nil {
+		return nil, fmt.Errorf("ipa: ldap: invalid filter %q: %w", filter, err)
+	}
+
+	l.mu.RLock()
+	bindDN, password, timeout := l.bindDN, l.password, l.timeout
+	l.mu.RUnlock()
+
+	krbClient, release, err := l.ipa.LeaseKrbClient()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if bindDN == "" && krbClient == nil {
+		return nil, ErrNoCredentials
+	}
+	gokrb5, ok := krbClient.(ipa.Gokrb5Client)
+	if bindDN == "" && !ok {
+		return nil, fmt.Errorf("ipa: %w: GSSAPI bind requires a gokrb5 kerberos client, got %T", ErrNoCredentials, krbClient)
+	}
+
+	conn, err := l.dial(timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if bindDN != "" {
+		err = conn.Bind(bindDN, password)
+	} else {
+		err = conn.GSSAPIBind(&gssapi.Client{Client: gokrb5.Client}, "ldap/"+l.hostname(), "")
+		if err != nil {
+			err = fmt.Errorf("ipa: %w: %w", ipa.ErrKerberosAuth, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req := goldap.NewSearchRequest(baseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false, filter, attrs, nil)
+	res, err := conn.SearchWithPaging(req, pageSize)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, ipa.ErrTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]map[string][]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		entry := map[string][]string{"dn": {e.DN}}
+		for _, attr := range e.Attributes {
+			entry[attr.Name] = attr.Values
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Returns the FreeIPA host name without any port
+func (l *Client) hostname() string {
+	if h, _, err := net.SplitHostPort(l.ipa.Host()); err == nil {
+		return h
+	}
+
+	return l.ipa.Host()
+}
+
+// Connect to the FreeIPA LDAP server
+func (l *Client) dial(timeout time.Duration) (*goldap.Conn, error) {
+	addr := l.addr
+	if addr == "" {
+		addr = net.JoinHostPort(l.hostname(), ldapsPort)
+	}
+
+	cfg := l.ipa.TLSConfig()
+	cfg.ServerName = l.hostname()
+	cfg.NextProtos = nil
+
+	conn, err := goldap.DialURL("ldaps://"+addr, goldap.DialWithTLSConfig(cfg), goldap.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	return conn, nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ldap
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/ubccr/goipa"
+)
+
+// Fake LDAP server handling a single connection. handle is called with each
+// request operation and its controls, nil if there are none, and returns the
+// responses, each optionally followed by its controls. The returned client
+// trusts the server certificate.
+func newLDAPStub(t *testing.T, handle func(op, controls *ber.Packet) [][]byte) *Client {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			msg, err := ber.ReadPacket(conn)
+			if err != nil || len(msg.Children) < 2 {
+				return
+			}
+			op := msg.Children[1]
+			if op.Tag == goldap.ApplicationUnbindRequest {
+				return
+			}
+			var controls *ber.Packet
+			if len(msg.Children) > 2 {
+				controls = msg.Children[2]
+			}
+			for _, res := range handle(op, controls) {
+				env := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				env.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msg.Children[0].Value, ""))
+				for r := bytes.NewReader(res); r.Len() > 0; {
+					p, err := ber.ReadPacket(r)
+					if err != nil {
+						t.Errorf("Invalid LDAP response: %s", err)
+						return
+					}
+					env.AppendChild(p)
+				}
+				conn.Write(env.Bytes())
+			}
+		}
+	}()
+
+	l := New(ipa.NewClientCustomHttp("127.0.0.1", "EXAMPLE.COM", ts.Client()))
+	l.addr = ln.Addr().String()
+	return l
+}
+
+// Returns an LDAP result of operation tag
+func ldapResult(tag ber.Tag, code int, message string) []byte {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, ""))
+	return p.Bytes()
+}
+
+// Returns a search result entry with attrs given as name and values
+func ldapEntry(dn string, attrs ...[]string) []byte {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for _, attr := range attrs {
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr[0], ""))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range attr[1:] {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		a.AppendChild(vals)
+		list.AppendChild(a)
+	}
+	p.AppendChild(list)
+	return p.Bytes()
+}
+
+// Returns a simple bind handler accepting password secret
+func bindOnly(op *ber.Packet) []byte {
+	if op.Tag != goldap.ApplicationBindRequest {
+		return nil
+	}
+	if op.Children[1].Value != "uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com" || op.Children[2].Data.String() != "secret" {
+		return ldapResult(goldap.ApplicationBindResponse, goldap.LDAPResultInvalidCredentials, "invalid credentials")
+	}
+	return ldapResult(goldap.ApplicationBindResponse, goldap.LDAPResultSuccess, "")
+}
+
+func TestSearch(t *testing.T) {
+	var search *ber.Packet
+	l := newLDAPStub(t, func(op, controls *ber.Packet) [][]byte {
+		if op.Tag != goldap.ApplicationSearchRequest {
+			return [][]byte{bindOnly(op)}
+		}
+		search = op
+		entry := ldapEntry("uid=jdoe,cn=users,cn=accounts,dc=example,dc=com",
+			[]string{"passwordGraceUserTime", "2"},
+			[]string{"memberOf", "cn=ipausers,cn=groups,cn=accounts,dc=example,dc=com", "cn=admins,cn=groups,cn=accounts,dc=example,dc=com"})
+		return [][]byte{entry, ldapResult(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess, "")}
+	})
+
+	// Clients search LDAP for ipa.Client.WatchEntries
+	var _ ipa.LDAPSearcher = l
+
+	if _, err := l.Search("dc=example,dc=com", "(uid=jdoe)", nil); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Expected ErrNoCredentials, got: %v", err)
+	}
+
+	l.SetBind("uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com", "secret")
+	entries, err := l.Search("cn=users,cn=accounts,dc=example,dc=com", "uid=jdoe", []string{"passwordgraceusertime", "memberof"})
+	if err != nil {
+		t.Fatalf("Failed to search: %s", err)
+	}
+
+	if search.Children[0].Value != "cn=users,cn=accounts,dc=example,dc=com" {
+		t.Errorf("Invalid search base: %v", search.Children[0].Value)
+	}
+	if filter, _ := goldap.DecompileFilter(search.Children[6]); filter != "(uid=jdoe)" {
+		t.Errorf("Invalid search filter: %s", filter)
+	}
+	if attrs := search.Children[7].Children; len(attrs) != 2 || attrs[0].Value != "passwordgraceusertime" {
+		t.Errorf("Invalid search attributes")
+	}
+
+	expected := []map[string][]string{{
+		"dn":                    {"uid=jdoe,cn=users,cn=accounts,dc=example,dc=com"},
+		"passwordGraceUserTime": {"2"},
+		"memberOf":              {"cn=ipausers,cn=groups,cn=accounts,dc=example,dc=com", "cn=admins,cn=groups,cn=accounts,dc=example,dc=com"},
+	}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Invalid search entries: %v", entries)
+	}
+
+	if _, err := l.Search("dc=example,dc=com", "(uid=jdoe", nil); err == nil {
+		t.Errorf("Expected error for invalid filter")
+	}
+}
+
+func TestSearchPaged(t *testing.T) {
+	var cookies []string
+	l := newLDAPStub(t, func(op, controls *ber.Packet) [][]byte {
+		if op.Tag != goldap.ApplicationSearchRequest {
+			return [][]byte{bindOnly(op)}
+		}
+
+		if controls == nil || len(controls.Children) != 1 {
+			t.Fatalf("Expected a paged results control")
+		}
+		control, err := goldap.DecodeControl(controls.Children[0])
+		paging, ok := control.(*goldap.ControlPaging)
+		if err != nil || !ok || paging.PagingSize != pageSize {
+			t.Fatalf("Invalid paged results control: %v", control)
+		}
+		cookie := string(paging.Cookie)
+		cookies = append(cookies, cookie)
+
+		next := goldap.NewControlPaging(0)
+		next.SetCookie([]byte(map[string]string{"": "page2", "page2": "page3", "page3": ""}[cookie]))
+		resControls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+		resControls.AppendChild(next.Encode())
+		done := ldapResult(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess, "")
+		entry := ldapEntry("uid=user-" + cookie + ",cn=users,cn=accounts,dc=example,dc=com")
+		return [][]byte{entry, append(done, resControls.Bytes()...)}
+	})
+
+	l.SetBind("uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com", "secret")
+	entries, err := l.Search("cn=users,cn=accounts,dc=example,dc=com", "(uid=*)", []string{"uid"})
+	if err != nil {
+		t.Fatalf("Failed to search: %s", err)
+	}
+
+	if !reflect.DeepEqual(cookies, []string{"", "page2", "page3"}) {
+		t.Errorf("Invalid page cookies: %q", cookies)
+	}
+	if len(entries) != 3 || entries[2]["dn"][0] != "uid=user-page3,cn=users,cn=accounts,dc=example,dc=com" {
+		t.Errorf("Entries of every page should be returned: %v", entries)
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	l := newLDAPStub(t, func(op, controls *ber.Packet) [][]byte {
+		return [][]byte{bindOnly(op)}
+	})
+	l.SetBind("uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com", "wrong")
+	_, err := l.Search("dc=example,dc=com", "(uid=jdoe)", nil)
+	if !goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		t.Errorf("Expected invalid credentials error, got: %v", err)
+	}
+
+	l = newLDAPStub(t, func(op, controls *ber.Packet) [][]byte {
+		if op.Tag != goldap.ApplicationSearchRequest {
+			return [][]byte{bindOnly(op)}
+		}
+		return [][]byte{ldapResult(goldap.ApplicationSearchResultDone, goldap.LDAPResultSizeLimitExceeded, "size limit exceeded")}
+	})
+	l.SetBind("uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com", "secret")
+	if _, err := l.Search("dc=example,dc=com", "(uid=*)", nil); !errors.Is(err, ipa.ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got: %v", err)
+	}
+
+	// Kerberos failures are reported before binding
+	l = newLDAPStub(t, func(op, controls *ber.Packet) [][]byte {
+		t.Errorf("Unexpected LDAP request %d", op.Tag)
+		return nil
+	})
+	cl := client.NewWithPassword("jdoe", "EXAMPLE.COM", "secret", config.New())
+	l.ipa.SetKrbClient(cl)
+	cl.Destroy()
+	if _, err := l.Search("dc=example,dc=com", "(uid=jdoe)", nil); !errors.Is(err, ipa.ErrKerberosAuth) {
+		t.Errorf("Expected ErrKerberosAuth, got: %v", err)
+	}
+
+	// Untrusted server certificates are rejected
+	addr := newLDAPStub(t, func(op, controls *ber.Packet) [][]byte { return nil }).addr
+	l = New(ipa.NewClient("127.0.0.1", "EXAMPLE.COM"))
+	l.addr = addr
+	l.SetBind("uid=reader,cn=sysaccounts,cn=etc,dc=example,dc=com", "secret")
+	if _, err := l.Search("dc=example,dc=com", "(uid=jdoe)", nil); err == nil {
+		t.Errorf("Expected error for untrusted certificate")
+	}
+
+	l.ipa.Close()
+	if _, err := l.Search("dc=example,dc=com", "(uid=jdoe)", nil); !errors.Is(err, ipa.ErrClientClosed) {
+		t.Errorf("Expected ErrClientClosed, got: %v", err)
+	}
+}
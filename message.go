@@ -0,0 +1,33 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+)
+
+// IpaMessage is an informational message returned by FreeIPA alongside a
+// result, for example a deprecation warning or a password expiry notice
+type IpaMessage struct {
+	// Message type, one of "debug", "info", "warning" or "error"
+	Type string `json:"type"`
+
+	// Message class name, for example "VersionMissing"
+	Name string `json:"name"`
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (m IpaMessage) String() string {
+	return fmt.Sprintf("%s %d (%s): %s", m.Type, m.Code, m.Name, m.Message)
+}
+
+// Collect messages from the response envelope and the command result
+func (r *Response) collectMessages() {
+	if r.Result != nil {
+		r.Messages = append(r.Messages, r.Result.Messages...)
+	}
+}
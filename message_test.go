@@ -0,0 +1,64 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestMessages(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	expiry := ipa.IpaMessage{Type: "warning", Name: "PasswordExpiring", Code: 13030, Message: "Your password will expire in 3 days"}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8",
+			"messages": [{"type": "warning", "name": "VersionMissing", "code": 13001, "message": "API Version number was not sent", "data": {"server_version": "2.251"}}],
+			"result": {"result": true, "value": "jdoe", "summary": "Changed password for \"jdoe@LOCAL\"",
+				"messages": [{"type": "warning", "name": "PasswordExpiring", "code": 13030, "message": "Your password will expire in 3 days"}]}}`, stubRequestID(r))
+	})
+
+	var events []*ipa.Event
+	c.SetObserver(func(event *ipa.Event) {
+		events = append(events, event)
+	})
+
+	messages, err := c.ChangePasswordDetailed("jdoe", "old", "new", "")
+	require.NoError(err)
+	require.Len(messages, 2)
+	assert.Equal("VersionMissing", messages[0].Name)
+	assert.Equal(13001, messages[0].Code)
+	assert.Equal(expiry, messages[1])
+	assert.Equal("warning 13030 (PasswordExpiring): Your password will expire in 3 days", messages[1].String())
+
+	require.Len(events, 1)
+	assert.Equal(ipa.EventMessages, events[0].Type)
+	assert.Equal("passwd", events[0].Method)
+	assert.Equal(messages, events[0].Messages)
+
+	require.NoError(c.ChangePassword("jdoe", "old", "new", ""))
+	assert.Len(events, 2)
+}
+
+func TestMessagesNone(t *testing.T) {
+	c := newTestClientStub(t, stubResult(`{"result": true, "value": "jdoe", "summary": "Changed password"}`))
+
+	var events []*ipa.Event
+	c.SetObserver(func(event *ipa.Event) {
+		events = append(events, event)
+	})
+
+	messages, err := c.ChangePasswordDetailed("jdoe", "old", "new", "")
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+	assert.Emptyf(t, events, "Responses without messages should not notify")
+}
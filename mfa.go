@@ -0,0 +1,145 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+// MFAStatus is the second factor configuration of a user as reported by
+// MFAStatusReport
+type MFAStatus struct {
+	Username  string
+	AuthTypes []string
+
+	// Number of enabled OTP tokens owned by the user
+	OTPTokens int
+
+	// Number of passkeys registered to the user
+	Passkeys int
+
+	// RADIUS proxy server and external identity provider the user's second
+	// factor is delegated to, empty if none
+	RadiusConfig string
+	IdPConfig    string
+
+	// Set by the MFAPolicy of the report
+	Covered bool
+}
+
+// MFAPolicy returns true if the second factor configuration of a user is
+// sufficient
+type MFAPolicy func(s *MFAStatus) bool
+
+// DefaultMFAPolicy considers users covered if they have an enabled OTP token,
+// a passkey, or a RADIUS proxy or identity provider link
+func DefaultMFAPolicy(s *MFAStatus) bool {
+	return s.OTPTokens > 0 || s.Passkeys > 0 || s.RadiusConfig != "" || s.IdPConfig != ""
+}
+
+// Returns the second factor configuration of the users matching options, the
+// user_find options, for example {"in_group": "staff"}, sorted by username.
+// Coverage is decided by DefaultMFAPolicy. See MFAStatusEach.
+func (c *Client) MFAStatusReport(options Options) ([]*MFAStatus, error) {
+	report := make([]*MFAStatus, 0)
+	err := c.MFAStatusEach(options, DefaultMFAPolicy, func(s *MFAStatus) error {
+		report = append(report, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Username < report[j].Username
+	})
+
+	return report, nil
+}
+
+// Call fn with the second factor configuration of each user matching
+// options, the user_find options, with Covered set by policy. A nil policy
+// uses DefaultMFAPolicy. Enabled OTP tokens are counted with a single
+// otptoken_find and users are found with a single user_find, whose records
+// are converted and passed to fn one at a time rather than collected first.
+// FreeIPA has no paged searches or attribute selection, so the default find
+// size limit is not applied and ErrTruncated is returned if the server
+// truncates either search. Iteration stops at the first error returned by fn.
+func (c *Client) MFAStatusEach(options Options, policy MFAPolicy, fn func(s *MFAStatus) error) error {
+	if policy == nil {
+		policy = DefaultMFAPolicy
+	}
+
+	tokens, err := c.enabledTokenCounts()
+	if err != nil {
+		return err
+	}
+
+	findOptions := Options{"sizelimit": 0}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+	findOptions["all"] = true
+	findOptions["no_members"] = true
+
+	res, err := c.rpc("user_find", []string{""}, findOptions)
+	if err != nil {
+		return err
+	}
+	if res.Result.Truncated {
+		return ErrTruncated
+	}
+
+	gjson.ParseBytes(res.Result.Data).ForEach(func(_, entry gjson.Result) bool {
+		u := new(User)
+		if err = u.fromJSON([]byte(entry.Raw)); err != nil {
+			return false
+		}
+		u.Loaded = userLoaded(findOptions)
+
+		s := &MFAStatus{
+			Username:     u.Username,
+			AuthTypes:    u.AuthTypes,
+			OTPTokens:    tokens[u.Username],
+			Passkeys:     len(u.Passkeys),
+			RadiusConfig: u.RadiusConfig,
+			IdPConfig:    u.IdPConfig,
+		}
+		s.Covered = policy(s)
+
+		err = fn(s)
+		return err == nil
+	})
+
+	return err
+}
+
+// Returns the number of enabled OTP tokens of each owner
+func (c *Client) enabledTokenCounts() (map[string]int, error) {
+	search := NewOTPTokenSearch().Disabled(false).SizeLimit(0)
+	res, err := c.rpc("otptoken_find", []string{}, search.toOptions())
+	if err != nil {
+		return nil, err
+	}
+	if res.Result.Truncated {
+		return nil, ErrTruncated
+	}
+
+	tokens, err := parseOTPTokenList(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, tok := range tokens {
+		if search.matches(tok) && tok.Owner != "" {
+			counts[tok.Owner]++
+		}
+	}
+
+	return counts, nil
+}
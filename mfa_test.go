@@ -0,0 +1,101 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newMFAStub(t *testing.T, truncated bool) (*ipa.Client, *[]map[string]interface{}) {
+	var userOptions []map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+
+		switch req.Method {
+		case "otptoken_find":
+			stubResult(`{"count": 4, "result": [
+				{"ipatokenuniqueid": ["t1"], "ipatokenowner": ["jdoe"]},
+				{"ipatokenuniqueid": ["t2"], "ipatokenowner": ["uid=jdoe,cn=users,cn=accounts,dc=local"]},
+				{"ipatokenuniqueid": ["t3"], "ipatokenowner": ["jsmith"], "ipatokendisabled": [true]},
+				{"ipatokenuniqueid": ["t4"]}
+			], "summary": null, "truncated": false}`)(w, r)
+		case "user_find":
+			opts := req.Options
+			userOptions = append(userOptions, opts)
+			if truncated {
+				stubResult(`{"count": 1, "result": [{"uid": ["jdoe"]}], "summary": null, "truncated": true}`)(w, r)
+				return
+			}
+			stubResult(`{"count": 5, "result": [
+				{"uid": ["jsmith"], "ipauserauthtype": ["otp"]},
+				{"uid": ["jdoe"], "ipauserauthtype": ["otp", "password"]},
+				{"uid": ["rad"], "ipauserauthtype": ["radius"], "ipatokenradiusconfiglink": ["cn=duo,cn=radiusproxy,dc=local"], "ipatokenradiususername": ["rad@duo"]},
+				{"uid": ["idp"], "ipauserauthtype": ["idp"], "ipaidpconfiglink": ["keycloak"], "ipaidpsub": ["idp@example.com"]},
+				{"uid": ["pk"], "ipauserauthtype": ["passkey"], "ipapasskey": ["passkey:a", "passkey:b"]}
+			], "summary": null, "truncated": false}`)(w, r)
+		}
+	})
+
+	return c, &userOptions
+}
+
+func TestMFAStatusReport(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, userOptions := newMFAStub(t, false)
+
+	report, err := c.MFAStatusReport(ipa.Options{"in_group": "staff"})
+	require.NoError(err)
+	require.Len(report, 5)
+
+	assert.Equal(ipa.MFAStatus{Username: "idp", AuthTypes: []string{"idp"}, IdPConfig: "keycloak", Covered: true}, *report[0])
+	assert.Equal(ipa.MFAStatus{Username: "jdoe", AuthTypes: []string{"otp", "password"}, OTPTokens: 2, Covered: true}, *report[1])
+	assert.Equalf(ipa.MFAStatus{Username: "jsmith", AuthTypes: []string{"otp"}}, *report[2], "Disabled tokens should not count")
+	assert.Equal(ipa.MFAStatus{Username: "pk", AuthTypes: []string{"passkey"}, Passkeys: 2, Covered: true}, *report[3])
+	assert.Equal(ipa.MFAStatus{Username: "rad", AuthTypes: []string{"radius"}, RadiusConfig: "duo", Covered: true}, *report[4])
+
+	require.Len(*userOptions, 1)
+	opts := (*userOptions)[0]
+	assert.Equal("staff", opts["in_group"])
+	assert.Equal(true, opts["all"])
+	assert.Equal(float64(0), opts["sizelimit"])
+}
+
+func TestMFAStatusEach(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, _ := newMFAStub(t, false)
+
+	// Only native second factors count
+	native := func(s *ipa.MFAStatus) bool { return s.OTPTokens > 0 || s.Passkeys > 0 }
+	covered := map[string]bool{}
+	err := c.MFAStatusEach(nil, native, func(s *ipa.MFAStatus) error {
+		covered[s.Username] = s.Covered
+		return nil
+	})
+	require.NoError(err)
+	assert.Equal(map[string]bool{"jsmith": false, "jdoe": true, "rad": false, "idp": false, "pk": true}, covered)
+
+	stop := errors.New("stop")
+	seen := 0
+	err = c.MFAStatusEach(nil, nil, func(s *ipa.MFAStatus) error {
+		seen++
+		return stop
+	})
+	assert.Equal(stop, err)
+	assert.Equal(1, seen)
+
+	c, _ = newMFAStub(t, true)
+	_, err = c.MFAStatusReport(nil)
+	assert.True(errors.Is(err, ipa.ErrTruncated))
+}
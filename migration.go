@@ -0,0 +1,139 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrMigrationDisabled is returned when migration mode is disabled on the
+// FreeIPA server
+var ErrMigrationDisabled = errors.New("migration mode disabled")
+
+// Returns true if the user was migrated with a pre-hashed password and has no
+// kerberos keys. The user must migrate their password with MigratePassword
+// before they can login with kerberos.
+func (c *Client) NeedsPasswordMigration(user *User) bool {
+	return !user.HasKeytab && user.HasPassword
+}
+
+// Returns true if migration mode is enabled on the FreeIPA server
+func (c *Client) MigrationEnabled() (bool, error) {
+	res, err := c.rpc("config_show", []string{}, Options{"all": true})
+	if err != nil {
+		return false, err
+	}
+
+	return parseBool(gjson.GetBytes(res.Result.Data, "ipamigrationenabled")), nil
+}
+
+// Migrate user password. This posts the username and password to the
+// FreeIPA migration page which generates the kerberos keys for users
+// migrated with pre-hashed passwords. If the client is authenticated,
+// migration mode is checked first and ErrMigrationDisabled is returned if
+// it's disabled.
+func (c *Client) MigratePassword(username, password string) error {
+	if c.authenticated() {
+		enabled, err := c.MigrationEnabled()
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return ErrMigrationDisabled
+		}
+	}
+
+	ipaUrl := fmt.Sprintf("https://%s/ipa/migration/migration.py", c.host)
+
+	form := url.Values{
+		"username": {username},
+		"password": {password},
+	}
+
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if err := c.checkReadOnly("migration"); err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		if c.dryRunSink != nil {
+			c.dryRunSink("migration", redactForm(form))
+		}
+		return ErrDryRun
+	}
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ipaUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", fmt.Sprintf("https://%s/ipa/migration/", c.host))
+
+	// The migration page reports the result with a redirect so don't follow it
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	trace := c.sampleTrace()
+	start := time.Now()
+	defer c.recordCall("migration", []string{username}, start)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		trace.trace("migration", req, redactForm(form), nil, nil, start, err)
+		return tlsError(c.host, err)
+	}
+	defer res.Body.Close()
+
+	trace.traceResponse("migration", req, redactForm(form), res, start)
+
+	if res.StatusCode < 300 || res.StatusCode > 399 {
+		return fmt.Errorf("ipa: password migration failed with HTTP status code: %d", res.StatusCode)
+	}
+
+	return migrationResult(res.Header.Get("Location"))
+}
+
+// Returns the result of a password migration from the page the migration
+// form redirected to. Older servers redirect to OK.html on success, FreeIPA
+// 4.x redirects to the web UI. Any other page is an error.
+func migrationResult(location string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("ipa: password migration returned invalid redirect %q: %w", location, err)
+	}
+
+	page := u.Path
+	if i := strings.LastIndex(page, "/"); i >= 0 {
+		page = page[i+1:]
+	}
+
+	switch {
+	case page == "OK.html":
+		return nil
+	case strings.TrimSuffix(u.Path, "/") == "/ipa/ui":
+		return nil
+	case page == "invalid.html":
+		return ErrInvalidPassword
+	case page == "unauthorized.html":
+		return fmt.Errorf("ipa: %w: password migration refused", ErrUnauthorized)
+	case page == "error.html":
+		return errors.New("ipa: password migration failed. See server logs for details")
+	}
+
+	return fmt.Errorf("ipa: password migration failed, unexpected redirect to %q", location)
+}
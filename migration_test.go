@@ -0,0 +1,87 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestNeedsPasswordMigration(t *testing.T) {
+	assert := assert.New(t)
+
+	c := ipa.NewClient("localhost", "LOCAL")
+	assert.True(c.NeedsPasswordMigration(&ipa.User{HasPassword: true}))
+	assert.False(c.NeedsPasswordMigration(&ipa.User{HasPassword: true, HasKeytab: true}))
+	assert.False(c.NeedsPasswordMigration(&ipa.User{}))
+}
+
+func TestMigratePassword(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ipa/json" {
+			stubResult(`{"result": {"ipamigrationenabled": [false]}, "value": null, "summary": null}`)(w, r)
+			return
+		}
+
+		require.Equal("/ipa/migration/migration.py", r.URL.Path)
+		require.NoError(r.ParseForm())
+
+		// migration.py replies with a 302 and a Location header, either a
+		// page relative to /ipa/migration/ or, on success with FreeIPA 4.x,
+		// the absolute web UI url
+		location := ""
+		switch r.PostForm.Get("password") {
+		case "correct":
+			location = "https://" + r.Host + "/ipa/ui"
+		case "legacy":
+			location = "OK.html"
+		case "broken":
+			location = "error.html"
+		case "refused":
+			location = "unauthorized.html"
+		case "index":
+			location = "index.html"
+		case "ok":
+			w.WriteHeader(http.StatusOK)
+			return
+		default:
+			location = "invalid.html"
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusFound)
+	})
+
+	err := c.MigratePassword("jdoe", "correct")
+	assert.NoError(err)
+
+	err = c.MigratePassword("jdoe", "legacy")
+	assert.NoError(err)
+
+	err = c.MigratePassword("jdoe", "wrong")
+	assert.ErrorIs(err, ipa.ErrInvalidPassword)
+
+	err = c.MigratePassword("jdoe", "broken")
+	assert.Error(err)
+
+	err = c.MigratePassword("jdoe", "refused")
+	assert.ErrorIs(err, ipa.ErrUnauthorized)
+
+	err = c.MigratePassword("jdoe", "index")
+	assert.Errorf(err, "Redirects to other pages should not be treated as success")
+
+	err = c.MigratePassword("jdoe", "ok")
+	assert.Errorf(err, "Responses without a redirect should not be treated as success")
+
+	enabled, err := c.MigrationEnabled()
+	require.NoError(err)
+	assert.False(enabled)
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// FreeIPA entities whose primary keys are normalized before being sent. The
+// value is true if FreeIPA lowercases the primary key. Group names are
+// lowercased unless the client is set to case sensitive groups, see
+// SetCaseSensitiveGroups.
+var nameEntities = map[string]bool{
+	"user":         true,
+	"stageuser":    true,
+	"group":        true,
+	"host":         true,
+	"hostgroup":    true,
+	"hbacsvc":      true,
+	"hbacsvcgroup": true,
+	"service":      false,
+	"hbacrule":     false,
+	"sudorule":     false,
+	"otptoken":     false,
+}
+
+// Normalize name, the primary key of a FreeIPA entity such as "user" or
+// "group", the way it is sent by the client wrappers: surrounding whitespace
+// is trimmed and the name is lowercased if FreeIPA lowercases the entity's
+// primary key. Returns an error wrapping ErrInvalidName if name contains
+// control or invisible formatting characters, such as zero-width spaces,
+// which commonly come from pasted input, or ErrEmptyArgument if name is
+// blank. Names of unknown entities are only trimmed and checked.
+func NormalizeName(entity, name string) (string, error) {
+	return normalizeName(entity, name, nameEntities[entity])
+}
+
+func normalizeName(entity, name string, lower bool) (string, error) {
+	trimmed := strings.TrimSpace(name)
+	if trimmed == "" {
+		return "", fmt.Errorf("ipa: %w: %s name", ErrEmptyArgument, entity)
+	}
+
+	for _, r := range trimmed {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return "", fmt.Errorf("ipa: %w: %s %q contains invisible character %U", ErrInvalidName, entity, name, r)
+		}
+	}
+
+	if lower {
+		trimmed = strings.ToLower(trimmed)
+	}
+
+	return trimmed, nil
+}
+
+// Set whether group names keep their case. By default group names are
+// lowercased like FreeIPA does, servers configured to preserve the case of
+// group names should enable this.
+func (c *Client) SetCaseSensitiveGroups(enabled bool) {
+	c.caseGroups = enabled
+}
+
+// Normalize name with the client's settings. See NormalizeName
+func (c *Client) normalizeName(entity, name string) (string, error) {
+	lower := nameEntities[entity]
+	if entity == "group" && c.caseGroups {
+		lower = false
+	}
+
+	return normalizeName(entity, name, lower)
+}
+
+// Normalize the primary key of method, the first param, if method belongs to
+// one of nameEntities. Returns a copy of params.
+func (c *Client) normalizeParams(method string, params []string) ([]string, error) {
+	entity, _, _ := strings.Cut(method, "_")
+	if _, ok := nameEntities[entity]; !ok || len(params) == 0 {
+		return params, nil
+	}
+
+	name, err := c.normalizeName(entity, params[0])
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := append([]string{name}, params[1:]...)
+	return normalized, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestNormalizeName(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		entity string
+		name   string
+		want   string
+	}{
+		{"user", " Jsmith ", "jsmith"},
+		{"group", "\tStaff\n", "staff"},
+		{"host", "Web.Example.COM ", "web.example.com"},
+		{"hostgroup", " Webservers", "webservers"},
+		{"hbacsvc", "SSHD", "sshd"},
+		{"service", " HTTP/web.local@LOCAL ", "HTTP/web.local@LOCAL"},
+		{"sudorule", " Admins NOPASSWD ", "Admins NOPASSWD"},
+		{"widget", " Mixed Case ", "Mixed Case"},
+	}
+
+	for _, test := range tests {
+		name, err := ipa.NormalizeName(test.entity, test.name)
+		if assert.NoErrorf(err, "%s %q", test.entity, test.name) {
+			assert.Equal(test.want, name)
+		}
+	}
+
+	for _, name := range []string{" Jsmith\u200b ", "j\u00adsmith", "\ufeffjsmith", "js\x00mith", "js\u202emith"} {
+		_, err := ipa.NormalizeName("user", name)
+		assert.Truef(errors.Is(err, ipa.ErrInvalidName), "%q should be invalid", name)
+	}
+
+	_, err := ipa.NormalizeName("user", " \t")
+	assert.True(errors.Is(err, ipa.ErrEmptyArgument))
+}
+
+func TestNormalizeWrappers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var sent []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		sent = append(sent, args[0])
+
+		stubResult(`{"result": {"cn": ["x"], "uid": ["x"], "fqdn": ["x"]}, "summary": null, "value": "x"}`)(w, r)
+	})
+
+	tests := []struct {
+		wrapper string
+		call    func(name string) error
+		want    string
+	}{
+		{"UserShow", func(name string) error { _, err := c.UserShow(name); return err }, "jsmith"},
+		{"UserDisable", func(name string) error { _, err := c.UserDisable(name); return err }, "jsmith"},
+		{"UserDelete", func(name string) error { return c.UserDelete(false, false, name) }, "jsmith"},
+		{"GroupShow", func(name string) error { _, err := c.GroupShow(name); return err }, "jsmith"},
+		{"GroupDelete", func(name string) error { return c.GroupDelete(name, false) }, "jsmith"},
+		{"HostShow", func(name string) error {
+			_, err := c.HostShow(strings.Replace(name, "Jsmith", "Web.Local", 1))
+			return err
+		}, "web.local"},
+		{"HostDisable", func(name string) error { return c.HostDisable(strings.Replace(name, "Jsmith", "Web.Local", 1)) }, "web.local"},
+		{"HostGroupDelete", func(name string) error { return c.HostGroupDelete(name, false) }, "jsmith"},
+		{"HbacSvcDel", func(name string) error { return c.HbacSvcDel(name) }, "jsmith"},
+		{"HbacSvcGroupDel", func(name string) error { return c.HbacSvcGroupDel(name) }, "jsmith"},
+		{"SudoRuleShow", func(name string) error { _, err := c.SudoRuleShow(name); return err }, "Jsmith"},
+		{"ServiceShow", func(name string) error { _, err := c.ServiceShow(name); return err }, "Jsmith"},
+	}
+
+	for _, test := range tests {
+		sent = nil
+		err := test.call(" Jsmith\u200b ")
+		assert.Truef(errors.Is(err, ipa.ErrInvalidName), "%s should reject zero-width characters", test.wrapper)
+		assert.Emptyf(sent, "%s should not send invalid names", test.wrapper)
+
+		err = test.call(" Jsmith ")
+		require.NoErrorf(err, "%s", test.wrapper)
+		if assert.Lenf(sent, 1, "%s", test.wrapper) {
+			assert.Equalf(test.want, sent[0], "%s should send the normalized name", test.wrapper)
+		}
+	}
+}
+
+func TestCaseSensitiveGroups(t *testing.T) {
+	var sent []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		sent = append(sent, args[0])
+
+		stubResult(`{"result": {"cn": ["Staff"]}, "summary": null, "value": "Staff"}`)(w, r)
+	})
+
+	c.SetCaseSensitiveGroups(true)
+	_, err := c.GroupShow(" Staff ")
+	require.NoError(t, err)
+	_, err = c.Clone().UserShow(" JDoe")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Staff", "jdoe"}, sent)
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	// EventKerberosRenew is emitted when kerberos credentials are renewed
+	EventKerberosRenew = "kerberos_renew"
+
+	// EventFindTruncated is emitted when FreeIPA truncates find results
+	// because the size or time limit was exceeded
+	EventFindTruncated = "find_truncated"
+
+	// EventMessages is emitted when a response includes messages, for
+	// example API version warnings
+	EventMessages = "messages"
+
+	// EventConnection is emitted when a request to FreeIPA obtains a
+	// connection, either an idle one from the pool or a new one. Only
+	// emitted if enabled with SetConnectionEvents
+	EventConnection = "connection"
+
+	// EventSessionLogin is emitted when the client logs in again after
+	// FreeIPA rejected its session, see SetSessionLogin
+	EventSessionLogin = "session_login"
+)
+
+// Event emitted to the client observer
+type Event struct {
+	Type string
+	Time time.Time
+	Err  error
+
+	// FreeIPA method the event relates to, if any
+	Method string
+
+	// Messages returned by FreeIPA, set for EventMessages only
+	Messages []IpaMessage
+
+	// Whether an idle connection was reused and, for new connections,
+	// whether the TLS session was resumed. Set for EventConnection only
+	ConnReused bool
+	TLSResumed bool
+
+	// Total session logins attempted by the client and how many failed. Set
+	// for EventSessionLogin only
+	LoginAttempts int64
+	LoginFailures int64
+}
+
+// Observer is called with each event emitted by the client, for example to
+// record metrics. Observers must not block.
+type Observer func(event *Event)
+
+// Set the observer called with client events
+func (c *Client) SetObserver(observer Observer) {
+	c.observer = observer
+}
+
+func (c *Client) notify(event *Event) {
+	if c.observer == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	c.observer(event)
+}
+
+// Call fn for every request to FreeIPA which takes longer than threshold,
+// including login, password change and migration form posts. params are the
+// method arguments, never passwords. fn is called after the request
+// completes, from the calling goroutine and without holding any client lock.
+// A nil fn disables the slow call log.
+func (c *Client) SetSlowCallThreshold(threshold time.Duration, fn func(method string, params []string, elapsed time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slowThreshold = threshold
+	c.slowCall = fn
+}
+
+// Returns the duration of the most recent request to FreeIPA, or 0 if no
+// request has been made. With concurrent calls this is whichever finished
+// last.
+func (c *Client) LastCallDuration() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastCall
+}
+
+// Record the duration of a request started at start and report it to the
+// slow call log if it exceeds the threshold
+func (c *Client) recordCall(method string, params []string, start time.Time) {
+	elapsed := time.Since(start)
+
+	c.mu.Lock()
+	c.lastCall = elapsed
+	threshold, fn := c.slowThreshold, c.slowCall
+	c.mu.Unlock()
+
+	if fn != nil && elapsed > threshold {
+		fn(method, params, elapsed)
+	}
+}
+
+// Emit EventConnection to the observer for every request to FreeIPA
+func (c *Client) SetConnectionEvents(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connEvents = enable
+}
+
+// Returns true if the most recent request to FreeIPA reused an idle
+// connection rather than opening a new one
+func (c *Client) LastCallReusedConn() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastReused
+}
+
+// Records how a request obtained its connection
+type connTrace struct {
+	mu      sync.Mutex
+	got     bool
+	reused  bool
+	resumed bool
+}
+
+// Returns req with hooks recording the connection it is sent on
+func (t *connTrace) attach(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.resumed = state.DidResume
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.got = true
+			t.reused = info.Reused
+		},
+	}))
+}
+
+// Record the connection used by a request and notify the observer. Nothing
+// is recorded if the request failed before obtaining a connection
+func (c *Client) recordConn(method string, t *connTrace) {
+	t.mu.Lock()
+	got, reused, resumed := t.got, t.reused, t.resumed
+	t.mu.Unlock()
+	if !got {
+		return
+	}
+
+	c.mu.Lock()
+	c.lastReused = reused
+	notify := c.connEvents
+	c.mu.Unlock()
+	if !notify {
+		return
+	}
+
+	c.notify(&Event{Type: EventConnection, Method: method, ConnReused: reused, TLSResumed: resumed})
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Format a datetime option value. FreeIPA expects datetimes using the
+// class-hint system, for example, '{"__datetime__": "YYYYMMDDHHMMSSZ"}'. t is
+// converted to UTC, so times in any location are stored as the same instant.
+// Time, time.Time and *time.Time option values are formatted with
+// OptDateTime when Options are marshalled.
+func OptDateTime(t time.Time) interface{} {
+	return map[string]interface{}{
+		"__datetime__": t.UTC().Format(IpaDatetimeFormat),
+	}
+}
+
+// Format a boolean option value for attributes which FreeIPA stores as the
+// strings "TRUE" or "FALSE", for example nsaccountlock when set via setattr
+func OptBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+
+	return "FALSE"
+}
+
+// Parse a boolean attribute value. FreeIPA returns booleans either as json
+// booleans or as the strings "TRUE" or "FALSE", optionally wrapped in a
+// single element array, depending on the server version and command. Use
+// this for every boolean attribute rather than gjson Bool, which treats
+// ["TRUE"] as false.
+func parseBool(res gjson.Result) bool {
+	if res.IsArray() {
+		res = res.Get("0")
+	}
+
+	if res.Type == gjson.String {
+		return strings.EqualFold(strings.TrimSpace(res.String()), "TRUE")
+	}
+
+	return res.Bool()
+}
+
+// Format a multi-valued option value. FreeIPA expects multi-valued
+// attributes as a list even when there is only a single value
+func OptStrings(vals ...string) []string {
+	return append([]string{}, vals...)
+}
+
+// Returns new Options with setattr set to attr=value. setattr replaces all
+// existing values of attr and is accepted by most *_mod commands
+func SetAttr(attr, value string) Options {
+	return Options{}.SetAttr(attr, value)
+}
+
+// Returns new Options with addattr set to attr=value. addattr adds a value to
+// a multi-valued attr
+func AddAttr(attr, value string) Options {
+	return Options{}.AddAttr(attr, value)
+}
+
+// Returns new Options with delattr set to attr=value. delattr removes a value
+// from a multi-valued attr
+func DelAttr(attr, value string) Options {
+	return Options{}.DelAttr(attr, value)
+}
+
+// Append attr=value to setattr. Call multiple times to set more than one
+// attribute in a single call
+func (o Options) SetAttr(attr, value string) Options {
+	return o.appendAttr("setattr", attr, value)
+}
+
+// Append attr=value to addattr
+func (o Options) AddAttr(attr, value string) Options {
+	return o.appendAttr("addattr", attr, value)
+}
+
+// Append attr=value to delattr
+func (o Options) DelAttr(attr, value string) Options {
+	return o.appendAttr("delattr", attr, value)
+}
+
+// Append attr=value to option. The values are copied first as they may be
+// the caller's slice
+func (o Options) appendAttr(option, attr, value string) Options {
+	var vals []string
+	switch v := o[option].(type) {
+	case []string:
+		vals = append([]string(nil), v...)
+	case string:
+		vals = []string{v}
+	}
+
+	o[option] = append(vals, attr+"="+value)
+
+	return o
+}
+
+// ExtraAttrs are attributes not mapped by the wrapper structs, for example
+// site specific schema extensions, sent with setattr, addattr and delattr by
+// UserMod, GroupMod and HostMod. Keys are attribute names and each value is
+// sent as a separate attr=value string. Values may contain '=', FreeIPA only
+// splits on the first one.
+type ExtraAttrs struct {
+	// Replace all values of the attribute. An empty list removes the
+	// attribute.
+	Set map[string][]string
+
+	// Add values to a multi-valued attribute
+	Add map[string][]string
+
+	// Remove values from a multi-valued attribute
+	Del map[string][]string
+}
+
+// Append the attributes of e to the setattr, addattr and delattr lists of o,
+// in attribute name order. Options with the same name as an attribute are
+// removed, so extra attributes deliberately override mapped ones instead of
+// being rejected by FreeIPA as overlapping.
+func (e ExtraAttrs) appendTo(o Options) error {
+	for _, extra := range []struct {
+		option string
+		attrs  map[string][]string
+	}{{"setattr", e.Set}, {"addattr", e.Add}, {"delattr", e.Del}} {
+		names := make([]string, 0, len(extra.attrs))
+		for attr := range extra.attrs {
+			if attr == "" || strings.ContainsAny(attr, "= ") {
+				return fmt.Errorf("ipa: invalid attribute name %q", attr)
+			}
+			names = append(names, attr)
+		}
+		sort.Strings(names)
+
+		for _, attr := range names {
+			delete(o, strings.ToLower(attr))
+			values := extra.attrs[attr]
+			if len(values) == 0 && extra.option == "setattr" {
+				o.appendAttr(extra.option, attr, "")
+			}
+			for _, v := range values {
+				o.appendAttr(extra.option, attr, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Marshal options to json accepted by the FreeIPA api. Nil values are
+// omitted, times are encoded using the datetime class-hint and whole number
+// floats, for example from decoding generic json, are encoded as integers.
+// Returns an error for values which can not be sent to FreeIPA such as
+// channels and functions.
+func (o Options) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(o))
+	for k, v := range o {
+		nv, err := normalizeOption(k, v)
+		if err != nil {
+			return nil, err
+		}
+		if nv != nil {
+			out[k] = nv
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// Normalize option value v for key. Returns nil if the option should be
+// omitted.
+func normalizeOption(key string, v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		return OptDateTime(val), nil
+	case *time.Time:
+		if val == nil {
+			return nil, nil
+		}
+		return OptDateTime(*val), nil
+	case Time:
+		if val.IsZero() {
+			return nil, nil
+		}
+		return OptDateTime(val.Time), nil
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val), nil
+		}
+		return val, nil
+	case float32:
+		return normalizeOption(key, float64(val))
+	}
+
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return nil, fmt.Errorf("ipa: unsupported type %T for option %s", v, key)
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if reflect.ValueOf(v).IsNil() {
+			return nil, nil
+		}
+	}
+
+	return v, nil
+}
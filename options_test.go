@@ -0,0 +1,207 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a client which records the json options of each rpc call minus
+// the api version
+func newRecordingClient(t *testing.T, options *string) *ipa.Client {
+	result := stubResult(`{"count": 0, "result": [], "summary": "0 users matched", "truncated": false}`)
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		opts := req.Options
+		delete(opts, "version")
+		raw, _ := json.Marshal(opts)
+		*options = string(raw)
+		result(w, r)
+	})
+}
+
+func TestOptionHelpers(t *testing.T) {
+	assert := assert.New(t)
+
+	dt := time.Date(2023, 4, 5, 6, 7, 8, 0, time.FixedZone("EST", -5*60*60))
+	assert.Equal(map[string]interface{}{"__datetime__": "20230405110708Z"}, ipa.OptDateTime(dt))
+	assert.Equal("TRUE", ipa.OptBool(true))
+	assert.Equal("FALSE", ipa.OptBool(false))
+	assert.Equal([]string{"a"}, ipa.OptStrings("a"))
+	assert.Equal([]string{}, ipa.OptStrings())
+}
+
+func TestOptionsWireFormat(t *testing.T) {
+	require := require.New(t)
+
+	var options string
+	c := newRecordingClient(t, &options)
+
+	dt := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	tests := []struct {
+		options ipa.Options
+		want    string
+	}{
+		{
+			ipa.SetAttr("departmentnumber", "42"),
+			`{"all":true,"no_members":false,"setattr":["departmentnumber=42"],"sizelimit":2000,"timelimit":10}`,
+		},
+		{
+			ipa.SetAttr("departmentnumber", "42").SetAttr("nsaccountlock", ipa.OptBool(true)),
+			`{"all":true,"no_members":false,"setattr":["departmentnumber=42","nsaccountlock=TRUE"],"sizelimit":2000,"timelimit":10}`,
+		},
+		{
+			ipa.AddAttr("mail", "a@example.com").DelAttr("mail", "b@example.com"),
+			`{"addattr":["mail=a@example.com"],"all":true,"delattr":["mail=b@example.com"],"no_members":false,"sizelimit":2000,"timelimit":10}`,
+		},
+		{
+			ipa.Options{"krbpasswordexpiration": ipa.OptDateTime(dt), "mail": ipa.OptStrings("a@example.com")},
+			`{"all":true,"krbpasswordexpiration":{"__datetime__":"20230405060708Z"},"mail":["a@example.com"],"no_members":false,"sizelimit":2000,"timelimit":10}`,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := c.UserFind(test.options)
+		require.NoError(err)
+		require.JSONEq(test.want, options)
+	}
+}
+
+func TestOptionsAttrCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	// Appending must not write into spare capacity of the caller's slice
+	vals := make([]string, 1, 4)
+	vals[0] = "cn=a"
+	base := ipa.Options{"setattr": vals}
+
+	first := base.SetAttr("sn", "b")
+	assert.Equal([]string{"cn=a", "sn=b"}, first["setattr"])
+	assert.Equal([]string{"cn=a"}, vals)
+
+	other := ipa.Options{"setattr": vals}.SetAttr("sn", "c")
+	assert.Equal([]string{"cn=a", "sn=c"}, other["setattr"])
+	assert.Equalf([]string{"cn=a", "sn=b"}, first["setattr"], "Options should not share a backing array")
+}
+
+func TestOptionsMarshalJSON(t *testing.T) {
+	dt := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	var nilSlice []string
+	var nilTime *time.Time
+
+	tests := []struct {
+		name    string
+		options ipa.Options
+		want    string
+	}{
+		{"nil", ipa.Options{"a": nil, "b": "x"}, `{"b":"x"}`},
+		{"nil slice", ipa.Options{"a": nilSlice}, `{}`},
+		{"empty slice", ipa.Options{"a": []string{}}, `{"a":[]}`},
+		{"empty string", ipa.Options{"a": ""}, `{"a":""}`},
+		{"time", ipa.Options{"a": dt}, `{"a":{"__datetime__":"20230405060708Z"}}`},
+		{"time pointer", ipa.Options{"a": &dt, "b": nilTime}, `{"a":{"__datetime__":"20230405060708Z"}}`},
+		{"ipa time", ipa.Options{"a": ipa.NewTime(dt), "b": ipa.Time{}}, `{"a":{"__datetime__":"20230405060708Z"}}`},
+		{"whole float", ipa.Options{"sizelimit": float64(100)}, `{"sizelimit":100}`},
+		{"negative whole float", ipa.Options{"a": float64(-3)}, `{"a":-3}`},
+		{"float", ipa.Options{"a": 1.5}, `{"a":1.5}`},
+		{"bool", ipa.Options{"a": true, "b": false}, `{"a":true,"b":false}`},
+		{"int", ipa.Options{"a": 42}, `{"a":42}`},
+		{"setattr", ipa.SetAttr("a", "1"), `{"setattr":["a=1"]}`},
+	}
+
+	for _, test := range tests {
+		out, err := json.Marshal(test.options)
+		if assert.NoErrorf(t, err, "Failed to marshal %s", test.name) {
+			assert.Equalf(t, test.want, string(out), "Invalid encoding of %s", test.name)
+		}
+	}
+
+	for name, v := range map[string]interface{}{
+		"chan":    make(chan int),
+		"func":    func() {},
+		"complex": complex(1, 2),
+	} {
+		_, err := json.Marshal(ipa.Options{"a": v})
+		assert.Errorf(t, err, "Marshal of %s should fail", name)
+	}
+}
+
+func TestOptionsMarshalJSONError(t *testing.T) {
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	_, err := c.UserFind(ipa.Options{"a": func() {}})
+	assert.Error(t, err)
+	assert.Equalf(t, 0, calls, "Unsupported options should fail before the request is sent")
+}
+
+func TestExtraAttrsWireFormat(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	var sent []map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		opts := req.Options
+		methods = append(methods, req.Method)
+		sent = append(sent, opts)
+		switch req.Method {
+		case "group_mod":
+			stubResult(`{"result": {"cn": ["staff"]}, "value": "staff"}`)(w, r)
+		case "host_mod":
+			stubResult(`{"result": {"fqdn": ["web.local"]}, "value": "web.local"}`)(w, r)
+		default:
+			stubResult(`{"result": {"uid": ["jdoe"]}, "value": "jdoe"}`)(w, r)
+		}
+	})
+
+	user := &ipa.User{Username: "jdoe", Email: "jdoe@example.com", Shell: "/bin/bash", UpdateLocked: true}
+	user.Extra = ipa.ExtraAttrs{
+		Set: map[string][]string{
+			"badgenumber":  {"A-1=2", "B-3"},
+			"mail":         {"override@example.com"},
+			"employeetype": {},
+		},
+		Add: map[string][]string{"customattribute": {"x==y"}},
+		Del: map[string][]string{"customattribute": {"old=1"}},
+	}
+	_, err := c.UserMod(user)
+	require.NoError(err)
+
+	opts := sent[0]
+	assert.Equal([]interface{}{"nsaccountlock=FALSE", "badgenumber=A-1=2", "badgenumber=B-3", "employeetype=", "mail=override@example.com"}, opts["setattr"])
+	assert.Equal([]interface{}{"customattribute=x==y"}, opts["addattr"])
+	assert.Equal([]interface{}{"customattribute=old=1"}, opts["delattr"])
+	assert.NotContainsf(opts, "mail", "Extra attributes should override mapped options")
+	assert.Equal("/bin/bash", opts["loginshell"])
+
+	_, err = c.GroupMod("staff", ipa.ExtraAttrs{Set: map[string][]string{"Description": {"a=b"}}})
+	require.NoError(err)
+	assert.Equal("group_mod", methods[1])
+	assert.Equal([]interface{}{"Description=a=b"}, sent[1]["setattr"])
+	assert.NotContains(sent[1], "addattr")
+
+	_, err = c.HostMod("web.local", ipa.ExtraAttrs{Add: map[string][]string{"l": {"Buffalo", "Amherst"}}})
+	require.NoError(err)
+	assert.Equal("host_mod", methods[2])
+	assert.Equal([]interface{}{"l=Buffalo", "l=Amherst"}, sent[2]["addattr"])
+
+	for _, name := range []string{"", "bad=name", "bad name"} {
+		_, err = c.GroupMod("staff", ipa.ExtraAttrs{Set: map[string][]string{name: {"x"}}})
+		assert.Errorf(err, "Attribute name %q should be rejected", name)
+	}
+	assert.Len(methods, 3)
+}
@@ -5,12 +5,19 @@
 package ipa
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"time"
+	"fmt"
+	"strings"
 
 	"github.com/tidwall/gjson"
 )
 
+// ErrTokenOwnerChange is returned when changing the owner of an OTP token
+// without admin privileges
+var ErrTokenOwnerChange = errors.New("changing otp token owner requires admin privileges")
+
 // OTP Token hash Algorithms supported by FreeIPA
 const (
 	AlgorithmSHA1   string = "sha1"
@@ -19,6 +26,9 @@ const (
 	AlgorithmSHA512        = "sha512"
 )
 
+// Maximum number of OTP token digits accepted when parsing token records
+const maxOTPDigits = 10
+
 // OTP Token types supported by FreeIPA
 const (
 	TokenTypeTOTP = "totp"
@@ -27,29 +37,48 @@ const (
 
 // OTPToken encapsulates FreeIPA otptokens
 type OTPToken struct {
-	DN          string    `json:"dn"`
-	UUID        string    `json:"ipatokenuniqueid"`
-	Algorithm   string    `json:"ipatokenotpalgorithm"`
-	Digits      int       `json:"ipatokenotpdigits"`
-	Owner       string    `json:"ipatokenowner"`
-	TimeStep    int       `json:"ipatokentotptimestep"`
-	ClockOffest int       `json:"ipatokentotpclockoffset"`
-	ManagedBy   string    `json:"managedby_user"`
-	Enabled     bool      `json:"-"`
-	Type        string    `json:"type"`
-	URI         string    `json:"uri"`
-	Description string    `json:"description"`
-	Vendor      string    `json:"ipatokenvendor"`
-	Model       string    `json:"ipatokenmodel"`
-	Serial      string    `json:"ipatokenserial"`
-	NotBefore   time.Time `json:"ipatokennotbefore"`
-	NotAfter    time.Time `json:"ipatokennotafter"`
+	DN          string  `json:"dn"`
+	UUID        string  `json:"ipatokenuniqueid"`
+	Algorithm   string  `json:"ipatokenotpalgorithm"`
+	Digits      int     `json:"ipatokenotpdigits"`
+	Owner       string  `json:"ipatokenowner"`
+	Interval    Seconds `json:"ipatokentotptimestep"`
+	ClockOffest int     `json:"ipatokentotpclockoffset"`
+	ManagedBy   string  `json:"managedby_user"`
+	Enabled     bool    `json:"-"`
+	Type        string  `json:"type"`
+	URI         string  `json:"uri"`
+	Description string  `json:"description"`
+	Vendor      string  `json:"ipatokenvendor"`
+	Model       string  `json:"ipatokenmodel"`
+	Serial      string  `json:"ipatokenserial"`
+	NotBefore   Time    `json:"ipatokennotbefore"`
+	NotAfter    Time    `json:"ipatokennotafter"`
+	Key         Secret  `json:"ipatokenotpkey"`
+
+	// Entry creation and last modification times
+	CreateTimestamp Time `json:"createtimestamp"`
+	ModifyTimestamp Time `json:"modifytimestamp"`
+
+	// Owner DN if FreeIPA returned the owner as a DN. Owner is always the
+	// uid of the owner
+	OwnerDN string `json:"-"`
+
+	// Users managing the token. ManagedBy is the first of these
+	ManagedByUsers []string `json:"managedby_users"`
+
+	// Deprecated: use Interval. TOTP time step in seconds
+	TimeStep int `json:"-"`
+
+	// Raw otptoken record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
 }
 
 var DefaultTOTPToken *OTPToken = &OTPToken{
 	Type:      TokenTypeTOTP,
 	Algorithm: AlgorithmSHA1,
 	Digits:    6,
+	Interval:  30,
 	TimeStep:  30,
 }
 
@@ -61,29 +90,64 @@ func (t *OTPToken) DisplayName() string {
 }
 
 func (t *OTPToken) fromJSON(raw []byte) error {
-	if !gjson.ValidBytes(raw) {
+	if !isRecord(raw) {
 		return errors.New("invalid otp token record json")
 	}
 
 	res := gjson.ParseBytes(raw)
+	t.Raw = stripSecrets(raw)
 
 	t.DN = res.Get("dn").String()
 	t.UUID = res.Get("ipatokenuniqueid.0").String()
 	t.Algorithm = res.Get("ipatokenotpalgorithm.0").String()
-	t.Digits = int(res.Get("ipatokenotpdigits.0").Int())
-	t.Owner = res.Get("ipatokenowner.0").String()
-	t.TimeStep = int(res.Get("ipatokentotptimestep.0").Int())
+	digits := res.Get("ipatokenotpdigits.0").Int()
+	if digits < 0 || digits > maxOTPDigits {
+		return fmt.Errorf("invalid otp token digits: %d", digits)
+	}
+
+	t.Digits = int(digits)
+	// Some FreeIPA versions return the owner as a user DN
+	owner := res.Get("ipatokenowner.0").String()
+	t.Owner = dnValue(owner, "uid")
+	if t.Owner != owner {
+		t.OwnerDN = owner
+	}
+	interval, err := parseSeconds(res.Get("ipatokentotptimestep"))
+	if err != nil {
+		return err
+	}
+	t.Interval = interval
+	t.TimeStep = int(interval)
 	t.ClockOffest = int(res.Get("ipatokentotpclockoffset.0").Int())
-	t.ManagedBy = res.Get("managedby_user.0").String()
-	t.Enabled = !res.Get("ipatokendisabled.0").Bool()
-	t.Type = res.Get("type").String()
+	res.Get("managedby_user").ForEach(func(key, value gjson.Result) bool {
+		t.ManagedByUsers = append(t.ManagedByUsers, dnValue(value.String(), "uid"))
+		return true
+	})
+	if len(t.ManagedByUsers) > 0 {
+		t.ManagedBy = t.ManagedByUsers[0]
+	}
+	// Find results omit ipatokendisabled for tokens which have never been
+	// disabled
+	t.Enabled = !parseBool(res.Get("ipatokendisabled"))
+	t.Type = strings.ToLower(res.Get("type").String())
 	t.URI = res.Get("uri").String()
 	t.Description = res.Get("description.0").String()
 	t.Vendor = res.Get("ipatokenvendor.0").String()
 	t.Model = res.Get("ipatokenmodel.0").String()
 	t.Serial = res.Get("ipatokenserial.0").String()
-	t.NotBefore = ParseDateTime(res.Get("ipatokennotbefore.0.__datetime__").String())
-	t.NotAfter = ParseDateTime(res.Get("ipatokennotafter.0.__datetime__").String())
+	t.NotBefore = parseTimeAttr(res, "ipatokennotbefore")
+	t.NotAfter = parseTimeAttr(res, "ipatokennotafter")
+	t.CreateTimestamp = parseTimeAttr(res, "createtimestamp")
+	t.ModifyTimestamp = parseTimeAttr(res, "modifytimestamp")
+	if key := res.Get("ipatokenotpkey.0"); key.Exists() {
+		// The key is returned by otptoken_add only, base64 encoded using the
+		// class-hint system, for example {"__base64__": "..."}
+		b, err := base64.StdEncoding.DecodeString(key.Get("__base64__").String())
+		if err != nil {
+			return fmt.Errorf("invalid otp token key: %w", err)
+		}
+		t.Key = NewSecret(b)
+	}
 
 	return nil
 }
@@ -101,20 +165,17 @@ func (c *Client) RemoveOTPToken(tokenUUID string) error {
 
 // Fetch OTP tokens by owner.
 func (c *Client) FetchOTPTokens(owner string) ([]*OTPToken, error) {
-	options := Options{
-		"ipatokenowner": owner,
-		"all":           true,
-	}
-
-	res, err := c.rpc("otptoken_find", []string{}, options)
+	return c.OTPTokenSearch(NewOTPTokenSearch().Owner(owner))
+}
 
-	if err != nil {
-		return nil, err
+// Parse list of otp token records returned from otptoken_find
+func parseOTPTokenList(raw []byte) ([]*OTPToken, error) {
+	if !gjson.ValidBytes(raw) {
+		return nil, errors.New("invalid otp token list json")
 	}
 
 	tokens := make([]*OTPToken, 0)
-
-	data := gjson.ParseBytes(res.Result.Data)
+	data := gjson.ParseBytes(raw)
 	for _, t := range data.Array() {
 		tok := new(OTPToken)
 		err := tok.fromJSON([]byte(t.Raw))
@@ -142,15 +203,22 @@ func (c *Client) AddOTPToken(token *OTPToken) (*OTPToken, error) {
 	if token.Digits == 0 {
 		token.Digits = DefaultTOTPToken.Digits
 	}
-	if token.TimeStep == 0 {
-		token.TimeStep = DefaultTOTPToken.TimeStep
+	if token.Interval == 0 {
+		token.Interval = Seconds(token.TimeStep)
+	}
+	if token.Interval == 0 {
+		token.Interval = DefaultTOTPToken.Interval
+	}
+	if err := token.Interval.validate(); err != nil {
+		return nil, err
 	}
+	token.TimeStep = int(token.Interval)
 
 	options := Options{
 		"type":                 token.Type,
 		"ipatokenotpalgorithm": token.Algorithm,
 		"ipatokenotpdigits":    token.Digits,
-		"ipatokentotptimestep": token.TimeStep,
+		"ipatokentotptimestep": token.Interval,
 		"no_qrcode":            true,
 		"qrcode":               false,
 		"no_members":           false,
@@ -160,6 +228,9 @@ func (c *Client) AddOTPToken(token *OTPToken) (*OTPToken, error) {
 	if token.Description != "" {
 		options["description"] = token.Description
 	}
+	if token.Owner != "" {
+		options["ipatokenowner"] = token.Owner
+	}
 	if token.Vendor != "" {
 		options["ipatokenvendor"] = token.Vendor
 	}
@@ -170,14 +241,10 @@ func (c *Client) AddOTPToken(token *OTPToken) (*OTPToken, error) {
 		options["ipatokenserial"] = token.Serial
 	}
 	if !token.NotBefore.IsZero() {
-		options["ipatokennotbefore"] = map[string]interface{}{
-			"__datetime__": token.NotBefore.Format(IpaDatetimeFormat),
-		}
+		options["ipatokennotbefore"] = token.NotBefore
 	}
 	if !token.NotAfter.IsZero() {
-		options["ipatokennotafter"] = map[string]interface{}{
-			"__datetime__": token.NotAfter.Format(IpaDatetimeFormat),
-		}
+		options["ipatokennotafter"] = token.NotAfter
 	}
 
 	res, err := c.rpc("otptoken_add", []string{}, options)
@@ -218,3 +285,71 @@ func (c *Client) DisableOTPToken(tokenUUID string) error {
 
 	return err
 }
+
+// Add users to the managers of OTP token
+func (c *Client) OTPTokenAddManagedBy(tokenUUID string, users ...string) (*OTPToken, error) {
+	return c.otpTokenManagedBy("otptoken_add_managedby", tokenUUID, users)
+}
+
+// Remove users from the managers of OTP token
+func (c *Client) OTPTokenRemoveManagedBy(tokenUUID string, users ...string) (*OTPToken, error) {
+	return c.otpTokenManagedBy("otptoken_remove_managedby", tokenUUID, users)
+}
+
+func (c *Client) otpTokenManagedBy(method, tokenUUID string, users []string) (*OTPToken, error) {
+	if len(users) == 0 {
+		return nil, errors.New("At least one user is required")
+	}
+
+	options := Options{
+		"user": users,
+		"all":  true,
+	}
+
+	res, err := c.rpc(method, []string{tokenUUID}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers(method, res.Result, "managedby.user")
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRec := new(OTPToken)
+	err = tokenRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenRec, nil
+}
+
+// Transfer ownership of OTP token to owner. Only admins can change the owner
+// of a token, ErrTokenOwnerChange is returned otherwise.
+func (c *Client) OTPTokenSetOwner(tokenUUID, owner string) (*OTPToken, error) {
+	if err := checkArgs("otptoken_mod", tokenUUID, owner); err != nil {
+		return nil, err
+	}
+
+	options := Options{
+		"ipatokenowner": owner,
+		"all":           true,
+	}
+
+	res, err := c.rpc("otptoken_mod", []string{tokenUUID}, options)
+	if err != nil {
+		if errors.Is(err, ErrNoPermission) {
+			return nil, ErrTokenOwnerChange
+		}
+		return nil, err
+	}
+
+	tokenRec := new(OTPToken)
+	err = tokenRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenRec, nil
+}
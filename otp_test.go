@@ -5,6 +5,9 @@
 package ipa_test
 
 import (
+	"encoding/json"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,7 +42,7 @@ func TestAddTOTPToken(t *testing.T) {
 		Type:        ipa.TokenTypeTOTP,
 		Algorithm:   ipa.AlgorithmSHA256,
 		Description: "this is a test token",
-		NotBefore:   time.Now(),
+		NotBefore:   ipa.NewTime(time.Now()),
 	}
 
 	tokenRec, err := userClient.AddOTPToken(token)
@@ -67,3 +70,158 @@ func TestAddTOTPToken(t *testing.T) {
 	err = c.UserDelete(false, false, username)
 	assert.NoErrorf(err, "Failed to remove user")
 }
+
+func TestOTPTokenManagedBy(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	owner := gofakeit.Username()
+	manager := gofakeit.Username()
+
+	_, err = addTestUser(c, owner, "")
+	require.NoErrorf(err, "Failed to add test user")
+	_, err = addTestUser(c, manager, "")
+	require.NoErrorf(err, "Failed to add test manager")
+
+	tokenRec, err := c.AddOTPToken(&ipa.OTPToken{
+		Type:      ipa.TokenTypeTOTP,
+		Algorithm: ipa.AlgorithmSHA256,
+		Owner:     owner,
+	})
+	require.NoErrorf(err, "Failed to add OTP token")
+
+	tokenRec, err = c.OTPTokenAddManagedBy(tokenRec.UUID, manager)
+	require.NoErrorf(err, "Failed to add token manager")
+	assert.Lenf(tokenRec.ManagedByUsers, 2, "Token should have two managers")
+	assert.Contains(tokenRec.ManagedByUsers, strings.ToLower(owner))
+	assert.Contains(tokenRec.ManagedByUsers, strings.ToLower(manager))
+
+	tokenRec, err = c.OTPTokenRemoveManagedBy(tokenRec.UUID, owner)
+	require.NoErrorf(err, "Failed to remove token manager")
+	assert.Equal([]string{strings.ToLower(manager)}, tokenRec.ManagedByUsers)
+
+	tokenRec, err = c.OTPTokenSetOwner(tokenRec.UUID, manager)
+	require.NoErrorf(err, "Failed to change token owner")
+	assert.Equal(strings.ToLower(manager), tokenRec.Owner)
+
+	err = c.RemoveOTPToken(tokenRec.UUID)
+	assert.NoErrorf(err, "Failed to remove token")
+
+	err = c.UserDelete(false, false, owner, manager)
+	assert.NoErrorf(err, "Failed to remove users")
+}
+
+func TestOTPTokenManagedByErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"completed": 0, "failed": {"managedby": {"user": [["jdoe", "no such entry"]]}}, "result": {"ipatokenuniqueid": ["abc"], "managedby_user": ["jsmith"]}}`))
+	_, err := c.OTPTokenAddManagedBy("abc", "jdoe")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "jdoe: no such entry")
+	}
+
+	_, err = c.OTPTokenAddManagedBy("abc")
+	assert.Error(err)
+
+	c = newTestClientStub(t, stubError(2100, "Insufficient access: Insufficient 'write' privilege to the 'ipatokenOwner' attribute"))
+	_, err = c.OTPTokenSetOwner("abc", "jdoe")
+	assert.ErrorIs(err, ipa.ErrTokenOwnerChange)
+}
+
+func TestAddOTPTokenInterval(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options map[string]interface{}
+	result := stubResult(`{"result": {"ipatokenuniqueid": ["abc"], "ipatokentotptimestep": ["60"], "type": "TOTP"}, "value": "abc"}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		options = stubDecode(r).Options
+		result(w, r)
+	})
+
+	tokenRec, err := c.AddOTPToken(&ipa.OTPToken{Interval: 60})
+	require.NoError(err)
+	assert.Equal(float64(60), options["ipatokentotptimestep"])
+	assert.Equal(time.Minute, tokenRec.Interval.Duration())
+	assert.Equal(60, tokenRec.TimeStep)
+
+	// The deprecated TimeStep is used if Interval is not set
+	_, err = c.AddOTPToken(&ipa.OTPToken{TimeStep: 45})
+	require.NoError(err)
+	assert.Equal(float64(45), options["ipatokentotptimestep"])
+
+	options = nil
+	_, err = c.AddOTPToken(&ipa.OTPToken{Interval: -30})
+	assert.ErrorIs(err, ipa.ErrInvalidDuration)
+	assert.Nilf(options, "Invalid tokens should not reach the server")
+}
+
+func TestOTPTokenOwnerDN(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Older servers return the owner and managers of found tokens as DNs
+	// while otptoken_add returns the uid
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+
+		switch req.Method {
+		case "otptoken_find":
+			stubResult(`{"count": 1, "truncated": false, "summary": "1 OTP token matched", "result": [{"ipatokenuniqueid": ["abc"], "type": "totp",
+				"ipatokenowner": ["uid=jsmith,cn=users,cn=accounts,dc=local"],
+				"managedby_user": ["uid=jsmith,cn=users,cn=accounts,dc=local", "admin"]}]}`)(w, r)
+		case "otptoken_add":
+			stubResult(`{"result": {"ipatokenuniqueid": ["abc"], "type": "TOTP", "ipatokenowner": ["jsmith"], "managedby_user": ["jsmith"]},
+				"summary": "Added OTP token \"abc\"", "value": "abc"}`)(w, r)
+		}
+	})
+
+	tokens, err := c.FetchOTPTokens("jsmith")
+	require.NoError(err)
+	require.Len(tokens, 1)
+	assert.Equal("jsmith", tokens[0].Owner)
+	assert.Equal("uid=jsmith,cn=users,cn=accounts,dc=local", tokens[0].OwnerDN)
+	assert.Equal([]string{"jsmith", "admin"}, tokens[0].ManagedByUsers)
+	assert.Equal("jsmith", tokens[0].ManagedBy)
+
+	out, err := json.Marshal(tokens[0])
+	require.NoError(err)
+	assert.Containsf(string(out), `"managedby_user":"jsmith"`, "ManagedBy should keep its JSON key")
+	assert.Contains(string(out), `"managedby_users":["jsmith","admin"]`)
+
+	token, err := c.AddOTPToken(&ipa.OTPToken{Type: ipa.TokenTypeTOTP, Owner: "jsmith"})
+	require.NoError(err)
+	assert.Equalf(tokens[0].Owner, token.Owner, "Owner should be the same for find and add")
+	assert.Empty(token.OwnerDN)
+	assert.Equal([]string{"jsmith"}, token.ManagedByUsers)
+}
+
+func TestAddOTPTokenDateTimes(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options map[string]json.RawMessage
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		json.Unmarshal(req.Params[1], &options)
+		stubResult(`{"result": {"ipatokenuniqueid": ["abc"], "type": "TOTP", "ipatokenowner": ["jdoe"],
+			"ipatokennotbefore": [{"__datetime__": "20230405060000Z"}], "ipatokennotafter": [{"__datetime__": "20240405060000Z"}]},
+			"summary": "Added OTP token \"abc\"", "value": "abc"}`)(w, r)
+	})
+
+	cest := time.FixedZone("CEST", 2*60*60)
+	token, err := c.AddOTPToken(&ipa.OTPToken{
+		Type:      ipa.TokenTypeTOTP,
+		Owner:     "jdoe",
+		NotBefore: ipa.NewTime(time.Date(2023, 4, 5, 8, 0, 0, 0, cest)),
+		NotAfter:  ipa.NewTime(time.Date(2024, 4, 5, 8, 0, 0, 0, cest)),
+	})
+	require.NoError(err)
+	assert.JSONEq(`{"__datetime__": "20230405060000Z"}`, string(options["ipatokennotbefore"]))
+	assert.JSONEq(`{"__datetime__": "20240405060000Z"}`, string(options["ipatokennotafter"]))
+	assert.Equal(time.UTC, token.NotBefore.Location())
+	assert.True(token.NotBefore.Equal(time.Date(2023, 4, 5, 8, 0, 0, 0, cest)))
+}
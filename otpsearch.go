@@ -0,0 +1,123 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"time"
+)
+
+// OTPTokenSearch builds an otptoken_find query. Create one with
+// NewOTPTokenSearch and run it with Client.OTPTokenSearch
+type OTPTokenSearch struct {
+	options        Options
+	enabled        *bool
+	expiringBefore time.Time
+	tokenType      string
+}
+
+// Returns a new search matching all tokens the caller can read
+func NewOTPTokenSearch() *OTPTokenSearch {
+	return &OTPTokenSearch{options: Options{}}
+}
+
+// Only match tokens owned by username
+func (s *OTPTokenSearch) Owner(username string) *OTPTokenSearch {
+	s.options["ipatokenowner"] = username
+	return s
+}
+
+// Only match tokens managed by username
+func (s *OTPTokenSearch) ManagedBy(username string) *OTPTokenSearch {
+	s.options["man_by_user"] = OptStrings(username)
+	return s
+}
+
+// Only match disabled tokens, or only enabled tokens if disabled is false
+func (s *OTPTokenSearch) Disabled(disabled bool) *OTPTokenSearch {
+	enabled := !disabled
+	s.enabled = &enabled
+	return s
+}
+
+// Only match tokens of type, TokenTypeTOTP or TokenTypeHOTP
+func (s *OTPTokenSearch) Type(tokenType string) *OTPTokenSearch {
+	s.tokenType = tokenType
+	return s
+}
+
+// Only match tokens which expire before t. Tokens without an expiry never
+// match
+func (s *OTPTokenSearch) ExpiringBefore(t time.Time) *OTPTokenSearch {
+	s.expiringBefore = t
+	return s
+}
+
+// Limit the number of tokens returned. See FindOptions
+func (s *OTPTokenSearch) SizeLimit(n int) *OTPTokenSearch {
+	s.options["sizelimit"] = n
+	return s
+}
+
+// Returns the otptoken_find options for the search
+func (s *OTPTokenSearch) toOptions() Options {
+	options := Options{"all": true}
+	for k, v := range s.options {
+		options[k] = v
+	}
+
+	if s.tokenType != "" {
+		options["type"] = s.tokenType
+	}
+
+	// Tokens which have never been disabled have no ipatokendisabled
+	// attribute so only disabled tokens can be matched on the server
+	if s.enabled != nil && !*s.enabled {
+		options["ipatokendisabled"] = true
+	}
+
+	return options
+}
+
+// Returns true if tok matches the filters FreeIPA can not apply
+func (s *OTPTokenSearch) matches(tok *OTPToken) bool {
+	if s.enabled != nil && tok.Enabled != *s.enabled {
+		return false
+	}
+
+	if !s.expiringBefore.IsZero() && (tok.NotAfter.IsZero() || !tok.NotAfter.Before(s.expiringBefore)) {
+		return false
+	}
+
+	return true
+}
+
+// Find OTP tokens matching s. otptoken_find only supports exact matches on
+// ipatokennotafter so ExpiringBefore, and Disabled(false), are applied to the
+// results. Size limits apply before this filtering.
+func (c *Client) OTPTokenSearch(s *OTPTokenSearch) ([]*OTPToken, error) {
+	if s.tokenType != "" && s.tokenType != TokenTypeTOTP && s.tokenType != TokenTypeHOTP {
+		return nil, fmt.Errorf("ipa: invalid otp token type: %s", s.tokenType)
+	}
+
+	res, err := c.rpc("otptoken_find", []string{}, s.toOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := parseOTPTokenList(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*OTPToken, 0, len(tokens))
+	for _, tok := range tokens {
+		if s.matches(tok) {
+			matched = append(matched, tok)
+		}
+	}
+
+	return matched, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestOTPTokenSearch(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options map[string]interface{}
+	result := stubResult(`{"count": 4, "result": [
+		{"ipatokenuniqueid": ["tok1"], "ipatokenowner": ["jdoe"], "type": "TOTP", "managedby_user": ["helpdesk"], "ipatokennotafter": [{"__datetime__": "20240101000000Z"}]},
+		{"ipatokenuniqueid": ["tok2"], "ipatokenowner": ["uid=jsmith,cn=users,cn=accounts,dc=local"], "type": "totp", "ipatokendisabled": ["TRUE"], "ipatokennotafter": [{"__datetime__": "20240301000000Z"}]},
+		{"ipatokenuniqueid": ["tok3"], "ipatokenowner": ["jdoe"], "type": "hotp", "ipatokendisabled": [true]},
+		{"ipatokenuniqueid": ["tok4"], "ipatokenowner": ["jdoe"], "ipatokendisabled": ["FALSE"], "ipatokennotafter": [{"__datetime__": "20231201000000Z"}]}
+	], "summary": "4 OTP tokens matched", "truncated": false}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		options = req.Options
+		delete(options, "version")
+		result(w, r)
+	})
+
+	tokens, err := c.OTPTokenSearch(ipa.NewOTPTokenSearch().ManagedBy("helpdesk").Type(ipa.TokenTypeTOTP).SizeLimit(10))
+	require.NoError(err)
+	assert.Equal(map[string]interface{}{
+		"all":         true,
+		"man_by_user": []interface{}{"helpdesk"},
+		"type":        "totp",
+		"sizelimit":   float64(10),
+		"timelimit":   float64(10),
+	}, options)
+	require.Len(tokens, 4)
+	assert.Truef(tokens[0].Enabled, "Tokens without ipatokendisabled should be enabled")
+	assert.False(tokens[1].Enabled)
+	assert.False(tokens[2].Enabled)
+	assert.True(tokens[3].Enabled)
+	assert.Equalf("jsmith", tokens[1].Owner, "Owner DNs should be parsed to the uid")
+	assert.Equal("totp", tokens[0].Type)
+
+	tokens, err = c.OTPTokenSearch(ipa.NewOTPTokenSearch().Disabled(true).ExpiringBefore(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(err)
+	assert.Equal(true, options["ipatokendisabled"])
+	require.Lenf(tokens, 1, "Disabled tokens without an expiry should not match")
+	assert.Equal("tok2", tokens[0].UUID)
+
+	tokens, err = c.OTPTokenSearch(ipa.NewOTPTokenSearch().Disabled(false))
+	require.NoError(err)
+	assert.NotContainsf(options, "ipatokendisabled", "Enabled tokens can not be matched on the server")
+	require.Len(tokens, 2)
+	assert.Equal("tok1", tokens[0].UUID)
+	assert.Equal("tok4", tokens[1].UUID)
+
+	tokens, err = c.FetchOTPTokens("jdoe")
+	require.NoError(err)
+	assert.Equal("jdoe", options["ipatokenowner"])
+	assert.Len(tokens, 4)
+
+	_, err = c.OTPTokenSearch(ipa.NewOTPTokenSearch().Type("sms"))
+	assert.Error(err)
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Character classes used when generating passwords. These match the classes
+// FreeIPA counts when enforcing krbpwdmindiffchars.
+const (
+	PasswordLower   = "abcdefghijklmnopqrstuvwxyz"
+	PasswordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	PasswordDigits  = "0123456789"
+	PasswordSpecial = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+
+	// PasswordAmbiguous are characters that are easily confused when read
+	PasswordAmbiguous = "0O1lI|"
+
+	// DefaultPasswordLength is the length of passwords generated by
+	// ResetPasswordLocal when the password policy does not require longer
+	DefaultPasswordLength = 16
+)
+
+// PasswordOption configures password generation
+type PasswordOption func(*passwordConfig)
+
+type passwordConfig struct {
+	requireSpecial bool
+	exclude        string
+}
+
+// NoAmbiguous excludes easily confused characters from generated passwords
+func NoAmbiguous() PasswordOption {
+	return func(cfg *passwordConfig) {
+		cfg.exclude += PasswordAmbiguous
+	}
+}
+
+// RequireSpecial ensures generated passwords contain at least one special
+// character regardless of the password policy
+func RequireSpecial() PasswordOption {
+	return func(cfg *passwordConfig) {
+		cfg.requireSpecial = true
+	}
+}
+
+// ExcludeChars excludes the given characters from generated passwords
+func ExcludeChars(chars string) PasswordOption {
+	return func(cfg *passwordConfig) {
+		cfg.exclude += chars
+	}
+}
+
+// Generate a random password using crypto/rand that satisfies the given
+// password policy. The generated password will be at least length characters
+// long, or the policy minimum length if larger. If policy is nil only length
+// and the provided options are considered.
+func GeneratePassword(policy *PasswordPolicy, length int, opts ...PasswordOption) (string, error) {
+	cfg := &passwordConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	// Special characters must remain the last class
+	classes := []string{}
+	for _, class := range []string{PasswordLower, PasswordUpper, PasswordDigits, PasswordSpecial} {
+		classes = append(classes, removeChars(class, cfg.exclude))
+	}
+
+	minClasses := 0
+	if policy != nil {
+		if policy.MinLength > length {
+			length = policy.MinLength
+		}
+		minClasses = policy.MinClasses
+	}
+
+	if minClasses > len(classes) {
+		return "", fmt.Errorf("ipa: password policy requires %d character classes, only %d supported", minClasses, len(classes))
+	}
+
+	// Pick the classes which must be present in the password
+	required := []string{}
+	for i, class := range classes {
+		if i < minClasses || (cfg.requireSpecial && i == len(classes)-1) {
+			if len(class) == 0 {
+				return "", errors.New("ipa: all characters of a required class have been excluded")
+			}
+			required = append(required, class)
+		}
+	}
+
+	if length < len(required) {
+		return "", fmt.Errorf("ipa: password length %d too short for %d required character classes", length, len(required))
+	}
+
+	pool := strings.Join(classes, "")
+	if len(pool) == 0 {
+		return "", errors.New("ipa: all password characters have been excluded")
+	}
+
+	passwd := make([]byte, 0, length)
+	for _, class := range required {
+		ch, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		passwd = append(passwd, ch)
+	}
+
+	for len(passwd) < length {
+		ch, err := randomChar(pool)
+		if err != nil {
+			return "", err
+		}
+		passwd = append(passwd, ch)
+	}
+
+	// Shuffle so required characters don't always lead the password
+	for i := len(passwd) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		passwd[i], passwd[j] = passwd[j], passwd[i]
+	}
+
+	return string(passwd), nil
+}
+
+func removeChars(s, exclude string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(exclude, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n.Int64()), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+
+	return chars[i], nil
+}
+
+// Reset user password to a locally generated password and return the new
+// password. The password is generated according to the users effective
+// password policy, set via user_mod and then changed using SetPassword so it
+// is not marked as expired. See SetPassword for caveats.
+func (c *Client) ResetPasswordLocal(username string, opts ...PasswordOption) (string, error) {
+	policy, err := c.EffectivePasswordPolicy(username)
+	if err != nil {
+		return "", err
+	}
+
+	tmpPasswd, err := GeneratePassword(policy, DefaultPasswordLength, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	passwd, err := GeneratePassword(policy, DefaultPasswordLength, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	options := Options{
+		"userpassword": tmpPasswd,
+	}
+
+	_, err = c.rpc("user_mod", []string{username}, options)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.SetPassword(username, tmpPasswd, passwd, "")
+	if err != nil {
+		return "", err
+	}
+
+	return passwd, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func countClasses(passwd string) map[string]int {
+	counts := make(map[string]int)
+	for _, ch := range passwd {
+		switch {
+		case strings.ContainsRune(ipa.PasswordLower, ch):
+			counts["lower"]++
+		case strings.ContainsRune(ipa.PasswordUpper, ch):
+			counts["upper"]++
+		case strings.ContainsRune(ipa.PasswordDigits, ch):
+			counts["digits"]++
+		case strings.ContainsRune(ipa.PasswordSpecial, ch):
+			counts["special"]++
+		default:
+			counts["other"]++
+		}
+	}
+
+	return counts
+}
+
+func TestGeneratePasswordPolicy(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	policy := &ipa.PasswordPolicy{
+		MinLength:  24,
+		MinClasses: 4,
+	}
+
+	for i := 0; i < 500; i++ {
+		passwd, err := ipa.GeneratePassword(policy, 8)
+		require.NoError(err)
+		assert.Lenf(passwd, 24, "Password should be policy minimum length")
+
+		counts := countClasses(passwd)
+		assert.Lenf(counts, 4, "Password should contain all character classes: %s", passwd)
+		assert.Zerof(counts["other"], "Password contains unknown characters: %s", passwd)
+	}
+}
+
+func TestGeneratePasswordOptions(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	policy := &ipa.PasswordPolicy{
+		MinLength:  8,
+		MinClasses: 1,
+	}
+
+	for i := 0; i < 500; i++ {
+		passwd, err := ipa.GeneratePassword(policy, 12, ipa.NoAmbiguous(), ipa.RequireSpecial(), ipa.ExcludeChars("\\"))
+		require.NoError(err)
+		assert.Lenf(passwd, 12, "Password should be requested length")
+		assert.Greaterf(countClasses(passwd)["special"], 0, "Password should contain a special character: %s", passwd)
+		assert.Falsef(strings.ContainsAny(passwd, ipa.PasswordAmbiguous+"\\"), "Password contains excluded characters: %s", passwd)
+	}
+
+	_, err := ipa.GeneratePassword(&ipa.PasswordPolicy{MinClasses: 5}, 12)
+	assert.Errorf(err, "Policy requiring unsupported number of classes should error")
+
+	_, err = ipa.GeneratePassword(&ipa.PasswordPolicy{MinClasses: 4}, 3)
+	assert.Errorf(err, "Length shorter than required classes should error")
+
+	_, err = ipa.GeneratePassword(nil, 12, ipa.RequireSpecial(), ipa.ExcludeChars(ipa.PasswordSpecial))
+	assert.Errorf(err, "Excluding a required class should error")
+}
+
+func TestGeneratePasswordDistribution(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	total := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		passwd, err := ipa.GeneratePassword(nil, 32)
+		require.NoError(err)
+
+		for class, n := range countClasses(passwd) {
+			total[class] += n
+		}
+	}
+
+	// Each class should be drawn roughly in proportion to its size in the pool
+	pool := len(ipa.PasswordLower + ipa.PasswordUpper + ipa.PasswordDigits + ipa.PasswordSpecial)
+	for class, chars := range map[string]string{
+		"lower":   ipa.PasswordLower,
+		"upper":   ipa.PasswordUpper,
+		"digits":  ipa.PasswordDigits,
+		"special": ipa.PasswordSpecial,
+	} {
+		expected := float64(32*1000*len(chars)) / float64(pool)
+		assert.InDeltaf(expected, float64(total[class]), expected*0.1, "Unexpected distribution for class %s", class)
+	}
+}
+
+func TestResetPasswordLocal(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	username := gofakeit.Username()
+
+	_, err = addTestUser(c, username, gofakeit.Password(true, true, true, true, false, 16))
+	require.NoErrorf(err, "Failed to add test user")
+
+	passwd, err := c.ResetPasswordLocal(username, ipa.RequireSpecial())
+	require.NoErrorf(err, "Failed to reset password")
+	assert.Greaterf(countClasses(passwd)["special"], 0, "Password should contain a special character")
+
+	userClient := ipa.NewDefaultClient()
+	err = userClient.RemoteLogin(username, passwd)
+	assert.NoErrorf(err, "User should be able to login with new password")
+
+	err = c.UserDelete(false, false, username)
+	assert.NoErrorf(err, "Failed to remove user")
+}
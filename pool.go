@@ -0,0 +1,108 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Largest QPS supported by the rate limit of bulk lookups, one request per
+// nanosecond
+const maxQPS = float64(time.Second)
+
+// Run work for items 0 to n-1 on concurrency workers. work returns a function
+// which is called on the calling goroutine in item order, for example to
+// write the item's result, so results are ordered while at most concurrency
+// items are in flight. done is closed once runOrdered returns. The first
+// error returned by an ordered function stops the run and is returned.
+func runOrdered(n, concurrency int, work func(i int, done <-chan struct{}) func() error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type job struct {
+		i      int
+		result chan func() error
+	}
+	jobs := make(chan job)
+	pending := make(chan chan func() error, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.result <- work(j.i, done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			result := make(chan func() error, 1)
+			select {
+			case pending <- result:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- job{i: i, result: result}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for result := range pending {
+		if err := (<-result)(); err != nil {
+			return err
+		}
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// Returns a channel ticking qps times per second shared by the workers of a
+// bulk lookup, or nil if qps is 0, and a function to stop it. Rates whose
+// interval does not fit in a time.Duration are rejected.
+func newRateLimit(qps float64) (<-chan time.Time, func(), error) {
+	if math.IsNaN(qps) || qps < 0 || qps > maxQPS {
+		return nil, nil, fmt.Errorf("ipa: invalid QPS: %v", qps)
+	}
+	if qps == 0 {
+		return nil, func() {}, nil
+	}
+
+	interval := float64(time.Second) / qps
+	if interval >= math.MaxInt64 {
+		return nil, nil, fmt.Errorf("ipa: invalid QPS: %v", qps)
+	}
+
+	ticker := time.NewTicker(time.Duration(interval))
+	return ticker.C, ticker.Stop, nil
+}
+
+// Wait for a tick of limit, if set. Returns false if done is closed first
+func waitRateLimit(limit <-chan time.Time, done <-chan struct{}) bool {
+	if limit == nil {
+		return true
+	}
+
+	select {
+	case <-limit:
+		return true
+	case <-done:
+		return false
+	}
+}
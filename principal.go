@@ -0,0 +1,95 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ping FreeIPA and return an error wrapping ErrPrincipalMismatch unless the
+// call was made as principal expected. Principals without a realm are
+// assumed to be in the client realm, see RequirePrincipal for how principals
+// are compared.
+func (c *Client) VerifyPrincipal(expected string) error {
+	res, err := c.Ping()
+	if err != nil {
+		return err
+	}
+
+	return principalMismatch("ping", normalizePrincipal(expected, c.realm), res.Principal, c.realm)
+}
+
+// Require every subsequent call to be made as principal expected, for example
+// to detect a session cookie belonging to another identity. FreeIPA reports
+// the principal with the response, so a mismatched call has already been
+// executed when it is detected: the call returns an error wrapping
+// ErrPrincipalMismatch and the session is cleared so no further calls are
+// made with it. Use VerifyPrincipal before the first modification to catch a
+// mismatch up front. An empty expected principal disables the check.
+//
+// Principals are compared with the realm upper case, defaulting to the
+// client realm, and user names and service hostnames lower case, as FreeIPA
+// stores them. The service name of a service principal, for example HTTP in
+// HTTP/www.example.com, is compared as is.
+func (c *Client) RequirePrincipal(expected string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.principal = ""
+	if strings.TrimSpace(expected) != "" {
+		c.principal = normalizePrincipal(expected, c.realm)
+	}
+}
+
+// Check the principal of a response to method against the required
+// principal, clearing the session on mismatch
+func (c *Client) checkPrincipal(method, principal string) error {
+	c.mu.RLock()
+	expected := c.principal
+	c.mu.RUnlock()
+	if expected == "" {
+		return nil
+	}
+
+	err := principalMismatch(method, expected, principal, c.realm)
+	if err != nil {
+		c.ClearSession()
+	}
+
+	return err
+}
+
+// Returns an error unless principal normalizes to expected, which must
+// already be normalized
+func principalMismatch(method, expected, principal, realm string) error {
+	if principal == "" {
+		return fmt.Errorf("ipa: %w: %s response has no principal, expected %s", ErrPrincipalMismatch, method, expected)
+	}
+	if normalizePrincipal(principal, realm) != expected {
+		return fmt.Errorf("ipa: %w: %s was called as %s, expected %s", ErrPrincipalMismatch, method, principal, expected)
+	}
+
+	return nil
+}
+
+// Returns principal in the form name@REALM. The realm defaults to realm and
+// is upper cased. User names and the hostname of service principals are
+// lower cased. Escaped @ in enterprise principal names, for example
+// jdoe\@example.com@EXAMPLE.COM, are part of the name.
+func normalizePrincipal(principal, realm string) string {
+	name := strings.TrimSpace(principal)
+	if i := strings.LastIndex(name, "@"); i >= 0 && (i == 0 || name[i-1] != '\\') {
+		name, realm = name[:i], name[i+1:]
+	}
+
+	if service, host, ok := strings.Cut(name, "/"); ok {
+		name = service + "/" + strings.ToLower(host)
+	} else {
+		name = strings.ToLower(name)
+	}
+
+	return name + "@" + strings.ToUpper(realm)
+}
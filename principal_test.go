@@ -0,0 +1,89 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+const testSessionID = "0123456789abcdef0123456789abcdef"
+
+// Returns a client whose server reports calls as made by *principal and
+// hands out a session cookie
+func newPrincipalStub(t *testing.T, principal *string) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", fmt.Sprintf("ipa_session=%s; Path=/ipa; HttpOnly; Secure", testSessionID))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": %q, "version": "4.9.8", "result": {"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}}`, stubRequestID(r), *principal)
+	})
+}
+
+func TestVerifyPrincipal(t *testing.T) {
+	tests := []struct {
+		name     string
+		actual   string
+		expected string
+		match    bool
+	}{
+		{"bare user", "admin@LOCAL", "admin", true},
+		{"realm case", "admin@LOCAL", "Admin@local", true},
+		{"other realm", "admin@LOCAL", "admin@EXAMPLE.COM", false},
+		{"other user", "admin@LOCAL", "jdoe", false},
+		{"service", "HTTP/WWW.Example.com@LOCAL", "HTTP/www.example.com", true},
+		{"service name case", "HTTP/www.example.com@LOCAL", "http/www.example.com@LOCAL", false},
+		{"host", "host/web.local@LOCAL", "host/WEB.LOCAL@LOCAL", true},
+		{"host and user", "host/web.local@LOCAL", "web.local", false},
+		{"enterprise", `jdoe\@example.com@LOCAL`, `jdoe\@Example.com`, true},
+		{"enterprise realm", `jdoe\@example.com@LOCAL`, `jdoe@example.com`, false},
+		{"missing", "", "admin", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal := test.actual
+			c := newPrincipalStub(t, &principal)
+			err := c.VerifyPrincipal(test.expected)
+			if test.match {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ipa.ErrPrincipalMismatch)
+			}
+		})
+	}
+}
+
+func TestRequirePrincipal(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	principal := "admin@LOCAL"
+	c := newPrincipalStub(t, &principal)
+	c.RequirePrincipal("admin")
+
+	_, err := c.Ping()
+	require.NoError(err)
+	require.Equal(testSessionID, c.SessionID())
+
+	// The session now belongs to someone else
+	principal = "jdoe@LOCAL"
+	_, err = c.Ping()
+	require.ErrorIs(err, ipa.ErrPrincipalMismatch)
+	assert.Contains(err.Error(), "jdoe@LOCAL")
+	assert.Emptyf(c.SessionID(), "Session should be cleared on mismatch")
+
+	// Clones keep the requirement
+	_, err = c.Clone().Ping()
+	assert.ErrorIs(err, ipa.ErrPrincipalMismatch)
+
+	c.RequirePrincipal("")
+	_, err = c.Ping()
+	assert.NoError(err)
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// PasswordPolicy encapsulates FreeIPA password policy data returned from ipa
+// pwpolicy commands
+type PasswordPolicy struct {
+	Group       string `json:"cn"`
+	Priority    int    `json:"cospriority"`
+	History     int    `json:"krbpwdhistorylength"`
+	MinClasses  int    `json:"krbpwdmindiffchars"`
+	MinLength   int    `json:"krbpwdminlength"`
+	MaxFailures int    `json:"krbpwdmaxfailure"`
+
+	// Maximum and minimum password lifetime. FreeIPA reports these in days
+	// and hours respectively
+	MaxLifetime Seconds `json:"-"`
+	MinLifetime Seconds `json:"-"`
+
+	// Period after which failed logins are reset and the duration of the
+	// lockout after MaxFailures failed logins
+	FailInterval Seconds `json:"krbpwdfailurecountinterval"`
+	LockoutTime  Seconds `json:"krbpwdlockoutduration"`
+
+	// Deprecated: use MaxLifetime. Max lifetime in days
+	MaxLife int `json:"krbmaxpwdlife"`
+
+	// Deprecated: use MinLifetime. Min lifetime in hours
+	MinLife int `json:"krbminpwdlife"`
+
+	// Deprecated: use FailInterval. Failure reset interval in seconds
+	FailureInterval int `json:"-"`
+
+	// Deprecated: use LockoutTime. Lockout duration in seconds
+	LockoutDuration int `json:"-"`
+}
+
+func (p *PasswordPolicy) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid password policy record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	p.Group = res.Get("cn.0").String()
+	p.Priority = int(res.Get("cospriority.0").Int())
+	p.MaxLife = int(res.Get("krbmaxpwdlife.0").Int())
+	p.MinLife = int(res.Get("krbminpwdlife.0").Int())
+	p.History = int(res.Get("krbpwdhistorylength.0").Int())
+	p.MinClasses = int(res.Get("krbpwdmindiffchars.0").Int())
+	p.MinLength = int(res.Get("krbpwdminlength.0").Int())
+	p.MaxFailures = int(res.Get("krbpwdmaxfailure.0").Int())
+	p.FailureInterval = int(res.Get("krbpwdfailurecountinterval.0").Int())
+	p.LockoutDuration = int(res.Get("krbpwdlockoutduration.0").Int())
+
+	p.MaxLifetime = Seconds(p.MaxLife) * 24 * 60 * 60
+	p.MinLifetime = Seconds(p.MinLife) * 60 * 60
+	p.FailInterval = Seconds(p.FailureInterval)
+	p.LockoutTime = Seconds(p.LockoutDuration)
+
+	return nil
+}
+
+// Fetch password policy by group name. If group is empty the global password
+// policy is returned.
+func (c *Client) PasswordPolicyShow(group string) (*PasswordPolicy, error) {
+	params := []string{}
+	if group != "" {
+		params = append(params, group)
+	}
+
+	return c.pwpolicyShow(params, Options{"all": true})
+}
+
+// Fetch the password policy in effect for the given user. FreeIPA computes
+// the effective policy server side, taking the user's group policies and
+// their priorities into account.
+func (c *Client) EffectivePasswordPolicy(username string) (*PasswordPolicy, error) {
+	return c.pwpolicyShow([]string{}, Options{"user": username, "all": true})
+}
+
+// NoLockout is returned by AttemptsRemaining if the user's password policy
+// never locks out the user
+const NoLockout = -1
+
+// Returns the number of failed logins left before the user is locked out, or
+// NoLockout if the user's password policy has no maximum failures. Failed
+// logins are counted by each server separately so the count is fetched from
+// all servers with user_status and the highest count is used. Counts older
+// than the policy's failure reset interval, and lockouts which have expired,
+// are not counted as the KDC resets them on the next login.
+func (c *Client) AttemptsRemaining(username string) (int, error) {
+	policy, err := c.EffectivePasswordPolicy(username)
+	if err != nil {
+		return 0, err
+	}
+
+	if policy.MaxFailures <= 0 {
+		return NoLockout, nil
+	}
+
+	res, err := c.rpc("user_status", []string{username}, Options{"all": true})
+	if err != nil {
+		return 0, err
+	}
+
+	failures := 0
+	gjson.ParseBytes(res.Result.Data).ForEach(func(_, value gjson.Result) bool {
+		if n := policy.failedLogins(value); n > failures {
+			failures = n
+		}
+		return true
+	})
+
+	if failures >= policy.MaxFailures {
+		return 0, nil
+	}
+
+	return policy.MaxFailures - failures, nil
+}
+
+// Returns the failed login count from a single server's user_status entry,
+// ignoring failures the KDC would reset
+func (p *PasswordPolicy) failedLogins(status gjson.Result) int {
+	count := int(status.Get("krbloginfailedcount.0").Int())
+	if count == 0 {
+		return 0
+	}
+
+	last := parseTimeAttr(status, "krblastfailedauth").Time
+	if last.IsZero() {
+		return count
+	}
+
+	now := parseTimeAttr(status, "now").Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if count >= p.MaxFailures {
+		if p.LockoutTime > 0 && !now.Before(last.Add(p.LockoutTime.Duration())) {
+			return 0
+		}
+		return count
+	}
+
+	if p.FailInterval > 0 && now.After(last.Add(p.FailInterval.Duration())) {
+		return 0
+	}
+
+	return count
+}
+
+func (c *Client) pwpolicyShow(params []string, options Options) (*PasswordPolicy, error) {
+	res, err := c.rpc("pwpolicy_show", params, options)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(PasswordPolicy)
+	err = policy.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
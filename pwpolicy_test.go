@@ -0,0 +1,110 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestPasswordPolicyShow(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	policy, err := c.PasswordPolicyShow("")
+	require.NoError(err)
+
+	assert.Equalf("global_policy", policy.Group, "Global password policy not returned")
+	assert.Greaterf(policy.MaxLife, 0, "Max lifetime should be set")
+}
+
+func TestPasswordPolicyDurations(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"cn": ["global_policy"], "krbmaxpwdlife": ["90"], "krbminpwdlife": ["1"], "krbpwdfailurecountinterval": ["60"], "krbpwdlockoutduration": ["600"]}, "value": "global_policy"}`))
+
+	policy, err := c.PasswordPolicyShow("")
+	require.NoError(err)
+	assert.Equal(90*24*time.Hour, policy.MaxLifetime.Duration())
+	assert.Equal(time.Hour, policy.MinLifetime.Duration())
+	assert.Equal(time.Minute, policy.FailInterval.Duration())
+	assert.Equal(10*time.Minute, policy.LockoutTime.Duration())
+	assert.Equal(90, policy.MaxLife)
+	assert.Equal(600, policy.LockoutDuration)
+}
+
+func TestAttemptsRemaining(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	maxFailures := "5"
+	status := ""
+	var policyUser string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+
+		switch req.Method {
+		case "pwpolicy_show":
+			var options struct {
+				User string `json:"user"`
+			}
+			json.Unmarshal(req.Params[1], &options)
+			policyUser = options.User
+			stubResult(`{"result": {"cn": ["staff"], "cospriority": ["10"], "krbpwdmaxfailure": ["`+maxFailures+`"],
+				"krbpwdfailurecountinterval": ["60"], "krbpwdlockoutduration": ["600"]}, "summary": null, "value": "staff"}`)(w, r)
+		case "user_status":
+			stubResult(`{"result": [`+status+`], "count": 2, "truncated": false, "summary": "Account disabled: False"}`)(w, r)
+		}
+	})
+
+	status = `{"server": "ipa1.local", "krbloginfailedcount": ["1"], "krblastfailedauth": [{"__datetime__": "20240101115930Z"}], "now": "20240101120000Z"},
+		{"server": "ipa2.local", "krbloginfailedcount": ["3"], "krblastfailedauth": [{"__datetime__": "20240101115950Z"}], "now": "20240101120000Z"}`
+	n, err := c.AttemptsRemaining("jdoe")
+	require.NoError(err)
+	assert.Equal("jdoe", policyUser)
+	assert.Equalf(2, n, "Highest failure count across servers should be used")
+
+	status = `{"server": "ipa1.local", "krbloginfailedcount": ["1"], "krblastfailedauth": [{"__datetime__": "20240101115930Z"}], "now": "20240101120000Z"},
+		{"server": "ipa2.local", "krbloginfailedcount": ["3"], "krblastfailedauth": [{"__datetime__": "20240101115000Z"}], "now": "20240101120000Z"}`
+	n, err = c.AttemptsRemaining("jdoe")
+	require.NoError(err)
+	assert.Equalf(4, n, "Failures older than the reset interval should not be counted")
+
+	status = `{"server": "ipa1.local", "krbloginfailedcount": ["5"], "krblastfailedauth": [{"__datetime__": "20240101115500Z"}], "now": "20240101120000Z"}`
+	n, err = c.AttemptsRemaining("jdoe")
+	require.NoError(err)
+	assert.Equalf(0, n, "Locked out user should have no attempts remaining")
+
+	status = `{"server": "ipa1.local", "krbloginfailedcount": ["5"], "krblastfailedauth": [{"__datetime__": "20240101114500Z"}], "now": "20240101120000Z"}`
+	n, err = c.AttemptsRemaining("jdoe")
+	require.NoError(err)
+	assert.Equalf(5, n, "Expired lockouts should not be counted")
+
+	maxFailures = "0"
+	n, err = c.AttemptsRemaining("jdoe")
+	require.NoError(err)
+	assert.Equal(ipa.NoLockout, n)
+}
+
+func TestUserPasswordPolicyDN(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"uid": ["jdoe"], "krbpwdpolicyreference": ["cn=staff,cn=LOCAL,cn=kerberos,dc=local"]}, "summary": null, "value": "jdoe"}`))
+
+	rec, err := c.UserShow("jdoe")
+	require.NoError(err)
+	assert.Equal("cn=staff,cn=LOCAL,cn=kerberos,dc=local", rec.PasswordPolicyDN)
+}
@@ -0,0 +1,246 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Client methods which mutate the directory, called with valid arguments.
+// Every exported Client method must be listed here or in
+// nonMutatingMethods.
+func mutatingWrappers(c *ipa.Client) map[string]func() error {
+	user := func() *ipa.User { return &ipa.User{Username: "jdoe", First: "John", Last: "Doe"} }
+	access := ipa.KeytabAccess{Users: []string{"jdoe"}}
+	future := time.Now().Add(24 * time.Hour)
+	extra := ipa.ExtraAttrs{Set: map[string][]string{"description": {"x"}}}
+	decommission := func() error {
+		r, _ := c.HostDecommission("web.local", ipa.DecommissionOptions{})
+		return r.Failed()[0].Err
+	}
+
+	return map[string]func() error{
+		"AddOTPToken":     func() error { _, err := c.AddOTPToken(&ipa.OTPToken{Owner: "jdoe"}); return err },
+		"AddUserToGroup":  func() error { _, err := c.AddUserToGroup("staff", "jdoe"); return err },
+		"AddUsersToGroup": func() error { _, err := c.AddUsersToGroup("staff", "jdoe"); return err },
+		"AutomountKeyAdd": func() error {
+			_, err := c.AutomountKeyAdd("default", "auto.home", "jdoe", "nfs:/home/jdoe")
+			return err
+		},
+		"AutomountKeyDel": func() error { return c.AutomountKeyDel("default", "auto.home", "jdoe") },
+		"AutomountKeyMod": func() error {
+			_, err := c.AutomountKeyMod("default", "auto.home", "jdoe", "nfs:/home/jdoe")
+			return err
+		},
+		"AutomountLocationAdd":          func() error { return c.AutomountLocationAdd("default") },
+		"AutomountLocationDel":          func() error { return c.AutomountLocationDel("default") },
+		"AutomountMapAdd":               func() error { _, err := c.AutomountMapAdd("default", "auto.home"); return err },
+		"AutomountMapDel":               func() error { return c.AutomountMapDel("default", "auto.home") },
+		"ChangePassword":                func() error { return c.ChangePassword("jdoe", "old", "new", "") },
+		"ChangePasswordDetailed":        func() error { _, err := c.ChangePasswordDetailed("jdoe", "old", "new", ""); return err },
+		"ChangePasswordWithExpiration":  func() error { _, err := c.ChangePasswordWithExpiration("jdoe", "old", "new", ""); return err },
+		"DisableHbacRule":               func() error { return c.DisableHbacRule("allow_all") },
+		"DisableOTPToken":               func() error { return c.DisableOTPToken("tok") },
+		"DisableSudoRule":               func() error { return c.DisableSudoRule("admins") },
+		"EnableHbacRule":                func() error { return c.EnableHbacRule("allow_all") },
+		"EnableOTPToken":                func() error { return c.EnableOTPToken("tok") },
+		"EnableSudoRule":                func() error { return c.EnableSudoRule("admins") },
+		"ExtendUserExpiration":          func() error { return c.ExtendUserExpiration("jdoe", future) },
+		"GroupAdd":                      func() error { _, err := c.GroupAdd("staff", ""); return err },
+		"GroupAddIdempotent":            func() error { _, _, err := c.GroupAddIdempotent("staff", ipa.GroupAddOptions{}); return err },
+		"GroupAddWithOptions":           func() error { _, err := c.GroupAddWithOptions("staff", ipa.GroupAddOptions{}); return err },
+		"GroupDelete":                   func() error { return c.GroupDelete("staff", false) },
+		"GroupMod":                      func() error { _, err := c.GroupMod("staff", extra); return err },
+		"GroupRemoveMemberSafe":         func() error { _, err := c.GroupRemoveMemberSafe("staff", true, "jdoe"); return err },
+		"GroupRename":                   func() error { _, err := c.GroupRename("staff", "faculty"); return err },
+		"GroupSyncMembers":              func() error { _, err := c.GroupSyncMembers("staff", []string{"jdoe"}); return err },
+		"HbacRuleAddService":            func() error { _, err := c.HbacRuleAddService("allow_all", []string{"sshd"}, nil); return err },
+		"HbacRuleRemoveService":         func() error { _, err := c.HbacRuleRemoveService("allow_all", []string{"sshd"}, nil); return err },
+		"HbacSvcAdd":                    func() error { _, err := c.HbacSvcAdd("sshd", ""); return err },
+		"HbacSvcDel":                    func() error { return c.HbacSvcDel("sshd") },
+		"HbacSvcGroupAdd":               func() error { _, err := c.HbacSvcGroupAdd("remote"); return err },
+		"HbacSvcGroupAddMember":         func() error { _, err := c.HbacSvcGroupAddMember("remote", "sshd"); return err },
+		"HbacSvcGroupDel":               func() error { return c.HbacSvcGroupDel("remote") },
+		"HbacSvcGroupRemoveMember":      func() error { _, err := c.HbacSvcGroupRemoveMember("remote", "sshd"); return err },
+		"HostAdd":                       func() error { _, err := c.HostAdd("web.local", "", true, true); return err },
+		"HostAddBulk":                   func() error { r, _ := c.HostAddBulk([]*ipa.HostSpec{{FQDN: "web.local"}}, 1); return r[0].Err },
+		"HostAddIdempotent":             func() error { _, _, err := c.HostAddIdempotent("web.local", "", true, true); return err },
+		"HostAddManagedBy":              func() error { _, err := c.HostAddManagedBy("web.local", "db.local"); return err },
+		"HostDecommission":              decommission,
+		"HostDel":                       func() error { return c.HostDel("web.local", ipa.HostDelOptions{}) },
+		"HostDisable":                   func() error { return c.HostDisable("web.local") },
+		"HostGroupAdd":                  func() error { _, err := c.HostGroupAdd("webservers", ""); return err },
+		"HostGroupAddMember":            func() error { _, err := c.HostGroupAddMember("webservers", "web.local"); return err },
+		"HostGroupAddWithOptions":       func() error { _, err := c.HostGroupAddWithOptions("webservers", ipa.HostGroupAddOptions{}); return err },
+		"HostGroupDelete":               func() error { return c.HostGroupDelete("webservers", false) },
+		"HostGroupRemoveMember":         func() error { _, err := c.HostGroupRemoveMember("webservers", "web.local"); return err },
+		"HostMod":                       func() error { _, err := c.HostMod("web.local", extra); return err },
+		"HostRemoveManagedBy":           func() error { _, err := c.HostRemoveManagedBy("web.local", "db.local"); return err },
+		"HostSetSSHKeys":                func() error { return c.HostSetSSHKeys("web.local", nil) },
+		"MigratePassword":               func() error { return c.MigratePassword("jdoe", "secret") },
+		"OTPTokenAddManagedBy":          func() error { _, err := c.OTPTokenAddManagedBy("tok", "jdoe"); return err },
+		"OTPTokenRemoveManagedBy":       func() error { _, err := c.OTPTokenRemoveManagedBy("tok", "jdoe"); return err },
+		"OTPTokenSetOwner":              func() error { _, err := c.OTPTokenSetOwner("tok", "jdoe"); return err },
+		"RemoveOTPToken":                func() error { return c.RemoveOTPToken("tok") },
+		"RemoveUserFromGroup":           func() error { _, err := c.RemoveUserFromGroup("staff", "jdoe"); return err },
+		"RemoveUsersFromGroup":          func() error { _, err := c.RemoveUsersFromGroup("staff", "jdoe"); return err },
+		"ResetPassword":                 func() error { _, err := c.ResetPassword("jdoe"); return err },
+		"ResetPasswordDetailed":         func() error { _, _, err := c.ResetPasswordDetailed("jdoe"); return err },
+		"ResetPasswordLocal":            func() error { _, err := c.ResetPasswordLocal("jdoe"); return err },
+		"ServiceAddHost":                func() error { _, err := c.ServiceAddHost("HTTP/web.local", "db.local"); return err },
+		"ServiceAllowCreateKeytab":      func() error { _, err := c.ServiceAllowCreateKeytab("HTTP/web.local", access); return err },
+		"ServiceAllowRetrieveKeytab":    func() error { _, err := c.ServiceAllowRetrieveKeytab("HTTP/web.local", access); return err },
+		"ServiceDisallowCreateKeytab":   func() error { _, err := c.ServiceDisallowCreateKeytab("HTTP/web.local", access); return err },
+		"ServiceDisallowRetrieveKeytab": func() error { _, err := c.ServiceDisallowRetrieveKeytab("HTTP/web.local", access); return err },
+		"ServiceRemoveHost":             func() error { _, err := c.ServiceRemoveHost("HTTP/web.local", "db.local"); return err },
+		"SetAuthTypes":                  func() error { _, err := c.SetAuthTypes("jdoe", []string{ipa.AuthTypeOTP}); return err },
+		"SetEnabled":                    func() error { return c.SetEnabled("sudorule", "admins", false) },
+		"SetEntryEnabled":               func() error { return c.SetEntryEnabled(ipa.EntryRef{Entity: "hbacrule", Name: "allow_all"}, false) },
+		"SetPassword":                   func() error { return c.SetPassword("jdoe", "old", "new", "") },
+		"SetUserClass":                  func() error { _, err := c.SetUserClass("jdoe", "contractor"); return err },
+		"SudoRuleAddOption":             func() error { return c.SudoRuleAddOption("admins", "!authenticate") },
+		"SudoRuleAddRunAsGroup":         func() error { return c.SudoRuleAddRunAsGroup("admins", "wheel") },
+		"SudoRuleAddRunAsUser":          func() error { return c.SudoRuleAddRunAsUser("admins", "root") },
+		"SudoRuleRemoveOption":          func() error { return c.SudoRuleRemoveOption("admins", "!authenticate") },
+		"SudoRuleRemoveRunAsGroup":      func() error { return c.SudoRuleRemoveRunAsGroup("admins", "wheel") },
+		"SudoRuleRemoveRunAsUser":       func() error { return c.SudoRuleRemoveRunAsUser("admins", "root") },
+		"SudoRuleSetRunAsGroupCategory": func() error { return c.SudoRuleSetRunAsGroupCategory("admins", ipa.CategoryAll) },
+		"SudoRuleSetRunAsUserCategory":  func() error { return c.SudoRuleSetRunAsUserCategory("admins", ipa.CategoryAll) },
+		"UserAdd":                       func() error { _, err := c.UserAdd(user(), false); return err },
+		"UserAddIdempotent":             func() error { _, _, err := c.UserAddIdempotent(user(), false); return err },
+		"UserAddPasskey":                func() error { _, err := c.UserAddPasskey("jdoe", "passkey:abc"); return err },
+		"UserAddTemporary":              func() error { _, err := c.UserAddTemporary(user(), future, false); return err },
+		"UserAddWithPassword":           func() error { _, err := c.UserAddWithPassword(user(), "secret"); return err },
+		"UserDelete":                    func() error { return c.UserDelete(false, true, "jdoe") },
+		"UserDeleteDetailed":            func() error { _, err := c.UserDeleteDetailed(ipa.UserDeleteOptions{}, "jdoe"); return err },
+		"UserDisable":                   func() error { _, err := c.UserDisable("jdoe"); return err },
+		"UserEnable":                    func() error { _, err := c.UserEnable("jdoe"); return err },
+		"UserMod":                       func() error { _, err := c.UserMod(user()); return err },
+		"UserModGuarded": func() error {
+			loaded, err := c.UserShow("jdoe", ipa.WithETag())
+			if err != nil {
+				return err
+			}
+			_, err = c.UserModGuarded(loaded)
+			return err
+		},
+		"UserModOptions":    func() error { _, err := c.UserModOptions("jdoe", ipa.Options{"loginshell": "/bin/zsh"}); return err },
+		"UserRemovePasskey": func() error { _, err := c.UserRemovePasskey("jdoe", "passkey:abc"); return err },
+		"UserRename":        func() error { _, err := c.UserRename("jdoe", "jsmith"); return err },
+		"UserSetLocked":     func() error { return c.UserSetLocked("jdoe", true) },
+		"UserSetSSHKeys":    func() error { _, err := c.UserSetSSHKeys("jdoe", nil); return err },
+	}
+}
+
+// Client methods which read from FreeIPA, change client settings or
+// authenticate and are allowed on read-only clients
+var nonMutatingMethods = []string{
+	"ACMEEnabled", "AttemptsRemaining", "AutomountKeyFind", "AutomountMapFind",
+	"CertProfileFind", "CertProfileShow", "CheckAccess", "ClearSession", "Clone",
+	"Close", "CommandMetadata", "Configure", "Diagnostics", "DryRun",
+	"EffectiveGroupMembers",
+	"EffectivePasswordPolicy", "Exists", "ExpiredUsers", "FetchOTPTokens",
+	"GroupExists", "GroupMemberCount", "GroupNesting", "GroupReferences",
+	"GroupShow", "GroupShowWithOptions", "HbacRulesForHost", "HbacSvcFind",
+	"Host", "HostExists", "HostGroupExists", "HostGroupReferences",
+	"HostGroupsForHost", "HostReferences", "HostShow", "HostShowWithOptions",
+	"Impersonate", "IsAdmin", "IsRetryable", "KeepPassword", "KerberosClient",
+	"KerberosValidUntil", "LastCallDuration", "LastCallReusedConn",
+	"LeaseKrbClient",
+	"ListCommands", "LocationFind", "Login", "LoginFromCCache", "LoginWithKeytab",
+	"MFAStatusEach", "MFAStatusReport",
+	"MigrationEnabled", "NeedsPasswordMigration", "OTPTokenSearch",
+	"PasswordPolicyShow", "Ping", "Realm", "RemoteLogin", "Renew",
+	"RequirePrincipal", "ServerFind", "ServerRoleFind", "ServiceShow",
+	"ServiceShowWithOptions", "SessionID", "SetCaseSensitiveGroups",
+	"SetConnectionEvents", "SetDryRunSink", "SetFindOptions", "SetKrbClient",
+	"SetLDAPSearcher", "SetMaxFindResponseSize", "SetMaxResponseSize",
+	"SetMutationGuard", "SetObserver",
+	"SetProtectedGroups", "SetReadOnly", "SetRetryableCodes",
+	"SetSessionLogin", "SetSessionValidator", "SetSlowCallThreshold",
+	"SetTraceWriter",
+	"StickySession", "SudoRuleShow", "SudoRulesForUser", "SupportsCommand",
+	"TLSConfig", "TokenComplianceCheck",
+	"TrustConfigShow",
+	"UseJSONRPC2", "UseKrbClient", "UserExists", "UserFind", "UserFindScope",
+	"UserPrimaryGroup", "UserReferences", "UserSearch", "UserShow",
+	"UserShowByPrincipal", "UserShowFast", "UserShowWithOptions",
+	"UsersByClass",
+	"UsersWithExpiringPasswords", "VerifyPrincipal", "Warm", "WatchEntries",
+	"WithAttemptTimeout", "WithCallTimeout",
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var sent []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		sent = append(sent, req.Method)
+
+		if strings.HasSuffix(req.Method, "_find") {
+			stubResult(`{"count": 0, "result": [], "summary": null, "truncated": false}`)(w, r)
+			return
+		}
+		stubResult(`{"result": {"uid": ["jdoe"], "cn": ["staff"], "fqdn": ["web.local"],
+			"modifytimestamp": [{"__datetime__": "20230105120000Z"}]}, "summary": null, "value": "jdoe"}`)(w, r)
+	})
+	c.SetReadOnly(true)
+
+	wrappers := mutatingWrappers(c)
+	classified := map[string]bool{}
+	for _, name := range nonMutatingMethods {
+		classified[name] = true
+	}
+
+	// New wrappers must be classified so they are covered by this test
+	client := reflect.TypeOf(c)
+	for i := 0; i < client.NumMethod(); i++ {
+		name := client.Method(i).Name
+		_, mutating := wrappers[name]
+		assert.Truef(mutating != classified[name], "Client.%s must be listed once in mutatingWrappers or nonMutatingMethods", name)
+	}
+
+	for name, call := range wrappers {
+		sent = nil
+		err := call()
+		assert.Truef(errors.Is(err, ipa.ErrReadOnlyClient), "%s should fail on a read-only client: %v", name, err)
+		for _, method := range sent {
+			assert.Falsef(ipa.IsMutatingMethod(method), "%s sent mutating method %s", name, method)
+		}
+	}
+
+	sent = nil
+	_, err := c.UserShow("jdoe")
+	require.NoError(err)
+	_, err = c.Clone().UserDisable("jdoe")
+	assert.True(errors.Is(err, ipa.ErrReadOnlyClient))
+	assert.Equal([]string{"user_show"}, sent)
+
+	c.SetReadOnly(false)
+	_, err = c.UserDisable("jdoe")
+	require.NoError(err)
+}
+
+func TestIsMutatingMethod(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, method := range []string{"user_show", "user_find", "ping", "json_metadata", "user_status", "config_show"} {
+		assert.Falsef(ipa.IsMutatingMethod(method), "%s should not be mutating", method)
+	}
+	for _, method := range []string{"user_add", "user_mod", "group_remove_member", "passwd", "change_password", "migration", "unknown_command"} {
+		assert.Truef(ipa.IsMutatingMethod(method), "%s should be mutating", method)
+	}
+}
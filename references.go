@@ -0,0 +1,144 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrHasReferences is returned when deleting an entry which is still
+// referenced by rules or roles
+var ErrHasReferences = errors.New("entry has references")
+
+// References lists the HBAC rules, sudo rules and roles which reference an
+// entry as a direct member
+type References struct {
+	HbacRules []string
+	SudoRules []string
+	Roles     []string
+}
+
+// Returns true if there are no references
+func (r *References) Empty() bool {
+	return len(r.HbacRules) == 0 && len(r.SudoRules) == 0 && len(r.Roles) == 0
+}
+
+func (r *References) String() string {
+	parts := []string{}
+	if len(r.HbacRules) > 0 {
+		parts = append(parts, "hbacrules: "+strings.Join(r.HbacRules, ", "))
+	}
+	if len(r.SudoRules) > 0 {
+		parts = append(parts, "sudorules: "+strings.Join(r.SudoRules, ", "))
+	}
+	if len(r.Roles) > 0 {
+		parts = append(parts, "roles: "+strings.Join(r.Roles, ", "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// ReferencesError is returned when deleting an entry which is still
+// referenced. It wraps ErrHasReferences.
+type ReferencesError struct {
+	Name       string
+	References *References
+}
+
+func (e *ReferencesError) Error() string {
+	return fmt.Sprintf("ipa: %s: %s is referenced by %s", ErrHasReferences, e.Name, e.References)
+}
+
+func (e *ReferencesError) Unwrap() error {
+	return ErrHasReferences
+}
+
+// Fetch the HBAC rules, sudo rules and roles which have group cn as a direct
+// member
+func (c *Client) GroupReferences(cn string) (*References, error) {
+	return c.references("group", cn)
+}
+
+// Fetch the HBAC rules, sudo rules and roles which have host group name as a
+// direct member
+func (c *Client) HostGroupReferences(name string) (*References, error) {
+	return c.references("hostgroup", name)
+}
+
+// Fetch the HBAC rules, sudo rules and roles which have host fqdn as a direct
+// member
+func (c *Client) HostReferences(fqdn string) (*References, error) {
+	return c.references("host", fqdn)
+}
+
+// Fetch the HBAC rules, sudo rules and roles which have user username as a
+// direct member
+func (c *Client) UserReferences(username string) (*References, error) {
+	return c.references("user", username)
+}
+
+// Find the rules and roles with member filter key set to value. References
+// through nested groups and rules applying by category are not included.
+func (c *Client) references(key, value string) (*References, error) {
+	refs := &References{}
+	for _, find := range []struct {
+		method string
+		names  *[]string
+	}{
+		{"hbacrule_find", &refs.HbacRules},
+		{"sudorule_find", &refs.SudoRules},
+		{"role_find", &refs.Roles},
+	} {
+		records, err := c.findRules(find.method, []Options{{key: value}})
+		if err != nil {
+			return nil, err
+		}
+
+		names := []string{}
+		for _, rec := range records {
+			names = append(names, rec.Get("cn.0").String())
+		}
+		sort.Strings(names)
+		*find.names = names
+	}
+
+	return refs, nil
+}
+
+// Delete group cn. If failOnReferences is true the group is not deleted and a
+// *ReferencesError is returned if any HBAC rules, sudo rules or roles
+// reference it.
+func (c *Client) GroupDelete(cn string, failOnReferences bool) error {
+	return c.deleteUnreferenced("group_del", cn, failOnReferences, c.GroupReferences)
+}
+
+// Delete host group name. If failOnReferences is true the host group is not
+// deleted and a *ReferencesError is returned if any HBAC rules, sudo rules or
+// roles reference it.
+func (c *Client) HostGroupDelete(name string, failOnReferences bool) error {
+	return c.deleteUnreferenced("hostgroup_del", name, failOnReferences, c.HostGroupReferences)
+}
+
+func (c *Client) deleteUnreferenced(method, name string, failOnReferences bool, references func(string) (*References, error)) error {
+	if failOnReferences {
+		refs, err := references(name)
+		if err != nil {
+			return err
+		}
+		if !refs.Empty() {
+			return &ReferencesError{Name: name, References: refs}
+		}
+	}
+
+	_, err := c.rpc(method, []string{name}, Options{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newReferencesStub(t *testing.T, methods *[]string) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		options := req.Options
+		*methods = append(*methods, req.Method)
+
+		switch {
+		case req.Method == "hbacrule_find" && options["group"] == "staff":
+			stubResult(`{"result": [{"cn": ["staff_ssh"]}, {"cn": ["staff_console"]}], "count": 2, "truncated": false}`)(w, r)
+		case req.Method == "role_find" && options["group"] == "staff":
+			stubResult(`{"result": [{"cn": ["helpdesk"]}], "count": 1, "truncated": false}`)(w, r)
+		case req.Method == "sudorule_find" && options["hostgroup"] == "big":
+			stubResult(`{"result": [], "count": 100, "truncated": true}`)(w, r)
+		case req.Method == "group_del" || req.Method == "hostgroup_del":
+			stubResult(`{"result": {"failed": []}, "summary": "Deleted", "value": ["x"]}`)(w, r)
+		default:
+			stubResult(`{"result": [], "count": 0, "truncated": false}`)(w, r)
+		}
+	})
+}
+
+func TestGroupReferences(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	c := newReferencesStub(t, &methods)
+
+	refs, err := c.GroupReferences("staff")
+	require.NoError(err)
+	assert.Equal([]string{"staff_console", "staff_ssh"}, refs.HbacRules)
+	assert.Empty(refs.SudoRules)
+	assert.Equal([]string{"helpdesk"}, refs.Roles)
+	assert.False(refs.Empty())
+	assert.Equal([]string{"hbacrule_find", "sudorule_find", "role_find"}, methods)
+
+	refs, err = c.UserReferences("jdoe")
+	require.NoError(err)
+	assert.True(refs.Empty())
+
+	_, err = c.HostGroupReferences("big")
+	assert.ErrorIs(err, ipa.ErrTruncated)
+}
+
+func TestGroupDeleteReferences(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	c := newReferencesStub(t, &methods)
+
+	err := c.GroupDelete("staff", true)
+	require.ErrorIs(err, ipa.ErrHasReferences)
+	var rerr *ipa.ReferencesError
+	require.True(errors.As(err, &rerr))
+	assert.Equal("staff", rerr.Name)
+	assert.Equal([]string{"helpdesk"}, rerr.References.Roles)
+	assert.NotContains(methods, "group_del")
+
+	// Without the check the group is deleted regardless
+	methods = nil
+	require.NoError(c.GroupDelete("staff", false))
+	assert.Equal([]string{"group_del"}, methods)
+
+	methods = nil
+	require.NoError(c.HostGroupDelete("web", true))
+	assert.Equal([]string{"hbacrule_find", "sudorule_find", "role_find", "hostgroup_del"}, methods)
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum time a call waits for another goroutine's session login
+	reloginWait = 30 * time.Second
+
+	// Delay after the first failed session login, doubled after each
+	// consecutive failure up to reloginMaxBackoff
+	reloginBackoff    = time.Second
+	reloginMaxBackoff = time.Minute
+)
+
+// Session login state shared by all goroutines using a client. At most one
+// login is in flight, see Client.relogin
+type reloginState struct {
+	mu   sync.Mutex
+	call *reloginCall
+
+	// Consecutive failed logins, the last error and when the next login may
+	// be attempted
+	failures int
+	lastErr  error
+	next     time.Time
+
+	// Total login attempts and failures reported to the observer
+	attempts int64
+	failed   int64
+}
+
+// A session login in flight. done is closed once err is set
+type reloginCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Set the function called to log in when FreeIPA rejects a call with HTTP
+// 401, for example because the session expired. The call is retried once
+// with the new session. Concurrent calls share a single login, and after a
+// failed login further logins are delayed with an exponential backoff,
+// failing calls in the meantime. Clients using kerberos log in again with
+// their credentials without setting login. Each login emits an
+// EventSessionLogin event.
+//
+//	c.SetSessionLogin(func(c *ipa.Client) error {
+//		return c.RemoteLogin(uid, passwd)
+//	})
+func (c *Client) SetSessionLogin(login func(c *Client) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessLogin = login
+}
+
+// Returns true if a request rejected with sessionID, which may be empty, can
+// be retried after logging in again
+func (c *Client) canRelogin(sessionID string, krbClient KrbClient) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sessLogin != nil || (len(sessionID) > 0 && krbClient != nil)
+}
+
+// Returns true if the client has a session other than stale
+func (c *Client) sessionReplaced(stale string) bool {
+	sessionID := c.SessionID()
+	return len(sessionID) > 0 && sessionID != stale
+}
+
+// Log in again after FreeIPA rejected session stale. Only one goroutine logs
+// in, others wait for its result. Returns nil without logging in if the
+// session was already replaced.
+func (c *Client) relogin(stale string, krbClient KrbClient) error {
+	// Derived clients share the login state of the client holding the session
+	o := c.sessionOwner()
+	o.mu.Lock()
+	if o.reloginState == nil {
+		o.reloginState = &reloginState{}
+	}
+	s := o.reloginState
+	o.mu.Unlock()
+
+	s.mu.Lock()
+	if c.sessionReplaced(stale) {
+		s.mu.Unlock()
+		return nil
+	}
+	if call := s.call; call != nil {
+		s.mu.Unlock()
+		return c.waitRelogin(call)
+	}
+	if wait := time.Until(s.next); s.failures > 0 && wait > 0 {
+		err := fmt.Errorf("%w (%d consecutive failures, next attempt in %s)", s.lastErr, s.failures, wait.Round(time.Millisecond))
+		s.mu.Unlock()
+		return err
+	}
+	call := &reloginCall{done: make(chan struct{})}
+	s.call = call
+	s.attempts++
+	s.mu.Unlock()
+
+	o.mu.Lock()
+	if o.sessionID == stale {
+		o.sessionID = ""
+	}
+	o.mu.Unlock()
+
+	c.mu.RLock()
+	login := c.sessLogin
+	c.mu.RUnlock()
+
+	var err error
+	if login != nil {
+		err = login(c)
+	} else {
+		// Without a session the ping is authenticated with SPNEGO and
+		// FreeIPA sets a new session cookie
+		_, err = c.call("ping", []string{}, nil, false)
+	}
+	if err != nil {
+		err = fmt.Errorf("ipa: %w: session login failed: %w", ErrUnauthorized, err)
+	}
+
+	s.mu.Lock()
+	if err != nil {
+		s.failed++
+		s.failures++
+		s.lastErr = err
+		backoff := reloginBackoff
+		for i := 1; i < s.failures && backoff < reloginMaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > reloginMaxBackoff {
+			backoff = reloginMaxBackoff
+		}
+		s.next = time.Now().Add(backoff)
+	} else {
+		s.failures = 0
+		s.lastErr = nil
+		s.next = time.Time{}
+	}
+	s.call = nil
+	attempts, failed := s.attempts, s.failed
+	call.err = err
+	close(call.done)
+	s.mu.Unlock()
+
+	c.notify(&Event{Type: EventSessionLogin, Err: err, LoginAttempts: attempts, LoginFailures: failed})
+
+	return err
+}
+
+// Wait for the session login call by another goroutine, bounded by
+// reloginWait and the call deadline
+func (c *Client) waitRelogin(call *reloginCall) error {
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	timer := time.NewTimer(reloginWait)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-timer.C:
+		return fmt.Errorf("ipa: %w: timed out after %s waiting for session login", ErrUnauthorized, reloginWait)
+	case <-ctx.Done():
+		return fmt.Errorf("ipa: waiting for session login: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Stub FreeIPA server whose sessions expire after a number of requests
+type expiringSessions struct {
+	mu       sync.Mutex
+	uses     int
+	fail     bool
+	sessions map[string]int
+	logins   int
+	expired  int
+	rejected int
+}
+
+func (s *expiringSessions) handler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/ipa/session/login_password" {
+		s.logins++
+		if s.fail {
+			w.Header().Set("X-IPA-Rejection-Reason", "invalid-password")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		session := fmt.Sprintf("%032d", s.logins)
+		s.sessions[session] = s.uses
+		w.Header().Set("Set-Cookie", fmt.Sprintf("ipa_session=%s; Path=/ipa; HttpOnly; Secure", session))
+		return
+	}
+
+	session := strings.TrimPrefix(r.Header.Get("Cookie"), "ipa_session=")
+	if s.sessions[session] == 0 {
+		s.rejected++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.sessions[session]--
+	if s.sessions[session] == 0 {
+		s.expired++
+	}
+
+	stubResult(`{"summary": "IPA server version 4.9.8", "value": null, "result": null}`)(w, r)
+}
+
+// Returns a client logging in to a stub server whose sessions expire after
+// uses requests, and the events it emits
+func newExpiringSessionStub(t *testing.T, uses int) (*ipa.Client, *expiringSessions, func() []*ipa.Event) {
+	stub := &expiringSessions{uses: uses, sessions: make(map[string]int)}
+	c := newTestClientStub(t, stub.handler)
+	c.SetSessionLogin(func(c *ipa.Client) error {
+		return c.RemoteLogin("admin", "secret")
+	})
+
+	var mu sync.Mutex
+	var events []*ipa.Event
+	c.SetObserver(func(event *ipa.Event) {
+		if event.Type == ipa.EventSessionLogin {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+	})
+
+	return c, stub, func() []*ipa.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*ipa.Event{}, events...)
+	}
+}
+
+func TestSessionRelogin(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, stub, events := newExpiringSessionStub(t, 2)
+
+	_, err := c.Ping()
+	require.NoErrorf(err, "Client without a session should log in")
+	_, err = c.Ping()
+	require.NoError(err)
+	_, err = c.Ping()
+	require.NoErrorf(err, "Expired session should be replaced")
+
+	assert.Equal(2, stub.logins)
+	assert.Equal(2, stub.rejected)
+	require.Len(events(), 2)
+	assert.Equal(int64(2), events()[1].LoginAttempts)
+	assert.Equal(int64(0), events()[1].LoginFailures)
+	assert.NoError(events()[1].Err)
+}
+
+func TestSessionReloginGuard(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, stub, _ := newExpiringSessionStub(t, 1)
+	require.NoError(c.RemoteLogin("admin", "secret"))
+	_, err := c.Ping()
+	require.NoError(err)
+
+	guarded := 0
+	c.SetMutationGuard(func(method string, params []string, options ipa.Options) error {
+		guarded++
+		return nil
+	})
+
+	require.NoError(c.UserDelete(false, true, "jdoe"))
+	assert.Equal(1, stub.rejected)
+	assert.Equalf(1, guarded, "Retry after a new login should not consult the guard again")
+}
+
+func TestSessionReloginStress(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	const workers = 8
+	const calls = 100
+	c, stub, events := newExpiringSessionStub(t, 50)
+	require.NoError(c.RemoteLogin("admin", "secret"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*calls)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				if _, err := c.Ping(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	assert.Equal(workers*calls/50, stub.expired)
+	assert.LessOrEqualf(stub.logins-1, stub.expired, "Each expired session should be replaced by at most one login, %d rejected calls", stub.rejected)
+	assert.Greater(stub.logins, 1)
+
+	ev := events()
+	require.Len(ev, stub.logins-1)
+	assert.Equal(int64(stub.logins-1), ev[len(ev)-1].LoginAttempts)
+	assert.Equal(int64(0), ev[len(ev)-1].LoginFailures)
+}
+
+func TestSessionReloginDerived(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	const workers = 8
+	const calls = 100
+	c, stub, events := newExpiringSessionStub(t, 50)
+	require.NoError(c.RemoteLogin("admin", "secret"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*calls)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				// Each call uses a new derived client, as callers setting a
+				// timeout per call do
+				if _, err := c.WithCallTimeout(10 * time.Second).Ping(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	assert.Equal(workers*calls/50, stub.expired)
+	assert.LessOrEqualf(stub.logins-1, stub.expired, "Derived clients should share session logins, %d rejected calls", stub.rejected)
+	assert.Equalf(fmt.Sprintf("%032d", stub.logins), c.SessionID(), "Sessions from derived clients should be written back")
+
+	ev := events()
+	require.Len(ev, stub.logins-1)
+	assert.Equal(int64(stub.logins-1), ev[len(ev)-1].LoginAttempts)
+}
+
+func TestSessionReloginBackoff(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, stub, events := newExpiringSessionStub(t, 1)
+	require.NoError(c.RemoteLogin("admin", "secret"))
+	_, err := c.Ping()
+	require.NoError(err)
+
+	stub.mu.Lock()
+	stub.fail = true
+	stub.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Ping()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.True(errors.Is(err, ipa.ErrUnauthorized), err)
+		assert.True(errors.Is(err, ipa.ErrInvalidPassword), err)
+	}
+
+	_, err = c.Ping()
+	assert.Truef(errors.Is(err, ipa.ErrInvalidPassword), "Calls during the backoff should fail with the last login error: %s", err)
+	assert.Contains(err.Error(), "next attempt in")
+
+	stub.mu.Lock()
+	assert.Equalf(2, stub.logins, "Failed login should not be retried during the backoff")
+	stub.mu.Unlock()
+
+	require.Len(events(), 1)
+	assert.Equal(int64(1), events()[0].LoginAttempts)
+	assert.Equal(int64(1), events()[0].LoginFailures)
+	assert.True(errors.Is(events()[0].Err, ipa.ErrInvalidPassword))
+}
@@ -0,0 +1,350 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ReportFormat is the output format of Report
+type ReportFormat int
+
+const (
+	// ReportCSV writes RFC 4180 CSV with a header row
+	ReportCSV ReportFormat = iota
+
+	// ReportJSONLines writes one JSON object per user
+	ReportJSONLines
+)
+
+// Report columns
+const (
+	ColumnUsername         = "username"
+	ColumnName             = "name"
+	ColumnEmail            = "email"
+	ColumnUid              = "uid"
+	ColumnGroups           = "groups"
+	ColumnIndirectGroups   = "indirect_groups"
+	ColumnAuthTypes        = "auth_types"
+	ColumnLocked           = "locked"
+	ColumnPreserved        = "preserved"
+	ColumnLastLogin        = "last_login"
+	ColumnLastFailedLogin  = "last_failed_login"
+	ColumnPasswordChanged  = "password_changed"
+	ColumnPasswordExpires  = "password_expires"
+	ColumnPrincipalExpires = "principal_expires"
+	ColumnOTPTokens        = "otp_tokens"
+	ColumnUserClasses      = "user_classes"
+)
+
+// DefaultReportColumns are written by Report if ReportOptions.Columns is empty
+var DefaultReportColumns = []string{
+	ColumnUsername,
+	ColumnName,
+	ColumnEmail,
+	ColumnGroups,
+	ColumnAuthTypes,
+	ColumnLocked,
+	ColumnLastLogin,
+	ColumnPasswordExpires,
+	ColumnOTPTokens,
+}
+
+// Returns the value of a report column for user u with tokens OTP tokens.
+// Values are string, []string, bool or int
+var reportColumns = map[string]func(u *User, tokens int) interface{}{
+	ColumnUsername:         func(u *User, _ int) interface{} { return u.Username },
+	ColumnName:             func(u *User, _ int) interface{} { return u.DisplayName },
+	ColumnEmail:            func(u *User, _ int) interface{} { return u.Email },
+	ColumnUid:              func(u *User, _ int) interface{} { return u.Uid },
+	ColumnGroups:           func(u *User, _ int) interface{} { return sortedCopy(u.Groups) },
+	ColumnIndirectGroups:   func(u *User, _ int) interface{} { return sortedCopy(u.IndirectGroups) },
+	ColumnAuthTypes:        func(u *User, _ int) interface{} { return sortedCopy(u.AuthTypes) },
+	ColumnLocked:           func(u *User, _ int) interface{} { return u.Locked },
+	ColumnPreserved:        func(u *User, _ int) interface{} { return u.Preserved },
+	ColumnLastLogin:        func(u *User, _ int) interface{} { return reportTime(u.LastLoginSuccess) },
+	ColumnLastFailedLogin:  func(u *User, _ int) interface{} { return reportTime(u.LastLoginFail) },
+	ColumnPasswordChanged:  func(u *User, _ int) interface{} { return reportTime(u.LastPasswdChange) },
+	ColumnPasswordExpires:  func(u *User, _ int) interface{} { return reportTime(u.PasswdExpire) },
+	ColumnPrincipalExpires: func(u *User, _ int) interface{} { return reportTime(u.PrincipalExpire) },
+	ColumnOTPTokens:        func(_ *User, tokens int) interface{} { return tokens },
+	ColumnUserClasses:      func(u *User, _ int) interface{} { return sortedCopy(u.Categories) },
+}
+
+// ReportOptions configures Report
+type ReportOptions struct {
+	Format ReportFormat
+
+	// Columns to write, in order. Defaults to DefaultReportColumns
+	Columns []string
+
+	// Include disabled users. Only enabled users are reported by default
+	IncludeDisabled bool
+
+	// Include preserved (soft deleted) users
+	IncludePreserved bool
+
+	// Only report members of Group, including indirect members through
+	// groups nested in it
+	Group string
+
+	// Number of users looked up concurrently. Defaults to 1
+	Concurrency int
+
+	// Maximum lookup requests per second across all workers, at most 1e9.
+	// 0 is unlimited
+	QPS float64
+}
+
+// Write an access review report of users to w. Usernames are searched for up
+// front, sorted, and each user and their OTP token count are then looked up
+// and written in turn, so rows are in a stable order and memory use does not
+// grow with the size of the directory. OTP tokens are only counted if the
+// otp_tokens column is requested. The default find size limit is not
+// applied; ErrTruncated is returned if the server truncates a search. The
+// first failed lookup stops the report and is returned.
+func Report(c *Client, opts ReportOptions, w io.Writer) error {
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = DefaultReportColumns
+	}
+	countTokens := false
+	for _, col := range columns {
+		if _, ok := reportColumns[col]; !ok {
+			return fmt.Errorf("ipa: invalid report column: %s", col)
+		}
+		if col == ColumnOTPTokens {
+			countTokens = true
+		}
+	}
+
+	var out reportWriter
+	switch opts.Format {
+	case ReportCSV:
+		cw := csv.NewWriter(w)
+		cw.UseCRLF = true
+		out = &csvReportWriter{w: cw, columns: columns}
+	case ReportJSONLines:
+		out = &jsonReportWriter{enc: json.NewEncoder(w), columns: columns}
+	default:
+		return fmt.Errorf("ipa: invalid report format: %d", opts.Format)
+	}
+
+	limit, stop, err := newRateLimit(opts.QPS)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	usernames, err := c.reportUsernames(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := out.header(); err != nil {
+		return err
+	}
+
+	// Rows are looked up by concurrent workers and written in order
+	return runOrdered(len(usernames), opts.Concurrency, func(i int, done <-chan struct{}) func() error {
+		r := c.reportRow(usernames[i], countTokens, limit, done)
+		return func() error {
+			if r.err != nil {
+				return r.err
+			}
+			return out.write(r.user, r.tokens)
+		}
+	})
+}
+
+// Returns the sorted usernames to report
+func (c *Client) reportUsernames(opts ReportOptions) ([]string, error) {
+	options := Options{
+		"sizelimit": 0,
+		"pkey_only": true,
+		"preserved": false,
+	}
+	if !opts.IncludeDisabled {
+		options["nsaccountlock"] = false
+	}
+	if opts.Group != "" {
+		options["in_group"] = opts.Group
+	}
+
+	usernames, err := c.findUsernames(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.IncludePreserved {
+		options["preserved"] = true
+		preserved, err := c.findUsernames(options)
+		if err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, preserved...)
+	}
+
+	sort.Strings(usernames)
+	return usernames, nil
+}
+
+func (c *Client) findUsernames(options Options) ([]string, error) {
+	res, err := c.rpc("user_find", []string{""}, options)
+	if err != nil {
+		return nil, err
+	}
+	if res.Result.Truncated {
+		return nil, ErrTruncated
+	}
+
+	usernames := []string{}
+	for _, entry := range gjson.ParseBytes(res.Result.Data).Array() {
+		usernames = append(usernames, entry.Get("uid.0").String())
+	}
+
+	return usernames, nil
+}
+
+type reportRow struct {
+	user   *User
+	tokens int
+	err    error
+}
+
+// Look up a single user and, if countTokens is set, the number of OTP tokens
+// they own. Each request waits on limit if set
+func (c *Client) reportRow(username string, countTokens bool, limit <-chan time.Time, done <-chan struct{}) reportRow {
+	if !waitRateLimit(limit, done) {
+		return reportRow{err: errors.New("report cancelled")}
+	}
+	user, err := c.UserShow(username)
+	if err != nil {
+		return reportRow{err: err}
+	}
+
+	row := reportRow{user: user}
+	if !countTokens {
+		return row
+	}
+
+	if !waitRateLimit(limit, done) {
+		return reportRow{err: errors.New("report cancelled")}
+	}
+	res, err := c.rpc("otptoken_find", []string{}, Options{
+		"ipatokenowner": username,
+		"pkey_only":     true,
+		"sizelimit":     0,
+	})
+	if err != nil {
+		return reportRow{err: err}
+	}
+	if res.Result.Truncated {
+		return reportRow{err: ErrTruncated}
+	}
+	row.tokens = len(gjson.ParseBytes(res.Result.Data).Array())
+
+	return row
+}
+
+type reportWriter interface {
+	header() error
+	write(u *User, tokens int) error
+}
+
+type csvReportWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (r *csvReportWriter) header() error {
+	return r.flush(r.columns)
+}
+
+func (r *csvReportWriter) write(u *User, tokens int) error {
+	record := make([]string, len(r.columns))
+	for i, col := range r.columns {
+		switch v := reportColumns[col](u, tokens).(type) {
+		case string:
+			record[i] = v
+		case []string:
+			record[i] = strings.Join(v, ";")
+		case bool:
+			record[i] = strconv.FormatBool(v)
+		case int:
+			record[i] = strconv.Itoa(v)
+		}
+	}
+
+	return r.flush(record)
+}
+
+// Write record and flush so rows are streamed as they are looked up
+func (r *csvReportWriter) flush(record []string) error {
+	if err := r.w.Write(record); err != nil {
+		return err
+	}
+	r.w.Flush()
+
+	return r.w.Error()
+}
+
+type jsonReportWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (r *jsonReportWriter) header() error {
+	return nil
+}
+
+// Write a JSON object with keys in column order
+func (r *jsonReportWriter) write(u *User, tokens int) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, col := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		value, err := json.Marshal(reportColumns[col](u, tokens))
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+
+	return r.enc.Encode(json.RawMessage(b.String()))
+}
+
+// Returns t in RFC 3339 format in UTC, or an empty string if t is zero
+func reportTime(t Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Returns a sorted copy of values, never nil
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+
+	return sorted
+}
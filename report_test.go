@@ -0,0 +1,136 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newReportStub(t *testing.T, finds *[]map[string]interface{}) *ipa.Client {
+	var mu sync.Mutex
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		options := req.Options
+
+		switch req.Method {
+		case "user_find":
+			mu.Lock()
+			*finds = append(*finds, options)
+			mu.Unlock()
+			if options["preserved"] == true {
+				stubResult(`{"result": [{"uid": ["gone"]}], "count": 1, "truncated": false}`)(w, r)
+				return
+			}
+			stubResult(`{"result": [{"uid": ["jsmith"]}, {"uid": ["adoe"]}], "count": 2, "truncated": false}`)(w, r)
+		case "user_show":
+			switch args[0] {
+			case "jsmith":
+				stubResult(`{"result": {"uid": ["jsmith"], "displayname": ["Smith, John \"JJ\""], "mail": ["jsmith@example.com"], "memberof_group": ["staff", "admins"], "ipauserauthtype": ["password", "otp"], "krblastsuccessfulauth": [{"__datetime__": "20230405060708Z"}]}, "value": "jsmith"}`)(w, r)
+			case "adoe":
+				stubResult(`{"result": {"uid": ["adoe"], "displayname": ["Ann Doe"], "memberof_group": ["staff"], "nsaccountlock": true}, "value": "adoe"}`)(w, r)
+			default:
+				stubResult(`{"result": {"uid": ["gone"], "preserved": true}, "value": "gone"}`)(w, r)
+			}
+		case "otptoken_find":
+			if options["ipatokenowner"] == "jsmith" {
+				stubResult(`{"result": [{"ipatokenuniqueid": ["a"]}, {"ipatokenuniqueid": ["b"]}], "count": 2, "truncated": false}`)(w, r)
+				return
+			}
+			stubResult(`{"result": [], "count": 0, "truncated": false}`)(w, r)
+		}
+	})
+}
+
+func TestReportCSV(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var finds []map[string]interface{}
+	c := newReportStub(t, &finds)
+
+	var out bytes.Buffer
+	err := ipa.Report(c, ipa.ReportOptions{Concurrency: 4, QPS: 1000}, &out)
+	require.NoError(err)
+
+	expected := "username,name,email,groups,auth_types,locked,last_login,password_expires,otp_tokens\r\n" +
+		"adoe,Ann Doe,,staff,,true,,,0\r\n" +
+		"jsmith,\"Smith, John \"\"JJ\"\"\",jsmith@example.com,admins;staff,otp;password,false,2023-04-05T06:07:08Z,,2\r\n"
+	assert.Equal(expected, out.String())
+
+	require.Len(finds, 1)
+	assert.Equal(false, finds[0]["nsaccountlock"])
+	assert.Equal(true, finds[0]["pkey_only"])
+}
+
+func TestReportJSONLines(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var finds []map[string]interface{}
+	c := newReportStub(t, &finds)
+
+	var out bytes.Buffer
+	err := ipa.Report(c, ipa.ReportOptions{
+		Format:           ipa.ReportJSONLines,
+		Columns:          []string{ipa.ColumnUsername, ipa.ColumnPreserved, ipa.ColumnGroups},
+		IncludeDisabled:  true,
+		IncludePreserved: true,
+		Group:            "staff",
+	}, &out)
+	require.NoError(err)
+
+	expected := `{"username":"adoe","preserved":false,"groups":["staff"]}` + "\n" +
+		`{"username":"gone","preserved":true,"groups":[]}` + "\n" +
+		`{"username":"jsmith","preserved":false,"groups":["admins","staff"]}` + "\n"
+	assert.Equal(expected, out.String())
+
+	require.Len(finds, 2)
+	assert.NotContains(finds[0], "nsaccountlock")
+	assert.Equal("staff", finds[0]["in_group"])
+	assert.Equal(true, finds[1]["preserved"])
+
+	err = ipa.Report(c, ipa.ReportOptions{Columns: []string{"shoe_size"}}, &out)
+	assert.Error(err)
+}
+
+func TestReportErrors(t *testing.T) {
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		if req.Method == "user_find" {
+			stubResult(`{"result": [{"uid": ["a"]}, {"uid": ["b"]}, {"uid": ["c"]}], "count": 3, "truncated": false}`)(w, r)
+			return
+		}
+		stubError(4001, "user not found")(w, r)
+	})
+
+	var out bytes.Buffer
+	err := ipa.Report(c, ipa.ReportOptions{Concurrency: 2}, &out)
+	var ierr *ipa.IpaError
+	require.ErrorAs(t, err, &ierr)
+	assert.Equal(t, 4001, ierr.Code)
+
+	c = newTestClientStub(t, stubResult(`{"result": [], "count": 2000, "truncated": true}`))
+	err = ipa.Report(c, ipa.ReportOptions{}, &out)
+	assert.ErrorIs(t, err, ipa.ErrTruncated)
+
+	// Rates the limiter can not tick at are rejected before any lookup
+	calls := 0
+	c = newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+	for _, qps := range []float64{-1, 2e9, 1e-10, math.NaN(), math.Inf(1)} {
+		assert.Errorf(t, ipa.Report(c, ipa.ReportOptions{QPS: qps}, &out), "QPS %v should be rejected", qps)
+	}
+	assert.Equal(t, 0, calls)
+}
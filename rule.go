@@ -0,0 +1,372 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// CategoryAll is the value of the user, host, service and command categories
+// of rules which apply to everything
+const CategoryAll = "all"
+
+// HbacRule encapsulates FreeIPA host based access control rules
+type HbacRule struct {
+	DN              string   `json:"dn"`
+	Name            string   `json:"cn"`
+	Description     string   `json:"description"`
+	Enabled         bool     `json:"ipaenabledflag"`
+	UserCategory    string   `json:"usercategory"`
+	HostCategory    string   `json:"hostcategory"`
+	ServiceCategory string   `json:"servicecategory"`
+	Users           []string `json:"memberuser_user"`
+	Groups          []string `json:"memberuser_group"`
+	Hosts           []string `json:"memberhost_host"`
+	HostGroups      []string `json:"memberhost_hostgroup"`
+	Services        []string `json:"memberservice_hbacsvc"`
+	ServiceGroups   []string `json:"memberservice_hbacsvcgroup"`
+
+	// Raw hbacrule record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+// SudoRule encapsulates FreeIPA sudo rules
+type SudoRule struct {
+	DN                 string   `json:"dn"`
+	Name               string   `json:"cn"`
+	Description        string   `json:"description"`
+	Enabled            bool     `json:"ipaenabledflag"`
+	Order              int      `json:"sudoorder"`
+	UserCategory       string   `json:"usercategory"`
+	HostCategory       string   `json:"hostcategory"`
+	CommandCategory    string   `json:"cmdcategory"`
+	Users              []string `json:"memberuser_user"`
+	Groups             []string `json:"memberuser_group"`
+	Hosts              []string `json:"memberhost_host"`
+	HostGroups         []string `json:"memberhost_hostgroup"`
+	AllowCommands      []string `json:"memberallowcmd_sudocmd"`
+	AllowCommandGroups []string `json:"memberallowcmd_sudocmdgroup"`
+	DenyCommands       []string `json:"memberdenycmd_sudocmd"`
+	DenyCommandGroups  []string `json:"memberdenycmd_sudocmdgroup"`
+	RunAsUsers         []string `json:"ipasudorunas_user"`
+	RunAsGroups        []string `json:"ipasudorunasgroup_group"`
+	Options            []string `json:"ipasudoopt"`
+
+	// Run as categories, CategoryAll if commands may be run as any user or
+	// group. RunAsUserGroups are groups whose members commands may be run as
+	RunAsUserCategory  string   `json:"ipasudorunasusercategory"`
+	RunAsGroupCategory string   `json:"ipasudorunasgroupcategory"`
+	RunAsUserGroups    []string `json:"ipasudorunas_group"`
+
+	// Raw sudorule record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+type ruleConfig struct {
+	includeDisabled bool
+}
+
+// RuleOption configures HbacRulesForHost and SudoRulesForUser
+type RuleOption func(*ruleConfig)
+
+// Include disabled rules
+func IncludeDisabledRules() RuleOption {
+	return func(cfg *ruleConfig) {
+		cfg.includeDisabled = true
+	}
+}
+
+// Parse multi-valued attr from res
+func parseStrings(res gjson.Result, attr string) []string {
+	var vals []string
+	res.Get(attr).ForEach(func(key, value gjson.Result) bool {
+		vals = append(vals, value.String())
+		return true
+	})
+
+	return vals
+}
+
+// Returns true if category is all, name is in names or any of groups is in
+// ruleGroups
+func ruleMatches(category string, names []string, name string, ruleGroups []string, groups ...[]string) bool {
+	if strings.EqualFold(category, CategoryAll) {
+		return true
+	}
+
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	for _, rg := range ruleGroups {
+		for _, gs := range groups {
+			for _, g := range gs {
+				if strings.EqualFold(rg, g) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *HbacRule) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid hbacrule record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	r.Raw = append(json.RawMessage(nil), raw...)
+
+	for _, attr := range []string{"memberuser_user", "memberuser_group", "memberhost_host", "memberhost_hostgroup"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
+
+	r.DN = res.Get("dn").String()
+	r.Name = res.Get("cn.0").String()
+	r.Description = res.Get("description.0").String()
+	r.Enabled = parseBool(res.Get("ipaenabledflag"))
+	r.UserCategory = res.Get("usercategory.0").String()
+	r.HostCategory = res.Get("hostcategory.0").String()
+	r.ServiceCategory = res.Get("servicecategory.0").String()
+	r.Users = parseStrings(res, "memberuser_user")
+	r.Groups = parseStrings(res, "memberuser_group")
+	r.Hosts = parseStrings(res, "memberhost_host")
+	r.HostGroups = parseStrings(res, "memberhost_hostgroup")
+	r.Services = parseStrings(res, "memberservice_hbacsvc")
+	r.ServiceGroups = parseStrings(res, "memberservice_hbacsvcgroup")
+
+	return nil
+}
+
+// Returns true if the rule applies to host directly, through a host group or
+// because the host category is all. Host groups must be fetched with HostShow
+func (r *HbacRule) AppliesToHost(host *Host) bool {
+	return ruleMatches(r.HostCategory, r.Hosts, host.FQDN, r.HostGroups, host.HostGroups, host.IndirectHostGroups)
+}
+
+// Returns true if the rule applies to user directly, through a group or
+// because the user category is all
+func (r *HbacRule) AppliesToUser(user *User) bool {
+	return ruleMatches(r.UserCategory, r.Users, user.Username, r.Groups, user.Groups, user.IndirectGroups)
+}
+
+func (r *SudoRule) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid sudorule record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	r.Raw = append(json.RawMessage(nil), raw...)
+
+	for _, attr := range []string{"memberuser_user", "memberuser_group", "memberhost_host", "memberhost_hostgroup"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
+
+	r.DN = res.Get("dn").String()
+	r.Name = res.Get("cn.0").String()
+	r.Description = res.Get("description.0").String()
+	r.Enabled = parseBool(res.Get("ipaenabledflag"))
+	r.Order = int(res.Get("sudoorder.0").Int())
+	r.UserCategory = res.Get("usercategory.0").String()
+	r.HostCategory = res.Get("hostcategory.0").String()
+	r.CommandCategory = res.Get("cmdcategory.0").String()
+	r.Users = parseStrings(res, "memberuser_user")
+	r.Groups = parseStrings(res, "memberuser_group")
+	r.Hosts = parseStrings(res, "memberhost_host")
+	r.HostGroups = parseStrings(res, "memberhost_hostgroup")
+	r.AllowCommands = parseStrings(res, "memberallowcmd_sudocmd")
+	r.AllowCommandGroups = parseStrings(res, "memberallowcmd_sudocmdgroup")
+	r.DenyCommands = parseStrings(res, "memberdenycmd_sudocmd")
+	r.DenyCommandGroups = parseStrings(res, "memberdenycmd_sudocmdgroup")
+	r.RunAsUsers = parseStrings(res, "ipasudorunas_user")
+	r.RunAsGroups = parseStrings(res, "ipasudorunasgroup_group")
+	r.Options = parseStrings(res, "ipasudoopt")
+	r.RunAsUserCategory = res.Get("ipasudorunasusercategory.0").String()
+	r.RunAsGroupCategory = res.Get("ipasudorunasgroupcategory.0").String()
+	r.RunAsUserGroups = parseStrings(res, "ipasudorunas_group")
+
+	return nil
+}
+
+// Returns true if the rule applies to host directly, through a host group or
+// because the host category is all. Host groups must be fetched with HostShow
+func (r *SudoRule) AppliesToHost(host *Host) bool {
+	return ruleMatches(r.HostCategory, r.Hosts, host.FQDN, r.HostGroups, host.HostGroups, host.IndirectHostGroups)
+}
+
+// Returns true if the rule applies to user directly, through a group or
+// because the user category is all
+func (r *SudoRule) AppliesToUser(user *User) bool {
+	return ruleMatches(r.UserCategory, r.Users, user.Username, r.Groups, user.Groups, user.IndirectGroups)
+}
+
+// Run find method once for each of queries and return the raw records,
+// de-duplicated by cn. Member filters given multiple values only match
+// records which have all of them, so each group is queried separately.
+// Returns ErrTruncated if any query is truncated as a partial list of rules
+// would be misleading.
+func (c *Client) findRules(method string, queries []Options) ([]gjson.Result, error) {
+	seen := make(map[string]bool)
+	records := []gjson.Result{}
+	for _, options := range queries {
+		options["all"] = true
+
+		res, err := c.rpc(method, []string{}, options)
+		if err != nil {
+			return nil, err
+		}
+		if res.Result.Truncated {
+			return nil, ErrTruncated
+		}
+
+		for _, rec := range gjson.ParseBytes(res.Result.Data).Array() {
+			cn := rec.Get("cn.0").String()
+			if seen[cn] {
+				continue
+			}
+			seen[cn] = true
+			records = append(records, rec)
+		}
+	}
+
+	return records, nil
+}
+
+// Fetch the HBAC rules which apply to host fqdn, including rules applying
+// through direct or nested host groups and rules with host category all.
+// Disabled rules are excluded unless IncludeDisabledRules is given. Rules are
+// sorted by name.
+func (c *Client) HbacRulesForHost(fqdn string, opts ...RuleOption) ([]*HbacRule, error) {
+	cfg := &ruleConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	host, err := c.HostShow(fqdn)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []Options{
+		{"host": host.FQDN},
+		{"hostcategory": CategoryAll},
+	}
+	for _, g := range append(host.HostGroups, host.IndirectHostGroups...) {
+		queries = append(queries, Options{"hostgroup": g})
+	}
+
+	records, err := c.findRules("hbacrule_find", queries)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*HbacRule, 0, len(records))
+	for _, rec := range records {
+		rule := new(HbacRule)
+		if err := rule.fromJSON([]byte(rec.Raw)); err != nil {
+			return nil, err
+		}
+		if !rule.Enabled && !cfg.includeDisabled {
+			continue
+		}
+		if !rule.AppliesToHost(host) {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+
+	return rules, nil
+}
+
+// Fetch the sudo rules which apply to username, including rules applying
+// through direct or nested groups and rules with user category all. Disabled
+// rules are excluded unless IncludeDisabledRules is given. Rules are sorted by
+// sudo order then name. Use SudoRule.AppliesToHost to select the rules which
+// apply on a given host.
+func (c *Client) SudoRulesForUser(username string, opts ...RuleOption) ([]*SudoRule, error) {
+	cfg := &ruleConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	user, err := c.UserShow(username)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []Options{
+		{"user": user.Username},
+		{"usercategory": CategoryAll},
+	}
+	for _, g := range append(user.Groups, user.IndirectGroups...) {
+		queries = append(queries, Options{"group": g})
+	}
+
+	records, err := c.findRules("sudorule_find", queries)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*SudoRule, 0, len(records))
+	for _, rec := range records {
+		rule := new(SudoRule)
+		if err := rule.fromJSON([]byte(rec.Raw)); err != nil {
+			return nil, err
+		}
+		if !rule.Enabled && !cfg.includeDisabled {
+			continue
+		}
+		if !rule.AppliesToUser(user) {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Order != rules[j].Order {
+			return rules[i].Order < rules[j].Order
+		}
+		return rules[i].Name < rules[j].Name
+	})
+
+	return rules, nil
+}
+
+// Enable HBAC rule. Enabling a rule which is already enabled succeeds.
+func (c *Client) EnableHbacRule(name string) error {
+	return c.SetEnabled("hbacrule", name, true)
+}
+
+// Disable HBAC rule. Disabling a rule which is already disabled succeeds.
+func (c *Client) DisableHbacRule(name string) error {
+	return c.SetEnabled("hbacrule", name, false)
+}
+
+// Enable sudo rule. Enabling a rule which is already enabled succeeds.
+func (c *Client) EnableSudoRule(name string) error {
+	return c.SetEnabled("sudorule", name, true)
+}
+
+// Disable sudo rule. Disabling a rule which is already disabled succeeds.
+func (c *Client) DisableSudoRule(name string) error {
+	return c.SetEnabled("sudorule", name, false)
+}
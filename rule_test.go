@@ -0,0 +1,82 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func newRuleStub(t *testing.T) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		options := req.Options
+
+		switch {
+		case req.Method == "host_show":
+			stubResult(`{"result": {"fqdn": ["web01.example.com"], "memberof_hostgroup": ["web"], "memberofindirect_hostgroup": ["servers"]}, "summary": null, "value": "web01.example.com"}`)(w, r)
+		case req.Method == "user_show":
+			stubResult(`{"result": {"uid": ["jdoe"], "memberof_group": ["staff"], "memberofindirect_group": ["admins"]}, "summary": null, "value": "jdoe"}`)(w, r)
+		case req.Method == "hbacrule_find" && options["hostcategory"] == "all":
+			stubResult(`{"result": [{"cn": ["allow_all"], "ipaenabledflag": [true], "hostcategory": ["all"], "usercategory": ["all"]}], "count": 1, "truncated": false}`)(w, r)
+		case req.Method == "hbacrule_find" && options["host"] == "web01.example.com":
+			stubResult(`{"result": [{"cn": ["web_direct"], "ipaenabledflag": ["TRUE"], "memberhost_host": ["web01.example.com"]}, {"cn": ["web_disabled"], "ipaenabledflag": ["FALSE"], "memberhost_host": ["web01.example.com"]}], "count": 2, "truncated": false}`)(w, r)
+		case req.Method == "hbacrule_find" && options["hostgroup"] == "servers":
+			stubResult(`{"result": [{"cn": ["servers_ssh"], "ipaenabledflag": [true], "memberhost_hostgroup": ["servers"], "memberservice_hbacsvc": ["sshd"]}, {"cn": ["web_direct"], "ipaenabledflag": [true], "memberhost_host": ["web01.example.com"]}], "count": 2, "truncated": false}`)(w, r)
+		case req.Method == "sudorule_find" && options["usercategory"] == "all":
+			stubResult(`{"result": [{"cn": ["everyone"], "ipaenabledflag": [true], "usercategory": ["all"], "hostcategory": ["all"], "sudoorder": ["10"]}], "count": 1, "truncated": false}`)(w, r)
+		case req.Method == "sudorule_find" && options["group"] == "admins":
+			stubResult(`{"result": [{"cn": ["admins_all"], "ipaenabledflag": [true], "memberuser_group": ["admins"], "memberhost_hostgroup": ["db"], "cmdcategory": ["all"], "sudoorder": ["1"]}], "count": 1, "truncated": false}`)(w, r)
+		default:
+			stubResult(`{"result": [], "count": 0, "truncated": false}`)(w, r)
+		}
+	})
+}
+
+func TestHbacRulesForHost(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newRuleStub(t)
+
+	rules, err := c.HbacRulesForHost("web01.example.com")
+	require.NoError(err)
+
+	names := []string{}
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	assert.Equal([]string{"allow_all", "servers_ssh", "web_direct"}, names)
+	assert.Equal([]string{"sshd"}, rules[1].Services)
+
+	rules, err = c.HbacRulesForHost("web01.example.com", ipa.IncludeDisabledRules())
+	require.NoError(err)
+	assert.Len(rules, 4)
+	assert.False(rules[3].Enabled)
+}
+
+func TestSudoRulesForUser(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newRuleStub(t)
+
+	rules, err := c.SudoRulesForUser("jdoe")
+	require.NoError(err)
+	require.Len(rules, 2)
+	assert.Equalf("admins_all", rules[0].Name, "Rules should be sorted by sudo order")
+	assert.Equal("all", rules[0].CommandCategory)
+	assert.Equal("everyone", rules[1].Name)
+
+	web := &ipa.Host{FQDN: "web01.example.com", HostGroups: []string{"web"}}
+	db := &ipa.Host{FQDN: "db01.example.com", IndirectHostGroups: []string{"db"}}
+	assert.False(rules[0].AppliesToHost(web))
+	assert.True(rules[0].AppliesToHost(db))
+	assert.True(rules[1].AppliesToHost(web))
+}
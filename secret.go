@@ -0,0 +1,110 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Attributes holding secrets which are redacted from trace logs and raw
+// records
+var secretAttrs = []string{"randompassword", "ipatokenotpkey", "userpassword"}
+
+// Request options and form fields holding secrets which are redacted from
+// traces
+var secretOptions = []string{"password", "current_password", "otp", "old_password", "new_password"}
+
+var secretAttrRegexp = regexp.MustCompile(`"(` + strings.Join(append(append([]string{}, secretAttrs...), secretOptions...), "|") + `)"(\s*:\s*)(\[[^\]]*\]|"(?:[^"\\]|\\.)*")`)
+
+// Secret attributes set with setattr or addattr, for example
+// "userpassword=secret"
+var secretAttrValueRegexp = regexp.MustCompile(`(?i)"(` + strings.Join(secretAttrs, "|") + `)=(?:[^"\\]|\\.)*"`)
+
+// Secret holds sensitive values such as passwords and OTP keys. Secrets are
+// redacted when formatted or marshalled so they are never logged by
+// accident. Use Reveal to access the value and Zero to wipe it once it is no
+// longer needed.
+type Secret struct {
+	b []byte
+}
+
+// Returns new Secret holding a copy of b
+func NewSecret(b []byte) Secret {
+	return Secret{b: append([]byte(nil), b...)}
+}
+
+// Returns the secret value. The returned slice shares memory with the Secret
+// and is wiped by Zero
+func (s Secret) Reveal() []byte {
+	return s.b
+}
+
+// Returns true if the secret is empty
+func (s Secret) IsEmpty() bool {
+	return len(s.b) == 0
+}
+
+// Wipe the secret value
+func (s *Secret) Zero() {
+	for i := range s.b {
+		s.b[i] = 0
+	}
+	s.b = nil
+}
+
+// Returns [REDACTED], or the empty string if the secret is empty
+func (s Secret) String() string {
+	if s.IsEmpty() {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return "ipa.Secret{" + s.String() + "}"
+}
+
+// Marshal the redacted value
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Returns raw json with the values of secret attributes replaced with
+// [REDACTED]
+func redactSecrets(raw []byte) []byte {
+	raw = secretAttrRegexp.ReplaceAll(raw, []byte(`"$1"$2"`+redacted+`"`))
+	return secretAttrValueRegexp.ReplaceAll(raw, []byte(`"$1=`+redacted+`"`))
+}
+
+// Returns a copy of the record json raw with secret attributes removed
+func stripSecrets(raw []byte) json.RawMessage {
+	var rec map[string]json.RawMessage
+	if json.Unmarshal(raw, &rec) != nil {
+		return append(json.RawMessage(nil), raw...)
+	}
+
+	found := false
+	for _, attr := range secretAttrs {
+		if _, ok := rec[attr]; ok {
+			delete(rec, attr)
+			found = true
+		}
+	}
+	if !found {
+		return append(json.RawMessage(nil), raw...)
+	}
+
+	out, err := json.Marshal(rec)
+	if err != nil {
+		return nil
+	}
+
+	return out
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := ipa.NewSecret([]byte("hunter2"))
+	user := &ipa.User{Username: "jdoe", RandomPassword: secret}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x"} {
+		assert.NotContainsf(fmt.Sprintf(format, user), "hunter2", "Secret formatted with %s", format)
+		assert.NotContainsf(fmt.Sprintf(format, *user), "hunter2", "Secret formatted with %s", format)
+	}
+	assert.Equal("[REDACTED]", secret.String())
+	assert.Equal("", ipa.Secret{}.String())
+
+	out, err := json.Marshal(user)
+	require.NoError(t, err)
+	assert.NotContains(string(out), "hunter2")
+	assert.Contains(string(out), `"randompassword":"[REDACTED]"`)
+
+	buf := secret.Reveal()
+	assert.Equal([]byte("hunter2"), buf)
+	secret.Zero()
+	assert.True(secret.IsEmpty())
+	assert.Equalf(make([]byte, 7), buf, "Zero should wipe the secret buffer")
+}
+
+func TestSecretRedaction(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	level, out := log.GetLevel(), log.StandardLogger().Out
+	log.SetOutput(&logs)
+	log.SetLevel(log.TraceLevel)
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		log.SetOutput(out)
+	})
+
+	c := newTestClientStub(t, stubResult(`{"result": {"ipatokenuniqueid": ["tok1"], "ipatokenotpkey": [{"__base64__": "aHVudGVyMg=="}], "uri": "otpauth://totp/jdoe"}, "summary": null, "value": "tok1"}`))
+
+	token, err := c.AddOTPToken(&ipa.OTPToken{})
+	require.NoError(err)
+	assert.Equal([]byte("hunter2"), token.Key.Reveal())
+	assert.NotContainsf(string(token.Raw), "aHVudGVyMg==", "Raw record should not include the key")
+	assert.NotContainsf(logs.String(), "aHVudGVyMg==", "Trace logs should not include the key")
+	// The text formatter quotes the message, so the payload quotes are escaped.
+	assert.Contains(logs.String(), `\"ipatokenotpkey\": \"[REDACTED]\"`)
+
+	c = newTestClientStub(t, stubResult(`{"result": {"uid": ["jdoe"], "randompassword": "p@ss\"word"}, "summary": null, "value": "jdoe"}`))
+	password, err := c.ResetPassword("jdoe")
+	require.NoError(err)
+	assert.Equal(`p@ss"word`, password)
+	assert.NotContains(logs.String(), `p@ss`)
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+
+	"github.com/tidwall/gjson"
+)
+
+// Server roles supported by FreeIPA
+const (
+	ServerRoleMaster       = "IPA master"
+	ServerRoleCA           = "CA server"
+	ServerRoleDNS          = "DNS server"
+	ServerRoleKRA          = "KRA server"
+	ServerRoleNTP          = "NTP server"
+	ServerRoleTrustAgent   = "AD trust agent"
+	ServerRoleTrustControl = "AD trust controller"
+)
+
+// IPAServer encapsulates FreeIPA server data returned from ipa server commands
+type IPAServer struct {
+	DN             string   `json:"dn"`
+	Name           string   `json:"cn"`
+	Roles          []string `json:"enabled_role_servrole"`
+	Location       string   `json:"ipalocation_location"`
+	ServiceWeight  int      `json:"ipaserviceweight"`
+	MinDomainLevel int      `json:"ipamindomainlevel"`
+	MaxDomainLevel int      `json:"ipamaxdomainlevel"`
+}
+
+// ServerRole encapsulates the status of a role on a FreeIPA server
+type ServerRole struct {
+	Server string `json:"server_server"`
+	Role   string `json:"role_servrole"`
+	Status string `json:"status"`
+}
+
+// Location encapsulates FreeIPA location data returned from ipa location
+// commands
+type Location struct {
+	DN          string `json:"dn"`
+	Name        string `json:"idnsname"`
+	Description string `json:"description"`
+}
+
+// Parse a FreeIPA DNS name. DNS names are returned using a class-hint
+// system, for example '[{"__dns_name__": "example.com."}]'
+func parseDNSName(res gjson.Result) string {
+	if res.IsObject() {
+		return res.Get("__dns_name__").String()
+	}
+
+	return res.String()
+}
+
+func (s *IPAServer) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid server record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	s.DN = res.Get("dn").String()
+	s.Name = res.Get("cn.0").String()
+	s.Location = parseDNSName(res.Get("ipalocation_location.0"))
+	s.ServiceWeight = int(res.Get("ipaserviceweight.0").Int())
+	s.MinDomainLevel = int(res.Get("ipamindomainlevel.0").Int())
+	s.MaxDomainLevel = int(res.Get("ipamaxdomainlevel.0").Int())
+	res.Get("enabled_role_servrole").ForEach(func(key, value gjson.Result) bool {
+		s.Roles = append(s.Roles, value.String())
+		return true
+	})
+
+	return nil
+}
+
+// Returns true if the role is enabled on the server
+func (s *IPAServer) HasRole(role string) bool {
+	for _, r := range s.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *ServerRole) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid server role record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	r.Server = res.Get("server_server").String()
+	r.Role = res.Get("role_servrole").String()
+	r.Status = res.Get("status").String()
+
+	return nil
+}
+
+// Returns true if the role is enabled
+func (r *ServerRole) Enabled() bool {
+	return r.Status == "enabled"
+}
+
+func (l *Location) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid location record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+
+	l.DN = res.Get("dn").String()
+	l.Name = parseDNSName(res.Get("idnsname.0"))
+	l.Description = res.Get("description.0").String()
+
+	return nil
+}
+
+// Find FreeIPA servers.
+func (c *Client) ServerFind() ([]*IPAServer, error) {
+	options := Options{
+		"no_members": false,
+		"all":        true,
+	}
+
+	res, err := c.rpc("server_find", []string{""}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*IPAServer, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, s := range data.Array() {
+		server := new(IPAServer)
+		err := server.fromJSON([]byte(s.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+// Find status of roles on a FreeIPA server. If server is empty the role
+// status of all servers is returned.
+func (c *Client) ServerRoleFind(server string) ([]*ServerRole, error) {
+	options := Options{
+		"include_master": true,
+	}
+
+	if server != "" {
+		options["server_server"] = server
+	}
+
+	res, err := c.rpc("server_role_find", []string{""}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]*ServerRole, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, r := range data.Array() {
+		role := new(ServerRole)
+		err := role.fromJSON([]byte(r.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// Find FreeIPA locations.
+func (c *Client) LocationFind() ([]*Location, error) {
+	options := Options{
+		"all": true,
+	}
+
+	res, err := c.rpc("location_find", []string{""}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make([]*Location, 0)
+	data := gjson.ParseBytes(res.Result.Data)
+	for _, l := range data.Array() {
+		loc := new(Location)
+		err := loc.fromJSON([]byte(l.Raw))
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, loc)
+	}
+
+	return locations, nil
+}
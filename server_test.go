@@ -0,0 +1,49 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestServerFind(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c, err := newTestClientCCache()
+	require.NoError(err)
+
+	servers, err := c.ServerFind()
+	require.NoErrorf(err, "Failed to find servers")
+	require.NotEmptyf(servers, "At least one server should be found")
+
+	var server *ipa.IPAServer
+	for _, s := range servers {
+		if s.Name == c.Host() {
+			server = s
+		}
+	}
+	require.NotNilf(server, "Client host not found in server list")
+	assert.Truef(server.HasRole(ipa.ServerRoleCA), "Server should have CA role")
+	assert.Greaterf(server.MinDomainLevel, 0, "Min domain level should be set")
+
+	roles, err := c.ServerRoleFind(server.Name)
+	require.NoErrorf(err, "Failed to find server roles")
+	require.NotEmptyf(roles, "Server roles should not be empty")
+
+	for _, r := range roles {
+		assert.Equalf(server.Name, r.Server, "Role returned for wrong server")
+		if r.Role == ipa.ServerRoleCA {
+			assert.Truef(r.Enabled(), "CA role should be enabled")
+		}
+	}
+
+	_, err = c.LocationFind()
+	assert.NoErrorf(err, "Failed to find locations")
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/tidwall/gjson"
+)
+
+// KeytabAccess lists the principals allowed to retrieve or create the keytab
+// of a service
+type KeytabAccess struct {
+	Users      []string
+	Groups     []string
+	Hosts      []string
+	HostGroups []string
+}
+
+// Returns true if no principals are listed
+func (a KeytabAccess) empty() bool {
+	return len(a.Users) == 0 && len(a.Groups) == 0 && len(a.Hosts) == 0 && len(a.HostGroups) == 0
+}
+
+func (a KeytabAccess) options() Options {
+	options := Options{"all": true}
+	if len(a.Users) > 0 {
+		options["user"] = a.Users
+	}
+	if len(a.Groups) > 0 {
+		options["group"] = a.Groups
+	}
+	if len(a.Hosts) > 0 {
+		options["host"] = a.Hosts
+	}
+	if len(a.HostGroups) > 0 {
+		options["hostgroup"] = a.HostGroups
+	}
+
+	return options
+}
+
+// Parse the principals allowed to perform the keytab operation from a
+// service record, for example ipaallowedtoperform_read_keys
+func parseKeytabAccess(res gjson.Result, attr string) KeytabAccess {
+	var a KeytabAccess
+	for suffix, list := range map[string]*[]string{
+		"_user":      &a.Users,
+		"_group":     &a.Groups,
+		"_host":      &a.Hosts,
+		"_hostgroup": &a.HostGroups,
+	} {
+		res.Get(attr + suffix).ForEach(func(key, value gjson.Result) bool {
+			*list = append(*list, value.String())
+			return true
+		})
+	}
+
+	return a
+}
+
+// Service encapsulates service data returned from ipa service commands
+type Service struct {
+	DN         string   `json:"dn"`
+	Principal  string   `json:"krbcanonicalname"`
+	Principals []string `json:"krbprincipalname"`
+	HasKeytab  bool     `json:"has_keytab"`
+	ManagedBy  []string `json:"managedby_host"`
+
+	// Principals allowed to retrieve and create the service keytab
+	RetrieveKeytab KeytabAccess `json:"-"`
+	CreateKeytab   KeytabAccess `json:"-"`
+
+	// Raw service record json as returned by FreeIPA
+	Raw json.RawMessage `json:"-"`
+}
+
+func (s *Service) fromJSON(raw []byte) error {
+	if !isRecord(raw) {
+		return errors.New("invalid service record json")
+	}
+
+	res := gjson.ParseBytes(raw)
+	s.Raw = append(json.RawMessage(nil), raw...)
+
+	if err := checkValueCount(res, "managedby_host", MaxGroups); err != nil {
+		return err
+	}
+
+	s.DN = res.Get("dn").String()
+	s.Principal = res.Get("krbcanonicalname.0").String()
+	res.Get("krbprincipalname").ForEach(func(key, value gjson.Result) bool {
+		s.Principals = append(s.Principals, value.String())
+		return true
+	})
+	if s.Principal == "" && len(s.Principals) > 0 {
+		s.Principal = s.Principals[0]
+	}
+	s.HasKeytab = parseBool(res.Get("has_keytab"))
+	res.Get("managedby_host").ForEach(func(key, value gjson.Result) bool {
+		s.ManagedBy = append(s.ManagedBy, value.String())
+		return true
+	})
+	s.RetrieveKeytab = parseKeytabAccess(res, "ipaallowedtoperform_read_keys")
+	s.CreateKeytab = parseKeytabAccess(res, "ipaallowedtoperform_write_keys")
+
+	return nil
+}
+
+// Fetch service details by calling the FreeIPA service-show method
+func (c *Client) ServiceShow(principal string) (*Service, error) {
+	return c.ServiceShowWithOptions(principal, DefaultShowOptions())
+}
+
+// Fetch service details by calling the FreeIPA service-show method with show
+// options o
+func (c *Client) ServiceShowWithOptions(principal string, o ShowOptions) (*Service, error) {
+	data, err := c.show("service_show", []string{principal}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceRec := new(Service)
+	err = serviceRec.fromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceRec, nil
+}
+
+// Add hosts to the managers of service principal. Managing hosts can
+// retrieve the service keytab.
+func (c *Client) ServiceAddHost(principal string, hosts ...string) (*Service, error) {
+	return c.serviceHost("service_add_host", principal, hosts)
+}
+
+// Remove hosts from the managers of service principal
+func (c *Client) ServiceRemoveHost(principal string, hosts ...string) (*Service, error) {
+	return c.serviceHost("service_remove_host", principal, hosts)
+}
+
+func (c *Client) serviceHost(method, principal string, hosts []string) (*Service, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("At least one host is required")
+	}
+
+	return c.serviceMember(method, principal, Options{"host": hosts, "all": true}, "managedby.host")
+}
+
+// Allow principals in access to retrieve the keytab of service principal
+func (c *Client) ServiceAllowRetrieveKeytab(principal string, access KeytabAccess) (*Service, error) {
+	return c.serviceKeytabAccess("service_allow_retrieve_keytab", "ipaallowedtoperform_read_keys", principal, access)
+}
+
+// Remove principals in access from those allowed to retrieve the keytab of
+// service principal
+func (c *Client) ServiceDisallowRetrieveKeytab(principal string, access KeytabAccess) (*Service, error) {
+	return c.serviceKeytabAccess("service_disallow_retrieve_keytab", "ipaallowedtoperform_read_keys", principal, access)
+}
+
+// Allow principals in access to create the keytab of service principal
+func (c *Client) ServiceAllowCreateKeytab(principal string, access KeytabAccess) (*Service, error) {
+	return c.serviceKeytabAccess("service_allow_create_keytab", "ipaallowedtoperform_write_keys", principal, access)
+}
+
+// Remove principals in access from those allowed to create the keytab of
+// service principal
+func (c *Client) ServiceDisallowCreateKeytab(principal string, access KeytabAccess) (*Service, error) {
+	return c.serviceKeytabAccess("service_disallow_create_keytab", "ipaallowedtoperform_write_keys", principal, access)
+}
+
+func (c *Client) serviceKeytabAccess(method, attr, principal string, access KeytabAccess) (*Service, error) {
+	if access.empty() {
+		return nil, errors.New("At least one user, group, host or host group is required")
+	}
+
+	return c.serviceMember(method, principal, access.options(),
+		attr+".user", attr+".group", attr+".host", attr+".hostgroup")
+}
+
+// Call a service member method and check for failed members at paths
+func (c *Client) serviceMember(method, principal string, options Options, paths ...string) (*Service, error) {
+	if err := checkArgs(method, principal); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc(method, []string{principal}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = failedMembers(method, res.Result, paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceRec := new(Service)
+	err = serviceRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceRec, nil
+}
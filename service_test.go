@@ -0,0 +1,97 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+const testServiceRecord = `{"dn": "krbprincipalname=HTTP/app.example.com@EXAMPLE.COM,cn=services,cn=accounts,dc=example,dc=com",
+	"krbcanonicalname": ["HTTP/app.example.com@EXAMPLE.COM"], "krbprincipalname": ["HTTP/app.example.com@EXAMPLE.COM"],
+	"has_keytab": true, "managedby_host": ["app.example.com", "mgmt.example.com"],
+	"ipaallowedtoperform_read_keys_host": ["mgmt.example.com"], "ipaallowedtoperform_read_keys_group": ["deployers"],
+	"ipaallowedtoperform_write_keys_hostgroup": ["provisioners"]}`
+
+// Returns a client which records the method and options of each call
+func newServiceStub(t *testing.T, method *string, options *map[string]interface{}, failed string) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		*method = req.Method
+		*options = req.Options
+		stubResult(`{"completed": 1, "failed": `+failed+`, "result": `+testServiceRecord+`}`)(w, r)
+	})
+}
+
+func TestServiceAddHost(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var method string
+	var options map[string]interface{}
+	c := newServiceStub(t, &method, &options, `{"managedby": {"host": []}}`)
+
+	svc, err := c.ServiceAddHost("HTTP/app.example.com", "mgmt.example.com")
+	require.NoError(err)
+	assert.Equal("service_add_host", method)
+	assert.Equal([]interface{}{"mgmt.example.com"}, options["host"])
+	assert.Equal("HTTP/app.example.com@EXAMPLE.COM", svc.Principal)
+	assert.True(svc.HasKeytab)
+	assert.Equal([]string{"app.example.com", "mgmt.example.com"}, svc.ManagedBy)
+	assert.Equal([]string{"mgmt.example.com"}, svc.RetrieveKeytab.Hosts)
+	assert.Equal([]string{"deployers"}, svc.RetrieveKeytab.Groups)
+	assert.Equal([]string{"provisioners"}, svc.CreateKeytab.HostGroups)
+
+	_, err = c.ServiceRemoveHost("HTTP/app.example.com", "mgmt.example.com")
+	require.NoError(err)
+	assert.Equal("service_remove_host", method)
+
+	_, err = c.ServiceAddHost("HTTP/app.example.com")
+	assert.Error(err)
+
+	c = newServiceStub(t, &method, &options, `{"managedby": {"host": [["nosuch.example.com", "no such entry"]]}}`)
+	_, err = c.ServiceAddHost("HTTP/app.example.com", "nosuch.example.com")
+	require.Error(err)
+	assert.Contains(err.Error(), "nosuch.example.com: no such entry")
+}
+
+func TestServiceKeytabAccess(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var method string
+	var options map[string]interface{}
+	c := newServiceStub(t, &method, &options, `{"ipaallowedtoperform_read_keys": {"user": [], "group": [], "host": [], "hostgroup": []}}`)
+
+	_, err := c.ServiceAllowRetrieveKeytab("HTTP/app.example.com", ipa.KeytabAccess{Hosts: []string{"mgmt.example.com"}, Groups: []string{"deployers"}})
+	require.NoError(err)
+	assert.Equal("service_allow_retrieve_keytab", method)
+	assert.Equal([]interface{}{"mgmt.example.com"}, options["host"])
+	assert.Equal([]interface{}{"deployers"}, options["group"])
+	assert.NotContains(options, "user")
+	assert.NotContains(options, "hostgroup")
+
+	_, err = c.ServiceDisallowRetrieveKeytab("HTTP/app.example.com", ipa.KeytabAccess{Users: []string{"jdoe"}})
+	require.NoError(err)
+	assert.Equal("service_disallow_retrieve_keytab", method)
+
+	_, err = c.ServiceAllowCreateKeytab("HTTP/app.example.com", ipa.KeytabAccess{HostGroups: []string{"provisioners"}})
+	require.NoError(err)
+	assert.Equal("service_allow_create_keytab", method)
+	assert.Equal([]interface{}{"provisioners"}, options["hostgroup"])
+
+	_, err = c.ServiceDisallowCreateKeytab("HTTP/app.example.com", ipa.KeytabAccess{})
+	assert.Error(err)
+
+	c = newServiceStub(t, &method, &options, `{"ipaallowedtoperform_write_keys": {"user": [["jdoe", "no such entry"]], "group": [], "host": [], "hostgroup": [["web", "This entry is already a member"]]}}`)
+	_, err = c.ServiceAllowCreateKeytab("HTTP/app.example.com", ipa.KeytabAccess{Users: []string{"jdoe"}, HostGroups: []string{"web"}})
+	require.Error(err)
+	assert.Contains(err.Error(), "jdoe: no such entry")
+	assert.Contains(err.Error(), "web: This entry is already a member")
+}
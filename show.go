@@ -0,0 +1,114 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ShowOptions are the flags common to the FreeIPA *_show methods. The zero
+// value returns only the default attributes of an entry, use
+// DefaultShowOptions for the options used by methods such as UserShow.
+type ShowOptions struct {
+	// Return all attributes, not only the default attributes
+	All bool
+
+	// Return attributes as stored in LDAP without FreeIPA formatting.
+	// Attribute names are lower cased before parsing. Membership attributes
+	// are returned as memberof DNs and are not parsed, use the Raw record.
+	Raw bool
+
+	// Return the attribute level rights of the current principal in the
+	// attributelevelrights attribute. See CheckAccess
+	Rights bool
+
+	// Do not return membership attributes
+	NoMembers bool
+}
+
+// Returns the show options used by the *_show wrappers such as UserShow and
+// GroupShow: all attributes including members.
+func DefaultShowOptions() ShowOptions {
+	return ShowOptions{All: true}
+}
+
+// Returns the FreeIPA options for o. raw and rights are only sent if set.
+func (o ShowOptions) toOptions() Options {
+	options := Options{
+		"all":        o.All,
+		"no_members": o.NoMembers,
+	}
+	if o.Raw {
+		options["raw"] = true
+	}
+	if o.Rights {
+		options["rights"] = true
+	}
+
+	return options
+}
+
+// Call the FreeIPA show method with params and show options o and return the
+// record json, normalized for the record parsers.
+func (c *Client) show(method string, params []string, o ShowOptions) ([]byte, error) {
+	res, err := c.rpc(method, params, o.toOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.Raw {
+		return res.Result.Data, nil
+	}
+
+	return lowerKeys(res.Result.Data), nil
+}
+
+// Returns record raw with attribute names lower cased. Raw output uses the
+// LDAP schema case, for example krbPrincipalName, while the record parsers
+// expect the lower case names FreeIPA returns by default. Values of
+// attributes differing only in case are merged.
+func lowerKeys(raw []byte) []byte {
+	var rec map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return raw
+	}
+
+	lower := make(map[string]json.RawMessage, len(rec))
+	for k, v := range rec {
+		key := strings.ToLower(k)
+		if prev, ok := lower[key]; ok {
+			v = mergeValues(prev, v)
+		}
+		lower[key] = v
+	}
+
+	out, err := json.Marshal(lower)
+	if err != nil {
+		return raw
+	}
+
+	return out
+}
+
+// Merge the json values a and b into a list
+func mergeValues(a, b json.RawMessage) json.RawMessage {
+	var vals []json.RawMessage
+	for _, v := range []json.RawMessage{a, b} {
+		var list []json.RawMessage
+		if err := json.Unmarshal(v, &list); err == nil {
+			vals = append(vals, list...)
+		} else {
+			vals = append(vals, v)
+		}
+	}
+
+	out, err := json.Marshal(vals)
+	if err != nil {
+		return a
+	}
+
+	return out
+}
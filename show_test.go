@@ -0,0 +1,88 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"github.com/ubccr/goipa"
+)
+
+func TestShowOptions(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var methods []string
+	var options []map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		opts := req.Options
+		delete(opts, "version")
+		methods = append(methods, req.Method)
+		options = append(options, opts)
+
+		switch req.Method {
+		case "user_show":
+			stubResult(`{"result": {"uid": ["jdoe"]}, "summary": null, "value": "jdoe"}`)(w, r)
+		case "group_show":
+			stubResult(`{"result": {"cn": ["staff"]}, "summary": null, "value": "staff"}`)(w, r)
+		case "host_show":
+			stubResult(`{"result": {"fqdn": ["web.local"]}, "summary": null, "value": "web.local"}`)(w, r)
+		case "service_show":
+			stubResult(`{"result": {"krbcanonicalname": ["HTTP/web.local@LOCAL"]}, "summary": null, "value": "HTTP/web.local@LOCAL"}`)(w, r)
+		}
+	})
+
+	_, err := c.UserShow("jdoe")
+	require.NoError(err)
+	_, err = c.GroupShow("staff")
+	require.NoError(err)
+	_, err = c.HostShow("web.local")
+	require.NoError(err)
+	_, err = c.ServiceShow("HTTP/web.local@LOCAL")
+	require.NoError(err)
+
+	assert.Equal([]string{"user_show", "group_show", "host_show", "service_show"}, methods)
+	for i := range methods {
+		assert.Equalf(map[string]interface{}{"all": true, "no_members": false}, options[i], "%s should use the default show options", methods[i])
+	}
+
+	methods, options = nil, nil
+	_, err = c.GroupShowWithOptions("staff", ipa.ShowOptions{Rights: true, NoMembers: true})
+	require.NoError(err)
+	assert.Equal(map[string]interface{}{"all": false, "no_members": true, "rights": true}, options[0])
+
+	_, err = c.UserShowWithOptions("jdoe", ipa.ShowOptions{All: true, Raw: true})
+	require.NoError(err)
+	assert.Equal(map[string]interface{}{"all": true, "no_members": false, "raw": true}, options[1])
+}
+
+func TestShowRaw(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"uid": ["jdoe"], "givenName": ["John"], "krbPrincipalName": ["jdoe@LOCAL"],
+		"uidNumber": ["1001"], "nsAccountLock": ["TRUE"], "krbLastPwdChange": ["20230105120000Z"], "userPassword": ["{SSHA}abc"],
+		"objectClass": ["top", "person"], "objectclass": ["ipaobject"], "memberOf": ["cn=staff,cn=groups,cn=accounts,dc=local"]},
+		"summary": null, "value": "jdoe"}`))
+
+	user, err := c.UserShowWithOptions("jdoe", ipa.ShowOptions{All: true, Raw: true})
+	require.NoError(err)
+	assert.Equal("jdoe", user.Username)
+	assert.Equal("John", user.First)
+	assert.Equal("jdoe@LOCAL", user.Principal)
+	assert.Equal("1001", user.Uid)
+	assert.True(user.Locked)
+	assert.Equal(time.Date(2023, 1, 5, 12, 0, 0, 0, time.UTC), user.LastPasswdChange.Time)
+	assert.Emptyf(user.Groups, "Raw memberof DNs should not be parsed as groups")
+	assert.Equal("cn=staff,cn=groups,cn=accounts,dc=local", gjson.GetBytes(user.Raw, "memberof.0").String())
+	assert.Equalf(int64(3), gjson.GetBytes(user.Raw, "objectclass.#").Int(), "Attributes differing in case should be merged")
+	assert.Falsef(gjson.GetBytes(user.Raw, "userpassword").Exists(), "Secrets should be removed from raw records")
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCategoryConflict is returned when a rule category is set to all while
+// the rule has explicit members of that category, or members are added while
+// the category is all
+var ErrCategoryConflict = errors.New("category conflicts with explicit members")
+
+// Fetch sudo rule by name
+func (c *Client) SudoRuleShow(name string) (*SudoRule, error) {
+	if err := checkArgs("sudorule_show", name); err != nil {
+		return nil, err
+	}
+
+	res, err := c.rpc("sudorule_show", []string{name}, Options{"all": true})
+	if err != nil {
+		return nil, err
+	}
+
+	rule := new(SudoRule)
+	err = rule.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// Add sudo option to rule, for example "!authenticate" for NOPASSWD rules or
+// "env_keep+=SSH_AUTH_SOCK". Adding an option the rule already has succeeds.
+func (c *Client) SudoRuleAddOption(rule, option string) error {
+	return c.sudoRuleOption("sudorule_add_option", rule, option)
+}
+
+// Remove sudo option from rule. Removing an option the rule does not have
+// succeeds.
+func (c *Client) SudoRuleRemoveOption(rule, option string) error {
+	return c.sudoRuleOption("sudorule_remove_option", rule, option)
+}
+
+func (c *Client) sudoRuleOption(method, rule, option string) error {
+	if err := checkArgs(method, rule, option); err != nil {
+		return err
+	}
+
+	_, err := c.rpc(method, []string{rule}, Options{"ipasudoopt": option})
+	if err != nil && !IsNoModifications(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Add users to the users rule runs commands as. ErrCategoryConflict is
+// returned if the rule's run as user category is all.
+func (c *Client) SudoRuleAddRunAsUser(rule string, users ...string) error {
+	return c.sudoRuleRunAs("sudorule_add_runasuser", rule, "ipasudorunas", "user", users)
+}
+
+// Remove users from the users rule runs commands as
+func (c *Client) SudoRuleRemoveRunAsUser(rule string, users ...string) error {
+	return c.sudoRuleRunAs("sudorule_remove_runasuser", rule, "ipasudorunas", "user", users)
+}
+
+// Add groups to the groups rule runs commands as. ErrCategoryConflict is
+// returned if the rule's run as group category is all.
+func (c *Client) SudoRuleAddRunAsGroup(rule string, groups ...string) error {
+	return c.sudoRuleRunAs("sudorule_add_runasgroup", rule, "ipasudorunasgroup", "group", groups)
+}
+
+// Remove groups from the groups rule runs commands as
+func (c *Client) SudoRuleRemoveRunAsGroup(rule string, groups ...string) error {
+	return c.sudoRuleRunAs("sudorule_remove_runasgroup", rule, "ipasudorunasgroup", "group", groups)
+}
+
+// Add or remove run as members. key is the option holding the members and
+// attr the attribute failures are reported under, for example ipasudorunas
+// for run as users.
+func (c *Client) sudoRuleRunAs(method, rule, attr, key string, names []string) error {
+	if err := checkArgs(method, rule); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("At least one name is required")
+	}
+
+	res, err := c.rpc(method, []string{rule}, Options{key: names})
+	if err != nil {
+		return categoryConflict(method, rule, err)
+	}
+
+	return failedMembers(method, res.Result, attr+"."+key)
+}
+
+// Set the run as user category of rule. CategoryAll runs commands as any
+// user, an empty category clears it. ErrCategoryConflict is returned when
+// setting CategoryAll on a rule with run as users.
+func (c *Client) SudoRuleSetRunAsUserCategory(rule, category string) error {
+	return c.sudoRuleCategory(rule, "ipasudorunasusercategory", category)
+}
+
+// Set the run as group category of rule. CategoryAll runs commands as any
+// group, an empty category clears it. ErrCategoryConflict is returned when
+// setting CategoryAll on a rule with run as groups.
+func (c *Client) SudoRuleSetRunAsGroupCategory(rule, category string) error {
+	return c.sudoRuleCategory(rule, "ipasudorunasgroupcategory", category)
+}
+
+func (c *Client) sudoRuleCategory(rule, attr, category string) error {
+	if err := checkArgs("sudorule_mod", rule); err != nil {
+		return err
+	}
+
+	_, err := c.rpc("sudorule_mod", []string{rule}, Options{attr: category})
+	if err != nil && !IsNoModifications(err) {
+		return categoryConflict("sudorule_mod", rule, err)
+	}
+
+	return nil
+}
+
+// Wrap FreeIPA mutually exclusive errors in ErrCategoryConflict. FreeIPA
+// refuses categories of all and explicit members on the same rule.
+func categoryConflict(method, rule string, err error) error {
+	if ErrorCode(err) != ErrCodeMutuallyExclusive {
+		return err
+	}
+
+	return fmt.Errorf("ipa: %w: %s on %s, a category of all and explicit members can not be combined, remove the members or clear the category first: %w",
+		ErrCategoryConflict, method, rule, err)
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a client backed by a single sudo rule kept in memory. Run as users
+// and groups can not be added while the matching category is all
+func newSudoRuleStub(t *testing.T) *ipa.Client {
+	rule := map[string][]string{
+		"cn":             {"admins_nopasswd"},
+		"ipaenabledflag": {"TRUE"},
+		"cmdcategory":    {"all"},
+	}
+	add := func(attr string, vals ...string) {
+		for _, v := range vals {
+			found := false
+			for _, cur := range rule[attr] {
+				found = found || cur == v
+			}
+			if !found {
+				rule[attr] = append(rule[attr], v)
+			}
+		}
+	}
+	remove := func(attr string, vals ...string) {
+		keep := []string{}
+		for _, cur := range rule[attr] {
+			drop := false
+			for _, v := range vals {
+				drop = drop || cur == v
+			}
+			if !drop {
+				keep = append(keep, cur)
+			}
+		}
+		rule[attr] = keep
+	}
+
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		var options struct {
+			Option            string   `json:"ipasudoopt"`
+			User              []string `json:"user"`
+			Group             []string `json:"group"`
+			RunAsUserCategory *string  `json:"ipasudorunasusercategory"`
+		}
+		json.Unmarshal(req.Params[1], &options)
+
+		switch req.Method {
+		case "sudorule_add_option":
+			add("ipasudoopt", options.Option)
+		case "sudorule_remove_option":
+			remove("ipasudoopt", options.Option)
+		case "sudorule_add_runasuser":
+			if len(rule["ipasudorunasusercategory"]) > 0 {
+				stubError(ipa.ErrCodeMutuallyExclusive, "users cannot be added when runAs user or runAs group category='all'")(w, r)
+				return
+			}
+			add("ipasudorunas_user", options.User...)
+		case "sudorule_remove_runasuser":
+			remove("ipasudorunas_user", options.User...)
+		case "sudorule_add_runasgroup":
+			add("ipasudorunasgroup_group", options.Group...)
+		case "sudorule_remove_runasgroup":
+			remove("ipasudorunasgroup_group", options.Group...)
+		case "sudorule_mod":
+			if options.RunAsUserCategory != nil {
+				if *options.RunAsUserCategory != "" && len(rule["ipasudorunas_user"]) > 0 {
+					stubError(ipa.ErrCodeMutuallyExclusive, "user runAsUser category cannot be set to 'all' while there are allowed users")(w, r)
+					return
+				}
+				remove("ipasudorunasusercategory", "all")
+				if *options.RunAsUserCategory != "" {
+					add("ipasudorunasusercategory", *options.RunAsUserCategory)
+				}
+			}
+		}
+
+		out, _ := json.Marshal(rule)
+		stubResult(fmt.Sprintf(`{"result": %s, "completed": 1, "failed": {"ipasudorunas": {"user": [], "group": []}, "ipasudorunasgroup": {"group": []}}, "summary": null, "value": "admins_nopasswd"}`, out))(w, r)
+	})
+}
+
+func TestSudoRuleOptions(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newSudoRuleStub(t)
+
+	require.NoError(c.SudoRuleAddOption("admins_nopasswd", "!authenticate"))
+	require.NoError(c.SudoRuleAddOption("admins_nopasswd", "env_keep+=SSH_AUTH_SOCK"))
+	require.NoError(c.SudoRuleAddOption("admins_nopasswd", "verifypw=never"))
+	require.NoError(c.SudoRuleRemoveOption("admins_nopasswd", "verifypw=never"))
+	require.NoError(c.SudoRuleAddRunAsUser("admins_nopasswd", "root", "postgres"))
+	require.NoError(c.SudoRuleRemoveRunAsUser("admins_nopasswd", "postgres"))
+	require.NoError(c.SudoRuleAddRunAsGroup("admins_nopasswd", "wheel"))
+
+	rule, err := c.SudoRuleShow("admins_nopasswd")
+	require.NoError(err)
+	assert.Equal("admins_nopasswd", rule.Name)
+	assert.True(rule.Enabled)
+	assert.Equal(ipa.CategoryAll, rule.CommandCategory)
+	assert.Equal([]string{"!authenticate", "env_keep+=SSH_AUTH_SOCK"}, rule.Options)
+	assert.Equal([]string{"root"}, rule.RunAsUsers)
+	assert.Equal([]string{"wheel"}, rule.RunAsGroups)
+	assert.Empty(rule.RunAsUserCategory)
+
+	err = c.SudoRuleSetRunAsUserCategory("admins_nopasswd", ipa.CategoryAll)
+	assert.ErrorIs(err, ipa.ErrCategoryConflict)
+	assert.Equalf(ipa.ErrCodeMutuallyExclusive, ipa.ErrorCode(err), "Server error should be wrapped")
+
+	require.NoError(c.SudoRuleRemoveRunAsUser("admins_nopasswd", "root"))
+	require.NoError(c.SudoRuleSetRunAsUserCategory("admins_nopasswd", ipa.CategoryAll))
+	rule, err = c.SudoRuleShow("admins_nopasswd")
+	require.NoError(err)
+	assert.Equal(ipa.CategoryAll, rule.RunAsUserCategory)
+	assert.Empty(rule.RunAsUsers)
+
+	err = c.SudoRuleAddRunAsUser("admins_nopasswd", "root")
+	assert.ErrorIs(err, ipa.ErrCategoryConflict)
+
+	require.NoError(c.SudoRuleSetRunAsUserCategory("admins_nopasswd", ""))
+	require.NoError(c.SudoRuleAddRunAsUser("admins_nopasswd", "root"))
+}
+
+func TestSudoRuleErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubResult(`{"result": {"cn": ["admins_nopasswd"]}, "completed": 0,
+		"failed": {"ipasudorunas": {"user": [["nosuchuser", "no such entry"]], "group": []}}, "summary": null, "value": "admins_nopasswd"}`))
+
+	err := c.SudoRuleAddRunAsUser("admins_nopasswd", "nosuchuser")
+	assert.ErrorContains(err, "nosuchuser: no such entry")
+
+	assert.Error(c.SudoRuleAddRunAsGroup("admins_nopasswd"))
+	assert.ErrorIs(c.SudoRuleAddOption("", "!authenticate"), ipa.ErrEmptyArgument)
+	assert.ErrorIs(c.SudoRuleAddOption("admins_nopasswd", ""), ipa.ErrEmptyArgument)
+
+	c = newTestClientStub(t, stubError(ipa.ErrCodeNoModifications, "no modifications to be performed"))
+	assert.NoErrorf(c.SudoRuleAddOption("admins_nopasswd", "!authenticate"), "Adding an existing option should succeed")
+
+	c = newTestClientStub(t, stubError(ipa.ErrCodeNotFound, "admins_nopasswd: sudo rule not found"))
+	err = c.SudoRuleAddRunAsUser("admins_nopasswd", "root")
+	assert.True(ipa.IsNotFound(err))
+	assert.False(errors.Is(err, ipa.ErrCategoryConflict))
+}
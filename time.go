@@ -0,0 +1,174 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Time wraps time.Time to marshal FreeIPA datetimes using the class-hint
+// format, for example '{"__datetime__": "YYYYMMDDHHMMSSZ"}'. All time.Time
+// methods are available on Time. Use the embedded Time field where a
+// time.Time value is required. FreeIPA stores datetimes in UTC: times are
+// converted to UTC when marshalled and unmarshalled times are in UTC.
+type Time struct {
+	time.Time
+}
+
+// Returns new Time wrapping t
+func NewTime(t time.Time) Time {
+	return Time{Time: t}
+}
+
+// Marshal to the FreeIPA class-hint format. Zero times are marshalled as null
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(OptDateTime(t.Time))
+}
+
+// Unmarshal from the FreeIPA class-hint format or a plain string. Both the
+// FreeIPA datetime format and RFC 3339 strings are accepted. Values wrapped
+// in a single element array, as returned by FreeIPA, are also accepted.
+func (t *Time) UnmarshalJSON(b []byte) error {
+	if !gjson.ValidBytes(b) {
+		return errors.New("invalid datetime json")
+	}
+
+	dt, err := parseTime(gjson.ParseBytes(b))
+	if err != nil {
+		return err
+	}
+
+	t.Time = dt
+	return nil
+}
+
+// Datetime layouts accepted when parsing. LDAP generalized time, used by
+// operational attributes such as createtimestamp, may include fractional
+// seconds and a numeric time zone offset.
+var datetimeLayouts = []string{
+	IpaDatetimeFormat,
+	"20060102150405.999999999Z0700",
+	"20060102150405Z0700",
+	time.RFC3339Nano,
+}
+
+// Parse datetime from json value res. Times with a zone offset are converted
+// to UTC
+func parseTime(res gjson.Result) (time.Time, error) {
+	if res.IsArray() {
+		res = res.Get("0")
+	}
+	if res.IsObject() {
+		res = res.Get("__datetime__")
+	}
+
+	if !res.Exists() || res.Type == gjson.Null {
+		return time.Time{}, nil
+	}
+
+	if res.Type != gjson.String {
+		return time.Time{}, fmt.Errorf("invalid datetime: %s", res.Raw)
+	}
+
+	return parseDatetimeString(res.String())
+}
+
+// Parse datetime string str using datetimeLayouts, returning the time in UTC
+func parseDatetimeString(str string) (time.Time, error) {
+	for _, layout := range datetimeLayouts {
+		dt, err := time.Parse(layout, str)
+		if err == nil {
+			return dt.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid datetime: %s", str)
+}
+
+// Parse datetime attribute attr from FreeIPA record res. Invalid datetimes
+// are returned as the zero time.
+func parseTimeAttr(res gjson.Result, attr string) Time {
+	dt, _ := parseTime(res.Get(attr))
+	return Time{Time: dt}
+}
+
+// ErrInvalidDuration is returned for negative durations or durations which
+// are not a whole number of seconds
+var ErrInvalidDuration = errors.New("invalid duration")
+
+// Seconds is a duration in whole seconds, the unit FreeIPA uses for
+// attributes such as ipatokentotptimestep and krbpwdlockoutduration
+type Seconds int64
+
+// Returns d as Seconds. ErrInvalidDuration is returned if d is negative or
+// not a whole number of seconds.
+func NewSeconds(d time.Duration) (Seconds, error) {
+	if d < 0 || d%time.Second != 0 {
+		return 0, fmt.Errorf("ipa: %w: %s", ErrInvalidDuration, d)
+	}
+
+	return Seconds(d / time.Second), nil
+}
+
+// Returns s as a time.Duration
+func (s Seconds) Duration() time.Duration {
+	return time.Duration(s) * time.Second
+}
+
+func (s Seconds) String() string {
+	return s.Duration().String()
+}
+
+// Returns ErrInvalidDuration if s is negative
+func (s Seconds) validate() error {
+	if s < 0 {
+		return fmt.Errorf("ipa: %w: %d seconds", ErrInvalidDuration, int64(s))
+	}
+
+	return nil
+}
+
+// Unmarshal from an integer or a string holding an integer. Values wrapped
+// in a single element array, as returned by FreeIPA, are also accepted.
+func (s *Seconds) UnmarshalJSON(b []byte) error {
+	if !gjson.ValidBytes(b) {
+		return errors.New("invalid seconds json")
+	}
+
+	v, err := parseSeconds(gjson.ParseBytes(b))
+	if err != nil {
+		return err
+	}
+
+	*s = v
+	return nil
+}
+
+// Parse seconds from json value res
+func parseSeconds(res gjson.Result) (Seconds, error) {
+	if res.IsArray() {
+		res = res.Get("0")
+	}
+	if !res.Exists() || res.Type == gjson.Null {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseInt(res.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid seconds: %s", res.Raw)
+	}
+
+	return Seconds(v), nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestTimeJSON(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	want := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	for _, in := range []string{
+		`{"__datetime__": "20230405060708Z"}`,
+		`[{"__datetime__": "20230405060708Z"}]`,
+		`"20230405060708Z"`,
+		`"2023-04-05T06:07:08Z"`,
+		`"20230405060708.000Z"`,
+		`"20230405080708+0200"`,
+	} {
+		var dt ipa.Time
+		require.NoErrorf(json.Unmarshal([]byte(in), &dt), "Failed to unmarshal %s", in)
+		assert.Truef(want.Equal(dt.Time), "Invalid datetime for %s", in)
+		assert.Equalf(time.UTC, dt.Location(), "Datetime %s should be in UTC", in)
+	}
+
+	var dt ipa.Time
+	require.NoError(json.Unmarshal([]byte(`null`), &dt))
+	assert.True(dt.IsZero())
+
+	assert.Error(json.Unmarshal([]byte(`"yesterday"`), &dt))
+	assert.Error(json.Unmarshal([]byte(`{"__datetime__": 1}`), &dt))
+
+	out, err := json.Marshal(ipa.NewTime(want.In(time.FixedZone("EST", -5*60*60))))
+	require.NoError(err)
+	assert.JSONEq(`{"__datetime__": "20230405060708Z"}`, string(out))
+
+	out, err = json.Marshal(ipa.Time{})
+	require.NoError(err)
+	assert.Equal(`null`, string(out))
+
+	// Round trip
+	var rt ipa.Time
+	out, err = json.Marshal(ipa.NewTime(want))
+	require.NoError(err)
+	require.NoError(json.Unmarshal(out, &rt))
+	assert.True(want.Equal(rt.Time))
+}
+
+func TestParseDateTime(t *testing.T) {
+	assert := assert.New(t)
+
+	want := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	for _, in := range []string{"20230405060708Z", "20230405080708+0200", "2023-04-05T01:07:08-05:00"} {
+		dt := ipa.ParseDateTime(in)
+		assert.Truef(want.Equal(dt), "Invalid datetime for %s", in)
+		assert.Equalf(time.UTC, dt.Location(), "Datetime %s should be in UTC", in)
+	}
+
+	assert.True(ipa.ParseDateTime("yesterday").IsZero())
+}
+
+func TestDateTimeWireFormat(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	cest := time.FixedZone("CEST", 2*60*60)
+	local := time.Date(2023, 4, 5, 8, 7, 8, 0, cest)
+
+	out, err := json.Marshal(ipa.Options{
+		"time":     local,
+		"ptr":      &local,
+		"ipa":      ipa.NewTime(local),
+		"explicit": ipa.OptDateTime(local),
+	})
+	require.NoError(err)
+	assert.JSONEqf(`{"time": {"__datetime__": "20230405060708Z"}, "ptr": {"__datetime__": "20230405060708Z"},
+		"ipa": {"__datetime__": "20230405060708Z"}, "explicit": {"__datetime__": "20230405060708Z"}}`, string(out),
+		"Datetimes should be converted to UTC before formatting")
+}
+
+func TestSeconds(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	s, err := ipa.NewSeconds(90 * time.Second)
+	require.NoError(err)
+	assert.Equal(ipa.Seconds(90), s)
+	assert.Equal(90*time.Second, s.Duration())
+	assert.Equal("1m30s", s.String())
+
+	_, err = ipa.NewSeconds(1500 * time.Millisecond)
+	assert.ErrorIs(err, ipa.ErrInvalidDuration)
+	_, err = ipa.NewSeconds(-time.Second)
+	assert.ErrorIs(err, ipa.ErrInvalidDuration)
+
+	for _, in := range []string{`30`, `"30"`, `["30"]`, `[30]`} {
+		var v ipa.Seconds
+		require.NoErrorf(json.Unmarshal([]byte(in), &v), "Failed to unmarshal %s", in)
+		assert.Equalf(ipa.Seconds(30), v, "Invalid seconds for %s", in)
+	}
+
+	var v ipa.Seconds
+	assert.Error(json.Unmarshal([]byte(`"30s"`), &v))
+
+	out, err := json.Marshal(ipa.Seconds(30))
+	require.NoError(err)
+	assert.Equal(`30`, string(out))
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"context"
+	"time"
+)
+
+// Returns a client for calls which must complete within timeout, for example
+// c.WithCallTimeout(2*time.Second).UserShow("jdoe"). The deadline starts when
+// WithCallTimeout is called and covers every request made through the
+// returned client, including methods which make several requests and retries
+// by the caller. Requests running at the deadline fail with an error wrapping
+// context.DeadlineExceeded. Timeouts may be longer than the http.Client
+// timeout of c.
+//
+// The returned client shares the session and kerberos credentials of c. It
+// must not be used after c is closed.
+func (c *Client) WithCallTimeout(timeout time.Duration) *Client {
+	d := c.derive()
+	deadline := time.Now().Add(timeout)
+	if d.deadline.IsZero() || deadline.Before(d.deadline) {
+		d.deadline = deadline
+	}
+	d.allowTimeout(timeout)
+
+	return d
+}
+
+// Returns a client which limits each request to FreeIPA to timeout. Combined
+// with WithCallTimeout this caps each attempt while the call deadline covers
+// all attempts.
+func (c *Client) WithAttemptTimeout(timeout time.Duration) *Client {
+	d := c.derive()
+	d.reqTimeout = timeout
+	d.allowTimeout(timeout)
+
+	return d
+}
+
+// Returns a clone of c sharing its session, session logins and kerberos
+// credentials. A session set by either client, for example after the session
+// expired, is used by both, as are renewed kerberos credentials. Closing the
+// clone leaves c usable.
+func (c *Client) derive() *Client {
+	d := c.Clone()
+	d.parent = c.sessionOwner()
+
+	c.mu.RLock()
+	d.closed = c.closed
+	c.mu.RUnlock()
+
+	return d
+}
+
+// Raise the http client timeout to timeout so it does not cut short longer
+// call timeouts
+func (c *Client) allowTimeout(timeout time.Duration) {
+	if c.httpClient == nil || c.httpClient.Timeout <= 0 || c.httpClient.Timeout >= timeout {
+		return
+	}
+
+	httpClient := *c.httpClient
+	httpClient.Timeout = timeout
+	c.httpClient = &httpClient
+}
+
+// Returns the context for a single request, bounded by the call deadline and
+// attempt timeout
+func (c *Client) requestContext() (context.Context, context.CancelFunc) {
+	deadline := c.deadline
+	if c.reqTimeout > 0 {
+		if d := time.Now().Add(c.reqTimeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithDeadline(context.Background(), deadline)
+}
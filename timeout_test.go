@@ -0,0 +1,161 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns an http handler which waits delay before replying with result
+func stubSlowResult(delay time.Duration, result string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		stubResult(result)(w, r)
+	}
+}
+
+func TestWithCallTimeout(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, stubSlowResult(3*time.Second, `{"result": {"uid": ["jdoe"]}, "summary": null, "value": "jdoe"}`))
+
+	start := time.Now()
+	_, err := c.WithCallTimeout(2 * time.Second).UserShow("jdoe")
+	require.Error(err)
+	assert.Truef(errors.Is(err, context.DeadlineExceeded), "Expected deadline error: %s", err)
+	assert.Less(time.Since(start), 3*time.Second)
+
+	user, err := c.UserShow("jdoe")
+	require.NoErrorf(err, "Default client should not be limited by a derived client timeout")
+	assert.Equal("jdoe", user.Username)
+}
+
+func TestCallTimeoutCoversAllRequests(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var calls int32
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		stubSlowResult(100*time.Millisecond, `{"summary": "IPA server version 4.9.8", "value": null, "result": null}`)(w, r)
+	})
+
+	limited := c.WithCallTimeout(250 * time.Millisecond)
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		_, err = limited.Ping()
+	}
+	require.Error(err)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Equalf(int32(3), atomic.LoadInt32(&calls), "The deadline should span every request made through the client")
+
+	// Attempts are capped individually while the call deadline covers all
+	// of them
+	attempts := c.WithCallTimeout(time.Second).WithAttemptTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err = attempts.Ping()
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Less(time.Since(start), 500*time.Millisecond)
+
+	_, err = c.WithAttemptTimeout(time.Second).Ping()
+	assert.NoError(err)
+}
+
+func TestCallTimeoutFormPost(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+
+	err := c.WithCallTimeout(100*time.Millisecond).SetPassword("jdoe", "old", "new", "")
+	assert.Truef(errors.Is(err, context.DeadlineExceeded), "Expected deadline error: %s", err)
+
+	err = c.WithCallTimeout(100*time.Millisecond).RemoteLogin("jdoe", "secret")
+	assert.Truef(errors.Is(err, context.DeadlineExceeded), "Expected deadline error: %s", err)
+}
+
+func TestCallTimeoutSession(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	session := strings.Repeat("a1b2c3d4", 8)
+	var cookies []string
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		w.Header().Set("Set-Cookie", fmt.Sprintf("ipa_session=%s; Path=/ipa; HttpOnly; Secure", session))
+		stubResult(`{"summary": "IPA server version 4.9.8", "value": null, "result": null}`)(w, r)
+	})
+	c.SetSessionValidator(func(cookie string) bool {
+		return len(cookie) == 64
+	})
+
+	_, err := c.Ping()
+	require.NoError(err)
+
+	limited := c.WithCallTimeout(time.Second)
+	_, err = limited.Ping()
+	require.NoError(err)
+	assert.Equalf("ipa_session="+session, cookies[1], "Derived client should use the session of the original")
+
+	require.NoError(limited.Close())
+	_, err = c.Ping()
+	assert.NoErrorf(err, "Closing a derived client should not close the original")
+	assert.Equal(session, c.SessionID())
+
+	require.NoError(c.Close())
+	_, err = c.WithCallTimeout(time.Second).Ping()
+	assert.ErrorIs(err, ipa.ErrClientClosed)
+}
+
+func TestCallTimeoutLongerThanHTTPTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID int `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": {"summary": "IPA server version 4.9.8", "value": null, "result": null}}`, req.ID)
+	}))
+	defer ts.Close()
+
+	httpClient := ts.Client()
+	httpClient.Timeout = 100 * time.Millisecond
+	c := ipa.NewClientCustomHttp(ts.Listener.Addr().String(), "LOCAL", httpClient)
+
+	_, err := c.Ping()
+	assert.Errorf(err, "Default client should time out")
+
+	_, err = c.WithCallTimeout(2 * time.Second).Ping()
+	assert.NoErrorf(err, "Call timeout should override a shorter http client timeout")
+	assert.Equal(100*time.Millisecond, httpClient.Timeout)
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedEntity is returned by SetEnabled for entities which can not
+// be enabled and disabled
+var ErrUnsupportedEntity = errors.New("entity can not be enabled or disabled")
+
+// Toggleable is an entry which can be enabled and disabled with SetEnabled
+type Toggleable interface {
+	// Returns the FreeIPA object name and primary key of the entry, for
+	// example "hbacrule" and the rule name
+	ToggleKey() (entity, name string)
+}
+
+// EntryRef refers to an entry by FreeIPA object name and primary key
+type EntryRef struct {
+	Entity string
+	Name   string
+}
+
+func (r EntryRef) ToggleKey() (string, string) {
+	return r.Entity, r.Name
+}
+
+func (r *HbacRule) ToggleKey() (string, string) {
+	return "hbacrule", r.Name
+}
+
+func (r *SudoRule) ToggleKey() (string, string) {
+	return "sudorule", r.Name
+}
+
+func (t *OTPToken) ToggleKey() (string, string) {
+	return "otptoken", t.UUID
+}
+
+// Enable or disable entry name of entity, which is one of "hbacrule",
+// "sudorule" or "otptoken". Enabling an entry which is already enabled, or
+// disabling one which is already disabled, succeeds. Other entities return
+// ErrUnsupportedEntity.
+func (c *Client) SetEnabled(entity, name string, enabled bool) error {
+	var err error
+	switch entity {
+	case "hbacrule", "sudorule":
+		method := entity + "_disable"
+		if enabled {
+			method = entity + "_enable"
+		}
+		_, err = c.rpc(method, []string{name}, Options{})
+	case "otptoken":
+		_, err = c.rpc("otptoken_mod", []string{name}, Options{
+			"ipatokendisabled": !enabled,
+			"all":              false,
+		})
+	default:
+		return fmt.Errorf("ipa: %w: %s", ErrUnsupportedEntity, entity)
+	}
+
+	switch ErrorCode(err) {
+	case ErrCodeAlreadyActive, ErrCodeAlreadyInactive, ErrCodeNoModifications:
+		return nil
+	}
+
+	return err
+}
+
+// Enable or disable entry, see SetEnabled
+func (c *Client) SetEntryEnabled(entry Toggleable, enabled bool) error {
+	entity, name := entry.ToggleKey()
+	return c.SetEnabled(entity, name, enabled)
+}
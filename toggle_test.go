@@ -0,0 +1,87 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+func TestSetEnabled(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var calls []string
+	var otpOptions []map[string]interface{}
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args := req.Args
+		calls = append(calls, req.Method+" "+args[0])
+
+		switch {
+		case args[0] == "missing":
+			stubError(4001, "missing: HBAC rule not found")(w, r)
+		case args[0] == "active" && req.Method != "otptoken_mod":
+			stubError(4009, "This entry is already enabled")(w, r)
+		case args[0] == "inactive" && req.Method != "otptoken_mod":
+			stubError(4010, "This entry is already disabled")(w, r)
+		case req.Method == "otptoken_mod":
+			options := req.Options
+			otpOptions = append(otpOptions, options)
+			if args[0] == "active" {
+				stubError(4202, "no modifications to be performed")(w, r)
+				return
+			}
+			stubResult(`{"result": {"ipatokenuniqueid": ["tok1"]}, "value": "tok1"}`)(w, r)
+		default:
+			stubResult(`{"result": true, "value": "`+args[0]+`"}`)(w, r)
+		}
+	})
+
+	for _, entity := range []string{"hbacrule", "sudorule"} {
+		t.Run(entity, func(t *testing.T) {
+			calls = nil
+			assert.NoError(c.SetEnabled(entity, "allow_ssh", true))
+			assert.NoError(c.SetEnabled(entity, "allow_ssh", false))
+			assert.NoErrorf(c.SetEnabled(entity, "active", true), "Already enabled should succeed")
+			assert.NoErrorf(c.SetEnabled(entity, "inactive", false), "Already disabled should succeed")
+			assert.Equal([]string{
+				entity + "_enable allow_ssh",
+				entity + "_disable allow_ssh",
+				entity + "_enable active",
+				entity + "_disable inactive",
+			}, calls)
+
+			var ierr *ipa.IpaError
+			require.ErrorAs(c.SetEnabled(entity, "missing", true), &ierr)
+			assert.Equal(4001, ierr.Code)
+		})
+	}
+
+	t.Run("otptoken", func(t *testing.T) {
+		calls = nil
+		assert.NoError(c.SetEnabled("otptoken", "tok1", false))
+		assert.NoErrorf(c.SetEntryEnabled(&ipa.OTPToken{UUID: "active"}, true), "Already enabled should succeed")
+		assert.Equal([]string{"otptoken_mod tok1", "otptoken_mod active"}, calls)
+		require.Len(otpOptions, 2)
+		assert.Equal(true, otpOptions[0]["ipatokendisabled"])
+		assert.Equal(false, otpOptions[1]["ipatokendisabled"])
+	})
+
+	calls = nil
+	require.NoError(c.DisableHbacRule("allow_all"))
+	require.NoError(c.EnableSudoRule("break_glass"))
+	require.NoError(c.SetEntryEnabled(ipa.EntryRef{Entity: "sudorule", Name: "admins"}, false))
+	assert.Equal([]string{"hbacrule_disable allow_all", "sudorule_enable break_glass", "sudorule_disable admins"}, calls)
+
+	calls = nil
+	assert.ErrorIs(c.SetEnabled("hostgroup", "web", false), ipa.ErrUnsupportedEntity)
+	assert.ErrorIs(c.SetEnabled("hbacrule", "", true), ipa.ErrEmptyArgument)
+	assert.Empty(calls)
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTraceBodyBytes is the number of body bytes traced when logrus trace
+// logging is enabled and no trace writer is set
+const DefaultTraceBodyBytes = 4096
+
+// Extra bytes redacted beyond the traced body so secrets straddling the
+// truncation point are still matched
+const traceRedactMargin = 256
+
+// Writes request and response summaries for FreeIPA calls
+type tracer struct {
+	mu         sync.Mutex
+	w          io.Writer
+	maxBody    int
+	sampleRate float64
+}
+
+// Used when logrus trace logging is enabled and no trace writer is set
+var logTracer = &tracer{w: logTraceWriter{}, maxBody: DefaultTraceBodyBytes, sampleRate: 1}
+
+// Writes each trace to logrus at trace level
+type logTraceWriter struct{}
+
+func (logTraceWriter) Write(p []byte) (int, error) {
+	log.Trace(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Trace FreeIPA calls to w. Each trace has the method, URL, status and
+// duration and the first maxBodyBytes of the request and response bodies
+// with secrets redacted. Use a maxBodyBytes of -1 to trace full bodies and 0
+// to omit bodies. Calls are traced with probability sampleRate, use 1 to
+// trace every call. A nil w disables tracing, in which case calls are traced
+// to logrus if the trace level is enabled.
+func (c *Client) SetTraceWriter(w io.Writer, maxBodyBytes int, sampleRate float64) {
+	if w == nil {
+		c.tracer = nil
+		return
+	}
+
+	c.tracer = &tracer{w: w, maxBody: maxBodyBytes, sampleRate: sampleRate}
+}
+
+// Returns the tracer for a call or nil if the call is not traced
+func (c *Client) sampleTrace() *tracer {
+	t := c.tracer
+	if t == nil {
+		if !log.IsLevelEnabled(log.TraceLevel) {
+			return nil
+		}
+		t = logTracer
+	}
+
+	if t.sampleRate < 1 && rand.Float64() >= t.sampleRate {
+		return nil
+	}
+
+	return t
+}
+
+// Write a trace for the call name. res and resBody may be nil if the call
+// failed. Safe to call on a nil tracer.
+func (t *tracer) trace(name string, req *http.Request, reqBody []byte, res *http.Response, resBody []byte, start time.Time, err error) {
+	if t == nil {
+		return
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "FreeIPA %s %s %s", name, req.Method, req.URL.Redacted())
+	if res != nil {
+		fmt.Fprintf(&b, " status=%d", res.StatusCode)
+	}
+	fmt.Fprintf(&b, " duration=%s", time.Since(start).Round(time.Microsecond))
+	if err != nil {
+		fmt.Fprintf(&b, " error=%q", err)
+	}
+	b.WriteString("\n")
+
+	if t.maxBody != 0 {
+		if reqBody != nil {
+			b.WriteString("> ")
+			b.Write(t.body(reqBody))
+			b.WriteString("\n")
+		}
+		if resBody != nil {
+			b.WriteString("< ")
+			b.Write(t.body(resBody))
+			b.WriteString("\n")
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(b.Bytes())
+}
+
+// Trace a call whose response body is not otherwise read. Reads at most the
+// traced number of bytes from the response body.
+func (t *tracer) traceResponse(name string, req *http.Request, reqBody []byte, res *http.Response, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	var body []byte
+	if t.maxBody < 0 {
+		body, _ = io.ReadAll(res.Body)
+	} else if t.maxBody > 0 {
+		body, _ = io.ReadAll(io.LimitReader(res.Body, int64(t.maxBody+traceRedactMargin)))
+	}
+
+	t.trace(name, req, reqBody, res, body, start, nil)
+}
+
+// Returns body redacted and truncated to the traced number of bytes
+func (t *tracer) body(body []byte) []byte {
+	if t.maxBody < 0 {
+		return redactSecrets(body)
+	}
+
+	if len(body) > t.maxBody+traceRedactMargin {
+		body = body[:t.maxBody+traceRedactMargin]
+	}
+	body = redactSecrets(body)
+	if len(body) <= t.maxBody {
+		return body
+	}
+
+	out := append([]byte{}, body[:t.maxBody]...)
+	return append(out, "... [truncated]"...)
+}
+
+// Returns the encoded form with secret fields redacted
+func redactForm(form url.Values) []byte {
+	redactedForm := url.Values{}
+	for k, v := range form {
+		redactedForm[k] = v
+	}
+	for _, k := range secretOptions {
+		if _, ok := redactedForm[k]; ok {
+			redactedForm[k] = []string{redacted}
+		}
+	}
+
+	return []byte(redactedForm.Encode())
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceWriter(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	records := `[` + strings.Repeat(`{"uid": ["jdoe"]},`, 100) + `{"uid": ["jsmith"]}]`
+	c := newTestClientStub(t, stubResult(`{"count": 101, "result": `+records+`, "summary": null, "truncated": false}`))
+
+	var trace bytes.Buffer
+	c.SetTraceWriter(&trace, 64, 1)
+
+	users, err := c.UserFind(nil)
+	require.NoError(err)
+	require.Len(users, 101)
+
+	out := trace.String()
+	assert.Regexp(`^FreeIPA user_find POST https://127.0.0.1:\d+/ipa/json status=200 duration=\S+\n`, out)
+	assert.Contains(out, "\n> {")
+	assert.Contains(out, "\n< {")
+	assert.Contains(out, "... [truncated]")
+	assert.NotContainsf(out, "jsmith", "Response body should be truncated")
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		assert.LessOrEqual(len(line), 2+64+len("... [truncated]"))
+	}
+
+	trace.Reset()
+	c.SetTraceWriter(&trace, -1, 1)
+	require.NoError(c.ChangePassword("jdoe", "old-s3cret", "new-s3cret", "123456"))
+	out = trace.String()
+	assert.Contains(out, `"password":"[REDACTED]"`)
+	assert.Contains(out, `"current_password":"[REDACTED]"`)
+	assert.Contains(out, `"otp":"[REDACTED]"`)
+	assert.NotContains(out, "s3cret")
+	assert.NotContains(out, "123456")
+	assert.NotContains(out, "... [truncated]")
+
+	trace.Reset()
+	c.SetTraceWriter(&trace, 0, 1)
+	_, err = c.UserFind(nil)
+	require.NoError(err)
+	assert.Equalf(1, strings.Count(trace.String(), "\n"), "Bodies should be omitted")
+
+	trace.Reset()
+	c.SetTraceWriter(&trace, -1, 0)
+	_, err = c.UserFind(nil)
+	require.NoError(err)
+	assert.Emptyf(trace.String(), "Sample rate 0 should not trace")
+}
+
+func TestTraceWriterForm(t *testing.T) {
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-IPA-Pwchange-Result", "ok")
+		w.Write([]byte("Password change successful"))
+	})
+
+	var trace bytes.Buffer
+	c.SetTraceWriter(&trace, -1, 1)
+
+	require.NoError(t, c.SetPassword("jdoe", "old-s3cret", "new-s3cret", "123456"))
+	out := trace.String()
+	assert.Contains(t, out, "FreeIPA change_password POST")
+	assert.Contains(t, out, "status=200")
+	assert.Contains(t, out, "new_password=%5BREDACTED%5D")
+	assert.Contains(t, out, "< Password change successful")
+	assert.NotContains(t, out, "s3cret")
+	assert.NotContains(t, out, "123456")
+}
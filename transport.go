@@ -0,0 +1,122 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ClientOption configures the http transport used by the client. See
+// Client.Configure
+type ClientOption func(t *http.Transport) error
+
+// Send requests through the proxy at u, for example "http://proxy:3128".
+// HTTPS requests, including those authenticated with kerberos, are tunneled
+// using CONNECT. Kerberos tickets are still requested from the KDC directly.
+// By default the proxy is taken from the HTTPS_PROXY and NO_PROXY environment
+// variables.
+func WithProxyURL(u string) ClientOption {
+	return func(t *http.Transport) error {
+		proxyURL, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("ipa: invalid proxy url: %w", err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return fmt.Errorf("ipa: invalid proxy url: %s", u)
+		}
+
+		t.Proxy = http.ProxyURL(proxyURL)
+		return nil
+	}
+}
+
+// Dial connections to FreeIPA, or the proxy if set, using d. Use this to plug
+// in a SOCKS5 dialer, for example from golang.org/x/net/proxy, or to tunnel
+// through an SSH bastion.
+func WithDialContext(d func(ctx context.Context, network, addr string) (net.Conn, error)) ClientOption {
+	return func(t *http.Transport) error {
+		if d == nil {
+			return errors.New("ipa: dial function is required")
+		}
+
+		t.DialContext = d
+		return nil
+	}
+}
+
+// Apply options to a copy of the client http transport and use it for
+// requests made by this client. The transport of clients it was cloned or
+// derived from, and of a custom http client, is left unchanged. The transport
+// must be an *http.Transport, which is always the case unless a custom http
+// client was provided. Configure should be called before the client is used
+// concurrently.
+func (c *Client) Configure(opts ...ClientOption) error {
+	transport, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("ipa: unsupported http transport %T", c.httpClient.Transport)
+	}
+
+	transport = transport.Clone()
+	for _, opt := range opts {
+		if err := opt(transport); err != nil {
+			return err
+		}
+	}
+
+	httpClient := *c.httpClient
+	httpClient.Transport = transport
+	c.httpClient = &httpClient
+	c.ownsHTTP = true
+
+	return nil
+}
+
+// Open a connection to FreeIPA and return it to the pool so the next call
+// does not pay for TCP and TLS setup. This sends an unauthenticated HEAD
+// request to /ipa/ui/ and succeeds whatever the response status; kerberos
+// and session authentication happen on the next call as usual. Idle
+// connections are closed by the transport after its idle timeout.
+func (c *Client) Warm(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fmt.Sprintf("https://%s/ipa/ui/", c.host), nil)
+	if err != nil {
+		return err
+	}
+
+	conn := &connTrace{}
+	req = conn.attach(req)
+
+	res, err := c.httpClient.Do(req)
+	c.recordConn("", conn)
+	if err != nil {
+		return tlsError(c.host, err)
+	}
+
+	// The body must be drained for the connection to be reused
+	io.Copy(io.Discard, res.Body)
+	return res.Body.Close()
+}
+
+// Returns a copy of the TLS config FreeIPA is verified with, for connecting to
+// other services on the FreeIPA host such as LDAP. Transports without a TLS
+// config, for example of custom http clients, get a config trusting the IPA
+// CA pool.
+func (c *Client) TLSConfig() *tls.Config {
+	if t, ok := c.httpClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		return t.TLSClientConfig.Clone()
+	}
+
+	return &tls.Config{RootCAs: ipaCertPool}
+}
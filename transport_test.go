@@ -0,0 +1,211 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// Returns a test proxy which tunnels CONNECT requests and counts them
+func newConnectProxy(t *testing.T, tunnels *int32) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		atomic.AddInt32(tunnels, 1)
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestProxyURL(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var tunnels int32
+	proxy := newConnectProxy(t, &tunnels)
+
+	c := newTestClientStub(t, stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`))
+	require.NoError(c.Configure(ipa.WithProxyURL(proxy.URL)))
+
+	_, err := c.Ping()
+	require.NoError(err)
+	assert.Equalf(int32(1), atomic.LoadInt32(&tunnels), "Request should be tunneled through the proxy")
+
+	assert.Error(c.Configure(ipa.WithProxyURL("proxy:3128")))
+	assert.Error(ipa.NewClientCustomHttp("localhost", "LOCAL", &http.Client{}).Configure(ipa.WithProxyURL(proxy.URL)))
+}
+
+func TestDialContext(t *testing.T) {
+	require := require.New(t)
+
+	var dials int32
+	dialer := &net.Dialer{}
+	c := newTestClientStub(t, stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`))
+	err := c.Configure(ipa.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dialer.DialContext(ctx, network, addr)
+	}))
+	require.NoError(err)
+
+	_, err = c.Ping()
+	require.NoError(err)
+	require.Equal(int32(1), atomic.LoadInt32(&dials))
+
+	require.Error(c.Configure(ipa.WithDialContext(nil)))
+}
+
+func TestConfigureCopiesTransport(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var dials int32
+	dialer := &net.Dialer{}
+	dial := ipa.WithDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dialer.DialContext(ctx, network, addr)
+	})
+
+	c := newTestClientStub(t, stubResult(`{"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}`))
+	derived := c.WithCallTimeout(time.Minute)
+	require.NoError(derived.Configure(dial))
+
+	_, err := c.Ping()
+	require.NoError(err)
+	assert.Equalf(int32(0), atomic.LoadInt32(&dials), "Configuring a derived client should not change the parent transport")
+
+	_, err = derived.Ping()
+	require.NoError(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&dials))
+
+	// The transport of a custom http client is not modified
+	custom := &http.Transport{}
+	c = ipa.NewClientCustomHttp("ipa.example.com", "EXAMPLE.COM", &http.Client{Transport: custom})
+	require.NoError(c.Configure(dial))
+	assert.Nil(custom.DialContext)
+}
+
+// Returns the TLS config of the client http transport
+func clientTLSConfig(t *testing.T, c *ipa.Client) *tls.Config {
+	var cfg *tls.Config
+	require.NoError(t, c.Configure(func(tr *http.Transport) error {
+		cfg = tr.TLSClientConfig
+		return nil
+	}))
+
+	return cfg
+}
+
+func TestTLSSessionCache(t *testing.T) {
+	cfg := clientTLSConfig(t, ipa.NewClient("ipa.example.com", "EXAMPLE.COM"))
+	require.NotNil(t, cfg)
+	assert.NotNilf(t, cfg.ClientSessionCache, "Owned transport should cache TLS sessions")
+
+	// A custom http client is used as given
+	custom := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{}}}
+	cfg = clientTLSConfig(t, ipa.NewClientCustomHttp("ipa.example.com", "EXAMPLE.COM", custom))
+	assert.Nil(t, cfg.ClientSessionCache)
+}
+
+func TestWarm(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Close the first connection so the next call needs a new one
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Connection", "close")
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+
+		var req struct {
+			ID int `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprintf(w, `{"error": null, "id": %d, "principal": "admin@LOCAL", "version": "4.9.8", "result": {"summary": "IPA server version 4.9.8. API version 2.245", "value": null, "result": null}}`, req.ID)
+	}))
+	t.Cleanup(ts.Close)
+
+	// The owned transport trusting the test server
+	c := ipa.NewClient(ts.Listener.Addr().String(), "LOCAL")
+	t.Cleanup(func() { c.Close() })
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	require.NoError(c.Configure(func(tr *http.Transport) error {
+		tr.TLSClientConfig.RootCAs = pool
+		return nil
+	}))
+
+	var events []*ipa.Event
+	c.SetObserver(func(event *ipa.Event) {
+		events = append(events, event)
+	})
+	c.SetConnectionEvents(true)
+
+	require.NoError(c.Warm(context.Background()))
+	assert.False(c.LastCallReusedConn())
+
+	_, err := c.Ping()
+	require.NoError(err)
+	assert.False(c.LastCallReusedConn())
+
+	_, err = c.Ping()
+	require.NoError(err)
+	assert.True(c.LastCallReusedConn())
+
+	require.Len(events, 3)
+	assert.Equal(ipa.EventConnection, events[0].Type)
+	assert.False(events[0].TLSResumed)
+	assert.Truef(events[1].TLSResumed, "New connection should resume the TLS session")
+	assert.True(events[2].ConnReused)
+	assert.Equal("ping", events[2].Method)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(c.Warm(ctx), context.Canceled)
+
+	c.Close()
+	assert.ErrorIs(c.Warm(context.Background()), ipa.ErrClientClosed)
+}
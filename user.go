@@ -10,8 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,6 +20,44 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// User authentication types supported by FreeIPA
+const (
+	AuthTypePassword = "password"
+	AuthTypeRADIUS   = "radius"
+	AuthTypeOTP      = "otp"
+	AuthTypePKINIT   = "pkinit"
+	AuthTypeHardened = "hardened"
+	AuthTypeIdP      = "idp"
+	AuthTypePasskey  = "passkey"
+)
+
+// AdminUser is the built-in FreeIPA admin account
+const AdminUser = "admin"
+
+// UserScope selects which users are searched by UserFindScope
+type UserScope int
+
+const (
+	// UserScopeActive searches active users only
+	UserScopeActive UserScope = iota
+
+	// UserScopePreserved searches preserved (soft deleted) users only
+	UserScopePreserved
+
+	// UserScopeAll searches both active and preserved users
+	UserScopeAll
+)
+
+var authTypes = map[string]bool{
+	AuthTypePassword: true,
+	AuthTypeRADIUS:   true,
+	AuthTypeOTP:      true,
+	AuthTypePKINIT:   true,
+	AuthTypeHardened: true,
+	AuthTypeIdP:      true,
+	AuthTypePasskey:  true,
+}
+
 // User encapsulates user data returned from ipa user commands
 type User struct {
 	UUID             string              `json:"ipauniqueid"`
@@ -32,8 +70,10 @@ type User struct {
 	Uid              string              `json:"uidnumber"`
 	Gid              string              `json:"gidnumber"`
 	Groups           []string            `json:"memberof_group"`
+	IndirectGroups   []string            `json:"memberofindirect_group"`
 	SSHAuthKeys      []*SSHAuthorizedKey `json:"ipasshpubkey"`
 	AuthTypes        []string            `json:"ipauserauthtype"`
+	Passkeys         []string            `json:"ipapasskey"`
 	HasKeytab        bool                `json:"has_keytab"`
 	HasPassword      bool                `json:"has_password"`
 	Locked           bool                `json:"nsaccountlock"`
@@ -43,15 +83,109 @@ type User struct {
 	TelephoneNumber  string              `json:"telephonenumber"`
 	Mobile           string              `json:"mobile"`
 	Shell            string              `json:"loginshell"`
-	Category         string              `json:"userclass"`
 	SudoRules        []string            `json:"memberofindirect_sudorule"`
 	HbacRules        []string            `json:"memberofindirect_hbacrule"`
-	LastPasswdChange time.Time           `json:"krblastpwdchange"`
-	PasswdExpire     time.Time           `json:"krbpasswordexpiration"`
-	PrincipalExpire  time.Time           `json:"krbprincipalexpiration"`
-	LastLoginSuccess time.Time           `json:"krblastsuccessfulauth"`
-	LastLoginFail    time.Time           `json:"krblastfailedauth"`
-	RandomPassword   string              `json:"randompassword"`
+	LastPasswdChange Time                `json:"krblastpwdchange"`
+	PasswdExpire     Time                `json:"krbpasswordexpiration"`
+	PrincipalExpire  Time                `json:"krbprincipalexpiration"`
+	LastLoginSuccess Time                `json:"krblastsuccessfulauth"`
+	LastLoginFail    Time                `json:"krblastfailedauth"`
+	RandomPassword   Secret              `json:"randompassword"`
+	CreateTimestamp  Time                `json:"createtimestamp"`
+	ModifyTimestamp  Time                `json:"modifytimestamp"`
+
+	// Canonical kerberos principal and all principals including aliases
+	CanonicalPrincipal string   `json:"krbcanonicalname"`
+	Principals         []string `json:"-"`
+
+	// User classes. userclass is multi-valued, Category is the first class.
+	// If Category is changed from the loaded value it replaces the first
+	// class when sending the user to FreeIPA, so code setting only Category
+	// keeps working. Otherwise Categories is sent as is
+	Category   string   `json:"userclass"`
+	Categories []string `json:"userclasses"`
+
+	// DN of the password policy applied to the user, set when the user has
+	// a group password policy. Only returned by FreeIPA with all. See
+	// EffectivePasswordPolicy
+	PasswordPolicyDN string `json:"krbpwdpolicyreference"`
+
+	// Manager uid. Manager DNs, returned with raw output, are converted to
+	// the uid
+	Manager string `json:"manager"`
+
+	// Name of the RADIUS proxy server the user's second factor is delegated
+	// to and the user's name on it
+	RadiusConfig   string `json:"ipatokenradiusconfiglink"`
+	RadiusUsername string `json:"ipatokenradiususername"`
+
+	// Name of the external identity provider the user authenticates with
+	// and the user's identifier at the provider
+	IdPConfig string `json:"ipaidpconfiglink"`
+	IdPUser   string `json:"ipaidpsub"`
+
+	// Name of the primary group. Only set by UserPrimaryGroup and by
+	// UserShow with WithResolvePrimaryGroup
+	PrimaryGroupName string `json:"-"`
+
+	// If true ToOptions includes Locked so UserMod locks or unlocks the
+	// user. Defaults to false so modifying a user never changes the lock
+	// status by accident.
+	UpdateLocked bool `json:"-"`
+
+	// If true ToOptions sends string attributes as is. By default they are
+	// normalized to Unicode NFC so composed and decomposed forms of the same
+	// name are stored, and compared by DiffUsers, as the same value.
+	NoNormalize bool `json:"-"`
+
+	// Unmapped attributes modified by UserMod after the attributes above,
+	// see ExtraAttrs
+	Extra ExtraAttrs `json:"-"`
+
+	// Raw user record json as returned by FreeIPA. Use this to access
+	// attributes not parsed into User, for example
+	// gjson.GetBytes(u.Raw, "departmentnumber.0")
+	Raw json.RawMessage `json:"-"`
+
+	// Parts of the user record requested from FreeIPA. Slices and
+	// attributes which were not loaded are left empty, check Loaded to
+	// tell them apart from empty values.
+	Loaded UserLoaded `json:"-"`
+
+	// modifytimestamp when loaded with WithETag, see UserModGuarded
+	loadedVersion time.Time
+
+	// Category as loaded, to tell whether the caller changed it
+	loadedCategory string
+}
+
+// UserLoaded is a bitmask of the parts of a User loaded from FreeIPA
+type UserLoaded uint
+
+const (
+	// Groups, IndirectGroups, HbacRules and SudoRules
+	UserLoadedMembers UserLoaded = 1 << iota
+
+	// All attributes, not only the FreeIPA default attributes or the
+	// attributes requested from UserShowFast
+	UserLoadedAll
+
+	// Every part of the user, as loaded by UserShow
+	UserLoadedFull = UserLoadedMembers | UserLoadedAll
+)
+
+// Returns the parts of the user records returned by a call with options:
+// memberships unless no_members was set and all attributes if all was set
+func userLoaded(options Options) UserLoaded {
+	var loaded UserLoaded
+	if noMembers, _ := options["no_members"].(bool); !noMembers {
+		loaded |= UserLoadedMembers
+	}
+	if all, _ := options["all"].(bool); all {
+		loaded |= UserLoadedAll
+	}
+
+	return loaded
 }
 
 // SSH Public Key
@@ -93,6 +227,91 @@ func (k *SSHAuthorizedKey) MarshalJSON() ([]byte, error) {
 	return json.Marshal(k.String())
 }
 
+func removeSSHAuthorizedKey(keys []*SSHAuthorizedKey, fingerprint string) []*SSHAuthorizedKey {
+	index := -1
+	for i, k := range keys {
+		if k.Fingerprint == fingerprint {
+			index = i
+			break
+		}
+	}
+
+	if index != -1 {
+		keys = append(keys[:index], keys[index+1:]...)
+	}
+
+	return keys
+}
+
+// SSHKeyPolicy controls how a key is added when a key with the same
+// fingerprint exists. Keys are compared by fingerprint only, so keys which
+// differ only in comment or options, for example from="..." restrictions,
+// are duplicates.
+type SSHKeyPolicy int
+
+const (
+	// SSHKeyReplace replaces the existing key, updating its comment and
+	// options
+	SSHKeyReplace SSHKeyPolicy = iota
+
+	// SSHKeyErrorOnDuplicate refuses the key with ErrDuplicateSSHKey
+	SSHKeyErrorOnDuplicate
+
+	// SSHKeyKeepBoth keeps both keys. FreeIPA rejects duplicate public keys
+	// so this policy is always refused.
+	SSHKeyKeepBoth
+)
+
+// ErrDuplicateSSHKey is returned when adding an ssh key whose fingerprint
+// matches an existing key with SSHKeyErrorOnDuplicate
+var ErrDuplicateSSHKey = errors.New("duplicate ssh key")
+
+// Add key to keys according to policy. Returns true if an existing key was
+// replaced
+func addSSHAuthorizedKey(keys []*SSHAuthorizedKey, key *SSHAuthorizedKey, policy SSHKeyPolicy) ([]*SSHAuthorizedKey, bool, error) {
+	if policy == SSHKeyKeepBoth {
+		return keys, false, errors.New("ipa: FreeIPA does not allow duplicate ssh keys")
+	}
+
+	index := -1
+	for i, k := range keys {
+		if key.Fingerprint == k.Fingerprint {
+			index = i
+		}
+	}
+
+	if index == -1 {
+		return append(keys, key), false, nil
+	}
+
+	if policy == SSHKeyErrorOnDuplicate {
+		return keys, false, fmt.Errorf("ipa: %w: %s", ErrDuplicateSSHKey, key.Fingerprint)
+	}
+
+	keys[index] = key
+	return keys, true, nil
+}
+
+// Returns true if keys contains a key with fingerprint
+func hasSSHKey(keys []*SSHAuthorizedKey, fingerprint string) bool {
+	for _, k := range keys {
+		if k.Fingerprint == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatSSHAuthorizedKeys(keys []*SSHAuthorizedKey) []string {
+	out := []string{}
+	for _, k := range keys {
+		out = append(out, k.String())
+	}
+
+	return out
+}
+
 func (u *User) ToOptions() Options {
 	options := Options{
 		"mail":            u.Email,
@@ -104,48 +323,107 @@ func (u *User) ToOptions() Options {
 		"ipasshpubkey":    u.FormatSSHAuthorizedKeys(),
 		"telephonenumber": u.TelephoneNumber,
 		"mobile":          u.Mobile,
-		"userclass":       u.Category,
+		"userclass":       "",
+	}
+
+	if classes := u.userClasses(); len(classes) > 0 {
+		options["userclass"] = classes
+	}
+
+	if !u.NoNormalize {
+		for k, v := range options {
+			switch v := v.(type) {
+			case string:
+				options[k] = normalizeString(v)
+			case []string:
+				if k == "userclass" {
+					for i := range v {
+						v[i] = normalizeString(v[i])
+					}
+				}
+			}
+		}
+	}
+
+	if u.UpdateLocked {
+		options.SetAttr("nsaccountlock", OptBool(u.Locked))
 	}
 
 	return options
 }
 
 func (u *User) fromJSON(raw []byte) error {
-	if !gjson.ValidBytes(raw) {
+	if !isRecord(raw) {
 		return errors.New("invalid user record json")
 	}
 
 	res := gjson.ParseBytes(raw)
+	u.Raw = stripSecrets(raw)
+
+	for _, attr := range []string{"ipasshpubkey", "ipapasskey"} {
+		if err := checkValueCount(res, attr, MaxSSHKeys); err != nil {
+			return err
+		}
+	}
+	for _, attr := range []string{"memberof_group", "memberofindirect_group", "memberof_hbacrule", "memberofindirect_hbacrule", "memberofindirect_sudorule"} {
+		if err := checkValueCount(res, attr, MaxGroups); err != nil {
+			return err
+		}
+	}
 
 	u.UUID = res.Get("ipauniqueid.0").String()
 	u.DN = res.Get("dn").String()
 	u.First = res.Get("givenname.0").String()
 	u.Last = res.Get("sn.0").String()
 	u.DisplayName = res.Get("displayname.0").String()
+	u.CanonicalPrincipal = res.Get("krbcanonicalname.0").String()
 	u.Principal = res.Get("krbprincipalname.0").String()
+	res.Get("krbprincipalname").ForEach(func(key, value gjson.Result) bool {
+		u.Principals = append(u.Principals, value.String())
+		return true
+	})
 	u.Username = res.Get("uid.0").String()
 	u.Uid = res.Get("uidnumber.0").String()
 	u.Gid = res.Get("gidnumber.0").String()
-	u.HasKeytab = res.Get("has_keytab").Bool()
-	u.HasPassword = res.Get("has_password").Bool()
-	u.Locked = res.Get("nsaccountlock").Bool()
-	u.Preserved = res.Get("preserved").Bool()
+	u.HasKeytab = parseBool(res.Get("has_keytab"))
+	u.HasPassword = parseBool(res.Get("has_password"))
+	u.Locked = parseBool(res.Get("nsaccountlock"))
+	if preserved := res.Get("preserved"); preserved.Exists() {
+		u.Preserved = parseBool(preserved)
+	} else {
+		// user_find results may omit preserved, fall back to the container
+		u.Preserved = strings.Contains(strings.ToLower(u.DN), ",cn=deleted users,")
+	}
 	u.HomeDir = res.Get("homedirectory.0").String()
 	u.Email = res.Get("mail.0").String()
 	u.Mobile = res.Get("mobile.0").String()
 	u.TelephoneNumber = res.Get("telephonenumber.0").String()
 	u.Shell = res.Get("loginshell.0").String()
+	u.Categories = parseStrings(res, "userclass")
 	u.Category = res.Get("userclass.0").String()
-	u.RandomPassword = res.Get("randompassword").String()
-	u.LastPasswdChange = ParseDateTime(res.Get("krblastpwdchange.0.__datetime__").String())
-	u.PasswdExpire = ParseDateTime(res.Get("krbpasswordexpiration.0.__datetime__").String())
-	u.PrincipalExpire = ParseDateTime(res.Get("krbprincipalexpiration.0.__datetime__").String())
-	u.LastLoginSuccess = ParseDateTime(res.Get("krblastsuccessfulauth.0.__datetime__").String())
-	u.LastLoginFail = ParseDateTime(res.Get("krblastfailedauth.0.__datetime__").String())
+	u.loadedCategory = u.Category
+	u.RandomPassword = NewSecret([]byte(res.Get("randompassword").String()))
+	u.PasswordPolicyDN = res.Get("krbpwdpolicyreference.0").String()
+	u.Manager = dnValue(res.Get("manager.0").String(), "uid")
+	u.RadiusConfig = dnValue(res.Get("ipatokenradiusconfiglink.0").String(), "cn")
+	u.RadiusUsername = res.Get("ipatokenradiususername.0").String()
+	u.IdPConfig = dnValue(res.Get("ipaidpconfiglink.0").String(), "cn")
+	u.IdPUser = res.Get("ipaidpsub.0").String()
+	u.LastPasswdChange = parseTimeAttr(res, "krblastpwdchange")
+	u.PasswdExpire = parseTimeAttr(res, "krbpasswordexpiration")
+	u.PrincipalExpire = parseTimeAttr(res, "krbprincipalexpiration")
+	u.LastLoginSuccess = parseTimeAttr(res, "krblastsuccessfulauth")
+	u.LastLoginFail = parseTimeAttr(res, "krblastfailedauth")
+	u.CreateTimestamp = parseTimeAttr(res, "createtimestamp")
+	u.ModifyTimestamp = parseTimeAttr(res, "modifytimestamp")
 	res.Get("memberof_group").ForEach(func(key, value gjson.Result) bool {
 		u.Groups = append(u.Groups, value.String())
 		return true
 	})
+	res.Get("memberofindirect_group").ForEach(func(key, value gjson.Result) bool {
+		u.IndirectGroups = append(u.IndirectGroups, value.String())
+		return true
+	})
 	res.Get("ipasshpubkey").ForEach(func(key, value gjson.Result) bool {
 		k, err := NewSSHAuthorizedKey(value.String())
 		if err == nil {
@@ -157,6 +435,10 @@ func (u *User) fromJSON(raw []byte) error {
 		u.AuthTypes = append(u.AuthTypes, value.String())
 		return true
 	})
+	res.Get("ipapasskey").ForEach(func(key, value gjson.Result) bool {
+		u.Passkeys = append(u.Passkeys, value.String())
+		return true
+	})
 	res.Get("memberof_hbacrule").ForEach(func(key, value gjson.Result) bool {
 		u.HbacRules = append(u.HbacRules, value.String())
 		return true
@@ -173,9 +455,41 @@ func (u *User) fromJSON(raw []byte) error {
 	return nil
 }
 
+// Returns the user classes to send to FreeIPA, Categories with the first
+// value replaced by Category if it was set or changed after loading
+func (u *User) userClasses() []string {
+	classes := append([]string{}, u.Categories...)
+	if u.Category != "" && u.Category != u.loadedCategory {
+		if len(classes) == 0 {
+			classes = append(classes, u.Category)
+		} else {
+			classes[0] = u.Category
+		}
+	}
+
+	return classes
+}
+
+// Returns true if the user is neither preserved nor locked
+func (u *User) IsActive() bool {
+	return !u.Preserved && !u.Locked
+}
+
+// Returns true if the user must log in with an OTP code, OTP is enabled and
+// password alone is not
+func (u *User) RequiresOTP() bool {
+	otp, password := false, false
+	for _, t := range u.AuthTypes {
+		otp = otp || t == AuthTypeOTP
+		password = password || t == AuthTypePassword
+	}
+
+	return otp && !password
+}
+
 // Returns true if OTP is the only authentication type enabled
 func (u *User) OTPOnly() bool {
-	if len(u.AuthTypes) == 1 && u.AuthTypes[0] == "otp" {
+	if len(u.AuthTypes) == 1 && u.AuthTypes[0] == AuthTypeOTP {
 		return true
 	}
 
@@ -193,93 +507,337 @@ func (u *User) HasGroup(group string) bool {
 	return false
 }
 
-// Removes ssh authorized key
-func (u *User) RemoveSSHAuthorizedKey(fingerprint string) {
-	index := -1
-	for i, k := range u.SSHAuthKeys {
-		if k.Fingerprint == fingerprint {
-			index = i
-			break
-		}
+// Returns true if the User is in group directly or through a nested group
+func (u *User) HasGroupIndirect(group string) bool {
+	if u.HasGroup(group) {
+		return true
 	}
 
-	if index != -1 {
-		u.SSHAuthKeys = append(u.SSHAuthKeys[:index], u.SSHAuthKeys[index+1:]...)
+	for _, g := range u.IndirectGroups {
+		if g == group {
+			return true
+		}
 	}
+
+	return false
 }
 
-// Add ssh authorized key
-func (u *User) AddSSHAuthorizedKey(key *SSHAuthorizedKey) {
-	index := -1
-	for i, k := range u.SSHAuthKeys {
-		if key.Fingerprint == k.Fingerprint {
-			index = i
-		}
-	}
+// Returns true if the User is the built-in admin account which FreeIPA does
+// not allow to be deleted
+func (u *User) IsProtected() bool {
+	return u.Username == AdminUser
+}
 
-	if index == -1 {
-		u.SSHAuthKeys = append(u.SSHAuthKeys, key)
-	} else {
-		u.SSHAuthKeys[index] = key
+// Removes ssh authorized key
+func (u *User) RemoveSSHAuthorizedKey(fingerprint string) {
+	u.SSHAuthKeys = removeSSHAuthorizedKey(u.SSHAuthKeys, fingerprint)
+}
+
+// Add ssh authorized key, replacing any key with the same fingerprint.
+// Returns true if a key was replaced
+func (u *User) AddSSHAuthorizedKey(key *SSHAuthorizedKey) bool {
+	replaced, _ := u.AddSSHAuthorizedKeyWithPolicy(key, SSHKeyReplace)
+	return replaced
+}
+
+// Add ssh authorized key, handling a key with the same fingerprint according
+// to policy. Returns true if a key was replaced
+func (u *User) AddSSHAuthorizedKeyWithPolicy(key *SSHAuthorizedKey, policy SSHKeyPolicy) (bool, error) {
+	keys, replaced, err := addSSHAuthorizedKey(u.SSHAuthKeys, key, policy)
+	if err != nil {
+		return false, err
 	}
+
+	u.SSHAuthKeys = keys
+	return replaced, nil
+}
+
+// Returns true if the user has an ssh key with fingerprint
+func (u *User) HasSSHKey(fingerprint string) bool {
+	return hasSSHKey(u.SSHAuthKeys, fingerprint)
 }
 
 // Format ssh authorized keys
 func (u *User) FormatSSHAuthorizedKeys() []string {
-	keys := []string{}
-	for _, k := range u.SSHAuthKeys {
-		keys = append(keys, k.String())
+	return formatSSHAuthorizedKeys(u.SSHAuthKeys)
+}
+
+// Set user ssh public keys. This replaces all existing keys and modifies no
+// other attributes. If keys is empty all ssh public keys are removed from the
+// user.
+func (c *Client) UserSetSSHKeys(username string, keys []*SSHAuthorizedKey) (*User, error) {
+	options := Options{
+		"ipasshpubkey": formatSSHAuthorizedKeys(keys),
 	}
 
-	return keys
+	if len(keys) == 0 {
+		options["ipasshpubkey"] = ""
+	}
+
+	rec, err := c.UserModOptions(username, options)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		// No modifications, the user already has keys
+		return c.UserShow(username)
+	}
+
+	return rec, nil
 }
 
-// Fetch user details by call the FreeIPA user-show method
-func (c *Client) UserShow(username string) (*User, error) {
+type userShowConfig struct {
+	resolvePrimaryGroup bool
+	etag                bool
+}
 
-	options := Options{
-		"no_members": false,
-		"all":        true,
+// UserShowOption configures UserShow
+type UserShowOption func(*userShowConfig)
+
+// Resolve the user's primary group name into PrimaryGroupName with an extra
+// lookup. PrimaryGroupName is left empty if no group has the user's GID
+func WithResolvePrimaryGroup() UserShowOption {
+	return func(cfg *userShowConfig) {
+		cfg.resolvePrimaryGroup = true
 	}
+}
 
-	res, err := c.rpc("user_show", []string{username}, options)
+// Record the user's modifytimestamp when it is loaded so UserModGuarded can
+// detect modifications made since. Requires the All show option, which
+// UserShow sets.
+func WithETag() UserShowOption {
+	return func(cfg *userShowConfig) {
+		cfg.etag = true
+	}
+}
 
+// Fetch user details by call the FreeIPA user-show method
+func (c *Client) UserShow(username string, opts ...UserShowOption) (*User, error) {
+	return c.UserShowWithOptions(username, DefaultShowOptions(), opts...)
+}
+
+// Fetch user details by calling the FreeIPA user-show method with show
+// options o
+func (c *Client) UserShowWithOptions(username string, o ShowOptions, opts ...UserShowOption) (*User, error) {
+	cfg := &userShowConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	data, err := c.show("user_show", []string{username}, o)
 	if err != nil {
 		return nil, err
 	}
 
 	userRec := new(User)
-	err = userRec.fromJSON(res.Result.Data)
+	err = userRec.fromJSON(data)
 	if err != nil {
 		return nil, err
 	}
+	userRec.Loaded = userLoaded(Options{"no_members": o.NoMembers, "all": o.All})
+
+	if cfg.etag {
+		userRec.loadedVersion = userRec.ModifyTimestamp.Time
+	}
+
+	if cfg.resolvePrimaryGroup {
+		_, err := c.UserPrimaryGroup(userRec)
+		if err != nil && !errors.Is(err, ErrGroupNotFound) {
+			return nil, err
+		}
+	}
 
 	return userRec, nil
 }
 
-// Find users.
+// Attributes fetched by UserShowFast when called with no attributes: what a
+// login needs to set up a session
+var UserLoginAttrs = []string{
+	"uid", "krbcanonicalname", "krbprincipalname", "uidnumber", "gidnumber",
+	"homedirectory", "loginshell", "ipauserauthtype", "nsaccountlock",
+}
+
+// Fetch only attrs of a user, UserLoginAttrs if attrs is empty. This is the
+// fast path for hot paths such as login-time lookups: no_members is set so
+// FreeIPA skips computing the user's memberships, which dominates the cost of
+// user_show for users in many groups, and all is only set if attrs includes
+// an attribute outside the FreeIPA defaults, as with UserSearch.Attrs.
+// FreeIPA has no attribute selection, so other attributes are dropped from
+// the record before it is parsed. The membership slices of the returned user
+// are nil and Loaded is zero; Raw holds only attrs.
+func (c *Client) UserShowFast(username string, attrs []string) (*User, error) {
+	if len(attrs) == 0 {
+		attrs = UserLoginAttrs
+	}
+
+	keep := []string{"uid"}
+	o := ShowOptions{NoMembers: true}
+	for _, attr := range attrs {
+		attr = strings.ToLower(attr)
+		keep = append(keep, attr)
+		if !defaultUserAttrs[attr] {
+			o.All = true
+		}
+	}
+
+	data, err := c.show("user_show", []string{username}, o)
+	if err != nil {
+		return nil, err
+	}
+	if !isRecord(data) {
+		return nil, errors.New("invalid user record json")
+	}
+
+	// The record is projected as a list of one to share the UserSearch
+	// projection
+	list := append(append([]byte{'['}, data...), ']')
+	users, err := parseUserList(projectRecords(list, keep), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return users[0], nil
+}
+
+// Fetch the primary group of user, the group with the user's GID, and set
+// user.PrimaryGroupName. This is usually the user private group, named after
+// the user. ErrGroupNotFound is returned if no FreeIPA group has the GID, for
+// example for GIDs from an Active Directory trust range.
+func (c *Client) UserPrimaryGroup(user *User) (*Group, error) {
+	group, err := c.groupByGid(user.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	user.PrimaryGroupName = group.Name
+	return group, nil
+}
+
+// Fetch user details by kerberos principal, for example an email-style alias.
+// user_show is tried first and if the user is not found a user_find on
+// krbprincipalname is used to resolve aliases. Principals without a realm
+// are assumed to be in the client realm.
+func (c *Client) UserShowByPrincipal(principal string) (*User, error) {
+	user, err := c.UserShow(principal)
+	if err == nil {
+		return user, nil
+	}
+
+	if !IsNotFound(err) {
+		return nil, err
+	}
+
+	if !strings.Contains(principal, "@") {
+		principal += "@" + c.realm
+	}
+
+	users, ferr := c.UserFind(Options{"krbprincipalname": principal})
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	if len(users) != 1 {
+		return nil, err
+	}
+
+	return users[0], nil
+}
+
+// Returns true if user is a FreeIPA admin, that is a direct or indirect
+// member of the admins group
+func (c *Client) IsAdmin(username string) (bool, error) {
+	user, err := c.UserShow(username)
+	if err != nil {
+		return false, err
+	}
+
+	return user.HasGroupIndirect(AdminsGroup), nil
+}
+
+// Find users. Only active users are returned unless options sets preserved,
+// see UserFindScope
 func (c *Client) UserFind(options Options) ([]*User, error) {
 	if options == nil {
 		options = Options{}
 	}
 
+	return c.userFind("", options)
+}
+
+func (c *Client) userFind(criteria string, options Options) ([]*User, error) {
 	options["no_members"] = false
 	options["all"] = true
 
-	res, err := c.rpc("user_find", []string{""}, options)
+	res, err := c.rpc("user_find", []string{criteria}, options)
 
 	if err != nil {
 		return nil, err
 	}
 
+	return parseUserList(res.Result.Data, userLoaded(options))
+}
+
+// Find users in scope. Any preserved option is replaced by the scope.
+func (c *Client) UserFindScope(scope UserScope, options Options) ([]*User, error) {
+	findOptions := Options{}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+
+	switch scope {
+	case UserScopeActive:
+		findOptions["preserved"] = false
+	case UserScopePreserved:
+		findOptions["preserved"] = true
+	case UserScopeAll:
+		active, err := c.UserFindScope(UserScopeActive, options)
+		if err != nil {
+			return nil, err
+		}
+
+		preserved, err := c.UserFindScope(UserScopePreserved, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(active, preserved...), nil
+	default:
+		return nil, fmt.Errorf("invalid user scope: %d", scope)
+	}
+
+	return c.UserFind(findOptions)
+}
+
+// Find users with user class class. Other options are passed to UserFind.
+func (c *Client) UsersByClass(class string, options Options) ([]*User, error) {
+	if err := checkArgs("user_find", class); err != nil {
+		return nil, err
+	}
+
+	findOptions := Options{}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+	findOptions["userclass"] = class
+
+	return c.UserFind(findOptions)
+}
+
+// Parse list of user records returned from user_find
+func parseUserList(raw []byte, loaded UserLoaded) ([]*User, error) {
+	if !gjson.ValidBytes(raw) {
+		return nil, errors.New("invalid user list json")
+	}
+
 	users := make([]*User, 0)
-	data := gjson.ParseBytes(res.Result.Data)
+	data := gjson.ParseBytes(raw)
 	for _, t := range data.Array() {
 		user := new(User)
 		err := user.fromJSON([]byte(t.Raw))
 		if err != nil {
 			return nil, err
 		}
+		user.Loaded = loaded
 
 		users = append(users, user)
 	}
@@ -287,8 +845,90 @@ func (c *Client) UserFind(options Options) ([]*User, error) {
 	return users, nil
 }
 
+// Find users whose password expires within the given duration from now,
+// including users whose password has already expired. Users without a
+// password expiration are excluded. The returned users are sorted by password
+// expiration, soonest first. Disabled accounts are excluded unless options
+// sets nsaccountlock, in which case it is passed to user_find as given. Set
+// nsaccountlock to nil to include both enabled and disabled accounts.
+func (c *Client) UsersWithExpiringPasswords(within time.Duration, options Options) ([]*User, error) {
+	findOptions := Options{}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+
+	if v, ok := findOptions["nsaccountlock"]; !ok {
+		findOptions["nsaccountlock"] = false
+	} else if v == nil {
+		delete(findOptions, "nsaccountlock")
+	}
+
+	return c.usersExpiringBy(findOptions, time.Now().Add(within), func(u *User) Time {
+		return u.PasswdExpire
+	})
+}
+
+// Find users whose kerberos principal expired at or before asOf, sorted by
+// expiration, oldest first. Both enabled and disabled accounts are returned,
+// check User.Locked to find expired accounts which are still enabled.
+func (c *Client) ExpiredUsers(asOf time.Time) ([]*User, error) {
+	return c.usersExpiringBy(Options{}, asOf, func(u *User) Time {
+		return u.PrincipalExpire
+	})
+}
+
+// Find users with options and return those whose expiration, as returned by
+// expiry, is set and not after cutoff, sorted soonest first. FreeIPA find
+// methods only match datetimes exactly, so the comparison is done client
+// side. The search is not size limited unless options sets sizelimit, and
+// ErrTruncated is returned instead of an incomplete list if a limit is
+// exceeded.
+func (c *Client) usersExpiringBy(options Options, cutoff time.Time, expiry func(u *User) Time) ([]*User, error) {
+	findOptions := Options{"sizelimit": 0}
+	for k, v := range options {
+		findOptions[k] = v
+	}
+	findOptions["no_members"] = false
+	findOptions["all"] = true
+
+	res, err := c.rpc("user_find", []string{""}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	if res.Result.Truncated {
+		return nil, ErrTruncated
+	}
+
+	users, err := parseUserList(res.Result.Data, userLoaded(findOptions))
+	if err != nil {
+		return nil, err
+	}
+
+	expiring := make([]*User, 0)
+	for _, u := range users {
+		if t := expiry(u); t.IsZero() || t.After(cutoff) {
+			continue
+		}
+
+		expiring = append(expiring, u)
+	}
+
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiry(expiring[i]).Before(expiry(expiring[j]).Time)
+	})
+
+	return expiring, nil
+}
+
 // Reset user password and return new random password
 func (c *Client) ResetPassword(username string) (string, error) {
+	password, _, err := c.ResetPasswordDetailed(username)
+	return password, err
+}
+
+// Reset user password and return new random password and any messages
+// returned by FreeIPA
+func (c *Client) ResetPasswordDetailed(username string) (string, []IpaMessage, error) {
 
 	options := Options{
 		"no_members": false,
@@ -298,26 +938,89 @@ func (c *Client) ResetPassword(username string) (string, error) {
 	res, err := c.rpc("user_mod", []string{username}, options)
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	userRec := new(User)
 	err = userRec.fromJSON(res.Result.Data)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
+	userRec.Loaded = userLoaded(options)
 
-	if len(userRec.RandomPassword) == 0 {
-		return "", errors.New("ipa: failed to reset user password. empty random password returned")
+	if userRec.RandomPassword.IsEmpty() {
+		return "", nil, errors.New("ipa: failed to reset user password. empty random password returned")
 	}
 
-	return userRec.RandomPassword, nil
+	return string(userRec.RandomPassword.Reveal()), res.Messages, nil
 }
 
 // Change user password. This will run the passwd ipa command. Optionally
 // provide an OTP if required
 func (c *Client) ChangePassword(username, old_passwd, new_passwd, otpcode string) error {
+	_, err := c.ChangePasswordDetailed(username, old_passwd, new_passwd, otpcode)
+	return err
+}
+
+// Change user password and return any messages returned by FreeIPA, for
+// example a password expiry notice to show to the user
+func (c *Client) ChangePasswordDetailed(username, old_passwd, new_passwd, otpcode string) ([]IpaMessage, error) {
+	res, err := c.changePassword(username, old_passwd, new_passwd, otpcode)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Messages, nil
+}
+
+// PasswordChangeResult is the result of ChangePasswordWithExpiration
+type PasswordChangeResult struct {
+	// Expiration of the new password, zero if it does not expire or could
+	// not be looked up
+	NewExpiration time.Time
+
+	// Warnings and notices returned by FreeIPA
+	Messages []IpaMessage
 
+	// True if an OTP was sent with the change and accepted
+	OTPUsed bool
+}
+
+// Change user password and return the new password expiration and any
+// messages returned by FreeIPA, for example to show "password changed,
+// expires on X". passwd does not return the user entry so the expiration is
+// looked up with user_show after the change. A failed lookup is logged and
+// leaves NewExpiration zero, the password has been changed regardless.
+func (c *Client) ChangePasswordWithExpiration(username, old_passwd, new_passwd, otpcode string) (*PasswordChangeResult, error) {
+	res, err := c.changePassword(username, old_passwd, new_passwd, otpcode)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PasswordChangeResult{
+		Messages: res.Messages,
+		OTPUsed:  len(otpcode) > 0,
+	}
+
+	if isRecord(res.Result.Data) {
+		result.NewExpiration = parseTimeAttr(gjson.ParseBytes(res.Result.Data), "krbpasswordexpiration").Time
+		return result, nil
+	}
+
+	user, err := c.UserShow(username)
+	if err != nil {
+		log.Warnf("FreeIPA failed to fetch password expiration of %s: %s", username, err)
+		return result, nil
+	}
+	result.NewExpiration = user.PasswdExpire.Time
+
+	return result, nil
+}
+
+// Run the passwd command. Invalid current password and password policy
+// errors wrap ErrInvalidPassword and ErrPasswordPolicy, the errors returned
+// by SetPassword for the same mistakes
+func (c *Client) changePassword(username, old_passwd, new_passwd, otpcode string) (*Response, error) {
 	options := Options{
 		"current_password": old_passwd,
 		"password":         new_passwd,
@@ -327,13 +1030,26 @@ func (c *Client) ChangePassword(username, old_passwd, new_passwd, otpcode string
 		options["otp"] = otpcode
 	}
 
-	_, err := c.rpc("passwd", []string{username}, options)
-
+	res, err := c.rpc("passwd", []string{username}, options)
 	if err != nil {
-		return err
+		var ierr *IpaError
+		if errors.As(err, &ierr) {
+			// Insufficient access is returned with invalid credentials if
+			// the current password or OTP is wrong
+			if IsPermissionDenied(err) && strings.Contains(strings.ToLower(ierr.Message), "invalid credentials") {
+				return nil, fmt.Errorf("ipa: %w: %w", ErrInvalidPassword, err)
+			}
+			// Database error with a constraint violation if the new
+			// password does not meet the policy. Other database errors are
+			// returned as is.
+			if isConstraintViolation(err) {
+				return nil, fmt.Errorf("ipa: %w: %w", ErrPasswordPolicy, err)
+			}
+		}
+		return nil, err
 	}
 
-	return nil
+	return res, nil
 }
 
 // Set user password. In FreeIPA when a password is first set or when a
@@ -346,9 +1062,13 @@ func (c *Client) ChangePassword(username, old_passwd, new_passwd, otpcode string
 // then immediately calling this function. *WARNING* See
 // https://www.freeipa.org/page/Self-Service_Password_Reset for security issues
 // and possible weaknesses of this approach.
+//
+// The change is posted to the change_password form endpoint. Clients without
+// a session, such as kerberos only clients, first log in as the user with
+// old_passwd on a separate session so the flow works with any authentication
+// method. If the password is rejected, no otpcode was given and the user must
+// use OTP, the error wraps ErrOTPRequired.
 func (c *Client) SetPassword(username, old_passwd, new_passwd, otpcode string) error {
-	ipaUrl := fmt.Sprintf("https://%s/ipa/session/change_password", c.host)
-
 	form := url.Values{
 		"user":         {username},
 		"otp":          {otpcode},
@@ -356,74 +1076,175 @@ func (c *Client) SetPassword(username, old_passwd, new_passwd, otpcode string) e
 		"new_password": {new_passwd},
 	}
 
-	req, err := http.NewRequest("POST", ipaUrl, strings.NewReader(form.Encode()))
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if err := c.checkReadOnly("change_password"); err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		if c.dryRunSink != nil {
+			c.dryRunSink("change_password", redactForm(form))
+		}
+		return ErrDryRun
+	}
+
+	// Clients without a session, for example kerberos only clients, first
+	// log in as the user with the old password on a separate session. A
+	// freshly reset password is expired so ErrExpiredPassword is expected
+	// and the change is posted regardless.
+	poster := c
+	if c.SessionID() == "" {
+		poster = c.Clone()
+		defer poster.Close()
+
+		err := poster.RemoteLogin(username, old_passwd+otpcode)
+		if err != nil && !errors.Is(err, ErrExpiredPassword) {
+			return c.otpRequired(username, otpcode, err)
+		}
+	}
+
+	err := poster.postPasswordChange(username, form)
+	if err != nil {
+		return c.otpRequired(username, otpcode, err)
+	}
+
+	return nil
+}
+
+// Post form to the change_password endpoint with the client session, if any
+func (c *Client) postPasswordChange(username string, form url.Values) error {
+	ipaUrl := fmt.Sprintf("https://%s/ipa/session/change_password", c.host)
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ipaUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Referer", fmt.Sprintf("https://%s/ipa", c.host))
+	if sessionID := c.SessionID(); sessionID != "" {
+		req.Header.Set("Cookie", fmt.Sprintf("ipa_session=%s", sessionID))
+	}
 
+	trace := c.sampleTrace()
+	start := time.Now()
+	defer c.recordCall("change_password", []string{username}, start)
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		trace.trace("change_password", req, redactForm(form), nil, nil, start, err)
+		return tlsError(c.host, err)
 	}
 	defer res.Body.Close()
 
-	if log.IsLevelEnabled(log.TraceLevel) {
-		dump, _ := httputil.DumpResponse(res, true)
-		log.Tracef("FreeIPA SetPassword response: %s", dump)
-	}
+	trace.traceResponse("change_password", req, redactForm(form), res, start)
 
 	if res.StatusCode != 200 {
 		return fmt.Errorf("ipa: change password failed with HTTP status code: %d", res.StatusCode)
 	}
 
-	status := res.Header.Get("x-ipa-pwchange-result")
-	if status == "policy-error" {
+	switch status := headerValue(res, "X-IPA-Pwchange-Result"); status {
+	case "ok":
+		return nil
+	case "policy-error":
 		return ErrPasswordPolicy
-	} else if status == "invalid-password" {
+	case "invalid-password":
 		return ErrInvalidPassword
-	} else if strings.ToLower(status) != "ok" {
+	default:
 		return fmt.Errorf("ipa: change password failed. Unknown status: %s", status)
 	}
+}
+
+// FreeIPA rejects a password without the OTP code of users who must use OTP
+// as an invalid password. If no otpcode was given and the client can look up
+// the user, err is wrapped in ErrOTPRequired for such users.
+func (c *Client) otpRequired(username, otpcode string, err error) error {
+	if otpcode != "" || !errors.Is(err, ErrInvalidPassword) || !c.authenticated() {
+		return err
+	}
 
-	return nil
+	user, uerr := c.UserShowWithOptions(username, ShowOptions{NoMembers: true})
+	if uerr != nil || !user.RequiresOTP() {
+		return err
+	}
+
+	return fmt.Errorf("ipa: %w: %w", ErrOTPRequired, err)
 }
 
-// Update user authentication types.
-func (c *Client) SetAuthTypes(username string, types []string) error {
+// Update user authentication types. Returns the updated user record.
+func (c *Client) SetAuthTypes(username string, types []string) (*User, error) {
+	for _, t := range types {
+		if !authTypes[t] {
+			return nil, fmt.Errorf("ipa: invalid user authentication type: %s", t)
+		}
+	}
+
 	options := Options{
 		"no_members":      false,
 		"ipauserauthtype": types,
-		"all":             false,
+		"all":             true,
 	}
 
 	if len(types) == 0 {
 		options["ipauserauthtype"] = ""
 	}
 
-	_, err := c.rpc("user_mod", []string{username}, options)
+	res, err := c.rpc("user_mod", []string{username}, options)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	userRec := new(User)
+	err = userRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+	userRec.Loaded = userLoaded(options)
+
+	return userRec, nil
 }
 
-// Disable User Account
-func (c *Client) UserDisable(username string) error {
+// Disable User Account. FreeIPA does not return the user entry when
+// disabling an account so the returned user is sparse, only Username and
+// Locked are set. Use UserShow to fetch the full record.
+func (c *Client) UserDisable(username string) (*User, error) {
 	_, err := c.rpc("user_disable", []string{username}, nil)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &User{Username: username, Locked: true}, nil
 }
 
-// Enable User Account
-func (c *Client) UserEnable(username string) error {
+// Enable User Account. FreeIPA does not return the user entry when enabling
+// an account so the returned user is sparse, only Username and Locked are
+// set. Use UserShow to fetch the full record.
+func (c *Client) UserEnable(username string) (*User, error) {
 	_, err := c.rpc("user_enable", []string{username}, nil)
 
 	if err != nil {
+		return nil, err
+	}
+
+	return &User{Username: username, Locked: false}, nil
+}
+
+// Lock or unlock user account by setting nsaccountlock with user_mod. Unlike
+// UserDisable this can be combined with other modifications, see
+// User.UpdateLocked.
+func (c *Client) UserSetLocked(username string, locked bool) error {
+	options := SetAttr("nsaccountlock", OptBool(locked))
+
+	_, err := c.rpc("user_mod", []string{username}, options)
+	if err != nil {
+		if IsNoModifications(err) {
+			return nil
+		}
 		return err
 	}
 
@@ -433,8 +1254,8 @@ func (c *Client) UserEnable(username string) error {
 // Add new user and set password. Note this requires "User Administrators"
 // Privilege in FreeIPA.
 func (c *Client) UserAddWithPassword(user *User, password string) (*User, error) {
-	if user.Username == "" {
-		return nil, errors.New("Username is required")
+	if err := checkArgs("user_add", user.Username); err != nil {
+		return nil, err
 	}
 	if password == "" {
 		return nil, errors.New("password is required")
@@ -445,7 +1266,7 @@ func (c *Client) UserAddWithPassword(user *User, password string) (*User, error)
 		return nil, err
 	}
 
-	err = c.SetPassword(rec.Username, rec.RandomPassword, password, "")
+	err = c.SetPassword(rec.Username, string(rec.RandomPassword.Reveal()), password, "")
 	if err != nil {
 		return nil, err
 	}
@@ -456,22 +1277,72 @@ func (c *Client) UserAddWithPassword(user *User, password string) (*User, error)
 // Add new user. If random is true a random password will be created for the
 // user. Note this requires "User Administrators" Privilege in FreeIPA.
 func (c *Client) UserAdd(user *User, random bool) (*User, error) {
-	if user.Username == "" {
-		return nil, errors.New("Username is required")
+	if err := checkArgs("user_add", user.Username); err != nil {
+		return nil, err
+	}
+
+	return c.userAdd(user, random, user.ToOptions())
+}
+
+// Add new user whose kerberos principal expires at expires, for example a
+// temporary contractor account. expires must be in the future. The returned
+// user has PrincipalExpire set. If random is true a random password will be
+// created for the user.
+func (c *Client) UserAddTemporary(user *User, expires time.Time, random bool) (*User, error) {
+	if err := checkArgs("user_add", user.Username); err != nil {
+		return nil, err
+	}
+	if err := checkExpiration(expires); err != nil {
+		return nil, err
 	}
 
 	options := user.ToOptions()
+	options["krbprincipalexpiration"] = OptDateTime(expires)
+
+	rec, err := c.userAdd(user, random, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older servers do not return the expiration from user_add
+	if rec.PrincipalExpire.IsZero() {
+		rec.PrincipalExpire = NewTime(expires.UTC().Truncate(time.Second))
+	}
+
+	return rec, nil
+}
+
+// Set the kerberos principal expiration of user username to newExpiry, which
+// must be in the future.
+func (c *Client) ExtendUserExpiration(username string, newExpiry time.Time) error {
+	if err := checkExpiration(newExpiry); err != nil {
+		return err
+	}
+
+	_, err := c.UserModOptions(username, Options{
+		"krbprincipalexpiration": OptDateTime(newExpiry),
+	})
 
+	return err
+}
+
+func checkExpiration(expires time.Time) error {
+	if !expires.After(time.Now()) {
+		return fmt.Errorf("ipa: %w: %s is not in the future", ErrInvalidExpiration, expires.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (c *Client) userAdd(user *User, random bool, options Options) (*User, error) {
 	if random {
 		options["random"] = true
 	}
 
 	res, err := c.rpc("user_add", []string{user.Username}, options)
 	if err != nil {
-		if ierr, ok := err.(*IpaError); ok {
-			if ierr.Code == 4002 {
-				return nil, ErrUserExists
-			}
+		if IsDuplicate(err) {
+			return nil, ErrUserExists
 		}
 		return nil, err
 	}
@@ -481,6 +1352,7 @@ func (c *Client) UserAdd(user *User, random bool) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	userRec.Loaded = userLoaded(options)
 
 	return userRec, nil
 }
@@ -502,23 +1374,153 @@ func (c *Client) UserDelete(preserve, stopOnError bool, usernames ...string) err
 	return nil
 }
 
+// Options for UserDeleteDetailed
+type UserDeleteOptions struct {
+	// Move users to the Delete container instead of permanently deleting
+	Preserve bool
+
+	// Continue deleting the remaining users if deleting one fails
+	ContinueOnError bool
+}
+
+// Result of deleting multiple entries
+type DeleteResult struct {
+	// Names of the deleted entries
+	Deleted []string
+
+	// Names of the entries which failed to delete mapped to the reason if
+	// known. FreeIPA does not report reasons in continuous mode so these are
+	// empty.
+	Failed map[string]string
+}
+
+// Delete users and report which were deleted and which failed. An error is
+// only returned if the request itself fails, for example for transport or
+// permission errors.
+func (c *Client) UserDeleteDetailed(opts UserDeleteOptions, usernames ...string) (*DeleteResult, error) {
+	var options = Options{
+		"continue": opts.ContinueOnError,
+		"preserve": opts.Preserve,
+	}
+
+	result := &DeleteResult{
+		Deleted: make([]string, 0),
+		Failed:  make(map[string]string),
+	}
+
+	res, err := c.rpc("user_del", usernames, options)
+	if err != nil {
+		// Without continue FreeIPA stops at the first user which is not
+		// found and reports it as "<username>: user not found"
+		var ierr *IpaError
+		if errors.As(err, &ierr) && IsNotFound(err) {
+			for i, username := range usernames {
+				if strings.HasPrefix(ierr.Message, username+":") {
+					result.Failed[username] = ierr.Message
+					// Users before the failed user were deleted
+					result.Deleted = append(result.Deleted, usernames[:i]...)
+					return result, nil
+				}
+			}
+		}
+		return nil, err
+	}
+
+	switch value := res.Result.Value.(type) {
+	case []interface{}:
+		for _, v := range value {
+			if username, ok := v.(string); ok {
+				result.Deleted = append(result.Deleted, username)
+			}
+		}
+	case string:
+		result.Deleted = append(result.Deleted, value)
+	}
+
+	gjson.GetBytes(res.Result.Data, "failed").ForEach(func(_, value gjson.Result) bool {
+		result.Failed[value.String()] = ""
+		return true
+	})
+
+	return result, nil
+}
+
 // Modify user. Currently only modifies a subset of user attributes: mail,
 // givenname, sn, homedirectory, loginshell, displayname, ipasshpubkey,
-// telephonenumber, and mobile
+// telephonenumber, and mobile. Other attributes can be modified with
+// user.Extra.
 func (c *Client) UserMod(user *User) (*User, error) {
-	if user.Username == "" {
-		return nil, errors.New("Username is required")
+	if err := checkArgs("user_mod", user.Username); err != nil {
+		return nil, err
 	}
 
 	options := user.ToOptions()
+	if err := user.Extra.appendTo(options); err != nil {
+		return nil, err
+	}
+
+	rec, err := c.UserModOptions(user.Username, options)
+	if err == nil && rec == nil {
+		return user, nil
+	}
+
+	return rec, err
+}
+
+// Modify user like UserMod, refusing with ErrConcurrentModification if the
+// user was modified since it was loaded by UserShow with WithETag. The
+// current modifytimestamp is fetched with an extra user_show before the
+// modification. FreeIPA has no compare and swap so the check is not atomic:
+// a modification made between the check and user_mod is still overwritten.
+// modifytimestamp has a resolution of one second, modifications in the same
+// second as the load are not detected. The returned user can be passed to
+// UserModGuarded again.
+func (c *Client) UserModGuarded(user *User) (*User, error) {
+	if err := checkArgs("user_mod", user.Username); err != nil {
+		return nil, err
+	}
+	if user.loadedVersion.IsZero() {
+		return nil, fmt.Errorf("ipa: user %s was not loaded with WithETag", user.Username)
+	}
 
-	res, err := c.rpc("user_mod", []string{user.Username}, options)
+	current, err := c.UserShowWithOptions(user.Username, ShowOptions{All: true, NoMembers: true})
 	if err != nil {
-		if ierr, ok := err.(*IpaError); ok {
-			// error 4202 - no modifications to be performed
-			if ierr.Code == 4202 {
-				return user, nil
-			}
+		return nil, err
+	}
+	if !current.ModifyTimestamp.Equal(user.loadedVersion) {
+		return nil, fmt.Errorf("ipa: %w: user %s was modified at %s after it was loaded", ErrConcurrentModification,
+			user.Username, current.ModifyTimestamp.Format(time.RFC3339))
+	}
+
+	rec, err := c.UserMod(user)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.ModifyTimestamp.IsZero() {
+		rec.loadedVersion = rec.ModifyTimestamp.Time
+	}
+
+	return rec, nil
+}
+
+// Modify user attributes given in options, for example the options returned
+// by User.ApplyChanges. Returns nil and no error if there were no
+// modifications to be performed.
+func (c *Client) UserModOptions(username string, options Options) (*User, error) {
+	if err := checkArgs("user_mod", username); err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = Options{}
+	}
+
+	// Fetch all attributes so the returned user's Raw record is complete
+	options["all"] = true
+
+	res, err := c.rpc("user_mod", []string{username}, options)
+	if err != nil {
+		if IsNoModifications(err) {
+			return nil, nil
 		}
 		return nil, err
 	}
@@ -528,6 +1530,74 @@ func (c *Client) UserMod(user *User) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	userRec.Loaded = userLoaded(options)
+
+	return userRec, nil
+}
+
+// Set the user classes of user username to class, replacing any existing
+// classes. An empty class removes all classes. Returns nil and no error if the
+// user already has only class.
+func (c *Client) SetUserClass(username, class string) (*User, error) {
+	return c.UserModOptions(username, Options{"userclass": class})
+}
+
+// Rename user. The user's kerberos principal is renamed to match and the old
+// principal is kept as an alias.
+func (c *Client) UserRename(oldName, newName string) (*User, error) {
+	if err := checkArgs("user_mod", oldName, newName); err != nil {
+		return nil, err
+	}
+	if oldName == newName {
+		return nil, errors.New("New username must be different")
+	}
+
+	options := Options{
+		"rename": newName,
+		"all":    true,
+	}
+
+	res, err := c.rpc("user_mod", []string{oldName}, options)
+	if err != nil {
+		return nil, renameError(err, ErrUserExists)
+	}
+
+	userRec := new(User)
+	err = userRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+	userRec.Loaded = userLoaded(options)
+
+	return userRec, nil
+}
+
+// Add passkey to user. The passkey is the opaque passkey mapping data, for
+// example as returned by "ipa user-add-passkey --register". Requires FreeIPA
+// 4.11 or later, older servers return ErrCommandNotSupported.
+func (c *Client) UserAddPasskey(username, passkey string) (*User, error) {
+	return c.userPasskey("user_add_passkey", username, passkey)
+}
+
+// Remove passkey from user. Requires FreeIPA 4.11 or later, older servers
+// return ErrCommandNotSupported.
+func (c *Client) UserRemovePasskey(username, passkey string) (*User, error) {
+	return c.userPasskey("user_remove_passkey", username, passkey)
+}
+
+func (c *Client) userPasskey(method, username, passkey string) (*User, error) {
+	options := Options{"all": true}
+	res, err := c.rpc(method, []string{username, passkey}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	userRec := new(User)
+	err = userRec.fromJSON(res.Result.Data)
+	if err != nil {
+		return nil, err
+	}
+	userRec.Loaded = userLoaded(options)
 
 	return userRec, nil
 }
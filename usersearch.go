@@ -0,0 +1,139 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// User attributes returned by user_find and user_show without all=true.
+// Requesting any other attribute with UserSearch.Attrs or UserShowFast
+// requires all=true.
+var defaultUserAttrs = map[string]bool{
+	"dn":                     true,
+	"uid":                    true,
+	"givenname":              true,
+	"sn":                     true,
+	"homedirectory":          true,
+	"loginshell":             true,
+	"uidnumber":              true,
+	"gidnumber":              true,
+	"mail":                   true,
+	"telephonenumber":        true,
+	"nsaccountlock":          true,
+	"preserved":              true,
+	"userclass":              true,
+	"ipauserauthtype":        true,
+	"krbprincipalname":       true,
+	"krbcanonicalname":       true,
+	"krbprincipalexpiration": true,
+}
+
+// UserSearch builds a user_find query. Create one with Client.UserSearch
+type UserSearch struct {
+	client   *Client
+	criteria string
+	options  Options
+	attrs    []string
+}
+
+// Returns a new user search matching criteria. FreeIPA matches criteria
+// against the uid, name and email attributes. Use "" to match all users.
+func (c *Client) UserSearch(criteria string) *UserSearch {
+	return &UserSearch{
+		client:   c,
+		criteria: criteria,
+		options:  Options{},
+	}
+}
+
+// Set user_find option key, for example "mail" or "in_group"
+func (s *UserSearch) Option(key string, value interface{}) *UserSearch {
+	s.options[key] = value
+	return s
+}
+
+// Limit the number of users returned. See FindOptions
+func (s *UserSearch) SizeLimit(n int) *UserSearch {
+	s.options["sizelimit"] = n
+	return s
+}
+
+// Only return attrs, for example "uid", "displayname" and "mail". FreeIPA
+// has no option to select attributes so all=true is only requested if an
+// attribute outside the default set is needed and memberships are only
+// requested if a memberof attribute is needed. Records are trimmed to attrs
+// and dn before parsing, so Raw only holds the requested attributes.
+func (s *UserSearch) Attrs(attrs ...string) *UserSearch {
+	s.attrs = append(s.attrs, attrs...)
+	return s
+}
+
+// Run the search
+func (s *UserSearch) Find() ([]*User, error) {
+	options := Options{}
+	for k, v := range s.options {
+		options[k] = v
+	}
+
+	if len(s.attrs) == 0 {
+		return s.client.userFind(s.criteria, options)
+	}
+
+	all, members := false, false
+	for _, attr := range s.attrs {
+		if strings.HasPrefix(attr, "memberof") {
+			members = true
+		} else if !defaultUserAttrs[attr] {
+			all = true
+		}
+	}
+	options["all"] = all
+	options["no_members"] = !members
+
+	res, err := s.client.rpc("user_find", []string{s.criteria}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !gjson.ValidBytes(res.Result.Data) {
+		return nil, errors.New("invalid user list json")
+	}
+
+	// Records are trimmed to the requested attributes so no part of the
+	// user is fully loaded
+	return parseUserList(projectRecords(res.Result.Data, s.attrs), 0)
+}
+
+// Returns the list of records raw trimmed to attrs and dn
+func projectRecords(raw []byte, attrs []string) []byte {
+	keep := map[string]bool{"dn": true}
+	for _, attr := range attrs {
+		keep[attr] = true
+	}
+
+	records := []map[string]json.RawMessage{}
+	for _, rec := range gjson.ParseBytes(raw).Array() {
+		out := make(map[string]json.RawMessage)
+		rec.ForEach(func(key, value gjson.Result) bool {
+			if keep[key.String()] {
+				out[key.String()] = json.RawMessage(value.Raw)
+			}
+			return true
+		})
+		records = append(records, out)
+	}
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserSearchAttrs(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var options map[string]interface{}
+	var args []string
+	result := stubResult(`{"count": 2, "result": [
+		{"dn": "uid=jdoe,cn=users,cn=accounts,dc=local", "uid": ["jdoe"], "displayname": ["John Doe"], "mail": ["jdoe@example.com"], "uidnumber": ["1001"], "memberof_group": ["ipausers", "staff"], "krblastpwdchange": [{"__datetime__": "20230405060708Z"}]},
+		{"uid": ["jsmith"]}
+	], "summary": "2 users matched", "truncated": false}`)
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		req := stubDecode(r)
+		args = req.Args
+		options = req.Options
+		result(w, r)
+	})
+
+	users, err := c.UserSearch("j").SizeLimit(50).Attrs("uid", "displayname", "mail").Find()
+	require.NoError(err)
+	assert.Equal([]string{"j"}, args)
+	assert.Equal(true, options["all"])
+	assert.Equal(true, options["no_members"])
+	assert.Equal(float64(50), options["sizelimit"])
+
+	require.Len(users, 2)
+	assert.Equal("jdoe", users[0].Username)
+	assert.Equal("John Doe", users[0].DisplayName)
+	assert.Equal("jdoe@example.com", users[0].Email)
+	assert.Equal("uid=jdoe,cn=users,cn=accounts,dc=local", users[0].DN)
+	assert.Emptyf(users[0].Uid, "Attributes not requested should not be parsed")
+	assert.Empty(users[0].Groups)
+	assert.True(users[0].LastPasswdChange.IsZero())
+	assert.JSONEq(`{"dn": "uid=jdoe,cn=users,cn=accounts,dc=local", "uid": ["jdoe"], "displayname": ["John Doe"], "mail": ["jdoe@example.com"]}`, string(users[0].Raw))
+
+	assert.Equal("jsmith", users[1].Username)
+	assert.Empty(users[1].DisplayName)
+	assert.Empty(users[1].Email)
+
+	_, err = c.UserSearch("").Attrs("uid", "mail").Find()
+	require.NoError(err)
+	assert.Equalf(false, options["all"], "Default attributes should not request all")
+	assert.Equal(true, options["no_members"])
+
+	users, err = c.UserSearch("").Attrs("uid", "memberof_group").Find()
+	require.NoError(err)
+	assert.Equal(false, options["no_members"])
+	assert.Equal([]string{"ipausers", "staff"}, users[0].Groups)
+
+	_, err = c.UserSearch("").Option("in_group", "staff").Find()
+	require.NoError(err)
+	assert.Equalf(true, options["all"], "Search without attrs should return all attributes")
+	assert.Equal("staff", options["in_group"])
+}
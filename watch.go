@@ -0,0 +1,214 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// Maximum delay between polls while requests are failing
+const watchMaxBackoff = 15 * time.Minute
+
+// LDAPSearcher searches the FreeIPA LDAP directory, for example a client from
+// the ldap package. Entries are returned as attribute values by attribute
+// name, with the DN of each entry as attribute "dn".
+type LDAPSearcher interface {
+	Search(baseDN, filter string, attrs []string) ([]map[string][]string, error)
+}
+
+// Set the LDAP searcher used by WatchEntries to find modified entries. The
+// searcher is not kept by clones, which have their own credentials.
+func (c *Client) SetLDAPSearcher(s LDAPSearcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ldapSearcher = s
+}
+
+// Entry returned by a watch poll
+type watchEntry struct {
+	name     string
+	modified time.Time
+}
+
+// High-water mark of a watch. Timestamps have one second resolution so
+// entries modified in the same second as the mark are remembered to avoid
+// reporting them again.
+type watchMark struct {
+	time  time.Time
+	names map[string]bool
+}
+
+// Returns the entries modified after the mark, sorted by modification time,
+// and advances the mark
+func (m *watchMark) update(entries []watchEntry) []watchEntry {
+	changed := []watchEntry{}
+	for _, e := range entries {
+		if e.modified.Before(m.time) || (e.modified.Equal(m.time) && m.names[e.name]) {
+			continue
+		}
+		changed = append(changed, e)
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].modified.Equal(changed[j].modified) {
+			return changed[i].name < changed[j].name
+		}
+		return changed[i].modified.Before(changed[j].modified)
+	})
+
+	for _, e := range changed {
+		if e.modified.After(m.time) {
+			m.time = e.modified
+			m.names = make(map[string]bool)
+		}
+		m.names[e.name] = true
+	}
+
+	return changed
+}
+
+// Poll entries of entity modified at or after since and call fn for each
+// changed entry until ctx is done. entity is the FreeIPA object name, for
+// example "user" or "group", and name is the primary key of the entry. Each
+// entry is reported once per modification, in order of modification time.
+//
+// This is eventually-consistent polling, not a change feed. FreeIPA find
+// methods can not filter on modifytimestamp, so each poll searches the
+// entity container in LDAP for entries modified since the last poll, using
+// the LDAP searcher set with SetLDAPSearcher, for example an ldap.Client.
+// WatchEntries returns an error if no searcher is set. Only the name and
+// modification time of changed entries are fetched, in pages, so polls stay
+// cheap on large directories. Timestamps have one second resolution and come
+// from the server that answers the poll, so with multiple replicas changes
+// are only seen once they have replicated, and deletes are not reported.
+//
+// Transport and LDAP failures and retryable FreeIPA errors, see
+// Client.IsRetryable, are retried with exponential backoff up to 15 minutes.
+// Other FreeIPA errors and ErrTruncated if the server size limit truncates
+// the search stop the watch and are returned. Otherwise the context error is
+// returned when ctx is done.
+func (c *Client) WatchEntries(ctx context.Context, entity string, interval time.Duration, since time.Time, fn func(name string, modified time.Time)) error {
+	if entity == "" {
+		return errors.New("Entity is required")
+	}
+	if interval <= 0 {
+		return errors.New("Watch interval must be positive")
+	}
+
+	c.mu.RLock()
+	searcher := c.ldapSearcher
+	c.mu.RUnlock()
+	if searcher == nil {
+		return errors.New("ipa: watching entries requires an LDAP searcher, see SetLDAPSearcher")
+	}
+
+	mark := &watchMark{time: since, names: make(map[string]bool)}
+	delay := interval
+	for {
+		entries, err := c.pollModified(searcher, entity, mark.time)
+		switch {
+		case err == nil:
+			for _, e := range mark.update(entries) {
+				fn(e.name, e.modified)
+			}
+			delay = interval
+		case c.watchRetryable(err):
+			delay *= 2
+			if delay > watchMaxBackoff {
+				delay = watchMaxBackoff
+			}
+			if delay < interval {
+				delay = interval
+			}
+			log.Warnf("FreeIPA watch of %s failed, retrying in %s: %s", entity, delay, err)
+		default:
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Returns true if err is a transient failure worth retrying
+func (c *Client) watchRetryable(err error) bool {
+	var ierr *IpaError
+	if errors.As(err, &ierr) {
+		return c.IsRetryable(err)
+	}
+
+	return !errors.Is(err, ErrTruncated) &&
+		!errors.Is(err, ErrResponseTooLarge) &&
+		!errors.Is(err, ErrCommandNotSupported) &&
+		!errors.Is(err, ErrClientClosed) &&
+		!errors.Is(err, ErrUnauthorized)
+}
+
+// Search the LDAP container of entity, as reported by the FreeIPA env
+// command, for entries modified at or after since
+func (c *Client) pollModified(searcher LDAPSearcher, entity string, since time.Time) ([]watchEntry, error) {
+	container := "container_" + entity
+	res, err := c.rpc("env", []string{"basedn", container}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	env := gjson.ParseBytes(res.Result.Data)
+	baseDN, containerDN := env.Get("basedn").String(), env.Get(container).String()
+	if baseDN == "" || containerDN == "" {
+		return nil, fmt.Errorf("ipa: no LDAP container for %s entries", entity)
+	}
+
+	filter := fmt.Sprintf("(modifyTimestamp>=%s)", since.UTC().Format(IpaDatetimeFormat))
+	recs, err := searcher.Search(containerDN+","+baseDN, filter, []string{"modifyTimestamp"})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []watchEntry{}
+	for _, rec := range recs {
+		var stamp string
+		for attr, vals := range rec {
+			if strings.EqualFold(attr, "modifyTimestamp") && len(vals) > 0 {
+				stamp = vals[0]
+			}
+		}
+		modified, err := time.Parse(IpaDatetimeFormat, stamp)
+		name := ""
+		if len(rec["dn"]) > 0 {
+			name = rdnValue(rec["dn"][0])
+		}
+		if err != nil || name == "" {
+			continue
+		}
+		entries = append(entries, watchEntry{name: name, modified: modified})
+	}
+
+	return entries, nil
+}
+
+// Returns the value of the first RDN of dn, for example jdoe for
+// uid=jdoe,cn=users,cn=accounts,dc=example,dc=com
+func rdnValue(dn string) string {
+	parsed, err := ParseDN(dn)
+	if err != nil || len(parsed) == 0 {
+		return ""
+	}
+
+	return parsed[0].Value
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Andrew E. Bruno. All rights reserved.
+// Use of this source code is governed by a BSD style
+// license that can be found in the LICENSE file.
+
+package ipa_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubccr/goipa"
+)
+
+// LDAP searcher returning the results of polls in turn, users mapped to their
+// modifyTimestamp or an error, and recording the searches made
+type fakeSearcher struct {
+	polls    []interface{}
+	searches []string
+	done     func()
+}
+
+func (s *fakeSearcher) Search(baseDN, filter string, attrs []string) ([]map[string][]string, error) {
+	s.searches = append(s.searches, baseDN+" "+filter+" "+strings.Join(attrs, ","))
+	if len(s.searches) > len(s.polls) {
+		s.done()
+		return nil, nil
+	}
+
+	switch poll := s.polls[len(s.searches)-1].(type) {
+	case error:
+		return nil, poll
+	case []string:
+		entries := []map[string][]string{}
+		for i := 0; i < len(poll); i += 2 {
+			entries = append(entries, map[string][]string{
+				"dn":              {fmt.Sprintf("uid=%s,cn=users,cn=accounts,dc=example,dc=com", poll[i])},
+				"modifyTimestamp": {poll[i+1]},
+			})
+		}
+		return entries, nil
+	}
+
+	return nil, nil
+}
+
+// Returns a client answering env requests for the user container
+func newWatchStub(t *testing.T) *ipa.Client {
+	return newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if req := stubDecode(r); req.Method != "env" {
+			t.Errorf("Unexpected method %s, entries should be searched in LDAP", req.Method)
+		}
+		stubResult(`{"result": {"basedn": "dc=example,dc=com", "container_user": "cn=users,cn=accounts"}, "count": 2, "total": 2, "summary": null}`)(w, r)
+	})
+}
+
+func TestWatchEntries(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	searcher := &fakeSearcher{done: cancel, polls: []interface{}{
+		[]string{"jdoe", "20240102100000Z", "jsmith", "20240102100005Z"},
+		[]string{"jsmith", "20240102100005Z", "alice", "20240102100005Z", "bad", "yesterday"},
+		errors.New("connection reset by peer"),
+		[]string{"jdoe", "20240102100100Z", "jsmith", "20240102100005Z", "alice", "20240102100005Z"},
+	}}
+	c := newWatchStub(t)
+	c.SetLDAPSearcher(searcher)
+
+	changes := []string{}
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	err := c.WatchEntries(ctx, "user", time.Millisecond, since, func(name string, modified time.Time) {
+		changes = append(changes, name+" "+modified.Format("15:04:05"))
+	})
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal([]string{
+		"jdoe 10:00:00",
+		"jsmith 10:00:05",
+		"alice 10:00:05",
+		"jdoe 10:01:00",
+	}, changes)
+
+	// Searches are filtered on the high-water mark and fetch only the
+	// modification time
+	require.Len(searcher.searches, 5)
+	base := "cn=users,cn=accounts,dc=example,dc=com "
+	assert.Equal(base+"(modifyTimestamp>=20240102000000Z) modifyTimestamp", searcher.searches[0])
+	assert.Equal(base+"(modifyTimestamp>=20240102100005Z) modifyTimestamp", searcher.searches[1])
+	assert.Equal(base+"(modifyTimestamp>=20240102100100Z) modifyTimestamp", searcher.searches[4])
+}
+
+func TestWatchEntriesErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	fn := func(name string, modified time.Time) {}
+
+	calls := 0
+	c := newTestClientStub(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		stubError(4001, "no such entry")(w, r)
+	})
+	err := c.WatchEntries(context.Background(), "user", time.Millisecond, time.Time{}, fn)
+	assert.Errorf(err, "Watching without an LDAP searcher should fail")
+	assert.Equal(0, calls)
+
+	c.SetLDAPSearcher(&fakeSearcher{})
+	err = c.WatchEntries(context.Background(), "user", time.Millisecond, time.Time{}, fn)
+	assert.True(ipa.IsNotFound(err))
+
+	c = newWatchStub(t)
+	c.SetLDAPSearcher(&fakeSearcher{polls: []interface{}{ipa.ErrTruncated}})
+	err = c.WatchEntries(context.Background(), "user", time.Millisecond, time.Time{}, fn)
+	assert.ErrorIs(err, ipa.ErrTruncated)
+
+	err = c.WatchEntries(context.Background(), "user", 0, time.Time{}, fn)
+	assert.Error(err)
+	err = c.WatchEntries(context.Background(), "", time.Millisecond, time.Time{}, fn)
+	assert.Error(err)
+}